@@ -0,0 +1,179 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "strconv"
+    "time"
+
+    "sippy/headers"
+    "sippy/time"
+    "sippy/types"
+)
+
+// The accounting interface of a call leg. The call controller
+// notifies the backends when the leg gets connected, when it is
+// disconnected after being connected and when the call attempt fails.
+type accounting interface {
+    conn(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string)
+    disc(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string, result int)
+    fail(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string, result int)
+}
+
+// Dispatches the events to several accounting backends at once
+type multiAccounting []accounting
+
+func (self multiAccounting) conn(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string) {
+    for _, acct := range self {
+        acct.conn(ua, rtime, origin)
+    }
+}
+
+func (self multiAccounting) disc(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string, result int) {
+    for _, acct := range self {
+        acct.disc(ua, rtime, origin, result)
+    }
+}
+
+func (self multiAccounting) fail(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string, result int) {
+    for _, acct := range self {
+        acct.fail(ua, rtime, origin, result)
+    }
+}
+
+// The call detail record of a single call leg
+type cdrRecord struct {
+    Leg             string      `json:"leg"`
+    Username        string      `json:"username"`
+    CLI             string      `json:"cli"`
+    CLD             string      `json:"cld"`
+    CallId          string      `json:"call_id"`
+    OrigCallId      string      `json:"orig_call_id"`
+    H323ConfId      string      `json:"h323_conf_id"`
+    RemoteIP        string      `json:"remote_ip"`
+    SetupTime       time.Time   `json:"setup_time"`
+    ConnectTime     *time.Time  `json:"connect_time"`
+    DisconnectTime  time.Time   `json:"disconnect_time"`
+    Duration        float64     `json:"duration"`
+    PDD             float64     `json:"pdd"`
+    Origin          string      `json:"disconnect_origin"`
+    SipCode         int         `json:"sip_code"`
+    Q850Cause       int         `json:"q850_cause"`
+    UserAgent       string      `json:"user_agent"`
+}
+
+// The backend that stores the call detail records
+type cdrWriter interface {
+    WriteCDR(*cdrRecord) error
+}
+
+type cdrAccounting struct {
+    global_config   *myConfigParser
+    writer          cdrWriter
+    rec             cdrRecord
+    drec            bool
+}
+
+func NewCdrAccounting(global_config *myConfigParser, writer cdrWriter, leg string) *cdrAccounting {
+    self := &cdrAccounting{
+        global_config   : global_config,
+        writer          : writer,
+    }
+    self.rec.Leg = leg
+    return self
+}
+
+func (self *cdrAccounting) setParams(username, caller, callee string, h323_cid *sippy_header.SipCiscoGUID, sip_cid, orig_sip_cid, remote_ip string) {
+    self.rec.Username = username
+    self.rec.CLI = caller
+    self.rec.CLD = callee
+    if h323_cid != nil {
+        self.rec.H323ConfId = h323_cid.HexForm()
+    }
+    self.rec.CallId = sip_cid
+    self.rec.OrigCallId = orig_sip_cid
+    self.rec.RemoteIP = remote_ip
+}
+
+func (self *cdrAccounting) conn(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string) {
+}
+
+func (self *cdrAccounting) disc(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string, result int) {
+    if self.drec {
+        return
+    }
+    self.drec = true
+    if rtime == nil {
+        rtime, _ = sippy_time.NewMonoTime()
+    }
+    duration, delay, connected, _ := ua.GetAcct(rtime)
+    if setup_ts := ua.GetSetupTs(); setup_ts != nil {
+        self.rec.SetupTime = setup_ts.Realt().UTC()
+        if p1xx_ts := ua.GetP1xxTs(); p1xx_ts != nil {
+            self.rec.PDD = p1xx_ts.Sub(setup_ts).Seconds()
+        }
+    } else {
+        self.rec.SetupTime = rtime.Realt().UTC()
+    }
+    if connected {
+        connect_time := self.rec.SetupTime.Add(delay)
+        self.rec.ConnectTime = &connect_time
+        self.rec.DisconnectTime = connect_time.Add(duration)
+        self.rec.Duration = duration.Seconds()
+    } else {
+        self.rec.DisconnectTime = self.rec.SetupTime.Add(delay)
+    }
+    self.rec.Origin = origin
+    self.rec.SipCode = result
+    self.rec.Q850Cause = q850Cause(result)
+    self.rec.UserAgent = ua.GetRemoteUA()
+    err := self.writer.WriteCDR(&self.rec)
+    if err != nil {
+        self.global_config.ErrorLogger().Error("Cannot write CDR for " + self.rec.CallId + ": " + err.Error())
+    }
+}
+
+func (self *cdrAccounting) fail(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string, result int) {
+    self.disc(ua, rtime, origin, result)
+}
+
+// Maps the SIP final response code onto the Q.850 cause value
+func q850Cause(result int) int {
+    if result < 300 {
+        // Normal call clearing
+        return 16
+    }
+    if h323err, ok := sipErrToH323Err[result]; ok {
+        cause, err := strconv.ParseInt(h323err[0], 16, 32)
+        if err == nil {
+            return int(cause)
+        }
+    }
+    // Interworking, unspecified
+    return 127
+}
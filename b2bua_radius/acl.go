@@ -0,0 +1,204 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "errors"
+    "net"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+type aclEntry struct {
+    ipnet       *net.IPNet
+    allow       bool
+}
+
+// The IP access list of CIDR allow and deny entries. The most specific
+// entry matching the address wins. The addresses not matching any
+// entry are accepted unless allow entries have ever been added to the
+// list, removing them all does not open it to everyone.
+type ipACL struct {
+    lock            sync.Mutex
+    entries         []*aclEntry
+    default_deny    bool
+}
+
+func NewIpACL() *ipACL {
+    return &ipACL{
+        entries     : make([]*aclEntry, 0),
+    }
+}
+
+// Parses the address or the CIDR network. The single address is
+// treated as a host network.
+func parseCIDR(s string) (*net.IPNet, error) {
+    s = strings.Trim(strings.TrimSpace(s), "[]")
+    if ! strings.Contains(s, "/") {
+        ip := net.ParseIP(s)
+        if ip == nil {
+            return nil, errors.New("invalid IP address: " + s)
+        }
+        if ip4 := ip.To4(); ip4 != nil {
+            return &net.IPNet{ IP : ip4, Mask : net.CIDRMask(32, 32) }, nil
+        }
+        return &net.IPNet{ IP : ip, Mask : net.CIDRMask(128, 128) }, nil
+    }
+    _, ipnet, err := net.ParseCIDR(s)
+    if err != nil {
+        return nil, errors.New("invalid CIDR network: " + s)
+    }
+    return ipnet, nil
+}
+
+// Adds the entry replacing the one for the same network if any.
+func (self *ipACL) add(s string, allow bool) error {
+    ipnet, err := parseCIDR(s)
+    if err != nil {
+        return err
+    }
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if allow {
+        self.default_deny = true
+    }
+    for _, entry := range self.entries {
+        if entry.ipnet.String() == ipnet.String() {
+            entry.allow = allow
+            return nil
+        }
+    }
+    self.entries = append(self.entries, &aclEntry{ ipnet : ipnet, allow : allow })
+    return nil
+}
+
+func (self *ipACL) remove(s string) error {
+    ipnet, err := parseCIDR(s)
+    if err != nil {
+        return err
+    }
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    for i, entry := range self.entries {
+        if entry.ipnet.String() == ipnet.String() {
+            self.entries = append(self.entries[:i], self.entries[i + 1:]...)
+            return nil
+        }
+    }
+    return errors.New("no such entry: " + ipnet.String())
+}
+
+func (self *ipACL) check(s string) bool {
+    ip := net.ParseIP(strings.Trim(s, "[]"))
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    var best *aclEntry
+    best_len := -1
+    for _, entry := range self.entries {
+        if ip == nil || ! entry.ipnet.Contains(ip) {
+            continue
+        }
+        if ones, _ := entry.ipnet.Mask.Size(); ones > best_len {
+            best, best_len = entry, ones
+        }
+    }
+    if best != nil {
+        return best.allow
+    }
+    return ! self.default_deny
+}
+
+func (self *ipACL) String() string {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    lines := make([]string, 0, len(self.entries))
+    for _, entry := range self.entries {
+        action := "deny"
+        if entry.allow {
+            action = "allow"
+        }
+        lines = append(lines, action + " " + entry.ipnet.String())
+    }
+    sort.Strings(lines)
+    res := "Access list:\n"
+    for _, line := range lines {
+        res += line + "\n"
+    }
+    if self.default_deny {
+        res += "default deny\n"
+    }
+    return res + "Total: " + strconv.Itoa(len(lines)) + "\n"
+}
+
+// The change made to the access list over the CLI.
+type aclEdit struct {
+    op          string
+    cidr        string
+}
+
+func (self aclEdit) apply(acl *ipACL) error {
+    switch self.op {
+    case "allow":
+        return acl.add(self.cidr, true)
+    case "deny":
+        return acl.add(self.cidr, false)
+    case "remove":
+        return acl.remove(self.cidr)
+    }
+    return errors.New("unknown acl command: " + self.op)
+}
+
+// Handles the "acl" CLI command. The changes made are re-applied on top
+// of the access list from the configuration file when it is reloaded.
+func (self *callMap) aclCommand(args []string) string {
+    self.config_lock.Lock()
+    defer self.config_lock.Unlock()
+    acl := self.global_config.accept_ips
+    if len(args) == 0 || args[0] == "list" {
+        return acl.String()
+    }
+    if len(args) != 2 {
+        return "ERROR: syntax error: acl [list|allow <cidr>|deny <cidr>|remove <cidr>]\n"
+    }
+    edit := aclEdit{ op : args[0], cidr : args[1] }
+    if err := edit.apply(acl); err != nil {
+        return "ERROR: " + err.Error() + "\n"
+    }
+    self.acl_edits = append(self.acl_edits, edit)
+    return "OK\n"
+}
+
+// Re-applies the CLI changes to the reloaded access list. The removal
+// of the entry no longer in the configuration file is not an error.
+func (self *callMap) replayAclEdits(acl *ipACL) {
+    for _, edit := range self.acl_edits {
+        edit.apply(acl)
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "testing"
+)
+
+func Test_IpACL(t *testing.T) {
+    for _, tc := range []struct {
+        name    string
+        edits   []aclEdit
+        checks  map[string]bool
+    }{
+        { "empty", nil, map[string]bool{ "1.2.3.4" : true, "::1" : true } },
+        { "deny only", []aclEdit{ { "deny", "10.0.0.0/8" } },
+          map[string]bool{ "10.1.2.3" : false, "1.2.3.4" : true } },
+        { "longest prefix", []aclEdit{
+              { "allow", "10.0.0.0/8" },
+              { "deny", "10.1.0.0/16" },
+              { "allow", "10.1.2.0/24" },
+              { "deny", "10.1.2.3" },
+            },
+          map[string]bool{
+              "10.0.0.1" : true,
+              "10.1.0.1" : false,
+              "10.1.2.1" : true,
+              "10.1.2.3" : false,
+              "11.0.0.1" : false,
+            } },
+        { "replace", []aclEdit{ { "allow", "10.0.0.0/8" }, { "deny", "10.0.0.0/8" } },
+          map[string]bool{ "10.0.0.1" : false, "1.2.3.4" : false } },
+        { "ipv6", []aclEdit{ { "allow", "2001:db8::/32" }, { "deny", "[2001:db8::1]" } },
+          map[string]bool{ "2001:db8::2" : true, "[2001:db8::1]" : false, "10.0.0.1" : false } },
+        { "remove more specific", []aclEdit{
+              { "allow", "10.0.0.0/8" },
+              { "deny", "10.1.0.0/16" },
+              { "remove", "10.1.0.0/16" },
+            },
+          map[string]bool{ "10.1.0.1" : true, "11.0.0.1" : false } },
+        { "remove last allow", []aclEdit{ { "allow", "10.0.0.0/8" }, { "remove", "10.0.0.0/8" } },
+          map[string]bool{ "10.0.0.1" : false, "1.2.3.4" : false } },
+        { "bad address", []aclEdit{ { "allow", "10.0.0.0/8" } },
+          map[string]bool{ "foo" : false } },
+    } {
+        acl := NewIpACL()
+        for _, edit := range tc.edits {
+            if err := edit.apply(acl); err != nil {
+                t.Fatalf("%s: %s %s: %s", tc.name, edit.op, edit.cidr, err.Error())
+            }
+        }
+        for ip, allowed := range tc.checks {
+            if acl.check(ip) != allowed {
+                t.Errorf("%s: %s: expected %v\n%s", tc.name, ip, allowed, acl.String())
+            }
+        }
+    }
+}
+
+func Test_IpACLErrors(t *testing.T) {
+    acl := NewIpACL()
+    for _, edit := range []aclEdit{
+            { "allow", "10.0.0.0/33" },
+            { "deny", "foo" },
+            { "remove", "10.0.0.0/8" },
+            { "block", "10.0.0.0/8" },
+          } {
+        if err := edit.apply(acl); err == nil {
+            t.Errorf("%s %s has been accepted", edit.op, edit.cidr)
+        }
+    }
+    if ! acl.check("1.2.3.4") {
+        t.Fatal("the failed edits have changed the access list")
+    }
+}
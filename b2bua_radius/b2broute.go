@@ -69,6 +69,18 @@ type B2BRoute struct {
     rtpp            bool
     outbound_proxy  *sippy_net.HostPort
     rnum            int
+    port            string
+    port_set        bool
+    parallel        bool
+    allowed_pts     []int
+    allowed_pts_set bool
+    group_timeout   time.Duration
+    group_skip      int
+    group_skipto    int
+    reg             string
+    loc             bool
+    loc_aor         string
+    rtarget         *sippy_header.SipURL
 }
 /*
 from sippy.SipHeader import SipHeader
@@ -85,7 +97,6 @@ class B2BRoute(object):
 */
 
 func NewB2BRoute(sroute string, global_config sippy_conf.Config) (*B2BRoute, error) {
-    var hostport []string
     var err error
 
     self := &B2BRoute{
@@ -98,50 +109,26 @@ func NewB2BRoute(sroute string, global_config sippy_conf.Config) (*B2BRoute, err
         rtpp            : true,
     }
     route := strings.Split(sroute, ";")
-    if strings.IndexRune(route[0], '@') != -1 {
-        tmp := strings.SplitN(route[0], "@", 2)
-        self.cld, self.hostport = tmp[0], tmp[1]
-        // Allow CLD to be forcefully removed by sending `Routing:@host" entry,
-        // as opposed to the Routing:host, which means that CLD should be obtained
-        // from the incoming call leg.
-        self.cld_set = true
+    if strings.HasPrefix(route[0], "loc:") {
+        // The registered contacts of the AOR, resolved when the call
+        // is routed
+        self.loc, self.loc_aor = true, route[0][4:]
+        self.hostport, self.hostonly = route[0], route[0]
+        self.port = global_config.GetMyPort().String()
     } else {
-        self.hostport = route[0]
-    }
-    ipv6only := false
-    if self.hostport[0] != '[' {
-        hostport = strings.SplitN(self.hostport, ":", 2)
-        self.hostonly = hostport[0]
-    } else {
-        hostport = strings.SplitN(self.hostport[1:], "]", 2)
-        if len(hostport) > 1 {
-            if hostport[1] == "" {
-                hostport = hostport[:1]
-            } else {
-                hostport[1] = hostport[1][1:]
-            }
+        hostport := route[0]
+        if strings.IndexRune(route[0], '@') != -1 {
+            tmp := strings.SplitN(route[0], "@", 2)
+            self.cld, hostport = tmp[0], tmp[1]
+            // Allow CLD to be forcefully removed by sending `Routing:@host" entry,
+            // as opposed to the Routing:host, which means that CLD should be obtained
+            // from the incoming call leg.
+            self.cld_set = true
         }
-        ipv6only = true
-        self.hostonly = "[" + hostport[0] + "]"
-    }
-    var port *sippy_net.MyPort
-    if len(hostport) == 1 {
-        port = global_config.GetMyPort()
-    } else {
-        port = sippy_net.NewMyPort(hostport[1])
-    }
-    self.ainfo = make([]*ainfo_item, 0)
-    ips, err := net.LookupIP(hostport[0])
-    if err != nil {
-        return nil, errors.New("NewB2BRoute: error resolving host IP '" + hostport[0] + "': " + err.Error())
-    }
-    for _, ip := range ips {
-        if ipv6only && sippy_net.IsIP4(ip) {
-            continue
+        if err = self.setHostPort(hostport, global_config); err != nil {
+            return nil, err
         }
-        self.ainfo = append(self.ainfo, &ainfo_item{ ip, port.String() })
     }
-    //self.params = []string{}
     for _, x := range route[1:] {
         av := strings.SplitN(x, "=", 2)
         switch av[0] {
@@ -210,6 +197,37 @@ func NewB2BRoute(sroute string, global_config sippy_conf.Config) (*B2BRoute, err
                 return nil, errors.New("Error parsing the rtpp '" + av[1] + "': " + err.Error())
             }
             self.rtpp = (v != 0)
+        case "gt":
+            tmp := strings.SplitN(av[1], ",", 2)
+            if len(tmp) != 2 {
+                return nil, errors.New("Error parsing the gt (no comma) '" + av[1] + "'")
+            }
+            timeout, err := strconv.Atoi(tmp[0])
+            if err != nil || timeout <= 0 {
+                return nil, errors.New("Error parsing the gt timeout '" + tmp[0] + "'")
+            }
+            self.group_skip, err = strconv.Atoi(tmp[1])
+            if err != nil || self.group_skip <= 0 {
+                return nil, errors.New("Error parsing the gt skip '" + tmp[1] + "'")
+            }
+            self.group_timeout = time.Duration(timeout) * time.Second
+        case "allowed_pts":
+            self.allowed_pts = []int{}
+            for _, s := range strings.Split(av[1], ",") {
+                s = strings.TrimSpace(s)
+                if s == "" { continue }
+                pt, err := strconv.Atoi(s)
+                if err != nil || pt < 0 || pt > 127 {
+                    return nil, errors.New("Error parsing allowed_pts '" + s + "'")
+                }
+                self.allowed_pts = append(self.allowed_pts, pt)
+            }
+            self.allowed_pts_set = true
+        case "reg":
+            if global_trunk_regs.get(av[1]) == nil {
+                return nil, errors.New("NewB2BRoute: unknown registration '" + av[1] + "'")
+            }
+            self.reg = av[1]
         case "op":
             host_port := strings.SplitN(av[1], ":", 2)
             if len(host_port) == 1 {
@@ -224,6 +242,70 @@ func NewB2BRoute(sroute string, global_config sippy_conf.Config) (*B2BRoute, err
     return self, nil
 }
 
+// Sets the destination of the route out of the "host[:port]".
+func (self *B2BRoute) setHostPort(hostport_s string, global_config sippy_conf.Config) error {
+    var hostport []string
+
+    self.hostport = hostport_s
+    ipv6only := false
+    if self.hostport[0] != '[' {
+        hostport = strings.SplitN(self.hostport, ":", 2)
+        self.hostonly = hostport[0]
+    } else {
+        hostport = strings.SplitN(self.hostport[1:], "]", 2)
+        if len(hostport) > 1 {
+            if hostport[1] == "" {
+                hostport = hostport[:1]
+            } else {
+                hostport[1] = hostport[1][1:]
+            }
+        }
+        ipv6only = true
+        self.hostonly = "[" + hostport[0] + "]"
+    }
+    var port *sippy_net.MyPort
+    if len(hostport) == 1 {
+        port = global_config.GetMyPort()
+    } else {
+        port = sippy_net.NewMyPort(hostport[1])
+        self.port_set = true
+    }
+    self.port = port.String()
+    self.ainfo = make([]*ainfo_item, 0)
+    if ip := net.ParseIP(hostport[0]); ip != nil {
+        if ipv6only && sippy_net.IsIP4(ip) {
+            return errors.New("NewB2BRoute: IPv4 address in brackets '" + hostport[0] + "'")
+        }
+        self.ainfo = append(self.ainfo, &ainfo_item{ ip, port.String() })
+    }
+    // The host names are left for the RFC 3263 lookup at the time the
+    // call is placed, so that the DNS TTLs are honoured.
+    //self.params = []string{}
+    return nil
+}
+
+// NewB2BRouteSet parses a set of routes. The '|' separated groups are
+// tried one after another while the '&' separated routes within a group
+// are all started at once.
+func NewB2BRouteSet(sroutes string, global_config sippy_conf.Config) ([]*B2BRoute, error) {
+    routes := []*B2BRoute{}
+    for _, sgroup := range strings.Split(sroutes, "|") {
+        for i, sroute := range strings.Split(sgroup, "&") {
+            sroute = strings.TrimSpace(sroute)
+            if sroute == "" {
+                return nil, errors.New("NewB2BRouteSet: empty route in '" + sroutes + "'")
+            }
+            route, err := NewB2BRoute(sroute, global_config)
+            if err != nil {
+                return nil, err
+            }
+            route.parallel = i > 0
+            routes = append(routes, route)
+        }
+    }
+    return routes, nil
+}
+
 func (self *B2BRoute) customize(rnum int, default_cld, default_cli string, default_credit_time time.Duration, pass_headers []sippy_header.SipHeader, max_credit_time time.Duration) {
     self.rnum = rnum
     if ! self.cld_set {
@@ -235,10 +317,9 @@ func (self *B2BRoute) customize(rnum int, default_cld, default_cli string, defau
     if ! self.crt_set {
         self.credit_time = default_credit_time
     }
-    //if self.params.has_key("gt") {
-    //    timeout, skip = self.params["gt"].split(",", 1)
-    //    self.params["group_timeout"] = (int(timeout), rnum + int(skip))
-    //}
+    if self.group_timeout > 0 {
+        self.group_skipto = rnum + self.group_skip
+    }
     self.extra_headers = append(self.extra_headers, pass_headers...)
     if max_credit_time != 0 {
         if self.credit_time == 0 || self.credit_time > max_credit_time {
@@ -255,6 +336,9 @@ func (self *B2BRoute) getCopy() *B2BRoute {
     if self.outbound_proxy != nil {
         cself.outbound_proxy = self.outbound_proxy.GetCopy()
     }
+    if self.rtarget != nil {
+        cself.rtarget = self.rtarget.GetCopy()
+    }
 
     cself.huntstop_scodes = make([]int, len(self.huntstop_scodes))
     copy(cself.huntstop_scodes, self.huntstop_scodes)
@@ -262,13 +346,34 @@ func (self *B2BRoute) getCopy() *B2BRoute {
     cself.ainfo = make([]*ainfo_item, len(self.ainfo))
     copy(cself.ainfo, self.ainfo)
 
+    cself.allowed_pts = make([]int, len(self.allowed_pts))
+    copy(cself.allowed_pts, self.allowed_pts)
+
     cself.extra_headers = make([]sippy_header.SipHeader, len(self.extra_headers))
     copy(cself.extra_headers, self.extra_headers)
 
     return &cself
 }
 
+func (self *B2BRoute) isHuntstop(scode int) bool {
+    for _, c := range self.huntstop_scodes {
+        if c == scode {
+            return true
+        }
+    }
+    return false
+}
+
+// Host names are resolved by the transaction layer as RFC 3263 says.
+// The SRV lookups are only done if no port has been given explicitly.
+func (self *B2BRoute) isHostName() bool {
+    return len(self.ainfo) == 0
+}
+
 func (self *B2BRoute) getNHAddr(source *sippy_net.HostPort) *sippy_net.HostPort {
+    if self.isHostName() {
+        return sippy_net.NewHostPort(self.hostonly, self.port)
+    }
     src_ip := net.ParseIP(source.Host.String())
     if src_ip == nil {
         return self.ainfo[0].HostPort()
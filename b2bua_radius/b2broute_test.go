@@ -0,0 +1,41 @@
+package main
+
+import (
+    "os"
+    "testing"
+
+    "sippy/conf"
+    "sippy/log"
+)
+
+func testConfig(t *testing.T) *myConfigParser {
+    sip_logger, err := sippy_log.NewSipLogger("b2bua", os.DevNull)
+    if err != nil {
+        t.Fatal(err)
+    }
+    global_config := NewMyConfigParser()
+    global_config.Config = sippy_conf.NewConfig(sippy_log.NewErrorLogger(), sip_logger)
+    return global_config
+}
+
+func Test_B2BRouteReg(t *testing.T) {
+    global_config := testConfig(t)
+    saved_regs := global_trunk_regs
+    defer func() { global_trunk_regs = saved_regs }()
+    var err error
+    global_trunk_regs, err = NewTrunkRegistrations("trunk@carrier.example.com;name=carrier", nil, global_config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    oroute, err := NewB2BRoute("1.2.3.4;reg=carrier", global_config)
+    if err != nil || oroute.reg != "carrier" {
+        t.Fatalf("the route has not been accepted: %v", err)
+    }
+    if _, err = NewB2BRoute("1.2.3.4;reg=other", global_config); err == nil {
+        t.Fatal("the route with an unknown registration has been accepted")
+    }
+    global_trunk_regs = nil
+    if _, err = NewB2BRoute("1.2.3.4;reg=carrier", global_config); err == nil {
+        t.Fatal("the route has been accepted with no registrations configured")
+    }
+}
@@ -0,0 +1,235 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+
+    "sippy/headers"
+)
+
+const (
+    CAC_GLOBAL  = "global"
+    CAC_SOURCE  = "source"
+    CAC_USER    = "user"
+    CAC_ROUTE   = "route"
+)
+
+// How often the idle counters are garbage collected
+const CAC_SWEEP_IVAL = 60 * time.Second
+
+type cacLimit struct {
+    max_calls   int
+    cps         float64
+}
+
+type cacKey struct {
+    scope       string
+    name        string
+}
+
+func (self cacKey) String() string {
+    if self.scope == CAC_GLOBAL {
+        return self.scope
+    }
+    return self.scope + " " + self.name
+}
+
+// The token bucket refilled at the rate of cps tokens per second and
+// holding up to one second worth of tokens, but at least one.
+type tokenBucket struct {
+    tokens      float64
+    last        time.Time
+}
+
+func (self *tokenBucket) refill(now time.Time, cps float64) {
+    self.tokens += now.Sub(self.last).Seconds() * cps
+    if self.tokens > self.size(cps) {
+        self.tokens = self.size(cps)
+    }
+    self.last = now
+}
+
+func (self *tokenBucket) size(cps float64) float64 {
+    if cps < 1 {
+        return 1
+    }
+    return cps
+}
+
+type cacCounter struct {
+    calls       int
+    admitted    uint64
+    rejected    uint64
+    bucket      tokenBucket
+}
+
+// Call admission control. The concurrent calls and the call rate are
+// limited globally, per source IP, per authenticated user and per
+// route. Only the scopes having a limit configured are tracked apart
+// from the global one.
+type callAdmission struct {
+    lock        sync.Mutex
+    limits      map[string]cacLimit
+    counters    map[cacKey]*cacCounter
+    last_sweep  time.Time
+}
+
+func NewCallAdmission(global_config *myConfigParser) *callAdmission {
+    self := &callAdmission{
+        counters    : make(map[cacKey]*cacCounter),
+        last_sweep  : time.Now(),
+    }
+    self.setLimits(global_config)
+    return self
+}
+
+func (self *callAdmission) setLimits(global_config *myConfigParser) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.limits = map[string]cacLimit{
+        CAC_GLOBAL  : { global_config.cac_max_calls, global_config.cac_cps },
+        CAC_SOURCE  : { global_config.cac_src_max_calls, global_config.cac_src_cps },
+        CAC_USER    : { global_config.cac_user_max_calls, global_config.cac_user_cps },
+        CAC_ROUTE   : { global_config.cac_route_max_calls, global_config.cac_route_cps },
+    }
+}
+
+// Returns the keys that have to be admitted for the call in the
+// scope given.
+func (self *callAdmission) keys(scope string, names ...string) []cacKey {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    ret := []cacKey{}
+    if limit := self.limits[scope]; limit.max_calls > 0 || limit.cps > 0 || scope == CAC_GLOBAL {
+        for _, name := range names {
+            ret = append(ret, cacKey{ scope, name })
+        }
+    }
+    return ret
+}
+
+// Admits the call to all of the keys given or to none of them.
+func (self *callAdmission) admit(keys []cacKey) bool {
+    now := time.Now()
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if now.Sub(self.last_sweep) > CAC_SWEEP_IVAL {
+        self.sweep(now)
+    }
+    counters := make([]*cacCounter, len(keys))
+    for i, key := range keys {
+        limit := self.limits[key.scope]
+        counter, ok := self.counters[key]
+        if ! ok {
+            counter = &cacCounter{ bucket : tokenBucket{ last : now } }
+            counter.bucket.tokens = counter.bucket.size(limit.cps)
+            self.counters[key] = counter
+        }
+        counter.bucket.refill(now, limit.cps)
+        if (limit.max_calls > 0 && counter.calls >= limit.max_calls) ||
+          (limit.cps > 0 && counter.bucket.tokens < 1) {
+            counter.rejected++
+            return false
+        }
+        counters[i] = counter
+    }
+    for i, counter := range counters {
+        counter.calls++
+        counter.admitted++
+        if self.limits[keys[i].scope].cps > 0 {
+            counter.bucket.tokens--
+        }
+    }
+    return true
+}
+
+func (self *callAdmission) release(keys []cacKey) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    for _, key := range keys {
+        if counter, ok := self.counters[key]; ok && counter.calls > 0 {
+            counter.calls--
+        }
+    }
+}
+
+// Drops the counters that have no calls and a full bucket as they are
+// indistinguishable from the fresh ones.
+func (self *callAdmission) sweep(now time.Time) {
+    self.last_sweep = now
+    for key, counter := range self.counters {
+        if key.scope == CAC_GLOBAL || counter.calls > 0 {
+            continue
+        }
+        cps := self.limits[key.scope].cps
+        counter.bucket.refill(now, cps)
+        if counter.bucket.tokens >= counter.bucket.size(cps) {
+            delete(self.counters, key)
+        }
+    }
+}
+
+func (self *callAdmission) String() string {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    keys := make([]cacKey, 0, len(self.counters))
+    for key := range self.counters {
+        keys = append(keys, key)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i].scope != keys[j].scope {
+            return keys[i].scope < keys[j].scope
+        }
+        return keys[i].name < keys[j].name
+    })
+    res := "Call admission counters (0 stands for no limit):\n"
+    for _, key := range keys {
+        counter := self.counters[key]
+        limit := self.limits[key.scope]
+        res += fmt.Sprintf("%s: calls %d/%d, cps %g, admitted %d, rejected %d\n", key.String(),
+          counter.calls, limit.max_calls, limit.cps, counter.admitted, counter.rejected)
+    }
+    return res
+}
+
+// Returns the response to reject the calls over the limits with.
+func cacReject(global_config *myConfigParser) (int, string, []sippy_header.SipHeader) {
+    eh := []sippy_header.SipHeader{}
+    if global_config.cac_retry_after > 0 {
+        eh = append(eh, sippy_header.NewSipGenericHF("Retry-After", strconv.Itoa(global_config.cac_retry_after)))
+    }
+    if global_config.cac_reject_code == 486 {
+        return 486, "Busy Here", eh
+    }
+    return 503, "Service Unavailable", eh
+}
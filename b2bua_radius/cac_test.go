@@ -0,0 +1,110 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+type cacStep struct {
+    op      string
+    keys    []cacKey
+    res     bool
+    wait    time.Duration
+}
+
+func cacAdmit(res bool, keys ...cacKey) cacStep { return cacStep{ op : "admit", keys : keys, res : res } }
+func cacRelease(keys ...cacKey) cacStep { return cacStep{ op : "release", keys : keys } }
+func cacWait(wait time.Duration) cacStep { return cacStep{ op : "wait", wait : wait } }
+
+// Moves the clock of the call admission back instead of sleeping
+func (self *callAdmission) rewind(d time.Duration) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.last_sweep = self.last_sweep.Add(-d)
+    for _, counter := range self.counters {
+        counter.bucket.last = counter.bucket.last.Add(-d)
+    }
+}
+
+func Test_CallAdmission(t *testing.T) {
+    g := cacKey{ CAC_GLOBAL, "" }
+    src1, src2 := cacKey{ CAC_SOURCE, "1.1.1.1" }, cacKey{ CAC_SOURCE, "2.2.2.2" }
+    rt1, rt2 := cacKey{ CAC_ROUTE, "1" }, cacKey{ CAC_ROUTE, "2" }
+    for _, tc := range []struct {
+        name    string
+        config  myConfigParser
+        steps   []cacStep
+    }{
+        { "no limits", myConfigParser{},
+          []cacStep{ cacAdmit(true, g), cacAdmit(true, g), cacAdmit(true, g, src1) } },
+        { "max calls", myConfigParser{ cac_max_calls : 2 },
+          []cacStep{ cacAdmit(true, g), cacAdmit(true, g), cacAdmit(false, g), cacRelease(g), cacAdmit(true, g), cacAdmit(false, g) } },
+        { "release unknown", myConfigParser{ cac_max_calls : 1 },
+          []cacStep{ cacRelease(g), cacRelease(src1), cacAdmit(true, g), cacAdmit(false, g) } },
+        { "cps", myConfigParser{ cac_cps : 2 },
+          []cacStep{ cacAdmit(true, g), cacAdmit(true, g), cacAdmit(false, g),
+            cacWait(500 * time.Millisecond), cacAdmit(true, g), cacAdmit(false, g),
+            // The bucket holds one second worth of calls at most
+            cacWait(10 * time.Second), cacAdmit(true, g), cacAdmit(true, g), cacAdmit(false, g) } },
+        { "slow cps", myConfigParser{ cac_cps : 0.5 },
+          []cacStep{ cacAdmit(true, g), cacAdmit(false, g), cacWait(time.Second), cacAdmit(false, g),
+            cacWait(time.Second), cacAdmit(true, g) } },
+        { "cps and max calls", myConfigParser{ cac_max_calls : 1, cac_cps : 10 },
+          []cacStep{ cacAdmit(true, g), cacAdmit(false, g), cacRelease(g), cacAdmit(true, g) } },
+        { "per source", myConfigParser{ cac_src_max_calls : 1 },
+          []cacStep{ cacAdmit(true, g, src1), cacAdmit(true, g, src2), cacAdmit(false, g, src1),
+            cacRelease(g, src1), cacAdmit(true, g, src1) } },
+        { "all or none", myConfigParser{ cac_src_max_calls : 1, cac_route_max_calls : 1 },
+          []cacStep{ cacAdmit(true, src1, rt1), cacAdmit(false, src2, rt1),
+            // The source has not been charged for the rejected call
+            cacAdmit(true, src2, rt2), cacAdmit(false, src2) } },
+    } {
+        cac := NewCallAdmission(&tc.config)
+        for i, step := range tc.steps {
+            switch step.op {
+            case "admit":
+                if res := cac.admit(step.keys); res != step.res {
+                    t.Errorf("%s: step %d: expected %v, got %v\n%s", tc.name, i, step.res, res, cac.String())
+                }
+            case "release":
+                cac.release(step.keys)
+            case "wait":
+                cac.rewind(step.wait)
+            }
+        }
+    }
+}
+
+func Test_CallAdmissionKeys(t *testing.T) {
+    cac := NewCallAdmission(&myConfigParser{ cac_user_cps : 1 })
+    if keys := cac.keys(CAC_GLOBAL, ""); len(keys) != 1 {
+        t.Fatal("the global scope is not tracked")
+    }
+    if keys := cac.keys(CAC_SOURCE, "1.1.1.1"); len(keys) != 0 {
+        t.Fatal("the scope without limits is tracked")
+    }
+    if keys := cac.keys(CAC_USER, "alice", "bob"); len(keys) != 2 || keys[1] != (cacKey{ CAC_USER, "bob" }) {
+        t.Fatalf("unexpected keys: %v", keys)
+    }
+    // The reload applies the new limits
+    cac.setLimits(&myConfigParser{ cac_src_max_calls : 1 })
+    if len(cac.keys(CAC_SOURCE, "1.1.1.1")) != 1 || len(cac.keys(CAC_USER, "alice")) != 0 {
+        t.Fatal("the new limits have not been applied")
+    }
+}
+
+func Test_CallAdmissionSweep(t *testing.T) {
+    cac := NewCallAdmission(&myConfigParser{ cac_src_cps : 1 })
+    src1, src2 := cacKey{ CAC_SOURCE, "1.1.1.1" }, cacKey{ CAC_SOURCE, "2.2.2.2" }
+    cac.admit([]cacKey{ src1 })
+    cac.admit([]cacKey{ src2 })
+    cac.release([]cacKey{ src1 })
+    cac.rewind(CAC_SWEEP_IVAL + time.Second)
+    cac.admit([]cacKey{ { CAC_GLOBAL, "" } })
+    if _, ok := cac.counters[src1]; ok {
+        t.Fatal("the idle counter has not been swept")
+    }
+    if _, ok := cac.counters[src2]; ! ok {
+        t.Fatal("the counter in use has been swept")
+    }
+}
@@ -28,12 +28,15 @@ package main
 
 import (
     "fmt"
+    "strconv"
     "strings"
     "sync"
+    "time"
 
     "sippy"
     "sippy/headers"
     "sippy/net"
+    "sippy/radius"
     "sippy/time"
     "sippy/types"
 )
@@ -42,6 +45,9 @@ type callController struct {
     id              int64
     uaA             sippy_types.UA
     uaO             sippy_types.UA
+    forks           map[sippy_types.UA]*B2BRoute
+    fork_fail       sippy_types.CCEvent
+    legs            []sippy_types.UA
     global_config   *myConfigParser
     state           CCState
     remote_ip       *sippy_net.MyAddress
@@ -56,11 +62,22 @@ type callController struct {
     caller_name     string
     rtp_proxy_session *sippy.Rtp_proxy_session
     eTry            *sippy.CCEventTry
-    huntstop_scodes []int
-    acctA           *fakeAccounting
+    acctA           accounting
     sip_tm          sippy_types.SipTransactionManager
     proxied         bool
     sdp_session     *sippy.SdpSession
+    sdp_session_o   *sippy.SdpSession
+    username        string
+    challenge       *sippy_header.SipWWWAuthenticate
+    route_headers   []sippy_header.SipHeader
+    loop_headers    []sippy_header.SipHeader
+    cac_keys        []cacKey
+    auth_username   string
+    local_domain    string
+    static_routes   []*B2BRoute
+    rtp_proxy_clients []sippy_types.RtpProxyClient
+    group_timer     *sippy.Timeout
+    group_skipto    int
 }
 /*
 class CallController(object):
@@ -83,13 +100,20 @@ func NewCallController(id int64, remote_ip *sippy_net.MyAddress, source *sippy_n
         routes          : make([]*B2BRoute, 0),
         pass_headers    : pass_headers,
         lock            : new(sync.Mutex),
-        huntstop_scodes : make([]int, 0),
+        forks           : make(map[sippy_types.UA]*B2BRoute),
+        legs            : make([]sippy_types.UA, 0),
         proxied         : false,
         sip_tm          : sip_tm,
         sdp_session     : sippy.NewSdpSession(),
     }
+    if global_config.topology_hiding {
+        self.sdp_session_o = sippy.NewSdpSession()
+    }
     self.uaA = sippy.NewUA(sip_tm, global_config, nil, self, self.lock, nil)
     self.uaA.SetKaInterval(self.global_config.keepalive_ans)
+    self.uaA.SetKaMethod(self.global_config.keepalive_ans_method)
+    self.uaA.SetKaMaxFailures(self.global_config.keepalive_ans_failures)
+    self.uaA.SetSessionTimer(self.global_config.session_expires_ans, self.global_config.session_min_se)
     self.uaA.SetLocalUA(sippy_header.NewSipUserAgent(self.global_config.GetMyUAName()))
     self.uaA.SetConnCb(self.aConn)
     self.uaA.SetDiscCb(self.aDisc)
@@ -118,26 +142,10 @@ func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.
                 self.state = CCStateDead
                 return
             }
-/*
-            if body != nil && self.global_config.has_key('_allowed_pts') {
-                try:
-                    body.parse()
-                except:
-                    self.uaA.RecvEvent(CCEventFail((400, "Malformed SDP Body"), rtime = event.rtime))
-                    self.state = CCStateDead
-                    return
-                allowed_pts = self.global_config['_allowed_pts']
-                mbody = body.content.sections[0].m_header
-                if mbody.transport.lower() == "rtp/avp" {
-                    old_len = len(mbody.formats)
-                    mbody.formats = [x for x in mbody.formats if x in allowed_pts]
-                    if len(mbody.formats) == 0 {
-                        self.uaA.RecvEvent(CCEventFail((488, "Not Acceptable Here")))
-                        self.state = CCStateDead
-                        return
-                    if old_len > len(mbody.formats) {
-                        body.content.sections[0].optimize_a()
-*/
+            if ! self.filterOffer(ev_try.GetBody(), self.global_config.allowed_pts, self.uaA, event.GetRtime()) {
+                self.state = CCStateDead
+                return
+            }
             if strings.HasPrefix(self.cld, "nat-") {
                 self.cld = self.cld[4:]
                 if ev_try.GetBody() != nil {
@@ -145,15 +153,11 @@ func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.
                 }
                 event = sippy.NewCCEventTry(self.cId, self.cGUID, self.cli, self.cld, ev_try.GetBody(), ev_try.GetSipAuthorization(), self.caller_name, nil, "")
             }
-/*
-            if self.global_config.has_key('static_tr_in') {
-                self.cld = re_replace(self.global_config['static_tr_in'], self.cld)
-                event = sippy.NewCCEventTry(self.cId, self.cGUID, self.cli, self.cld, body, auth, self.caller_name)
-            }
-*/
-            if len(global_rtp_proxy_clients) > 0 {
+            self.cld = self.global_config.tr_in.translate(self.cld)
+            self.cli = self.global_config.tr_cli_in.translate(self.cli)
+            if len(self.rtp_proxy_clients) > 0 {
                 var err error
-                self.rtp_proxy_session, err = sippy.NewRtp_proxy_session(self.global_config, global_rtp_proxy_clients, self.cId.CallId, "", "", self.global_config.b2bua_socket, /*notify_tag*/ fmt.Sprintf("r%%20%d", self.id), self.lock)
+                self.rtp_proxy_session, err = sippy.NewRtp_proxy_session(self.global_config, self.rtp_proxy_clients, self.cId.CallId, "", "", self.global_config.b2bua_socket, /*notify_tag*/ fmt.Sprintf("r%%20%d", self.id), self.lock)
                 if err != nil {
                     self.uaA.RecvEvent(sippy.NewCCEventFail(500, "Internal Server Error (4)", event.GetRtime(), ""))
                     self.state = CCStateDead
@@ -164,43 +168,90 @@ func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.
             }
             self.eTry = ev_try
             self.state = CCStateWaitRoute
-            //if ! self.global_config['auth_enable'] {
-                //self.username = self.remote_ip
-                self.rDone()
-            //} else if auth == nil || auth.username == nil || len(auth.username) == 0 {
-            //    self.username = self.remote_ip
-            //    self.auth_proc = self.global_config['_radius_client'].do_auth(self.remote_ip, self.cli, self.cld, self.cGUID, \
-            //      self.cId, self.remote_ip, self.rDone)
-            //} else {
-            //    self.username = auth.username
-            //    self.auth_proc = self.global_config['_radius_client'].do_auth(auth.username, self.cli, self.cld, self.cGUID, 
-            //      self.cId, self.remote_ip, self.rDone, auth.realm, auth.nonce, auth.uri, auth.response)
-            //}
+            auth := ev_try.GetSipAuthorization()
+            if self.auth_username != "" {
+                // Authenticated locally
+                self.username = self.auth_username
+                self.rDone(nil, sippy_radius.RESULT_ACCEPT)
+            } else if ! self.global_config.auth_enable {
+                self.username = self.remote_ip.String()
+                self.rDone(nil, sippy_radius.RESULT_ACCEPT)
+            } else if auth == nil || auth.GetUsername() == "" {
+                self.username = self.remote_ip.String()
+                global_radius_client.doAuth(self.remote_ip.String(), self.cli, self.cld, self.cGUID,
+                  self.cId, self.remote_ip.String(), self.authDone, nil)
+            } else {
+                self.username = auth.GetUsername()
+                global_radius_client.doAuth(auth.GetUsername(), self.cli, self.cld, self.cGUID,
+                  self.cId, self.remote_ip.String(), self.authDone, auth)
+            }
+            return
+        }
+        if self.state != CCStateARComplete && self.state != CCStateConnected && self.state != CCStateDisconnecting {
+            return
+        }
+        if _, is_ev_disconnect := event.(*sippy.CCEventDisconnect); is_ev_disconnect && (len(self.forks) > 1 || self.uaO == nil) {
+            // None of the parallel legs might have been selected yet
+            for uaO := range self.forks {
+                uaO.RecvEvent(event)
+            }
             return
         }
-        if (self.state != CCStateARComplete && self.state != CCStateConnected && self.state != CCStateDisconnecting) || self.uaO == nil {
+        if self.uaO == nil {
             return
         }
+        if ev_update, ok := event.(*sippy.CCEventUpdate); ok {
+            if ! self.filterOffer(ev_update.GetBody(), self.legAllowedPts(self.uaO), ua, event.GetRtime()) {
+                return
+            }
+        }
+        if self.sdp_session_o != nil {
+            self.sdp_session_o.FixupVersion(event.GetBody())
+        }
         self.uaO.RecvEvent(event)
     } else {
+        oroute, ok := self.forks[ua]
+        if ! ok {
+            // The leg has lost the race to some other parallel leg
+            switch event.(type) {
+            case *sippy.CCEventConnect, *sippy.CCEventPreConnect:
+                ua.RecvEvent(sippy.NewCCEventDisconnect(nil, event.GetRtime(), ""))
+            }
+            return
+        }
+        if self.global_config.topology_hiding {
+            event = self.hideEgress(event)
+        }
         ev_fail, is_ev_fail := event.(*sippy.CCEventFail)
-        _, is_ev_disconnect := event.(*sippy.CCEventFail)
+        _, is_ev_disconnect := event.(*sippy.CCEventDisconnect)
         if (is_ev_fail || is_ev_disconnect) && self.state == CCStateARComplete &&
           (self.uaA.GetState() == sippy_types.UAS_STATE_TRYING ||
-          self.uaA.GetState() == sippy_types.UAS_STATE_RINGING) && len(self.routes) > 0 {
-            huntstop := false
-            if is_ev_fail {
-                for _, c := range self.huntstop_scodes {
-                    if c == ev_fail.GetScode() {
-                        huntstop = true
-                        break
+          self.uaA.GetState() == sippy_types.UAS_STATE_RINGING) {
+            delete(self.forks, ua)
+            if is_ev_fail && oroute.isHuntstop(ev_fail.GetScode()) {
+                self.cancelForks(nil, event.GetRtime())
+            } else {
+                if self.fork_fail == nil || betterFailure(event, self.fork_fail) {
+                    self.fork_fail = event
+                }
+                if len(self.forks) > 0 {
+                    // Wait for the rest of the parallel legs
+                    if self.uaO == ua {
+                        self.uaO = nil
                     }
+                    return
+                }
+                if len(self.routes) > 0 {
+                    self.placeNextGroup()
+                    return
                 }
+                event = self.fork_fail
             }
-            if ! huntstop {
-                route := self.routes[0]
-                self.routes = self.routes[1:]
-                self.placeOriginate(route)
+        } else if ! self.selectBestLeg(event, ua) {
+            return
+        }
+        if ev_update, ok := event.(*sippy.CCEventUpdate); ok {
+            if ! self.filterOffer(ev_update.GetBody(), self.global_config.allowed_pts, ua, event.GetRtime()) {
                 return
             }
         }
@@ -209,89 +260,332 @@ func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.
     }
 }
 
-func (self *callController) rDone(/*results*/) {
-/*
+// Applies the payload type filter to the SDP offer received from the
+// leg. The offer is rejected if it has no usable stream left.
+func (self *callController) filterOffer(body sippy_types.MsgBody, allowed_pts []int, ua sippy_types.UA, rtime *sippy_time.MonoTime) bool {
+    ok, err := filterPayloadTypes(body, allowed_pts)
+    if err != nil {
+        ev := sippy.NewCCEventFail(400, "Malformed SDP Body", rtime, "")
+        ev.SetWarning(err.Error())
+        ua.RecvEvent(ev)
+        return false
+    }
+    if ! ok {
+        ua.RecvEvent(sippy.NewCCEventFail(488, "Not Acceptable Here", rtime, ""))
+        return false
+    }
+    return true
+}
+
+// Returns the payload types allowed on the outgoing leg
+func (self *callController) legAllowedPts(uaO sippy_types.UA) []int {
+    if oroute, ok := self.forks[uaO]; ok && oroute.allowed_pts_set {
+        return oroute.allowed_pts
+    }
+    return self.global_config.allowed_pts
+}
+
+// selectBestLeg picks the parallel leg that the caller is going to hear.
+// The leg that answers first wins and the others are cancelled. Until then
+// the last leg to send early media is preferred, or else the first leg to
+// ring. Returns true if the event comes from the best leg.
+func (self *callController) selectBestLeg(event sippy_types.CCEvent, ua sippy_types.UA) bool {
+    switch ev := event.(type) {
+    case *sippy.CCEventConnect, *sippy.CCEventPreConnect:
+        self.uaO = ua
+        self.cancelForks(ua, event.GetRtime())
+    case *sippy.CCEventRing:
+        if ev.GetScode() > 100 && (ev.GetBody() != nil || self.uaO == nil) {
+            self.uaO = ua
+        }
+    }
+    return ua == self.uaO
+}
+
+// cancelForks cancels all parallel legs except the winner one.
+func (self *callController) cancelForks(winner sippy_types.UA, rtime *sippy_time.MonoTime) {
+    extra_headers := []sippy_header.SipHeader{}
+    if winner != nil {
+        extra_headers = append(extra_headers, sippy_header.NewSipReason("SIP", "200", "Call completed elsewhere"))
+    }
+    for uaO := range self.forks {
+        if uaO == winner {
+            continue
+        }
+        delete(self.forks, uaO)
+        uaO.RecvEvent(sippy.NewCCEventDisconnect(nil, rtime, "", extra_headers...))
+    }
+}
+
+// betterFailure tells if the failure is a better one to report to the
+// caller than the current one. A 6xx wins, otherwise the lower class does.
+func betterFailure(event, current sippy_types.CCEvent) bool {
+    ev_fail, ok := event.(*sippy.CCEventFail)
+    if ! ok {
+        return false
+    }
+    cur_fail, ok := current.(*sippy.CCEventFail)
+    if ! ok {
+        return true
+    }
+    if cur_fail.GetScode() >= 600 {
+        return false
+    }
+    return ev_fail.GetScode() >= 600 || ev_fail.GetScode() / 100 < cur_fail.GetScode() / 100
+}
+
+func (self *callController) authDone(results []*sippy_radius.Attribute, rcode int) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.rDone(results, rcode)
+}
+
+func (self *callController) rDone(results []*sippy_radius.Attribute, rcode int) {
     // Check that we got necessary result from Radius
-    if len(results) != 2 || results[1] != 0:
-        if isinstance(self.uaA.state, UasStateTrying):
-            if self.challenge != nil:
-                event = CCEventFail((401, "Unauthorized"))
-                event.extra_header = self.challenge
-            else:
-                event = CCEventFail((403, "Auth Failed"))
+    if rcode != sippy_radius.RESULT_ACCEPT {
+        if self.uaA.GetState() == sippy_types.UAS_STATE_TRYING {
+            var event *sippy.CCEventFail
+            if self.challenge != nil {
+                event = sippy.NewCCEventFail(401, "Unauthorized", nil, "", self.challenge)
+            } else {
+                event = sippy.NewCCEventFail(403, "Auth Failed", nil, "")
+            }
             self.uaA.RecvEvent(event)
             self.state = CCStateDead
+        }
         return
-    if self.global_config['acct_enable']:
-        self.acctA = RadiusAccounting(self.global_config, "answer", \
-          send_start = self.global_config['start_acct_enable'], lperiod = \
-          self.global_config.getdefault('alive_acct_int', nil))
-        self.acctA.ms_precision = self.global_config.getdefault('precise_acct', false)
-        self.acctA.setParams(self.username, self.cli, self.cld, self.cGUID, self.cId, self.remote_ip)
-    else:
-*/
+    }
+    if (self.global_config.auth_enable || self.auth_username != "") && self.uaA.GetState() == sippy_types.UAS_STATE_TRYING {
+        cac_keys := global_cac.keys(CAC_USER, self.username)
+        if ! global_cac.admit(cac_keys) {
+            scode, reason, eh := cacReject(self.global_config)
+            self.uaA.RecvEvent(sippy.NewCCEventFail(scode, reason, nil, "", eh...))
+            self.state = CCStateDead
+            return
+        }
+        self.cac_keys = append(self.cac_keys, cac_keys...)
+    }
+    self.acctA = self.newAccounting("answer", self.cli, self.cld, self.cId.CallId, self.remote_ip.String())
+    if self.acctA == nil {
         self.acctA = NewFakeAccounting()
+    }
     // Check that uaA is still in a valid state, send acct stop
     if self.uaA.GetState() != sippy_types.UAS_STATE_TRYING {
-        //self.acctA.disc(self.uaA, time(), "caller")
+        now, _ := sippy_time.NewMonoTime()
+        self.acctA.disc(self.uaA, now, "caller", 0)
         return
     }
-/*
-    cli = [x[1][4:] for x in results[0] if x[0] == "h323-ivr-in" && x[1].startswith("CLI:")]
-    if len(cli) > 0:
-        self.cli = cli[0]
-        if len(self.cli) == 0:
-            self.cli = nil
-    caller_name = [x[1][5:] for x in results[0] if x[0] == "h323-ivr-in" && x[1].startswith("CNAM:")]
-    if len(caller_name) > 0:
-        self.caller_name = caller_name[0]
-        if len(self.caller_name) == 0:
-            self.caller_name = nil
-    credit_time = [x for x in results[0] if x[0] == "h323-credit-time"]
-    if len(credit_time) > 0:
-        credit_time = int(credit_time[0][1])
-    else:
-        credit_time := time.Duration(0)
-    if ! self.global_config.has_key('_static_route'):
-        routing = [x for x in results[0] if x[0] == "h323-ivr-in" && x[1].startswith("Routing:")]
-        if len(routing) == 0:
-            self.uaA.RecvEvent(CCEventFail((500, "Internal Server Error (2)")))
+    var credit_time time.Duration
+    credit_time_set := false
+    sroutes := []string{}
+    for _, attr := range results {
+        switch {
+        case attr.Name == "h323-ivr-in" && strings.HasPrefix(attr.Value, "CLI:"):
+            self.cli = attr.Value[4:]
+        case attr.Name == "h323-ivr-in" && strings.HasPrefix(attr.Value, "CNAM:"):
+            self.caller_name = attr.Value[5:]
+        case attr.Name == "h323-ivr-in" && strings.HasPrefix(attr.Value, "Routing:"):
+            sroutes = append(sroutes, attr.Value[8:])
+        case attr.Name == "h323-credit-time" && ! credit_time_set:
+            v, err := strconv.Atoi(attr.Value)
+            if err != nil {
+                self.global_config.ErrorLogger().Error("Bad h323-credit-time: " + attr.Value)
+                continue
+            }
+            if v < 0 { v = 0 }
+            credit_time = time.Duration(v) * time.Second
+            credit_time_set = true
+        }
+    }
+    var routing []*B2BRoute
+    if self.static_routes == nil {
+        if len(sroutes) == 0 && global_http_routing != nil {
+            req := newHttpRoutingRequest(self.username, self.cli, self.cld, self.caller_name, self.cId.CallId,
+              self.remote_ip.String(), append(self.pass_headers, self.route_headers...))
+            global_http_routing.lookup(req, func(routing []*B2BRoute) {
+                self.lock.Lock()
+                defer self.lock.Unlock()
+                if self.uaA.GetState() != sippy_types.UAS_STATE_TRYING {
+                    return
+                }
+                self.routingDone(routing, credit_time, credit_time_set)
+            })
+            return
+        }
+        if len(sroutes) == 0 {
+            self.uaA.RecvEvent(sippy.NewCCEventFail(500, "Internal Server Error (2)", nil, ""))
             self.state = CCStateDead
             return
-        routing = [B2BRoute(x[1][8:]) for x in routing]
-    else {
-*/
-        routing := []*B2BRoute{ global_static_route.getCopy() }
-//    }
+        }
+        for _, sroute := range sroutes {
+            oroutes, err := NewB2BRouteSet(sroute, self.global_config)
+            if err != nil {
+                self.global_config.ErrorLogger().Error("Error parsing the route '" + sroute + "': " + err.Error())
+                continue
+            }
+            routing = append(routing, oroutes...)
+        }
+    } else {
+        routing = make([]*B2BRoute, len(self.static_routes))
+        for i, oroute := range self.static_routes {
+            routing[i] = oroute.getCopy()
+        }
+    }
+    self.routingDone(routing, credit_time, credit_time_set)
+}
+
+func (self *callController) routingDone(routing []*B2BRoute, credit_time time.Duration, credit_time_set bool) {
+    routing, had_loc := self.expandLocRoutes(routing)
+    if len(routing) == 0 && had_loc {
+        // Nobody is registered
+        self.uaA.RecvEvent(sippy.NewCCEventFail(480, "Temporarily Unavailable", nil, ""))
+        self.state = CCStateDead
+        return
+    }
+    if len(routing) == 0 {
+        self.uaA.RecvEvent(sippy.NewCCEventFail(500, "Internal Server Error (2)", nil, ""))
+        self.state = CCStateDead
+        return
+    }
     rnum := 0
+    no_codecs := false
     for _, oroute := range routing {
+        if oroute.allowed_pts_set && self.eTry.GetBody() != nil {
+            ok, _ := filterPayloadTypes(self.eTry.GetBody().GetCopy(), oroute.allowed_pts)
+            if ! ok {
+                // No codec in common with the route
+                no_codecs = true
+                continue
+            }
+        }
         rnum += 1
-        oroute.customize(rnum, self.cld, self.cli, 0, self.pass_headers, 0)
-        //oroute.customize(rnum, self.cld, self.cli, credit_time, self.pass_headers, self.global_config.max_credit_time)
-        //if oroute.credit_time == 0 || oroute.expires == 0 {
-        //    continue
-        //}
+        oroute.customize(rnum, self.cld, self.cli, credit_time, self.pass_headers, self.global_config.max_credit_time)
+        if oroute.credit_time == 0 && (oroute.crt_set || credit_time_set) {
+            // No credit left
+            continue
+        }
         self.routes = append(self.routes, oroute)
         //println "Got route:", oroute.hostport, oroute.cld
     }
+    if len(self.routes) == 0 && no_codecs {
+        self.uaA.RecvEvent(sippy.NewCCEventFail(488, "Not Acceptable Here", nil, ""))
+        self.state = CCStateDead
+        return
+    }
     if len(self.routes) == 0 {
         self.uaA.RecvEvent(sippy.NewCCEventFail(500, "Internal Server Error (3)", nil, ""))
         self.state = CCStateDead
         return
     }
     self.state = CCStateARComplete
-    route := self.routes[0]
+    self.placeNextGroup()
+}
+
+// placeNextGroup starts the next route, or all the routes of the next
+// parallel group at once.
+func (self *callController) placeNextGroup() {
+    group := []*B2BRoute{ self.routes[0] }
     self.routes = self.routes[1:]
-    self.placeOriginate(route)
+    for len(self.routes) > 0 && self.routes[0].parallel {
+        group = append(group, self.routes[0])
+        self.routes = self.routes[1:]
+    }
+    self.forks = make(map[sippy_types.UA]*B2BRoute)
+    self.uaO = nil
+    if group[0].rnum >= self.group_skipto {
+        // The routes of the timed group are over, the rest of them
+        // stay under its timer.
+        self.cancelGroupTimer()
+    }
+    admitted := make([]*B2BRoute, 0, len(group))
+    cac_keys := make([][]cacKey, 0, len(group))
+    cac_rejected := false
+    for _, oroute := range group {
+        if oroute.reg != "" && ! global_trunk_regs.isRegistered(oroute.reg) {
+            // The trunk is not registered with the carrier, skip it
+            continue
+        }
+        keys := global_cac.keys(CAC_ROUTE, oroute.hostport)
+        if ! global_cac.admit(keys) {
+            // The route is over its limits, skip it
+            cac_rejected = true
+            continue
+        }
+        admitted = append(admitted, oroute)
+        cac_keys = append(cac_keys, keys)
+    }
+    if len(admitted) == 0 {
+        if len(self.routes) > 0 {
+            self.placeNextGroup()
+            return
+        }
+        self.state = CCStateDead
+        if self.fork_fail != nil {
+            // Report the failure of the routes actually tried
+            self.uaA.RecvEvent(self.fork_fail)
+        } else if ! cac_rejected {
+            self.uaA.RecvEvent(sippy.NewCCEventFail(503, "Service Unavailable", nil, ""))
+        } else {
+            scode, reason, eh := cacReject(self.global_config)
+            self.uaA.RecvEvent(sippy.NewCCEventFail(scode, reason, nil, "", eh...))
+        }
+        return
+    }
+    self.fork_fail = nil
+    for _, oroute := range admitted {
+        if oroute.group_timeout > 0 && self.group_timer == nil {
+            skipto := oroute.group_skipto
+            self.group_skipto = skipto
+            self.group_timer = sippy.StartTimeout(func() { self.groupExpires(skipto) }, self.lock,
+              oroute.group_timeout, 1, self.global_config.ErrorLogger())
+        }
+    }
+    for i, oroute := range admitted {
+        self.placeOriginate(oroute, len(group) > 1, cac_keys[i])
+    }
+}
+
+func (self *callController) cancelGroupTimer() {
+    if self.group_timer != nil {
+        self.group_timer.Cancel()
+        self.group_timer = nil
+    }
+}
+
+// groupExpires abandons the current group of routes that has been
+// trying for too long and jumps ahead to the route number skipto.
+func (self *callController) groupExpires(skipto int) {
+    self.group_timer = nil
+    if self.state != CCStateARComplete || len(self.routes) == 0 || self.routes[0].rnum > skipto ||
+      (self.uaA.GetState() != sippy_types.UAS_STATE_TRYING && self.uaA.GetState() != sippy_types.UAS_STATE_RINGING) {
+        return
+    }
+    // The route skipto might have been dropped from the list, e.g. for
+    // the lack of credit, so jump to the first one after it.
+    for len(self.routes) > 0 && self.routes[0].rnum < skipto {
+        self.routes = self.routes[1:]
+    }
+    // When the last group in the list has timeouted don't disconnect
+    // the current attempt forcefully. Instead, make sure that if the
+    // current originate call leg fails no more routes will be
+    // processed.
+    if len(self.routes) == 0 {
+        return
+    }
+    // The failures of the disconnected legs bring the next group in
+    forks := make([]sippy_types.UA, 0, len(self.forks))
+    for uaO := range self.forks {
+        forks = append(forks, uaO)
+    }
+    for _, uaO := range forks {
+        uaO.Disconnect(nil, "")
+    }
 }
 
-func (self *callController) placeOriginate(oroute *B2BRoute) {
+func (self *callController) placeOriginate(oroute *B2BRoute, forked bool, cac_keys []cacKey) {
     //cId, cGUID, cli, cld, body, auth, caller_name = self.eTry.getData()
-    cld := oroute.cld
-    self.huntstop_scodes = oroute.huntstop_scodes
-    //if self.global_config.has_key('static_tr_out') {
-    //    cld = re_replace(self.global_config['static_tr_out'], cld)
-    //}
+    cld := self.global_config.tr_out.translate(oroute.cld)
+    cli := self.global_config.tr_cli_out.translate(oroute.cli)
     var nh_address *sippy_net.HostPort
     if oroute.hostport == "sip-ua" {
         //host = self.source[0]
@@ -300,66 +594,133 @@ func (self *callController) placeOriginate(oroute *B2BRoute) {
         //host = oroute.hostonly
         nh_address = oroute.getNHAddr(self.source)
     }
-    //if ! oroute.forward_on_fail && self.global_config['acct_enable'] {
-    //    self.acctO = RadiusAccounting(self.global_config, "originate",
-    //      send_start = self.global_config['start_acct_enable'], /*lperiod*/
-    //      self.global_config.getdefault('alive_acct_int', nil))
-    //    self.acctO.ms_precision = self.global_config.getdefault('precise_acct', false)
-    //    self.acctO.setParams(oroute.params.get('bill-to', self.username), oroute.params.get('bill-cli', oroute.cli), \
-    //      oroute.params.get('bill-cld', cld), self.cGUID, self.cId, host)
-    //else {
-    //    self.acctO = nil
-    //}
-    //self.acctA.credit_time = oroute.credit_time
-    //disc_handlers = []
-    //if ! oroute.forward_on_fail && self.global_config['acct_enable'] {
-    //    disc_handlers.append(self.acctO.disc)
-    //}
-    self.uaO = sippy.NewUA(self.sip_tm, self.global_config, nh_address, self, self.lock, nil)
+    cId := self.egressCallId(oroute.rnum)
+    var acctO accounting
+    if ! oroute.forward_on_fail {
+        acctO = self.newAccounting("originate", cli, cld, cId.CallId, nh_address.Host.String())
+    }
+    uaO := sippy.NewUA(self.sip_tm, self.global_config, nh_address, self, self.lock, nil)
+    if oroute.rtarget != nil {
+        uaO.SetRTarget(oroute.rtarget.GetCopy())
+    } else if oroute.isHostName() && ! oroute.port_set {
+        // Leave the port out of the Request-URI to let the
+        // transaction layer do the NAPTR/SRV lookups.
+        uaO.SetRTarget(sippy_header.NewSipURL("", nh_address.Host, nil, false))
+    }
+    self.forks[uaO] = oroute
+    self.legs = append(self.legs, uaO)
+    if ! forked {
+        self.uaO = uaO
+    }
     // oroute.user, oroute.passw, nh_address, oroute.credit_time,
     //  /*expire_time*/ oroute.expires, /*no_progress_time*/ oroute.no_progress_expires, /*extra_headers*/ oroute.extra_headers)
-    //self.uaO.SetConnCbs([]sippy_types.OnConnectListener{ self.oConn })
-    self.uaO.SetExtraHeaders(oroute.extra_headers)
-    self.uaO.SetDeadCb(self.oDead)
-    self.uaO.SetLocalUA(sippy_header.NewSipUserAgent(self.global_config.GetMyUAName()))
-    if oroute.outbound_proxy != nil && self.source.String() != oroute.outbound_proxy.String() {
-        self.uaO.SetOutboundProxy(oroute.outbound_proxy)
+    outbound_proxy := oroute.outbound_proxy
+    if oroute.user != "" {
+        uaO.SetUsername(oroute.user)
+        uaO.SetPassword(oroute.passw)
+    } else if agent := global_trunk_regs.get(oroute.reg); agent != nil {
+        // Authenticate the calls to the carrier as the registered trunk
+        uaO.SetUsername(agent.GetUsername())
+        uaO.SetPassword(agent.GetPassword())
+        if outbound_proxy == nil {
+            outbound_proxy = agent.GetRegistrar()
+        }
+    }
+    if acctO != nil {
+        uaO.SetConnCb(func(rtime *sippy_time.MonoTime, origin string) {
+            acctO.conn(uaO, rtime, origin)
+        })
+        uaO.SetDiscCb(func(rtime *sippy_time.MonoTime, origin string, result int, inreq sippy_types.SipRequest) {
+            acctO.disc(uaO, rtime, origin, result)
+        })
+        uaO.SetFailCb(func(rtime *sippy_time.MonoTime, origin string, result int) {
+            acctO.fail(uaO, rtime, origin, result)
+        })
+    }
+    uaO.SetExtraHeaders(oroute.extra_headers)
+    if oroute.credit_time > 0 {
+        uaO.SetCreditTime(oroute.credit_time)
+    }
+    uaO.SetDeadCb(func() {
+        global_cac.release(cac_keys)
+        self.oDead()
+    })
+    uaO.SetLocalUA(sippy_header.NewSipUserAgent(self.global_config.GetMyUAName()))
+    if outbound_proxy != nil && self.source.String() != outbound_proxy.String() {
+        uaO.SetOutboundProxy(outbound_proxy)
     }
     var body sippy_types.MsgBody
+    if self.eTry.GetBody() != nil {
+        body = self.eTry.GetBody().GetCopy()
+        if oroute.allowed_pts_set {
+            filterPayloadTypes(body, oroute.allowed_pts)
+        }
+        if self.sdp_session_o != nil {
+            self.sdp_session_o.FixupVersion(body)
+        }
+    }
     if self.rtp_proxy_session != nil && oroute.rtpp {
-        self.uaO.SetOnLocalSdpChange(self.rtp_proxy_session.OnCallerSdpChange)
-        self.uaO.SetOnRemoteSdpChange(self.rtp_proxy_session.OnCalleeSdpChange)
-        self.rtp_proxy_session.SetCallerRaddress(nh_address)
-        if self.eTry.GetBody() != nil {
-            body = self.eTry.GetBody().GetCopy()
+        uaO.SetOnLocalSdpChange(self.rtp_proxy_session.OnCallerSdpChange)
+        uaO.SetOnRemoteSdpChange(func(body sippy_types.MsgBody, f func(sippy_types.MsgBody)) error {
+            if _, ok := self.forks[uaO]; ! ok {
+                // Don't let the leg that lost the race to steal the media
+                f(body)
+                return nil
+            }
+            return self.rtp_proxy_session.OnCalleeSdpChange(body, f)
+        })
+        if nh_address.ParseIP() != nil {
+            self.rtp_proxy_session.SetCallerRaddress(nh_address)
         }
         self.proxied = true
     }
-    self.uaO.SetKaInterval(self.global_config.keepalive_orig)
-    //if oroute.params.has_key('group_timeout') {
-    //    timeout, skipto = oroute.params['group_timeout']
-    //    Timeout(self.group_expires, timeout, 1, skipto)
-    //}
-    //if self.global_config.getdefault('hide_call_id', false) {
-    //    cId = SipCallId(md5(str(cId)).hexdigest() + ("-b2b_%d" % oroute.rnum))
-    //} else {
-        cId := sippy_header.NewSipCallIdFromString(self.eTry.GetSipCallId().CallId + fmt.Sprintf("-b2b_%d", oroute.rnum))
-    //}
+    uaO.SetKaInterval(self.global_config.keepalive_orig)
+    uaO.SetKaMethod(self.global_config.keepalive_orig_method)
+    uaO.SetKaMaxFailures(self.global_config.keepalive_orig_failures)
+    uaO.SetSessionTimer(self.global_config.session_expires_orig, self.global_config.session_min_se)
     caller_name := oroute.caller_name
     if caller_name == "" {
         caller_name = self.caller_name
     }
-    event := sippy.NewCCEventTry(cId, self.eTry.GetSipCiscoGUID(), oroute.cli, cld, body, self.eTry.GetSipAuthorization(), caller_name, nil, "")
-    //if self.eTry.max_forwards != nil {
-    //    event.max_forwards = self.eTry.max_forwards - 1
-    //    if event.max_forwards <= 0 {
-    //        self.uaA.RecvEvent(sippy.NewCCEventFail(483, "Too Many Hops", nil, ""))
-    //        self.state = CCStateDead
-    //        return
-    //    }
-    //}
+    event := sippy.NewCCEventTry(cId, self.eTry.GetSipCiscoGUID(), cli, cld, body, self.eTry.GetSipAuthorization(), caller_name, nil, "")
+    if self.eTry.GetMaxForwards() != nil {
+        // The hop count has been checked upon the INVITE receipt
+        max_forwards, err := self.eTry.GetMaxForwards().GetBody()
+        if err == nil {
+            event.SetMaxForwards(sippy_header.NewSipMaxForwards(max_forwards.Number - 1))
+        }
+    }
+    for _, hf := range self.loop_headers {
+        event.AppendExtraHeader(hf.GetCopyAsIface())
+    }
+    event.AppendExtraHeader(sippy_header.NewSipGenericHF(LOOP_HEADER, self.global_config.loop_id))
     event.SetReason(self.eTry.GetReason())
-    self.uaO.RecvEvent(event)
+    uaO.RecvEvent(event)
+}
+
+// Builds the accounting of a call leg out of the enabled backends.
+// Returns nil when the accounting is disabled.
+func (self *callController) newAccounting(leg, cli, cld, sip_cid, remote_ip string) accounting {
+    var accts multiAccounting
+    if self.global_config.acct_enable {
+        racct := NewRadiusAccounting(self.global_config, leg, self.lock,
+          self.global_config.alive_acct_int, self.global_config.start_acct_enable)
+        racct.ms_precision = self.global_config.precise_acct
+        racct.setParams(self.username, cli, cld, self.cGUID, sip_cid, remote_ip)
+        accts = append(accts, racct)
+    }
+    if global_cdr_writer != nil {
+        cacct := NewCdrAccounting(self.global_config, global_cdr_writer, leg)
+        cacct.setParams(self.username, cli, cld, self.cGUID, sip_cid, self.cId.CallId, remote_ip)
+        accts = append(accts, cacct)
+    }
+    switch len(accts) {
+    case 0:
+        return nil
+    case 1:
+        return accts[0]
+    }
+    return accts
 }
 
 func (self *callController) disconnect(rtime *sippy_time.MonoTime) {
@@ -372,34 +733,52 @@ func (self *callController) disconnect(rtime *sippy_time.MonoTime) {
 */
 func (self *callController) aConn(rtime *sippy_time.MonoTime, origin string) {
     self.state = CCStateConnected
-    //self.acctA.conn(rtime, origin)
+    self.cancelGroupTimer()
+    if self.acctA != nil {
+        self.acctA.conn(self.uaA, rtime, origin)
+    }
 }
 
 func (self *callController) aFail(rtime *sippy_time.MonoTime, origin string, result int) {
-    self.aDisc(rtime, origin, result, nil)
+    if self.acctA != nil {
+        self.acctA.fail(self.uaA, rtime, origin, result)
+    }
+    self.aTerminated()
 }
 
 func (self *callController) aDisc(rtime *sippy_time.MonoTime, origin string, result int, inreq sippy_types.SipRequest) {
+    if self.acctA != nil {
+        self.acctA.disc(self.uaA, rtime, origin, result)
+    }
+    self.aTerminated()
+}
+
+func (self *callController) aTerminated() {
     //if self.state == CCStateWaitRoute && self.auth_proc != nil {
     //    self.auth_proc.cancel()
     //    self.auth_proc = nil
     //}
-    if self.uaO != nil && self.state != CCStateDead {
+    self.cancelGroupTimer()
+    self.releaseAdmission()
+    if len(self.forks) > 0 && self.state != CCStateDead {
         self.state = CCStateDisconnecting
     } else {
         self.state = CCStateDead
     }
-    //if self.acctA != nil {
-    //    self.acctA.disc(ua, rtime, origin, result)
-    //}
     if self.rtp_proxy_session != nil {
         self.rtp_proxy_session.Delete()
         self.rtp_proxy_session = nil
     }
 }
 
+// Gives the call slots back once the ingress call leg is over.
+func (self *callController) releaseAdmission() {
+    global_cac.release(self.cac_keys)
+    self.cac_keys = nil
+}
+
 func (self *callController) aDead() {
-    if self.uaO == nil || self.uaO.GetState() == sippy_types.UA_STATE_DEAD {
+    if self.legsDead() {
         if global_cmap.debug_mode {
             println("garbadge collecting", self)
         }
@@ -410,7 +789,7 @@ func (self *callController) aDead() {
 }
 
 func (self *callController) oDead() {
-    if self.uaA.GetState() == sippy_types.UA_STATE_DEAD {
+    if self.uaA.GetState() == sippy_types.UA_STATE_DEAD && self.legsDead() {
         if global_cmap.debug_mode {
             println("garbadge collecting", self)
         }
@@ -420,19 +799,12 @@ func (self *callController) oDead() {
     }
 }
 
-/*
-    def group_expires(self, skipto):
-        if self.state != CCStateARComplete || len(self.routes) == 0 || self.routes[0][0] > skipto || \
-          (! isinstance(self.uaA.state, UasStateTrying) && ! isinstance(self.uaA.state, UasStateRinging)):
-            return
-        // When the last group in the list has timeouted don't disconnect
-        // the current attempt forcefully. Instead, make sure that if the
-        // current originate call leg fails no more routes will be
-        // processed.
-        if skipto == self.routes[-1][0] + 1:
-            self.routes = []
-            return
-        while self.routes[0][0] != skipto:
-            self.routes.pop(0)
-        self.uaO.disconnect()
-        */
+func (self *callController) legsDead() bool {
+    for _, uaO := range self.legs {
+        if uaO.GetState() != sippy_types.UA_STATE_DEAD {
+            return false
+        }
+    }
+    return true
+}
+
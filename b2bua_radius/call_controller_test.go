@@ -0,0 +1,268 @@
+package main
+
+import (
+    "sync"
+    "testing"
+    "time"
+
+    "sippy"
+    "sippy/headers"
+    "sippy/net"
+    "sippy/time"
+    "sippy/types"
+)
+
+type test_cc_ua struct {
+    sippy_types.UA
+    state           sippy_types.UaStateID
+    disconnected    bool
+    events          []sippy_types.CCEvent
+}
+
+func (self *test_cc_ua) GetState() sippy_types.UaStateID { return self.state }
+func (self *test_cc_ua) Disconnect(*sippy_time.MonoTime, string) { self.disconnected = true }
+func (self *test_cc_ua) RecvEvent(event sippy_types.CCEvent) { self.events = append(self.events, event) }
+
+func (self *test_cc_ua) gotDisconnect() bool {
+    for _, event := range self.events {
+        if _, ok := event.(*sippy.CCEventDisconnect); ok {
+            return true
+        }
+    }
+    return false
+}
+
+// Builds the call controller in the middle of the parallel forking
+func testForkingCC(nforks int) (*callController, *test_cc_ua, []*test_cc_ua) {
+    uaA := &test_cc_ua{ state : sippy_types.UAS_STATE_RINGING }
+    cc := &callController{
+        state           : CCStateARComplete,
+        uaA             : uaA,
+        forks           : make(map[sippy_types.UA]*B2BRoute),
+        global_config   : &myConfigParser{},
+        sdp_session     : sippy.NewSdpSession(),
+    }
+    forks := make([]*test_cc_ua, nforks)
+    for i := range forks {
+        forks[i] = &test_cc_ua{ state : sippy_types.UAC_STATE_RINGING }
+        cc.forks[forks[i]] = &B2BRoute{ rnum : i + 1, parallel : i > 0 }
+    }
+    return cc, uaA, forks
+}
+
+func testRoutes(rnums ...int) []*B2BRoute {
+    routes := make([]*B2BRoute, len(rnums))
+    for i, rnum := range rnums {
+        routes[i] = &B2BRoute{ rnum : rnum }
+    }
+    return routes
+}
+
+func Test_GroupExpires(t *testing.T) {
+    tests := []struct {
+        rnums       []int
+        skipto      int
+        remaining   []int
+        disc        bool
+    }{
+        { []int{ 2, 3, 4 }, 3, []int{ 3, 4 }, true },
+        { []int{ 2, 3, 4 }, 2, []int{ 2, 3, 4 }, true },
+        // The route 3 has been dropped
+        { []int{ 2, 4, 5 }, 3, []int{ 4, 5 }, true },
+        { []int{ 2, 4 }, 5, []int{}, false },
+        { []int{ 2, 4 }, 7, []int{}, false },
+        // The group timer is stale
+        { []int{ 4, 5 }, 3, []int{ 4, 5 }, false },
+    }
+    for _, tc := range tests {
+        uaO := &test_cc_ua{ state : sippy_types.UAC_STATE_RINGING }
+        cc := &callController{
+            state   : CCStateARComplete,
+            uaA     : &test_cc_ua{ state : sippy_types.UAS_STATE_RINGING },
+            uaO     : uaO,
+            routes  : testRoutes(tc.rnums...),
+            forks   : map[sippy_types.UA]*B2BRoute{ uaO : &B2BRoute{ rnum : 1 } },
+        }
+        cc.groupExpires(tc.skipto)
+        if len(cc.routes) != len(tc.remaining) {
+            t.Errorf("%v skipto %d: %d routes left, expected %v", tc.rnums, tc.skipto, len(cc.routes), tc.remaining)
+            continue
+        }
+        for i, oroute := range cc.routes {
+            if oroute.rnum != tc.remaining[i] {
+                t.Errorf("%v skipto %d: route %d left, expected %v", tc.rnums, tc.skipto, oroute.rnum, tc.remaining)
+            }
+        }
+        if uaO.disconnected != tc.disc {
+            t.Errorf("%v skipto %d: disconnected %v, expected %v", tc.rnums, tc.skipto, uaO.disconnected, tc.disc)
+        }
+    }
+}
+
+type test_cc_tr struct {
+    sippy_types.ClientTransaction
+}
+
+func (self *test_cc_tr) SetOutboundProxy(*sippy_net.HostPort) {}
+func (self *test_cc_tr) Cancel(...sippy_header.SipHeader) {}
+
+// Swallows the requests of the egress legs
+type test_cc_tm struct {
+    sippy_types.SipTransactionManager
+    targets     []string
+    dests       []string
+}
+
+func (self *test_cc_tm) RegConsumer(sippy_types.UA, string) {}
+func (self *test_cc_tm) UnregConsumer(sippy_types.UA, string) {}
+func (self *test_cc_tm) BeginClientTransaction(sippy_types.SipRequest, sippy_types.ClientTransaction) {}
+
+func (self *test_cc_tm) CreateClientTransaction(req sippy_types.SipRequest, resp_receiver sippy_types.ResponseReceiver, session_lock sync.Locker, laddress *sippy_net.HostPort, userv sippy_net.Transport, req_out_cb func(sippy_types.SipRequest)) (sippy_types.ClientTransaction, error) {
+    self.targets = append(self.targets, req.GetRURI().String())
+    self.dests = append(self.dests, req.GetTargetProto() + ":" + req.GetTarget().String())
+    return &test_cc_tr{}, nil
+}
+
+// Builds the call controller ready to place the egress legs
+func testRoutingCC(t *testing.T, sroutes string) (*callController, *test_cc_tm) {
+    global_config := testConfig(t)
+    routes, err := NewB2BRouteSet(sroutes, global_config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, oroute := range routes {
+        oroute.customize(i + 1, "123", "456", 0, nil, 0)
+    }
+    tm := &test_cc_tm{}
+    cc := &callController{
+        state           : CCStateARComplete,
+        uaA             : &test_cc_ua{ state : sippy_types.UAS_STATE_RINGING },
+        forks           : make(map[sippy_types.UA]*B2BRoute),
+        global_config   : global_config,
+        source          : sippy_net.NewHostPort("192.168.0.1", "5060"),
+        routes          : routes,
+        lock            : new(sync.Mutex),
+        sdp_session     : sippy.NewSdpSession(),
+        sip_tm          : tm,
+        eTry            : sippy.NewCCEventTry(sippy_header.NewSipCallIdFromString("abc@192.168.0.1"), nil, "456", "123", nil, nil, "", nil, ""),
+    }
+    return cc, tm
+}
+
+func Test_GroupTimeoutSequential(t *testing.T) {
+    saved_cac := global_cac
+    defer func() { global_cac = saved_cac }()
+    global_cac = NewCallAdmission(&myConfigParser{})
+
+    // The routes 1 to 3 are under the group timer, the route 4 is next
+    cc, tm := testRoutingCC(t, "10.0.0.1;gt=1,3|10.0.0.2|10.0.0.3|10.0.0.4")
+    cc.routes[0].group_timeout = 100 * time.Millisecond
+    cc.lock.Lock()
+    cc.placeNextGroup()
+    if cc.group_timer == nil || cc.uaO == nil || cc.forks[cc.uaO].rnum != 1 {
+        cc.lock.Unlock()
+        t.Fatal("The first route has not been placed with the group timer")
+    }
+    // The failure of the first route must not stop the group timer
+    cc.RecvEvent(sippy.NewCCEventFail(486, "Busy Here", nil, ""), cc.uaO)
+    uaO := cc.uaO
+    if uaO == nil || cc.forks[uaO].rnum != 2 || cc.group_timer == nil {
+        cc.lock.Unlock()
+        t.Fatal("The second route has been placed without the group timer")
+    }
+    cc.lock.Unlock()
+
+    time.Sleep(300 * time.Millisecond)
+    cc.lock.Lock()
+    defer cc.lock.Unlock()
+    // The disconnected leg brings in the route skipped to
+    if _, ok := cc.forks[uaO]; ok || len(cc.routes) != 0 || cc.uaO == nil || cc.forks[cc.uaO].rnum != 4 {
+        t.Fatalf("The call has not jumped to the route 4: %d routes left", len(cc.routes))
+    }
+    if len(tm.targets) != 3 || tm.targets[2] != "sip:123@10.0.0.4:5060" || cc.group_timer != nil {
+        t.Fatalf("Unexpected routes tried: %v", tm.targets)
+    }
+}
+
+func Test_ForkingSelectedLegFails(t *testing.T) {
+    cc, uaA, forks := testForkingCC(3)
+    cc.RecvEvent(sippy.NewCCEventRing(183, "Session Progress", nil, nil, ""), forks[1])
+    if cc.uaO != forks[1] || len(uaA.events) != 1 {
+        t.Fatal("The ringing leg has not been selected")
+    }
+    cc.RecvEvent(sippy.NewCCEventFail(486, "Busy Here", nil, ""), forks[1])
+    cc.RecvEvent(sippy.NewCCEventFail(480, "Temporarily Unavailable", nil, ""), forks[2])
+    if cc.uaO != nil || len(uaA.events) != 1 {
+        t.Fatal("The failures have not been held back")
+    }
+    // The last leg standing must be selected once it speaks up
+    cc.RecvEvent(sippy.NewCCEventRing(180, "Ringing", nil, nil, ""), forks[0])
+    if cc.uaO != forks[0] || len(uaA.events) != 2 {
+        t.Fatal("The remaining leg has not been selected")
+    }
+    cc.RecvEvent(sippy.NewCCEventConnect(200, "OK", nil, nil, ""), forks[0])
+    if _, ok := uaA.events[len(uaA.events) - 1].(*sippy.CCEventConnect); ! ok {
+        t.Fatal("The answer has not been passed to the caller")
+    }
+}
+
+func Test_ForkingCancel(t *testing.T) {
+    cc, _, forks := testForkingCC(2)
+    cc.RecvEvent(sippy.NewCCEventRing(180, "Ringing", nil, nil, ""), forks[0])
+    cc.RecvEvent(sippy.NewCCEventFail(486, "Busy Here", nil, ""), forks[0])
+    // The caller gives up before the remaining leg has been selected
+    cc.RecvEvent(sippy.NewCCEventDisconnect(nil, nil, ""), cc.uaA)
+    if ! forks[1].gotDisconnect() {
+        t.Fatal("The remaining leg has not been cancelled")
+    }
+
+    cc, _, forks = testForkingCC(3)
+    cc.RecvEvent(sippy.NewCCEventRing(180, "Ringing", nil, nil, ""), forks[2])
+    cc.RecvEvent(sippy.NewCCEventDisconnect(nil, nil, ""), cc.uaA)
+    for i, uaO := range forks {
+        if ! uaO.gotDisconnect() {
+            t.Fatalf("The leg %d has not been cancelled", i)
+        }
+    }
+}
+
+func Test_PlaceNextGroupNoneAdmitted(t *testing.T) {
+    saved_cac := global_cac
+    defer func() { global_cac = saved_cac }()
+    global_cac = NewCallAdmission(&myConfigParser{ cac_route_max_calls : 1 })
+    // The route "busy" is at its limit
+    global_cac.admit(global_cac.keys(CAC_ROUTE, "busy"))
+
+    tests := []struct {
+        fork_fail   sippy_types.CCEvent
+        route       *B2BRoute
+        scode       int
+    }{
+        { sippy.NewCCEventFail(486, "Busy Here", nil, ""), &B2BRoute{ rnum : 2, hostport : "busy" }, 486 },
+        { nil, &B2BRoute{ rnum : 2, hostport : "busy" }, 503 },
+        { sippy.NewCCEventFail(404, "Not Found", nil, ""), &B2BRoute{ rnum : 2, hostport : "idle", reg : "trunk" }, 404 },
+        { nil, &B2BRoute{ rnum : 2, hostport : "idle", reg : "trunk" }, 503 },
+    }
+    for i, tc := range tests {
+        uaA := &test_cc_ua{ state : sippy_types.UAS_STATE_RINGING }
+        cc := &callController{
+            state           : CCStateARComplete,
+            uaA             : uaA,
+            global_config   : &myConfigParser{},
+            fork_fail       : tc.fork_fail,
+            routes          : []*B2BRoute{ tc.route },
+        }
+        cc.placeNextGroup()
+        if cc.state != CCStateDead {
+            t.Errorf("%d: the call is not dead", i)
+        }
+        if len(uaA.events) != 1 {
+            t.Errorf("%d: %d events sent to the caller", i, len(uaA.events))
+            continue
+        }
+        ev_fail, ok := uaA.events[0].(*sippy.CCEventFail)
+        if ! ok || ev_fail.GetScode() != tc.scode {
+            t.Errorf("%d: %d expected, got %v", i, tc.scode, uaA.events[0])
+        }
+    }
+}
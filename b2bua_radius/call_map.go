@@ -54,6 +54,10 @@ type callMap struct {
     proxy           sippy_types.StatefulProxy
     cc_id           int64
     cc_id_lock      sync.Mutex
+    config_lock     sync.Mutex
+    rtpp_refs       map[sippy_types.RtpProxyClient]int
+    rtpp_retired    map[sippy_types.RtpProxyClient]bool
+    acl_edits       []aclEdit
 }
 
 /*
@@ -72,6 +76,8 @@ func NewCallMap(global_config *myConfigParser) *callMap {
         gc_timeout      : time.Minute,
         debug_mode      : false,
         safe_restart    : false,
+        rtpp_refs       : make(map[sippy_types.RtpProxyClient]int),
+        rtpp_retired    : make(map[sippy_types.RtpProxyClient]bool),
     }
     go func() {
         sighup_ch := make(chan os.Signal, 1)
@@ -85,7 +91,7 @@ func NewCallMap(global_config *myConfigParser) *callMap {
         for {
             select {
             case <-sighup_ch:
-                self.discAll(syscall.SIGHUP)
+                self.reloadConfig(syscall.SIGHUP)
             case <-sigusr2_ch:
                 self.toggleDebug()
             case <-sigprof_ch:
@@ -105,9 +111,10 @@ func NewCallMap(global_config *myConfigParser) *callMap {
 }
 
 func (self *callMap) OnNewDialog(req sippy_types.SipRequest, sip_t sippy_types.ServerTransaction) (sippy_types.UA, sippy_types.RequestReceiver, sippy_types.SipResponse) {
-    to_body, err := req.GetTo().GetBody(self.global_config)
+    global_config, static_routes := self.getConfig()
+    to_body, err := req.GetTo().GetBody(global_config)
     if err != nil {
-        self.global_config.ErrorLogger().Error("CallMap::OnNewDialog: #1: " + err.Error())
+        global_config.ErrorLogger().Error("CallMap::OnNewDialog: #1: " + err.Error())
         return nil, nil, req.GenResponse(500, "Internal Server Error", nil, nil)
     }
     //except Exception as exception:
@@ -133,38 +140,62 @@ func (self *callMap) OnNewDialog(req sippy_types.SipRequest, sip_t sippy_types.S
             via, err = vias[0].GetBody()
         }
         if err != nil {
-            self.global_config.ErrorLogger().Error("CallMap::OnNewDialog: #2: " + err.Error())
+            global_config.ErrorLogger().Error("CallMap::OnNewDialog: #2: " + err.Error())
             return nil, nil, req.GenResponse(500, "Internal Server Error", nil, nil)
         }
-        remote_ip := via.GetTAddr(self.global_config).Host
+        remote_ip := via.GetTAddr(global_config).Host
         source := req.GetSource()
 
         // First check if request comes from IP that
         // we want to accept our traffic from
-        if ! self.global_config.checkIP(source.Host.String())  {
+        if ! global_config.checkIP(source.Host.String())  {
             return nil, nil, req.GenResponse(403, "Forbidden", nil, nil)
         }
-/*
+        loop_headers, looped := loopHeaders(req, global_config.loop_id)
+        if looped {
+            return nil, nil, req.GenResponse(482, "Loop Detected", nil, nil)
+        }
+        if ! hasHopsLeft(req) {
+            return nil, nil, req.GenResponse(483, "Too Many Hops", nil, nil)
+        }
         var challenge *sippy_header.SipWWWAuthenticate
-        if self.global_config.auth_enable {
+        if global_config.auth_enable {
             // Prepare challenge if no authorization header is present.
             // Depending on configuration, we might try remote ip auth
             // first and then challenge it or challenge immediately.
-            if self.global_config["digest_auth"] && req.countHFs("authorization") == 0 {
-                challenge = NewSipWWWAuthenticate()
-                challenge.getBody().realm = req.getRURI().host
+            if global_config.digest_auth && req.GetSipAuthorization() == nil {
+                challenge = sippy_header.NewSipWWWAuthenticateWithRealm(req.GetRURI().Host.String())
             }
             // Send challenge immediately if digest is the
             // only method of authenticating
-            if challenge != nil && self.global_config.getdefault("digest_auth_only", false) {
-                resp = req.GenResponse(401, "Unauthorized")
-                resp.appendHeader(challenge)
-                return resp, nil, nil
+            if challenge != nil && global_config.digest_auth_only {
+                resp := req.GenResponse(401, "Unauthorized", nil, nil)
+                resp.AppendHeader(challenge)
+                return nil, nil, resp
             }
         }
-*/
+        auth_username := ""
+        if global_digest_auth != nil {
+            var resp sippy_types.SipResponse
+            auth_username, resp = global_digest_auth.Authenticate(req)
+            if resp != nil {
+                return nil, nil, resp
+            }
+        }
+        cac_keys := append(global_cac.keys(CAC_GLOBAL, ""), global_cac.keys(CAC_SOURCE, source.Host.String())...)
+        if ! global_cac.admit(cac_keys) {
+            scode, reason, eh := cacReject(global_config)
+            resp := req.GenResponse(scode, reason, nil, nil)
+            for _, h := range eh {
+                resp.AppendHeader(h)
+            }
+            return nil, nil, resp
+        }
         pass_headers := []sippy_header.SipHeader{}
-        for _, header := range self.global_config.pass_headers {
+        for _, header := range global_config.pass_headers {
+            if global_config.topology_hiding && revealsTopology(header) {
+                continue
+            }
             hfs := req.GetHFs(header)
             pass_headers = append(pass_headers, hfs...)
         }
@@ -172,14 +203,32 @@ func (self *callMap) OnNewDialog(req sippy_types.SipRequest, sip_t sippy_types.S
         id := self.cc_id
         self.cc_id++
         self.cc_id_lock.Unlock()
-        cc := NewCallController(id, remote_ip, source, self.global_config, pass_headers, self.sip_tm)
-        //cc.challenge = challenge
+        cc := NewCallController(id, remote_ip, source, global_config, pass_headers, self.sip_tm)
+        cc.challenge = challenge
+        cc.loop_headers = loop_headers
+        cc.cac_keys = cac_keys
+        cc.auth_username = auth_username
+        cc.local_domain = strings.ToLower(req.GetRURI().Host.String())
+        cc.static_routes = static_routes
+        cc.rtp_proxy_clients = self.holdRtpProxyClients()
+        if global_http_routing != nil {
+            for _, header := range global_config.http_route_headers {
+                cc.route_headers = append(cc.route_headers, req.GetHFs(header)...)
+            }
+        }
         //rval := cc.uaA.RecvRequest(req, sip_t)
         self.ccmap_lock.Lock()
         self.ccmap[id] = cc
         self.ccmap_lock.Unlock()
         return cc.uaA, cc.uaA, nil
     }
+    if req.GetMethod() == "REGISTER" && global_registrar != nil {
+        if ! global_config.checkIP(req.GetSource().Host.String()) {
+            return nil, nil, req.GenResponse(403, "Forbidden", nil, nil)
+        }
+        // The registrar authenticates the request itself
+        return nil, global_registrar, nil
+    }
     if self.proxy != nil && (req.GetMethod() == "REGISTER" || req.GetMethod() == "SUBSCRIBE") {
         return nil, self.proxy, nil
     }
@@ -205,6 +254,73 @@ func (self *callMap) discAll(signum syscall.Signal) {
     }
 }
 
+// Returns the configuration and the static routes for the new call.
+func (self *callMap) getConfig() (*myConfigParser, []*B2BRoute) {
+    self.config_lock.Lock()
+    defer self.config_lock.Unlock()
+    return self.global_config, global_static_routes
+}
+
+// Returns the RTPproxy clients for the new call. The clients are held
+// until the call is dropped so that the ones removed by a reload are
+// not shut down under the calls still using them.
+func (self *callMap) holdRtpProxyClients() []sippy_types.RtpProxyClient {
+    self.config_lock.Lock()
+    defer self.config_lock.Unlock()
+    for _, rtpp := range global_rtp_proxy_clients {
+        self.rtpp_refs[rtpp]++
+    }
+    return global_rtp_proxy_clients
+}
+
+func (self *callMap) releaseRtpProxyClients(rtp_proxy_clients []sippy_types.RtpProxyClient) {
+    self.config_lock.Lock()
+    defer self.config_lock.Unlock()
+    for _, rtpp := range rtp_proxy_clients {
+        self.rtpp_refs[rtpp]--
+        if self.rtpp_refs[rtpp] > 0 {
+            continue
+        }
+        delete(self.rtpp_refs, rtpp)
+        if self.rtpp_retired[rtpp] {
+            delete(self.rtpp_retired, rtpp)
+            rtpp.Shutdown()
+        }
+    }
+}
+
+// Replaces the RTPproxy clients for the new calls. The clients no
+// longer configured are shut down once the last call using them is
+// over. Must be called with the config_lock held.
+func (self *callMap) swapRtpProxyClients(rtp_proxy_clients []sippy_types.RtpProxyClient) {
+    keep := make(map[sippy_types.RtpProxyClient]bool)
+    for _, rtpp := range rtp_proxy_clients {
+        keep[rtpp] = true
+        delete(self.rtpp_retired, rtpp)
+    }
+    for _, rtpp := range global_rtp_proxy_clients {
+        switch {
+        case keep[rtpp]:
+        case self.rtpp_refs[rtpp] > 0:
+            self.rtpp_retired[rtpp] = true
+        default:
+            rtpp.Shutdown()
+        }
+    }
+    global_rtp_proxy_clients = rtp_proxy_clients
+}
+
+func (self *callMap) reloadConfig(signum syscall.Signal) {
+    println(fmt.Sprintf("Signal %d received, reloading the configuration", signum))
+    res, err := self.reload()
+    if err != nil {
+        global_config, _ := self.getConfig()
+        global_config.ErrorLogger().Error("Configuration reload failed: " + err.Error())
+        return
+    }
+    print(res)
+}
+
 func (self *callMap) toggleDebug() {
     if self.debug_mode {
         println("Signal received, toggling extra debug output off")
@@ -224,7 +340,9 @@ func (self *callMap) GClector() {
     if self.debug_mode {
         //println(self.global_config["_sip_tm"].tclient, self.global_config["_sip_tm"].tserver)
         for _, cc := range self.ccmap {
-            println(cc.uaA.GetStateName(), cc.uaO.GetStateName())
+            for _, uaO := range cc.legs {
+                println(cc.uaA.GetStateName(), uaO.GetStateName())
+            }
         }
     //} else {
     //    fmt.Printf("[%d]: %d client, %d server transactions in memory\n",
@@ -324,7 +442,10 @@ func (self *callMap) recvCommand(clim sippy_cli.CLIManagerIface, data string) {
         dlist := []*callController{}
         self.ccmap_lock.Lock()
         for _, cc := range self.ccmap {
-            if cc.cId.CallId != args[0] {
+            cc.lock.Lock()
+            found := cc.hasCallId(args[0])
+            cc.lock.Unlock()
+            if ! found {
                 continue
             }
             dlist = append(dlist, cc)
@@ -362,11 +483,33 @@ func (self *callMap) recvCommand(clim sippy_cli.CLIManagerIface, data string) {
             if cc.state == CCStateConnected {
                 cc.disconnect(ts)
             } else if cc.state == CCStateARComplete {
-                cc.uaO.Disconnect(ts, "")
+                for uaO := range cc.forks {
+                    uaO.Disconnect(ts, "")
+                }
             }
         }
         clim.Send("OK\n")
         return
+    case "reload":
+        res, err := self.reload()
+        if err != nil {
+            clim.Send("ERROR: " + err.Error() + "\n")
+            return
+        }
+        clim.Send(res)
+        return
+    case "acl":
+        clim.Send(self.aclCommand(args))
+        return
+    case "cac":
+        clim.Send(global_cac.String())
+        return
+    case "reg":
+        clim.Send(global_trunk_regs.String())
+        return
+    case "loc":
+        clim.Send(locationString())
+        return
     default:
         clim.Send("ERROR: unknown command\n")
     }
@@ -374,6 +517,11 @@ func (self *callMap) recvCommand(clim sippy_cli.CLIManagerIface, data string) {
 
 func (self *callMap) DropCC(cc_id int64) {
     self.ccmap_lock.Lock()
+    cc, ok := self.ccmap[cc_id]
     delete(self.ccmap, cc_id)
     self.ccmap_lock.Unlock()
+    if ok {
+        cc.releaseAdmission()
+        self.releaseRtpProxyClients(cc.rtp_proxy_clients)
+    }
 }
@@ -0,0 +1,186 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    CDR_FORMAT_CSV      = "csv"
+    CDR_FORMAT_JSONL    = "jsonl"
+)
+
+var cdrCsvHeader = []string{
+    "leg", "username", "cli", "cld", "call_id", "orig_call_id", "h323_conf_id", "remote_ip",
+    "setup_time", "connect_time", "disconnect_time", "duration", "pdd", "disconnect_origin",
+    "sip_code", "q850_cause", "user_agent",
+}
+
+// Writes the call detail records into a local file either as CSV or
+// as JSON lines. The file is rotated when it grows over max_size bytes
+// or gets older than max_age, the rotated file gets the time stamp
+// appended to its name.
+type cdrFileWriter struct {
+    lock        sync.Mutex
+    fname       string
+    format      string
+    max_size    int64
+    max_age     time.Duration
+    f           *os.File
+    size        int64
+    opened      time.Time
+}
+
+func NewCdrFileWriter(fname, format string, max_size int64, max_age time.Duration) (*cdrFileWriter, error) {
+    if format != CDR_FORMAT_CSV && format != CDR_FORMAT_JSONL {
+        return nil, errors.New("unsupported CDR format: " + format)
+    }
+    self := &cdrFileWriter{
+        fname       : fname,
+        format      : format,
+        max_size    : max_size,
+        max_age     : max_age,
+    }
+    err := self.open()
+    if err != nil {
+        return nil, err
+    }
+    return self, nil
+}
+
+func (self *cdrFileWriter) open() error {
+    f, err := os.OpenFile(self.fname, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+    if err != nil {
+        return err
+    }
+    st, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+    self.f = f
+    self.size = st.Size()
+    self.opened = time.Now()
+    return nil
+}
+
+func (self *cdrFileWriter) rotate() error {
+    self.f.Close()
+    self.f = nil
+    err := os.Rename(self.fname, self.fname + "." + time.Now().Format("20060102150405.000"))
+    if err != nil {
+        return err
+    }
+    return self.open()
+}
+
+func (self *cdrFileWriter) needRotate() bool {
+    if self.size == 0 {
+        return false
+    }
+    if self.max_size > 0 && self.size >= self.max_size {
+        return true
+    }
+    if self.max_age > 0 && time.Since(self.opened) >= self.max_age {
+        return true
+    }
+    return false
+}
+
+func (self *cdrFileWriter) WriteCDR(rec *cdrRecord) error {
+    var buf []byte
+    var err error
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if self.f == nil {
+        // the previous rotation has failed
+        if err = self.open(); err != nil {
+            return err
+        }
+    } else if self.needRotate() {
+        if err = self.rotate(); err != nil {
+            return err
+        }
+    }
+    switch self.format {
+    case CDR_FORMAT_JSONL:
+        buf, err = json.Marshal(rec)
+        if err != nil {
+            return err
+        }
+        buf = append(buf, '\n')
+    default:
+        buf = self.formatCsv(rec)
+    }
+    n, err := self.f.Write(buf)
+    self.size += int64(n)
+    return err
+}
+
+func (self *cdrFileWriter) formatCsv(rec *cdrRecord) []byte {
+    buf := &bytes.Buffer{}
+    w := csv.NewWriter(buf)
+    if self.size == 0 {
+        w.Write(cdrCsvHeader)
+    }
+    connect_time := ""
+    if rec.ConnectTime != nil {
+        connect_time = formatCdrTime(*rec.ConnectTime)
+    }
+    w.Write([]string{
+        rec.Leg, rec.Username, rec.CLI, rec.CLD, rec.CallId, rec.OrigCallId, rec.H323ConfId, rec.RemoteIP,
+        formatCdrTime(rec.SetupTime), connect_time, formatCdrTime(rec.DisconnectTime),
+        fmt.Sprintf("%.3f", rec.Duration), fmt.Sprintf("%.3f", rec.PDD), rec.Origin,
+        strconv.Itoa(rec.SipCode), strconv.Itoa(rec.Q850Cause), rec.UserAgent,
+    })
+    w.Flush()
+    return buf.Bytes()
+}
+
+func (self *cdrFileWriter) Close() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if self.f != nil {
+        self.f.Close()
+        self.f = nil
+    }
+}
+
+func formatCdrTime(t time.Time) string {
+    return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
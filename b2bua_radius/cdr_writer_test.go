@@ -0,0 +1,93 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func Test_CdrFileWriter(t *testing.T) {
+    rec := &cdrRecord{
+        Leg         : "A",
+        CLI         : "alice",
+        CLD         : "bob, jr",
+        CallId      : "abc@1.2.3.4",
+        SetupTime   : time.Now(),
+        SipCode     : 200,
+    }
+    for _, tc := range []struct {
+        name        string
+        format      string
+        max_size    int64
+        max_age     time.Duration
+        age         time.Duration
+        nfiles      int
+        nlines      int
+    }{
+        { "csv", CDR_FORMAT_CSV, 0, 0, time.Hour, 1, 3 },
+        { "jsonl", CDR_FORMAT_JSONL, 0, 0, time.Hour, 1, 2 },
+        { "csv size", CDR_FORMAT_CSV, 1, 0, 0, 2, 2 },
+        { "jsonl size", CDR_FORMAT_JSONL, 1, 0, 0, 2, 1 },
+        { "csv big enough", CDR_FORMAT_CSV, 64 * 1024, 0, 0, 1, 3 },
+        { "csv age", CDR_FORMAT_CSV, 0, time.Minute, time.Hour, 2, 2 },
+        { "csv young", CDR_FORMAT_CSV, 0, time.Minute, 0, 1, 3 },
+    } {
+        dir := t.TempDir()
+        fname := filepath.Join(dir, "cdr.log")
+        w, err := NewCdrFileWriter(fname, tc.format, tc.max_size, tc.max_age)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err = w.WriteCDR(rec); err != nil {
+            t.Fatalf("%s: %s", tc.name, err.Error())
+        }
+        w.opened = w.opened.Add(-tc.age)
+        if err = w.WriteCDR(rec); err != nil {
+            t.Fatalf("%s: %s", tc.name, err.Error())
+        }
+        w.Close()
+        files, _ := filepath.Glob(filepath.Join(dir, "cdr.log*"))
+        if len(files) != tc.nfiles {
+            t.Errorf("%s: expected %d files, got %v", tc.name, tc.nfiles, files)
+        }
+        buf, _ := ioutil.ReadFile(fname)
+        lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+        if len(lines) != tc.nlines {
+            t.Errorf("%s: expected %d lines, got %q", tc.name, tc.nlines, string(buf))
+            continue
+        }
+        switch tc.format {
+        case CDR_FORMAT_CSV:
+            if lines[0] != strings.Join(cdrCsvHeader, ",") || ! strings.Contains(lines[1], `,"bob, jr",`) {
+                t.Errorf("%s: bad CSV: %q", tc.name, string(buf))
+            }
+        case CDR_FORMAT_JSONL:
+            var res cdrRecord
+            if err = json.Unmarshal([]byte(lines[0]), &res); err != nil || res.CLD != rec.CLD || res.ConnectTime != nil {
+                t.Errorf("%s: bad JSON: %q", tc.name, lines[0])
+            }
+        }
+    }
+}
+
+func Test_CdrFileWriterReopen(t *testing.T) {
+    fname := filepath.Join(t.TempDir(), "cdr.log")
+    if _, err := NewCdrFileWriter(fname, "xml", 0, 0); err == nil {
+        t.Fatal("the unknown format has been accepted")
+    }
+    for i := 0; i < 2; i++ {
+        w, err := NewCdrFileWriter(fname, CDR_FORMAT_CSV, 0, 0)
+        if err != nil {
+            t.Fatal(err)
+        }
+        w.WriteCDR(&cdrRecord{ Leg : "A" })
+        w.Close()
+    }
+    buf, _ := ioutil.ReadFile(fname)
+    if strings.Count(string(buf), cdrCsvHeader[0]) != 1 || strings.Count(string(buf), "\n") != 3 {
+        t.Fatalf("the CSV header is repeated in the appended file: %q", string(buf))
+    }
+}
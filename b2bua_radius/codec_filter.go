@@ -0,0 +1,80 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "strconv"
+    "strings"
+
+    "sippy/types"
+)
+
+// Removes the payload types that are not in the allowed list from every
+// RTP stream of the SDP offer along with their rtpmap and fmtp attributes.
+// The streams with no payload types left are rejected by setting the port
+// to zero. Returns false if the offer has no usable stream left.
+func filterPayloadTypes(body sippy_types.MsgBody, allowed_pts []int) (bool, error) {
+    if body == nil || len(allowed_pts) == 0 {
+        return true, nil
+    }
+    sdp, err := body.GetSdp()
+    if err != nil {
+        return false, err
+    }
+    allowed := make(map[string]bool)
+    for _, pt := range allowed_pts {
+        allowed[strconv.Itoa(pt)] = true
+    }
+    usable := false
+    for _, section := range sdp.GetSections() {
+        mbody := section.GetMHeader()
+        if mbody == nil || mbody.GetPort() == "0" {
+            continue
+        }
+        if ! strings.Contains(strings.ToUpper(mbody.GetTransport()), "RTP/") {
+            // Not an RTP stream, e.g. the T.38 fax
+            usable = true
+            continue
+        }
+        formats := []string{}
+        for _, format := range mbody.GetFormats() {
+            if allowed[format] {
+                formats = append(formats, format)
+            }
+        }
+        if len(formats) == 0 {
+            mbody.SetPort("0")
+            continue
+        }
+        if len(formats) < len(mbody.GetFormats()) {
+            section.SetFormats(formats)
+        }
+        usable = true
+    }
+    return usable, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "strings"
+    "testing"
+
+    "sippy"
+)
+
+const testSdp = "v=0\r\n" +
+  "o=- 1 1 IN IP4 1.2.3.4\r\n" +
+  "s=-\r\n" +
+  "c=IN IP4 1.2.3.4\r\n" +
+  "t=0 0\r\n" +
+  "m=audio 10000 RTP/AVP 0 8 18 101\r\n" +
+  "a=rtpmap:0 PCMU/8000\r\n" +
+  "a=rtpmap:8 PCMA/8000\r\n" +
+  "a=rtpmap:18 G729/8000\r\n" +
+  "a=fmtp:18 annexb=no\r\n" +
+  "a=rtpmap:101 telephone-event/8000\r\n" +
+  "a=fmtp:101 0-15\r\n" +
+  "a=sendrecv\r\n"
+
+func Test_FilterPayloadTypes(t *testing.T) {
+    video := "m=video 10002 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n"
+    image := "m=image 10004 udptl t38\r\n"
+    for _, tc := range []struct {
+        name        string
+        sdp         string
+        allowed     []int
+        usable      bool
+        formats     []string
+        absent      []string
+    }{
+        { "no filter", testSdp, nil, true, []string{ "0 8 18 101" }, nil },
+        { "filtered", testSdp, []int{ 8, 101 }, true, []string{ "8 101" }, []string{ "rtpmap:0 ", "rtpmap:18 ", "fmtp:18 " } },
+        { "all allowed", testSdp, []int{ 0, 8, 18, 101, 9 }, true, []string{ "0 8 18 101" }, nil },
+        { "none left", testSdp, []int{ 9 }, false, []string{ "" }, nil },
+        { "one stream left", testSdp + video, []int{ 96 }, true, []string{ "", "96" }, nil },
+        { "not RTP", testSdp + image, []int{ 9 }, true, []string{ "", "t38" }, nil },
+    } {
+        body := sippy.NewMsgBody(tc.sdp, "application/sdp")
+        usable, err := filterPayloadTypes(body, tc.allowed)
+        if err != nil || usable != tc.usable {
+            t.Errorf("%s: expected %v, got %v, %v", tc.name, tc.usable, usable, err)
+            continue
+        }
+        sdp, _ := body.GetSdp()
+        sections := sdp.GetSections()
+        if len(sections) != len(tc.formats) {
+            t.Errorf("%s: expected %d streams, got %d", tc.name, len(tc.formats), len(sections))
+            continue
+        }
+        for i, section := range sections {
+            // The rejected stream has the port set to zero
+            mbody := section.GetMHeader()
+            if tc.formats[i] == "" {
+                if mbody.GetPort() != "0" {
+                    t.Errorf("%s: stream %d has not been rejected", tc.name, i)
+                }
+            } else if mbody.GetPort() == "0" || strings.Join(mbody.GetFormats(), " ") != tc.formats[i] {
+                t.Errorf("%s: stream %d: unexpected formats %v", tc.name, i, mbody.GetFormats())
+            }
+        }
+        for _, attr := range tc.absent {
+            if strings.Contains(body.String(), "a=" + attr) {
+                t.Errorf("%s: %s has not been removed:\n%s", tc.name, attr, body.String())
+            }
+        }
+    }
+    if usable, err := filterPayloadTypes(nil, []int{ 9 }); ! usable || err != nil {
+        t.Fatal("the call without the SDP has been rejected")
+    }
+    if _, err := filterPayloadTypes(sippy.NewMsgBody("foo", "text/plain"), []int{ 9 }); err == nil {
+        t.Fatal("the body that is not SDP has been accepted")
+    }
+}
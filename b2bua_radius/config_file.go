@@ -0,0 +1,211 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "bufio"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "strings"
+)
+
+const CONFIG_SECTION = "general"
+
+// The options not to be taken from or written into the config file
+var configFileSkip = map[string]bool{
+    "config"            : true,
+    "rtp_proxy_client"  : true, // compatibility option, see rtp_proxy_clients
+}
+
+// Reads the options in the "key = value" format from the [general]
+// section of the file. The value is validated by the same flag that
+// parses the command line, the options already given on the command
+// line are not overridden.
+func readConfigFile(fs *flag.FlagSet, fname string) error {
+    f, err := os.Open(fname)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    on_cmdline := make(map[flag.Value]bool)
+    fs.Visit(func(fl *flag.Flag) { on_cmdline[fl.Value] = true })
+
+    section := ""
+    scanner := bufio.NewScanner(f)
+    for lineno := 1; scanner.Scan(); lineno++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || line[0] == '#' || line[0] == ';' {
+            continue
+        }
+        if line[0] == '[' {
+            if line[len(line) - 1] != ']' {
+                return fmt.Errorf("%s:%d: malformed section header", fname, lineno)
+            }
+            section = strings.TrimSpace(line[1:len(line) - 1])
+            continue
+        }
+        if section != CONFIG_SECTION {
+            return fmt.Errorf("%s:%d: option outside of the [%s] section", fname, lineno, CONFIG_SECTION)
+        }
+        kv := strings.SplitN(line, "=", 2)
+        if len(kv) != 2 {
+            return fmt.Errorf("%s:%d: \"key = value\" expected", fname, lineno)
+        }
+        key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+        fl := fs.Lookup(key)
+        if fl == nil || len(key) == 1 || configFileSkip[key] {
+            return fmt.Errorf("%s:%d: unknown option %s", fname, lineno, key)
+        }
+        if on_cmdline[fl.Value] {
+            continue
+        }
+        if bf, ok := fl.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+            value, err = parseConfigBool(value)
+            if err != nil {
+                return fmt.Errorf("%s:%d: %s: %s", fname, lineno, key, err.Error())
+            }
+        }
+        if err = fs.Set(key, value); err != nil {
+            return fmt.Errorf("%s:%d: invalid value \"%s\" for %s: %s", fname, lineno, value, key, err.Error())
+        }
+    }
+    return scanner.Err()
+}
+
+func parseConfigBool(value string) (string, error) {
+    switch strings.ToLower(value) {
+    case "1", "yes", "true", "on":
+        return "true", nil
+    case "0", "no", "false", "off":
+        return "false", nil
+    }
+    return "", errors.New("not a boolean: " + value)
+}
+
+// Writes the effective value of every long option in the format
+// understood by readConfigFile.
+func writeConfigFile(fs *flag.FlagSet, fname string) error {
+    f, err := os.Create(fname)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(f)
+    fmt.Fprintf(w, "[%s]\n", CONFIG_SECTION)
+    fs.VisitAll(func(fl *flag.Flag) {
+        if len(fl.Name) == 1 || configFileSkip[fl.Name] {
+            return
+        }
+        value := fl.Value.String()
+        if bf, ok := fl.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+            if value == "true" {
+                value = "on"
+            } else {
+                value = "off"
+            }
+        }
+        fmt.Fprintf(w, "%s = %s\n", fl.Name, value)
+    })
+    err = w.Flush()
+    if err1 := f.Close(); err == nil {
+        err = err1
+    }
+    return err
+}
+
+// Takes the RADIUS servers, secret, timeout and retries from the
+// radiusclient.conf unless these are given explicitly.
+func readRadiusclientConf(fs *flag.FlagSet, fname string) error {
+    params, err := readRadiusclientFile(fname)
+    if err != nil {
+        return err
+    }
+    is_set := make(map[string]bool)
+    fs.Visit(func(fl *flag.Flag) { is_set[fl.Name] = true })
+    set := func(name, value string) error {
+        if is_set[name] || value == "" {
+            return nil
+        }
+        if err := fs.Set(name, value); err != nil {
+            return fmt.Errorf("%s: invalid value \"%s\" for %s: %s", fname, value, name, err.Error())
+        }
+        return nil
+    }
+    authservers := strings.Join(strings.Fields(strings.Replace(params["authserver"], ",", " ", -1)), ",")
+    acctservers := strings.Join(strings.Fields(strings.Replace(params["acctserver"], ",", " ", -1)), ",")
+    if err = set("radius_servers", authservers); err != nil {
+        return err
+    }
+    if err = set("radius_acct_servers", acctservers); err != nil {
+        return err
+    }
+    if err = set("radius_timeout", params["radius_timeout"]); err != nil {
+        return err
+    }
+    if err = set("radius_retries", params["radius_retries"]); err != nil {
+        return err
+    }
+    if params["servers"] != "" && authservers != "" {
+        // The servers file lists "host secret" pairs, take the secret
+        // of the first authentication server.
+        servers, err := readRadiusclientFile(params["servers"])
+        if err != nil {
+            return err
+        }
+        host := strings.SplitN(strings.SplitN(authservers, ",", 2)[0], ":", 2)[0]
+        if err = set("radius_secret", servers[host]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Reads the "key value" lines of the radiusclient configuration files
+func readRadiusclientFile(fname string) (map[string]string, error) {
+    f, err := os.Open(fname)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    params := make(map[string]string)
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || line[0] == '#' {
+            continue
+        }
+        kv := strings.Fields(line)
+        if len(kv) < 2 {
+            continue
+        }
+        params[kv[0]] = strings.Join(kv[1:], " ")
+    }
+    return params, scanner.Err()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "flag"
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+)
+
+func testFlagSet() *flag.FlagSet {
+    fs := flag.NewFlagSet("b2bua", flag.ContinueOnError)
+    fs.SetOutput(ioutil.Discard)
+    var static_route, config string
+    var sip_port int
+    var digest_auth bool
+    fs.StringVar(&static_route, "s", "", "static_route")
+    fs.StringVar(&static_route, "static_route", "", "static route")
+    fs.IntVar(&sip_port, "sip_port", 5060, "SIP port")
+    fs.BoolVar(&digest_auth, "digest_auth", true, "digest auth")
+    fs.StringVar(&config, "config", "", "config file")
+    return fs
+}
+
+func Test_ReadConfigFile(t *testing.T) {
+    fname := filepath.Join(t.TempDir(), "b2bua.conf")
+    for _, tc := range []struct {
+        name    string
+        args    []string
+        body    string
+        values  map[string]string
+    }{
+        { "empty", nil, "", map[string]string{ "sip_port" : "5060", "digest_auth" : "true" } },
+        { "values", nil, "# comment\n; comment\n\n[general]\n  static_route = 1.2.3.4;op=5.6.7.8 \nsip_port=5070\ndigest_auth = off\n",
+          map[string]string{ "static_route" : "1.2.3.4;op=5.6.7.8", "sip_port" : "5070", "digest_auth" : "false" } },
+        { "booleans", nil, "[general]\ndigest_auth = Yes\n", map[string]string{ "digest_auth" : "true" } },
+        { "command line wins", []string{ "-sip_port", "5080", "-s", "9.9.9.9" }, "[general]\nsip_port = 5070\nstatic_route = 1.2.3.4\n",
+          map[string]string{ "sip_port" : "5080", "static_route" : "9.9.9.9" } },
+        { "no section", nil, "sip_port = 5070\n", nil },
+        { "other section", nil, "[other]\nsip_port = 5070\n", nil },
+        { "bad section", nil, "[general\n", nil },
+        { "no value", nil, "[general]\nsip_port\n", nil },
+        { "unknown", nil, "[general]\nfoo = bar\n", nil },
+        { "short", nil, "[general]\ns = 1.2.3.4\n", nil },
+        { "skipped", nil, "[general]\nconfig = other.conf\n", nil },
+        { "bad int", nil, "[general]\nsip_port = abc\n", nil },
+        { "bad bool", nil, "[general]\ndigest_auth = maybe\n", nil },
+    } {
+        if err := ioutil.WriteFile(fname, []byte(tc.body), 0600); err != nil {
+            t.Fatal(err)
+        }
+        fs := testFlagSet()
+        if err := fs.Parse(tc.args); err != nil {
+            t.Fatal(err)
+        }
+        err := readConfigFile(fs, fname)
+        if tc.values == nil {
+            if err == nil {
+                t.Errorf("%s: the file has been accepted", tc.name)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %s", tc.name, err.Error())
+            continue
+        }
+        for name, value := range tc.values {
+            if fs.Lookup(name).Value.String() != value {
+                t.Errorf("%s: %s: expected %q, got %q", tc.name, name, value, fs.Lookup(name).Value.String())
+            }
+        }
+    }
+    if err := readConfigFile(testFlagSet(), filepath.Join(filepath.Dir(fname), "missing.conf")); err == nil {
+        t.Fatal("the missing file has been accepted")
+    }
+}
+
+func Test_WriteConfigFile(t *testing.T) {
+    fname := filepath.Join(t.TempDir(), "b2bua.conf")
+    fs := testFlagSet()
+    fs.Parse([]string{ "-s", "1.2.3.4;op=5.6.7.8", "-sip_port", "5070", "-digest_auth=false", "-config", "b2bua.conf" })
+    if err := writeConfigFile(fs, fname); err != nil {
+        t.Fatal(err)
+    }
+    buf, _ := ioutil.ReadFile(fname)
+    expected := "[general]\ndigest_auth = off\nsip_port = 5070\nstatic_route = 1.2.3.4;op=5.6.7.8\n"
+    if string(buf) != expected {
+        t.Fatalf("unexpected config file: %q", string(buf))
+    }
+    // The file written is read back to the same values
+    rfs := testFlagSet()
+    if err := readConfigFile(rfs, fname); err != nil {
+        t.Fatal(err)
+    }
+    fs.VisitAll(func(fl *flag.Flag) {
+        if fl.Name != "config" && rfs.Lookup(fl.Name).Value.String() != fl.Value.String() {
+            t.Errorf("%s: expected %q, got %q", fl.Name, fl.Value.String(), rfs.Lookup(fl.Name).Value.String())
+        }
+    })
+}
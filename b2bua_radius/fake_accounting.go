@@ -26,6 +26,11 @@
 // SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package main
 
+import (
+    "sippy/time"
+    "sippy/types"
+)
+
 type fakeAccounting struct {
 }
 
@@ -33,6 +38,15 @@ func NewFakeAccounting() *fakeAccounting {
     return &fakeAccounting{
     }
 }
+
+func (self *fakeAccounting) conn(sippy_types.UA, *sippy_time.MonoTime, string) {
+}
+
+func (self *fakeAccounting) disc(sippy_types.UA, *sippy_time.MonoTime, string, int) {
+}
+
+func (self *fakeAccounting) fail(sippy_types.UA, *sippy_time.MonoTime, string, int) {
+}
 /*
 class FakeAccounting(object):
     def __init__(self, *args):
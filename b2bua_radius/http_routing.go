@@ -0,0 +1,283 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "sippy/headers"
+)
+
+// The largest routing engine reply accepted
+const HTTP_ROUTE_MAX_REPLY = 1024 * 1024
+
+// The call details POSTed to the external routing engine
+type httpRoutingRequest struct {
+    CallId      string              `json:"call_id"`
+    CLI         string              `json:"cli"`
+    CLD         string              `json:"cld"`
+    CallerName  string              `json:"caller_name"`
+    Username    string              `json:"username"`
+    SourceIP    string              `json:"source_ip"`
+    Headers     map[string][]string `json:"headers"`
+}
+
+// A route returned by the routing engine. The options mirror the ones
+// accepted by NewB2BRoute.
+type httpRoute struct {
+    CLD             *string     `json:"cld"`
+    Host            string      `json:"host"`
+    Parallel        bool        `json:"parallel"`
+    CreditTime      *int        `json:"credit-time"`
+    Expires         *int        `json:"expires"`
+    NpExpires       *int        `json:"np_expires"`
+    HsScodes        []int       `json:"hs_scodes"`
+    Auth            string      `json:"auth"`
+    CLI             *string     `json:"cli"`
+    Cnam            string      `json:"cnam"`
+    Ash             []string    `json:"ash"`
+    OutboundProxy   string      `json:"op"`
+    Rtpp            *int        `json:"rtpp"`
+    ForwardOnFail   bool        `json:"forward_on_fail"`
+    AllowedPts      []int       `json:"allowed_pts"`
+    GroupTimeout    string      `json:"gt"`
+}
+
+type httpRoutingResponse struct {
+    Routes      []json.RawMessage   `json:"routes"`
+}
+
+type httpRouting struct {
+    global_config   *myConfigParser
+    url             string
+    client          *http.Client
+    fallback        []*B2BRoute
+}
+
+func NewHttpRouting(global_config *myConfigParser) (*httpRouting, error) {
+    self := &httpRouting{
+        global_config   : global_config,
+        url             : global_config.http_route_url,
+        client          : &http.Client{ Timeout : global_config.http_route_timeout },
+    }
+    if global_config.http_route_fallback != "" {
+        var err error
+        self.fallback, err = NewB2BRouteSet(global_config.http_route_fallback, global_config)
+        if err != nil {
+            return nil, errors.New("Error parsing the fallback route: " + err.Error())
+        }
+    }
+    return self, nil
+}
+
+// Looks up the routes asynchronously. The callback receives the routes
+// returned by the routing engine or the fallback routes if the engine
+// has failed or timed out. The routes are fresh copies the caller is
+// free to customize.
+func (self *httpRouting) lookup(req *httpRoutingRequest, res_cb func([]*B2BRoute)) {
+    go func() {
+        btime := time.Now()
+        routes, err := self.doLookup(req)
+        if err != nil {
+            self.global_config.ErrorLogger().Error(fmt.Sprintf("HTTP routing of %s failed after %.3f sec: %s",
+                req.CallId, time.Since(btime).Seconds(), err.Error()))
+            routes = make([]*B2BRoute, len(self.fallback))
+            for i, route := range self.fallback {
+                routes[i] = route.getCopy()
+            }
+        }
+        res_cb(routes)
+    }()
+}
+
+func (self *httpRouting) doLookup(req *httpRoutingRequest) ([]*B2BRoute, error) {
+    buf, err := json.Marshal(req)
+    if err != nil {
+        return nil, err
+    }
+    resp, err := self.client.Post(self.url, "application/json", bytes.NewReader(buf))
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    body, err := ioutil.ReadAll(io.LimitReader(resp.Body, HTTP_ROUTE_MAX_REPLY + 1))
+    if err != nil {
+        return nil, err
+    }
+    if len(body) > HTTP_ROUTE_MAX_REPLY {
+        return nil, errors.New("the routing reply is too big")
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, errors.New("unexpected HTTP status: " + resp.Status)
+    }
+    return parseHttpRoutes(body, self.global_config)
+}
+
+// Parses the routing engine reply. Every route is either a string in
+// the same format as the Routing: AVP or an object.
+func parseHttpRoutes(body []byte, global_config *myConfigParser) ([]*B2BRoute, error) {
+    var resp httpRoutingResponse
+
+    err := json.Unmarshal(body, &resp)
+    if err != nil {
+        return nil, errors.New("Error parsing the routing reply: " + err.Error())
+    }
+    if len(resp.Routes) == 0 {
+        return nil, errors.New("no routes in the routing reply")
+    }
+    routes := []*B2BRoute{}
+    for _, raw := range resp.Routes {
+        raw = bytes.TrimSpace(raw)
+        if len(raw) > 0 && raw[0] == '"' {
+            var sroute string
+            if err = json.Unmarshal(raw, &sroute); err != nil {
+                return nil, err
+            }
+            oroutes, err := NewB2BRouteSet(sroute, global_config)
+            if err != nil {
+                return nil, err
+            }
+            routes = append(routes, oroutes...)
+            continue
+        }
+        var hroute httpRoute
+        if err = json.Unmarshal(raw, &hroute); err != nil {
+            return nil, errors.New("Error parsing the route " + string(raw) + ": " + err.Error())
+        }
+        sroute, err := hroute.routeString()
+        if err != nil {
+            return nil, err
+        }
+        oroute, err := NewB2BRoute(sroute, global_config)
+        if err != nil {
+            return nil, err
+        }
+        oroute.parallel = hroute.Parallel && len(routes) > 0
+        routes = append(routes, oroute)
+    }
+    return routes, nil
+}
+
+// Converts the route object into the string form understood by NewB2BRoute
+func (self *httpRoute) routeString() (string, error) {
+    if self.Host == "" {
+        return "", errors.New("route without the host")
+    }
+    for _, s := range []string{ self.Host, self.Auth, self.OutboundProxy } {
+        if strings.ContainsAny(s, ";&|") {
+            return "", errors.New("invalid characters in the route '" + s + "'")
+        }
+    }
+    sroute := self.Host
+    if self.CLD != nil {
+        sroute = *self.CLD + "@" + sroute
+    }
+    params := []string{}
+    if self.CreditTime != nil {
+        params = append(params, "credit-time=" + strconv.Itoa(*self.CreditTime))
+    }
+    if self.Expires != nil {
+        params = append(params, "expires=" + strconv.Itoa(*self.Expires))
+    }
+    if self.NpExpires != nil {
+        params = append(params, "np_expires=" + strconv.Itoa(*self.NpExpires))
+    }
+    if len(self.HsScodes) > 0 {
+        scodes := make([]string, len(self.HsScodes))
+        for i, scode := range self.HsScodes {
+            scodes[i] = strconv.Itoa(scode)
+        }
+        params = append(params, "hs_scodes=" + strings.Join(scodes, ","))
+    }
+    if self.AllowedPts != nil {
+        pts := make([]string, len(self.AllowedPts))
+        for i, pt := range self.AllowedPts {
+            pts[i] = strconv.Itoa(pt)
+        }
+        params = append(params, "allowed_pts=" + strings.Join(pts, ","))
+    }
+    if self.GroupTimeout != "" {
+        params = append(params, "gt=" + self.GroupTimeout)
+    }
+    if self.ForwardOnFail {
+        params = append(params, "forward_on_fail")
+    }
+    if self.Auth != "" {
+        params = append(params, "auth=" + self.Auth)
+    }
+    if self.CLI != nil {
+        params = append(params, "cli=" + *self.CLI)
+    }
+    if self.Cnam != "" {
+        params = append(params, "cnam=" + url.QueryEscape(self.Cnam))
+    }
+    for _, ash := range self.Ash {
+        params = append(params, "ash=" + url.QueryEscape(ash))
+    }
+    if self.Rtpp != nil {
+        params = append(params, "rtpp=" + strconv.Itoa(*self.Rtpp))
+    }
+    if self.OutboundProxy != "" {
+        params = append(params, "op=" + self.OutboundProxy)
+    }
+    for _, s := range params {
+        if strings.ContainsAny(s, ";&|") {
+            return "", errors.New("invalid characters in the route parameter '" + s + "'")
+        }
+    }
+    if len(params) > 0 {
+        sroute += ";" + strings.Join(params, ";")
+    }
+    return sroute, nil
+}
+
+func newHttpRoutingRequest(username, cli, cld, caller_name, call_id, source_ip string, hdrs []sippy_header.SipHeader) *httpRoutingRequest {
+    req := &httpRoutingRequest{
+        CallId      : call_id,
+        CLI         : cli,
+        CLD         : cld,
+        CallerName  : caller_name,
+        Username    : username,
+        SourceIP    : source_ip,
+        Headers     : make(map[string][]string),
+    }
+    for _, hdr := range hdrs {
+        req.Headers[hdr.Name()] = append(req.Headers[hdr.Name()], hdr.StringBody())
+    }
+    return req
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func Test_HttpRouteString(t *testing.T) {
+    str := func(s string) *string { return &s }
+    num := func(n int) *int { return &n }
+    for _, tc := range []struct {
+        route   httpRoute
+        res     string
+    }{
+        { httpRoute{}, "" },
+        { httpRoute{ Host : "1.2.3.4" }, "1.2.3.4" },
+        { httpRoute{ Host : "gw.example.com:5080", CLD : str("123"), CreditTime : num(60), HsScodes : []int{ 486, 600 } },
+          "123@gw.example.com:5080;credit-time=60;hs_scodes=486,600" },
+        { httpRoute{ Host : "1.2.3.4", Expires : num(30), NpExpires : num(5), GroupTimeout : "2,1", ForwardOnFail : true },
+          "1.2.3.4;expires=30;np_expires=5;gt=2,1;forward_on_fail" },
+        { httpRoute{ Host : "1.2.3.4", Auth : "user:pass", CLI : str("456"), Cnam : "Alice Smith", Ash : []string{ "X-Foo: bar" } },
+          "1.2.3.4;auth=user:pass;cli=456;cnam=Alice+Smith;ash=X-Foo%3A+bar" },
+        { httpRoute{ Host : "1.2.3.4", AllowedPts : []int{ 0, 8 }, Rtpp : num(0), OutboundProxy : "5.6.7.8" },
+          "1.2.3.4;allowed_pts=0,8;rtpp=0;op=5.6.7.8" },
+        { httpRoute{ Host : "1.2.3.4;op=5.6.7.8" }, "" },
+        { httpRoute{ Host : "1.2.3.4|5.6.7.8" }, "" },
+        { httpRoute{ Host : "1.2.3.4", CLI : str("1;2") }, "" },
+        { httpRoute{ Host : "1.2.3.4", GroupTimeout : "2&1" }, "" },
+    } {
+        res, err := tc.route.routeString()
+        if tc.res == "" {
+            if err == nil {
+                t.Errorf("%+v: the route has been accepted: %s", tc.route, res)
+            }
+            continue
+        }
+        if err != nil || res != tc.res {
+            t.Errorf("%+v: expected %q, got %q, %v", tc.route, tc.res, res, err)
+        }
+    }
+}
+
+func Test_ParseHttpRoutes(t *testing.T) {
+    global_config := testConfig(t)
+    for _, tc := range []struct {
+        body        string
+        hosts       []string
+        parallel    []bool
+    }{
+        { `{"routes":["1.2.3.4"]}`, []string{ "1.2.3.4" }, []bool{ false } },
+        { `{"routes":["1.2.3.4|5.6.7.8&9.9.9.9"]}`, []string{ "1.2.3.4", "5.6.7.8", "9.9.9.9" }, []bool{ false, false, true } },
+        { `{"routes":[{"host":"1.2.3.4","parallel":true},{"host":"5.6.7.8","parallel":true}]}`,
+          []string{ "1.2.3.4", "5.6.7.8" }, []bool{ false, true } },
+        { `{"routes":[ "1.2.3.4", {"host":"5.6.7.8","cld":"123"} ]}`, []string{ "1.2.3.4", "5.6.7.8" }, []bool{ false, false } },
+        { `{"routes":[]}`, nil, nil },
+        { `{}`, nil, nil },
+        { `not json`, nil, nil },
+        { `{"routes":[5]}`, nil, nil },
+        { `{"routes":[{"cld":"123"}]}`, nil, nil },
+        { `{"routes":[{"host":"1.2.3.4","credit-time":"60"}]}`, nil, nil },
+        { `{"routes":["1.2.3.4&"]}`, nil, nil },
+    } {
+        routes, err := parseHttpRoutes([]byte(tc.body), global_config)
+        if tc.hosts == nil {
+            if err == nil {
+                t.Errorf("%s: the reply has been accepted", tc.body)
+            }
+            continue
+        }
+        if err != nil || len(routes) != len(tc.hosts) {
+            t.Errorf("%s: expected %d routes, got %d, %v", tc.body, len(tc.hosts), len(routes), err)
+            continue
+        }
+        for i, route := range routes {
+            if route.hostonly != tc.hosts[i] || route.parallel != tc.parallel[i] {
+                t.Errorf("%s: route %d: unexpected %s, parallel %v", tc.body, i, route.hostonly, route.parallel)
+            }
+        }
+    }
+}
+
+func Test_HttpRoutingLookup(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var req httpRoutingRequest
+        json.NewDecoder(r.Body).Decode(&req)
+        if req.CLD == "big" {
+            // The oversized reply
+            w.Write([]byte(`{"routes":["1.2.3.4"` + strings.Repeat(" ", HTTP_ROUTE_MAX_REPLY) + `]}`))
+            return
+        }
+        w.Write([]byte(`{"routes":["1.2.3.4"]}`))
+    }))
+    defer server.Close()
+    global_config := testConfig(t)
+    global_config.http_route_url = server.URL
+    global_config.http_route_timeout = 5 * time.Second
+    global_config.http_route_fallback = "5.6.7.8"
+    routing, err := NewHttpRouting(global_config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    lookup := func(cld string) string {
+        req := newHttpRoutingRequest("alice", "123", cld, "", "abc@1.2.3.4", "1.2.3.4", nil)
+        res := make(chan []*B2BRoute, 1)
+        routing.lookup(req, func(routes []*B2BRoute) { res <- routes })
+        routes := <-res
+        if len(routes) != 1 {
+            t.Fatalf("expected 1 route, got %d", len(routes))
+        }
+        return routes[0].hostonly
+    }
+    if host := lookup("456"); host != "1.2.3.4" {
+        t.Fatalf("the routing reply has not been used: %s", host)
+    }
+    // The oversized reply is not read in full, the fallback is used
+    if host := lookup("big"); host != "5.6.7.8" {
+        t.Fatalf("the fallback route has not been used: %s", host)
+    }
+}
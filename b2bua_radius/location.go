@@ -0,0 +1,156 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "sippy"
+    "sippy/conf"
+    "sippy/headers"
+    "sippy/net"
+)
+
+// Replaces the "loc:" routes with the routes to the contacts registered
+// for the AOR. The contacts with the higher q-value are tried first while
+// the ones with the same q-value are forked to in parallel. The AOR is
+// the CLD at the domain of the Request-URI unless given in the route.
+// Reports if there have been any "loc:" routes.
+func (self *callController) expandLocRoutes(routing []*B2BRoute) ([]*B2BRoute, bool) {
+    ret := make([]*B2BRoute, 0, len(routing))
+    had_loc := false
+    for _, oroute := range routing {
+        if ! oroute.loc {
+            ret = append(ret, oroute)
+            continue
+        }
+        had_loc = true
+        if global_registrar == nil {
+            continue
+        }
+        aor := oroute.loc_aor
+        if aor == "" {
+            aor = self.cld
+        }
+        if strings.IndexRune(aor, '@') == -1 {
+            aor += "@" + self.local_domain
+        }
+        tmp := strings.SplitN(aor, "@", 2)
+        bindings := global_registrar.GetStore().Lookup(tmp[0] + "@" + strings.ToLower(tmp[1]))
+        sort.SliceStable(bindings, func(i, j int) bool { return bindings[i].Q > bindings[j].Q })
+        first := true
+        for i, b := range bindings {
+            nroute, err := oroute.forBinding(b, self.global_config)
+            if err != nil {
+                self.global_config.ErrorLogger().Error("Cannot route to the contact " + b.Contact + ": " + err.Error())
+                continue
+            }
+            if first {
+                nroute.parallel = oroute.parallel
+                first = false
+            } else {
+                nroute.parallel = b.Q == bindings[i - 1].Q
+                nroute.group_timeout = 0
+            }
+            ret = append(ret, nroute)
+        }
+    }
+    return ret, had_loc
+}
+
+// Makes the route to the registered contact out of the "loc:" one.
+func (self *B2BRoute) forBinding(b *sippy.Binding, global_config sippy_conf.Config) (*B2BRoute, error) {
+    url, err := sippy_header.ParseSipURL(b.Contact, false, global_config)
+    if err != nil {
+        return nil, err
+    }
+    nroute := self.getCopy()
+    nroute.loc, nroute.loc_aor = false, ""
+    addr := url.GetAddr(global_config)
+    if err = nroute.setHostPort(addr.Host.String() + ":" + addr.Port.String(), global_config); err != nil {
+        return nil, err
+    }
+    // The contact is the Request-URI as it has been registered, the
+    // scheme and the transport parameter select how it is reached.
+    nroute.rtarget = url
+    nroute.cld, nroute.cld_set = url.Username, true
+    if nroute.outbound_proxy != nil {
+        return nroute, nil
+    }
+    if len(b.Path) > 0 {
+        // Reach the contact through the proxies it has registered via
+        addr, err := sippy_header.ParseSipAddress(strings.Trim(b.Path[0], "<>"), false, global_config)
+        if err != nil {
+            return nil, err
+        }
+        nroute.outbound_proxy = addr.GetUrl().GetAddr(global_config)
+        for _, p := range b.Path {
+            nroute.extra_headers = append(nroute.extra_headers, sippy_header.CreateSipRoute(p)...)
+        }
+    } else if b.Received != "" && b.Received != url.GetAddr(global_config).String() {
+        // The contact is behind a NAT, send to where the REGISTER
+        // has come from
+        idx := strings.LastIndex(b.Received, ":")
+        if idx > 0 {
+            nroute.outbound_proxy = sippy_net.NewHostPort(b.Received[:idx], b.Received[idx + 1:])
+        }
+    }
+    return nroute, nil
+}
+
+// Lists the registered bindings for the "loc" CLI command.
+func locationString() string {
+    if global_registrar == nil {
+        return "Registrar is not enabled\n"
+    }
+    store := global_registrar.GetStore()
+    res := ""
+    now := time.Now()
+    for _, aor := range store.AORs() {
+        for _, b := range store.Lookup(aor) {
+            res += fmt.Sprintf("%s: %s q=%.3g expires=%d", aor, b.Contact, b.Q, int(b.Expires.Sub(now).Seconds()))
+            if b.Received != "" {
+                res += " received=" + b.Received
+            }
+            if len(b.Path) > 0 {
+                res += " path=" + strings.Join(b.Path, ",")
+            }
+            if b.InstanceId != "" {
+                res += " instance=" + b.InstanceId
+            }
+            res += "\n"
+        }
+    }
+    if res == "" {
+        return "No bindings\n"
+    }
+    return res
+}
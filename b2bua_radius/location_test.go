@@ -0,0 +1,37 @@
+package main
+
+import (
+    "testing"
+
+    "sippy"
+)
+
+func Test_LocRouteTarget(t *testing.T) {
+    cc, tm := testRoutingCC(t, "10.0.0.9")
+    for i, tc := range []struct {
+        binding     sippy.Binding
+        target      string
+        dest        string
+    }{
+        { sippy.Binding{ Contact : "sip:alice@10.0.0.5:5070;transport=tcp", Received : "10.0.0.5:5070" },
+          "sip:alice@10.0.0.5:5070;transport=tcp", "tcp:10.0.0.5:5070" },
+        { sippy.Binding{ Contact : "sip:bob@192.0.2.7:40000;transport=ws", Received : "192.0.2.7:40000" },
+          "sip:bob@192.0.2.7:40000;transport=ws", "ws:192.0.2.7:40000" },
+        { sippy.Binding{ Contact : "sips:carol@192.0.2.8", Received : "192.0.2.8:5061" },
+          "sips:carol@192.0.2.8", "tls:192.0.2.8:5061" },
+        // The contact behind a NAT is reached where it has registered from
+        { sippy.Binding{ Contact : "sip:dave@10.1.1.1:5060;transport=tcp;ob", Received : "203.0.113.1:34567" },
+          "sip:dave@10.1.1.1:5060;transport=tcp;ob", "tcp:203.0.113.1:34567" },
+    } {
+        nroute, err := cc.routes[0].forBinding(&tc.binding, cc.global_config)
+        if err != nil {
+            t.Fatal(err)
+        }
+        cc.lock.Lock()
+        cc.placeOriginate(nroute, false, nil)
+        cc.lock.Unlock()
+        if len(tm.targets) != i + 1 || tm.targets[i] != tc.target || tm.dests[i] != tc.dest {
+            t.Errorf("%s: unexpected egress target %v, %v", tc.binding.Contact, tm.targets, tm.dests)
+        }
+    }
+}
@@ -0,0 +1,79 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "crypto/md5"
+    "encoding/hex"
+    "os"
+    "strconv"
+    "strings"
+
+    "sippy/headers"
+    "sippy/types"
+)
+
+// The Max-Forwards can not detect the loops between the B2BUAs as
+// each of them starts the egress call leg afresh. So every instance
+// appends its own id to the header and refuses the calls carrying it.
+const LOOP_HEADER = "X-B2BUA-Loop"
+
+// Derives the id of the instance from the host name and the SIP
+// listening socket so that it survives restarts.
+func defaultLoopId(sip_address string, sip_port int) string {
+    hostname, _ := os.Hostname()
+    sum := md5.Sum([]byte(hostname + "/" + sip_address + ":" + strconv.Itoa(sip_port)))
+    return hex.EncodeToString(sum[:8])
+}
+
+// Returns the loop marker headers of the request and whether any of
+// them carries the given id.
+func loopHeaders(req sippy_types.SipRequest, loop_id string) ([]sippy_header.SipHeader, bool) {
+    hfs := req.GetHFs(LOOP_HEADER)
+    for _, hf := range hfs {
+        for _, id := range strings.Split(hf.StringBody(), ",") {
+            if strings.TrimSpace(id) == loop_id {
+                return hfs, true
+            }
+        }
+    }
+    return hfs, false
+}
+
+// Checks that the request has at least one hop left to be forwarded
+// to the egress call leg.
+func hasHopsLeft(req sippy_types.SipRequest) bool {
+    if req.GetMaxForwards() == nil {
+        return true
+    }
+    max_forwards, err := req.GetMaxForwards().GetBody()
+    if err != nil {
+        return true
+    }
+    return max_forwards.Number - 1 > 0
+}
@@ -27,7 +27,9 @@
 package main
 
 import (
+    "io/ioutil"
     "os"
+    "strconv"
     "strings"
 
     "sippy"
@@ -36,9 +38,16 @@ import (
     "sippy/types"
 )
 
-var global_static_route *B2BRoute
+var global_static_routes []*B2BRoute
 var global_rtp_proxy_clients []sippy_types.RtpProxyClient
 var global_cmap *callMap
+var global_radius_client *radiusAuthorisation
+var global_cdr_writer cdrWriter
+var global_http_routing *httpRouting
+var global_cac *callAdmission
+var global_digest_auth *sippy.DigestAuth
+var global_trunk_regs *trunkRegistrations
+var global_registrar *sippy.Registrar
 /*
 from sippy.Timeout import Timeout
 from sippy.Signal import Signal
@@ -118,48 +127,70 @@ func main() {
         return
     }
 
-    if global_config.static_route != "" {
-        global_static_route, err = NewB2BRoute(global_config.static_route, global_config)
-        if err != nil {
-            println("Error parsing the static route")
-            println(err.Error())
-            return
-        }
-    //} else if ! global_config.auth_enable {
-    } else if true { // radius is not implemented
-        println("ERROR: static route should be specified when Radius auth is disabled")
+    if global_config.static_route == "" && ! global_config.auth_enable && global_config.http_route_url == "" {
+        println("ERROR: static route or the routing engine URL should be specified when Radius auth is disabled")
         return
     }
 /*
     if writeconf != nil:
         global_config.write(open(writeconf, 'w'))
-
+*/
+    if global_config.writeconf != "" {
+        err = writeConfigFile(global_config.flags, global_config.writeconf)
+        if err != nil {
+            println("Cannot write the configuration: " + err.Error())
+            return
+        }
+    }
+/*
     if ! global_config['foreground']:
         daemonize(logfile = global_config['logfile'])
 */
-    global_rtp_proxy_clients = make([]sippy_types.RtpProxyClient, len(global_config.rtp_proxy_clients))
-    for i, address := range global_config.rtp_proxy_clients {
-        opts, err := sippy.NewRtpProxyClientOpts(address, nil /*bind_address*/, global_config, global_config.ErrorLogger())
+    global_rtp_proxy_clients, err = newRtpProxyClients(global_config, nil, nil)
+    if err != nil {
+        println("Cannot initialize rtpproxy client: " + err.Error())
+        return
+    }
+    if global_config.auth_enable || global_config.acct_enable {
+        global_radius_client = NewRadiusAuthorisation(global_config)
+    }
+    if global_config.auth_db != "" {
+        store, err := sippy.NewFileCredentialStore(global_config.auth_db)
         if err != nil {
-            println("Cannot initialize rtpproxy client: " + err.Error())
+            println("Cannot read the user database: " + err.Error())
             return
         }
-        opts.SetHeartbeatInterval(global_config.hrtb_ival)
-        opts.SetHeartbeatRetryInterval(global_config.hrtb_retr_ival)
-        rtpp := sippy.NewRtpProxyClient(opts)
-        err = rtpp.Start()
+        global_digest_auth = sippy.NewDigestAuth(store)
+        global_digest_auth.SetRealm(global_config.auth_realm)
+        global_digest_auth.SetProxy(global_config.auth_challenge == 407)
+        global_digest_auth.SetNonceTTL(global_config.auth_nonce_ttl)
+        global_digest_auth.SetAlgorithms(global_config.auth_algorithms)
+    }
+    if global_config.cdr_file != "" {
+        global_cdr_writer, err = NewCdrFileWriter(global_config.cdr_file, global_config.cdr_format,
+                                global_config.cdr_rotate_size, global_config.cdr_rotate_ival)
         if err != nil {
-            println("Cannot initialize rtpproxy client: " + err.Error())
+            println("Cannot open the CDR file: " + err.Error())
             return
         }
-        global_rtp_proxy_clients[i] = rtpp
     }
-/*
-    if global_config['auth_enable'] || global_config['acct_enable']:
-        global_config['_radius_client'] = RadiusAuthorisation(global_config)
-*/
+    if global_config.registrar_enable {
+        var store sippy.LocationStore = sippy.NewMemoryLocationStore()
+        if global_config.registrar_db != "" {
+            store, err = sippy.NewFileLocationStore(global_config.registrar_db)
+            if err != nil {
+                println("Cannot read the registrar database: " + err.Error())
+                return
+            }
+        }
+        global_registrar = sippy.NewRegistrar(store, global_config)
+        global_registrar.SetExpiresLimits(global_config.registrar_min_expires, global_config.registrar_max_expires,
+          global_config.registrar_default_expires)
+        global_registrar.SetAuthenticator(global_digest_auth.Authenticate)
+    }
     global_config.SetMyUAName("Sippy B2BUA (RADIUS)")
 
+    global_cac = NewCallAdmission(global_config)
     global_cmap = NewCallMap(global_config)
 /*
     if global_config.getdefault('xmpp_b2bua_id', nil) != nil:
@@ -182,6 +213,30 @@ func main() {
         }
         global_cmap.proxy = sippy.NewStatefulProxy(sip_tm, sip_proxy, global_config)
     }
+    if global_config.trunk_regs != "" {
+        global_trunk_regs, err = NewTrunkRegistrations(global_config.trunk_regs, sip_tm, global_config)
+        if err != nil {
+            println("Error parsing the trunk registrations: " + err.Error())
+            return
+        }
+        global_trunk_regs.start()
+    }
+    // The routes can refer to the trunk registrations by name
+    if global_config.static_route != "" {
+        global_static_routes, err = NewB2BRouteSet(global_config.static_route, global_config)
+        if err != nil {
+            println("Error parsing the static route")
+            println(err.Error())
+            return
+        }
+    }
+    if global_config.http_route_url != "" {
+        global_http_routing, err = NewHttpRouting(global_config)
+        if err != nil {
+            println(err.Error())
+            return
+        }
+    }
 
     cmdfile := global_config.b2bua_socket
     if strings.HasPrefix(cmdfile, "unix:") {
@@ -198,5 +253,12 @@ func main() {
         file(global_config['pidfile'], 'w').write(str(os.getpid()) + '\n')
         Signal(SIGUSR1, reopen, SIGUSR1, global_config['logfile'])
 */
+    if global_config.pidfile != "" {
+        err = ioutil.WriteFile(global_config.pidfile, []byte(strconv.Itoa(os.Getpid()) + "\n"), 0644)
+        if err != nil {
+            println("Cannot write the PID file: " + err.Error())
+            return
+        }
+    }
     sip_tm.Run()
 }
@@ -29,40 +29,134 @@ package main
 import (
     "errors"
     "flag"
+    "io/ioutil"
+    "net"
+    "os"
     "strconv"
     "strings"
     "time"
 
     "sippy/conf"
+    "sippy/headers"
     "sippy/log"
     "sippy/net"
 )
 
 type myConfigParser struct {
     sippy_conf.Config
-    accept_ips          map[string]bool
+    accept_ips          *ipACL
     static_route        string
     sip_proxy           string
-    //auth_enable         bool
+    http_route_url      string
+    http_route_timeout  time.Duration
+    http_route_fallback string
+    http_route_headers  []string
+    auth_enable         bool
+    digest_auth         bool
+    digest_auth_only    bool
+    auth_db             string
+    auth_realm          string
+    auth_challenge      int
+    auth_nonce_ttl      time.Duration
+    auth_algorithms     []string
+    trunk_regs          string
+    registrar_enable    bool
+    registrar_db        string
+    registrar_min_expires int
+    registrar_max_expires int
+    registrar_default_expires int
+    acct_enable         bool
+    start_acct_enable   bool
+    precise_acct        bool
+    alive_acct_int      time.Duration
+    cdr_file            string
+    cdr_format          string
+    cdr_rotate_size     int64
+    cdr_rotate_ival     time.Duration
     rtp_proxy_clients   []string
     pass_headers        []string
     keepalive_ans       time.Duration
     keepalive_orig      time.Duration
+    keepalive_ans_method string
+    keepalive_orig_method string
+    keepalive_ans_failures int
+    keepalive_orig_failures int
+    session_expires_ans time.Duration
+    session_expires_orig time.Duration
+    session_min_se      time.Duration
     b2bua_socket        string
     hrtb_retr_ival      time.Duration
     hrtb_ival           time.Duration
+    radius_servers      []string
+    radius_acct_servers []string
+    radius_secret       string
+    radius_timeout      time.Duration
+    radius_retries      int
+    static_tr_in        string
+    static_tr_out       string
+    static_tr_cli_in    string
+    static_tr_cli_out   string
+    tr_in               *numberTranslator
+    tr_out              *numberTranslator
+    tr_cli_in           *numberTranslator
+    tr_cli_out          *numberTranslator
+    allowed_pts         []int
+    max_credit_time     time.Duration
+    cac_max_calls       int
+    cac_cps             float64
+    cac_src_max_calls   int
+    cac_src_cps         float64
+    cac_user_max_calls  int
+    cac_user_cps        float64
+    cac_route_max_calls int
+    cac_route_cps       float64
+    cac_reject_code     int
+    cac_retry_after     int
+    hide_call_id        bool
+    topology_hiding     bool
+    loop_id             string
+    sip_address         string
+    pidfile             string
+    radiusclient_conf   string
+    writeconf           string
+    config_file         string
+    flags               *flag.FlagSet
+    values              map[string]string
 }
 
 func NewMyConfigParser() *myConfigParser {
     return &myConfigParser{
         rtp_proxy_clients   : make([]string, 0),
-        accept_ips          : make(map[string]bool),
-        //auth_enable         : false,
+        accept_ips          : NewIpACL(),
+        auth_enable         : false,
+        digest_auth         : true,
+        digest_auth_only    : false,
+        acct_enable         : false,
+        start_acct_enable   : false,
+        precise_acct        : false,
+        cdr_format          : CDR_FORMAT_CSV,
         pass_headers        : make([]string, 0),
+        http_route_headers  : make([]string, 0),
+        radius_servers      : make([]string, 0),
+        radius_acct_servers : make([]string, 0),
+        allowed_pts         : make([]int, 0),
     }
 }
 
 func (self *myConfigParser) Parse() error {
+    return self.parse(flag.CommandLine, os.Args[1:], nil)
+}
+
+// Re-reads the configuration file taking the command line into
+// account just like the Parse does. The logging and the SIP settings
+// are reused from the running configuration.
+func (self *myConfigParser) Reparse(running *myConfigParser) error {
+    fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+    fs.SetOutput(ioutil.Discard)
+    return self.parse(fs, os.Args[1:], running)
+}
+
+func (self *myConfigParser) parse(fs *flag.FlagSet, args []string, running *myConfigParser) error {
 /*
     global_config.digest_auth = true
     global_config.start_acct_enable = false
@@ -97,25 +191,46 @@ func (self *myConfigParser) Parse() error {
             global_config.check_and_set('pidfile', a)
             continue
             */
+    fs.StringVar(&self.sip_address, "l", "*", "sip_address")
+    fs.StringVar(&self.sip_address, "sip_address", "*", "local SIP address to listen for incoming SIP requests " +
+                                "(\"*\", \"0.0.0.0\" or \"::\" to listen on all IPv4 or IPv6 interfaces)")
+    fs.StringVar(&self.pidfile, "P", "", "pidfile")
+    fs.StringVar(&self.pidfile, "pidfile", "", "path to the B2BUA PID file")
     var logfile string
-    flag.StringVar(&logfile, "L", "/var/log/sip.log", "logfile")
-    flag.StringVar(&logfile, "logfile", "/var/log/sip.log", "path to the B2BUA log file")
+    fs.StringVar(&logfile, "L", "/var/log/sip.log", "logfile")
+    fs.StringVar(&logfile, "logfile", "/var/log/sip.log", "path to the B2BUA log file")
 
-    flag.StringVar(&self.static_route, "s", "", "static route for all SIP calls")
-    flag.StringVar(&self.static_route, "static_route", "", "static route for all SIP calls")
+    fs.StringVar(&self.static_route, "s", "", "static route for all SIP calls")
+    fs.StringVar(&self.static_route, "static_route", "", "static route for all SIP calls. The \"|\" separated " +
+                                "routes are tried one by one, the \"&\" separated routes are forked in parallel")
+    fs.StringVar(&self.http_route_url, "http_route_url", "", "URL of the external routing engine. The call " +
+                                "details are POSTed there as JSON when neither the static route nor the Radius " +
+                                "routing is available")
+    var http_route_timeout int
+    fs.IntVar(&http_route_timeout, "http_route_timeout", 2000, "timeout of the external routing engine " +
+                                "requests (milliseconds)")
+    fs.StringVar(&self.http_route_fallback, "http_route_fallback", "", "route set to use when the external " +
+                                "routing engine fails or times out")
+    var http_route_headers string
+    fs.StringVar(&http_route_headers, "http_route_headers", "", "list of SIP header field names that the B2BUA " +
+                                "will send to the external routing engine (comma-separated list)")
 
     var accept_ips string
-    flag.StringVar(&accept_ips, "a", "", "accept_ips")
-    flag.StringVar(&accept_ips, "accept_ips", "", "IP addresses that we will only be accepting incoming " +
+    fs.StringVar(&accept_ips, "a", "", "accept_ips")
+    fs.StringVar(&accept_ips, "accept_ips", "", "IP addresses or CIDR networks that we will only be accepting incoming " +
                                 "calls from (comma-separated list). If the parameter " +
                                 "is not specified, we will accept from any IP and " +
                                 "then either try to authenticate if authentication " +
                                 "is enabled, or just let them to pass through")
+    var deny_ips string
+    fs.StringVar(&deny_ips, "deny_ips", "", "IP addresses or CIDR networks that we will never be accepting " +
+                                "incoming calls from (comma-separated list). The most specific " +
+                                "match of the accept_ips and the deny_ips wins")
 
     var hrtb_ival int
-    flag.IntVar(&hrtb_ival, "rtpp_hrtb_ival", 10, "rtpproxy hearbeat interval (seconds)")
+    fs.IntVar(&hrtb_ival, "rtpp_hrtb_ival", 10, "rtpproxy hearbeat interval (seconds)")
     var hrtb_retr_ival int
-    flag.IntVar(&hrtb_retr_ival, "rtpp_hrtb_retr_ival", 60, "rtpproxy hearbeat retry interval (seconds)")
+    fs.IntVar(&hrtb_retr_ival, "rtpp_hrtb_retr_ival", 60, "rtpproxy hearbeat retry interval (seconds)")
 /*
         if o == '-a':
             global_config.check_and_set('accept_ips', a)
@@ -138,6 +253,25 @@ func (self *myConfigParser) Parse() error {
                 sys.__stderr__.write('ERROR: -A argument not in the range 0-2\n')
                 usage(global_config, true)
             continue
+*/
+    var acct_level int
+    fs.IntVar(&acct_level, "A", -1, "Radius accounting level: 0 - no accounting, 1 - Stop only, 2 - Start and Stop")
+    fs.BoolVar(&self.acct_enable, "acct_enable", false, "enable or disable Radius accounting")
+    fs.BoolVar(&self.start_acct_enable, "start_acct_enable", false, "enable start Radius accounting")
+    fs.BoolVar(&self.precise_acct, "precise_acct", false, "do Radius accounting with millisecond precision")
+    var alive_acct_int int
+    fs.IntVar(&alive_acct_int, "alive_acct_int", 0, "interval for sending alive Radius accounting in " +
+                                "second (0 to disable alive accounting)")
+    fs.StringVar(&self.cdr_file, "cdr_file", "", "write call detail records of both call legs into " +
+                                "the specified file")
+    fs.StringVar(&self.cdr_format, "cdr_format", CDR_FORMAT_CSV, "format of the call detail records: " +
+                                "\"csv\" or \"jsonl\" (JSON lines)")
+    var cdr_rotate_size, cdr_rotate_ival int
+    fs.IntVar(&cdr_rotate_size, "cdr_rotate_size", 0, "rotate the CDR file when it grows over the specified " +
+                                "size in kilobytes (0 to disable)")
+    fs.IntVar(&cdr_rotate_ival, "cdr_rotate_ival", 0, "rotate the CDR file after the specified number " +
+                                "of seconds (0 to disable)")
+/*
         if o == '-t':
             global_config.check_and_set('static_tr_in', a)
             continue
@@ -145,21 +279,90 @@ func (self *myConfigParser) Parse() error {
             global_config.check_and_set('static_tr_out', a)
             continue
 */
+    fs.StringVar(&self.static_tr_in, "t", "", "static_tr_in")
+    fs.StringVar(&self.static_tr_in, "static_tr_in", "", "translation rule (regexp) to apply to all incoming " +
+                                "(ingress) destination numbers")
+    fs.StringVar(&self.static_tr_out, "T", "", "static_tr_out")
+    fs.StringVar(&self.static_tr_out, "static_tr_out", "", "translation rule (regexp) to apply to all outgoing " +
+                                "(egress) destination numbers")
+    fs.StringVar(&self.static_tr_cli_in, "static_tr_cli_in", "", "translation rule (regexp) to apply to all incoming " +
+                                "(ingress) caller numbers")
+    fs.StringVar(&self.static_tr_cli_out, "static_tr_cli_out", "", "translation rule (regexp) to apply to all outgoing " +
+                                "(egress) caller numbers")
     var ka_level, keepalive_ans, keepalive_orig int
-    flag.IntVar(&ka_level, "k", 0, "keepalive level")
-    flag.IntVar(&keepalive_ans, "keepalive_ans", 0, "send periodic \"keep-alive\" re-INVITE requests on " +
+    fs.IntVar(&ka_level, "k", 0, "keepalive level")
+    fs.IntVar(&keepalive_ans, "keepalive_ans", 0, "send periodic \"keep-alive\" re-INVITE requests on " +
                                 "answering (ingress) call leg and disconnect a call " +
                                 "if the re-INVITE fails (period in seconds, 0 to disable)")
-    flag.IntVar(&keepalive_orig, "keepalive_orig", 0, "send periodic \"keep-alive\" re-INVITE requests on " +
+    fs.IntVar(&keepalive_orig, "keepalive_orig", 0, "send periodic \"keep-alive\" re-INVITE requests on " +
                              "originating (egress) call leg and disconnect a call " +
                              "if the re-INVITE fails (period in seconds, 0 to disable)")
+    fs.StringVar(&self.keepalive_ans_method, "keepalive_ans_method", "INVITE", "request used for the keep-alives " +
+                                "on answering (ingress) call leg: INVITE (with the SDP), OPTIONS or UPDATE (without SDP)")
+    fs.StringVar(&self.keepalive_orig_method, "keepalive_orig_method", "INVITE", "request used for the keep-alives " +
+                                "on originating (egress) call leg: INVITE (with the SDP), OPTIONS or UPDATE (without SDP)")
+    fs.IntVar(&self.keepalive_ans_failures, "keepalive_ans_failures", 1, "number of consecutive failed keep-alives " +
+                                "that disconnect a call on answering (ingress) call leg")
+    fs.IntVar(&self.keepalive_orig_failures, "keepalive_orig_failures", 1, "number of consecutive failed keep-alives " +
+                                "that disconnect a call on originating (egress) call leg")
+    var session_expires_ans, session_expires_orig, session_min_se int
+    fs.IntVar(&session_expires_ans, "session_expires_ans", 0, "use RFC 4028 session timers with the given " +
+                                "session interval on answering (ingress) call leg, the peers that support " +
+                                "them are not sent the keep-alives (seconds, 0 to disable)")
+    fs.IntVar(&session_expires_orig, "session_expires_orig", 0, "use RFC 4028 session timers with the given " +
+                                "session interval on originating (egress) call leg, the peers that support " +
+                                "them are not sent the keep-alives (seconds, 0 to disable)")
+    fs.IntVar(&session_min_se, "session_min_se", 90, "smallest session interval accepted from the peers (seconds)")
 /*
         if o == '-m':
             global_config.check_and_set('max_credit_time', a)
             continue
 */
-    //flag.BoolVar(&self.auth_enable, "a", false, "auth_enable")
-    //flag.BoolVar(&self.auth_enable, "auth_enable", false, "enable or disable Radius authentication")
+    var max_credit_time int
+    fs.IntVar(&max_credit_time, "m", 0, "max_credit_time")
+    fs.IntVar(&max_credit_time, "max_credit_time", 0, "upper limit of session time for all calls in " +
+                                "seconds (0 for no limit)")
+    fs.IntVar(&self.cac_max_calls, "cac_max_calls", 0, "maximum number of concurrent calls (0 for no limit)")
+    fs.Float64Var(&self.cac_cps, "cac_cps", 0, "maximum number of new calls per second (0 for no limit)")
+    fs.IntVar(&self.cac_src_max_calls, "cac_src_max_calls", 0, "maximum number of concurrent calls per " +
+                                "source IP (0 for no limit)")
+    fs.Float64Var(&self.cac_src_cps, "cac_src_cps", 0, "maximum number of new calls per second per " +
+                                "source IP (0 for no limit)")
+    fs.IntVar(&self.cac_user_max_calls, "cac_user_max_calls", 0, "maximum number of concurrent calls per " +
+                                "authenticated user (0 for no limit)")
+    fs.Float64Var(&self.cac_user_cps, "cac_user_cps", 0, "maximum number of new calls per second per " +
+                                "authenticated user (0 for no limit)")
+    fs.IntVar(&self.cac_route_max_calls, "cac_route_max_calls", 0, "maximum number of concurrent calls per " +
+                                "route destination, the routes over the limit are skipped (0 for no limit)")
+    fs.Float64Var(&self.cac_route_cps, "cac_route_cps", 0, "maximum number of new calls per second per " +
+                                "route destination (0 for no limit)")
+    fs.IntVar(&self.cac_reject_code, "cac_reject_code", 503, "SIP response code to reject the calls over " +
+                                "the limits with, either 503 or 486")
+    fs.IntVar(&self.cac_retry_after, "cac_retry_after", 5, "value of the Retry-After header sent with the " +
+                                "rejection of the calls over the limits in seconds (0 to omit the header)")
+    fs.BoolVar(&self.auth_enable, "auth_enable", false, "enable or disable Radius authentication")
+    fs.BoolVar(&self.digest_auth, "digest_auth", true, "enable or disable SIP Digest authentication of " +
+                                "incoming INVITE requests")
+    fs.BoolVar(&self.digest_auth_only, "digest_auth_only", false, "only use SIP Digest method to authenticate " +
+                                "incoming INVITE requests. If the option is not " +
+                                "specified or set to \"off\" then B2BUA will try to " +
+                                "do remote IP authentication first and if that fails " +
+                                "then send a challenge and re-authenticate when " +
+                                "challenge response comes in")
+    fs.StringVar(&self.auth_db, "auth_db", "", "file with the user credentials to authenticate incoming " +
+                                "INVITE requests locally with SIP Digest instead of Radius, one " +
+                                "\"username:password\" or \"username:realm:HA1\" per line (path to file)")
+    fs.StringVar(&self.auth_realm, "auth_realm", "", "realm of the local SIP Digest authentication, the host " +
+                                "part of the Request-URI by default")
+    fs.IntVar(&self.auth_challenge, "auth_challenge", 401, "style of the local SIP Digest authentication " +
+                                "challenge, either 401 (WWW-Authenticate) or 407 (Proxy-Authenticate)")
+    var auth_nonce_ttl int
+    fs.IntVar(&auth_nonce_ttl, "auth_nonce_ttl", 300, "lifetime of the local SIP Digest authentication " +
+                                "nonces (seconds)")
+    var auth_algorithms string
+    fs.StringVar(&auth_algorithms, "auth_algorithms", "MD5", "digest algorithms offered by the local SIP Digest " +
+                                "authentication in the order of preference, any of MD5, SHA-256 and " +
+                                "SHA-512-256 (comma-separated list)")
 /*
         if o == '-r':
             global_config.check_and_set('rtp_proxy_client', a)
@@ -171,13 +374,22 @@ func (self *myConfigParser) Parse() error {
             global_config.check_and_set('radiusclient.conf', a)
             continue
 */
+    var allowed_pts string
+    fs.StringVar(&allowed_pts, "F", "", "allowed_pts")
+    fs.StringVar(&allowed_pts, "allowed_pts", "", "list of allowed media (RTP) IANA-assigned payload " +
+                                "types that the B2BUA will pass from input to " +
+                                "output, payload types not in this list will be " +
+                                "filtered out (comma separated list)")
+    fs.StringVar(&self.radiusclient_conf, "R", "", "radiusclient.conf")
+    fs.StringVar(&self.radiusclient_conf, "radiusclient.conf", "", "path to the radiusclient.conf file to take " +
+                                "the RADIUS servers, secret, timeout and retries from when not given explicitly")
     var pass_header, pass_headers string
-    flag.StringVar(&pass_header, "h", "", "pass_header")
-    flag.StringVar(&pass_headers, "pass_headers", "", "list of SIP header field names that the B2BUA will " +
+    fs.StringVar(&pass_header, "h", "", "pass_header")
+    fs.StringVar(&pass_headers, "pass_headers", "", "list of SIP header field names that the B2BUA will " +
                                 "pass from ingress call leg to egress call leg " +
                                 "unmodified (comma-separated list)")
-    flag.StringVar(&self.b2bua_socket, "c", "/var/run/b2bua.sock", "b2bua_socket")
-    flag.StringVar(&self.b2bua_socket, "b2bua_socket", "/var/run/b2bua.sock", "path to the B2BUA command socket or address to listen " +
+    fs.StringVar(&self.b2bua_socket, "c", "/var/run/b2bua.sock", "b2bua_socket")
+    fs.StringVar(&self.b2bua_socket, "b2bua_socket", "/var/run/b2bua.sock", "path to the B2BUA command socket or address to listen " +
                                         "for commands in the format \"udp:host[:port]\"")
 /*
         if o == '-M':
@@ -196,23 +408,108 @@ func (self *myConfigParser) Parse() error {
             writeconf = a.strip()
             continue
 */
+    fs.BoolVar(&self.hide_call_id, "H", false, "hide_call_id")
+    fs.BoolVar(&self.hide_call_id, "hide_call_id", false, "do not pass Call-ID header value from ingress call " +
+                                "leg to egress call leg")
+    fs.BoolVar(&self.topology_hiding, "topology_hiding", false, "hide the topology of each call leg from the other: " +
+                                "generate opaque Call-IDs, never pass the Via, Record-Route, Contact, User-Agent " +
+                                "and Server headers nor the redirect targets and scrub the SDP origin. The media " +
+                                "addresses are hidden only when the RTPproxy is used")
+    fs.StringVar(&self.loop_id, "loop_id", "", "id of this B2BUA instance in the " + LOOP_HEADER + " header " +
+                                "used for the loop detection, derived from the host name and the SIP address by default")
+    var config_file string
+    fs.StringVar(&config_file, "C", "", "config")
+    fs.StringVar(&config_file, "config", "", "load configuration from file (path to file)")
+    fs.StringVar(&self.writeconf, "W", "", "write the effective configuration into the file (path to file)")
     var rtp_proxy_clients, rtp_proxy_client string
-    flag.StringVar(&rtp_proxy_clients, "rtp_proxy_clients", "", "comma-separated list of paths or addresses of the " +
+    fs.StringVar(&rtp_proxy_clients, "rtp_proxy_clients", "", "comma-separated list of paths or addresses of the " +
                                                                 "RTPproxy control socket. Address in the format " +
                                                                 "\"udp:host[:port]\" (comma-separated list)")
-    flag.StringVar(&rtp_proxy_client, "rtp_proxy_client", "", "RTPproxy control socket. Address in the format \"udp:host[:port]\"")
-    flag.StringVar(&self.sip_proxy, "sip_proxy", "", "address of the helper proxy to handle \"REGISTER\" " +
+    fs.StringVar(&rtp_proxy_client, "rtp_proxy_client", "", "RTPproxy control socket. Address in the format \"udp:host[:port]\"")
+    fs.StringVar(&self.trunk_regs, "trunk_regs", "", "registrations with the upstream carriers in the format " +
+                                "\"user@domain[:port];registrar=host[:port];auth=user:pass;expires=secs;" +
+                                "contact=uri;name=name\", the calls are routed through the registration by " +
+                                "the \"reg=name\" route parameter (\"|\" separated list)")
+    fs.BoolVar(&self.registrar_enable, "registrar_enable", false, "handle \"REGISTER\" requests locally, the " +
+                                "registered contacts are reachable through the \"loc:\" routes, the users are " +
+                                "authenticated against the auth_db which is required then")
+    fs.StringVar(&self.registrar_db, "registrar_db", "", "file to keep the snapshot of the registrations in so " +
+                                "that they survive a restart, the registrations are kept in memory only by " +
+                                "default (path to file)")
+    fs.IntVar(&self.registrar_min_expires, "registrar_min_expires", 60, "shortest registration interval " +
+                                "accepted by the registrar (seconds)")
+    fs.IntVar(&self.registrar_max_expires, "registrar_max_expires", 7200, "longest registration interval " +
+                                "granted by the registrar (seconds)")
+    fs.IntVar(&self.registrar_default_expires, "registrar_default_expires", 3600, "registration interval " +
+                                "granted by the registrar when the client does not ask for one (seconds)")
+    fs.StringVar(&self.sip_proxy, "sip_proxy", "", "address of the helper proxy to handle \"REGISTER\" " +
                                  "and \"SUBSCRIBE\" messages. Address in the format \"host[:port]\"")
+    var radius_servers, radius_acct_servers string
+    var radius_timeout int
+    fs.StringVar(&radius_servers, "radius_servers", "", "comma-separated list of the RADIUS servers in the " +
+                                "format \"host[:port]\", tried in the order given")
+    fs.StringVar(&radius_acct_servers, "radius_acct_servers", "", "comma-separated list of the RADIUS accounting " +
+                                "servers, the same hosts as in the radius_servers on port 1813 by default")
+    fs.StringVar(&self.radius_secret, "radius_secret", "", "RADIUS shared secret")
+    fs.IntVar(&radius_timeout, "radius_timeout", 2, "time to wait for the RADIUS reply before retransmitting (seconds)")
+    fs.IntVar(&self.radius_retries, "radius_retries", 3, "number of times to send the RADIUS request to a " +
+                                "server before failing over to the next one")
     var sip_port int
-    flag.IntVar(&sip_port, "p", 5060, "sip_port")
-    flag.IntVar(&sip_port, "sip_port", 5060, "local UDP port to listen for incoming SIP requests")
-    flag.Parse()
+    fs.IntVar(&sip_port, "p", 5060, "sip_port")
+    fs.IntVar(&sip_port, "sip_port", 5060, "local UDP port to listen for incoming SIP requests")
+    var sip_tcp bool
+    fs.BoolVar(&sip_tcp, "sip_tcp", false, "enable SIP over TCP on the same local address and port")
+    var sip_tls bool
+    var tls_port int
+    var tls_cert, tls_key, tls_ca string
+    var tls_verify_client bool
+    fs.BoolVar(&sip_tls, "sip_tls", false, "enable SIP over TLS (SIPS)")
+    fs.IntVar(&tls_port, "sip_tls_port", 5061, "local TCP port to listen for incoming SIP over TLS requests")
+    fs.StringVar(&tls_cert, "tls_cert", "", "path to the TLS certificate file (PEM)")
+    fs.StringVar(&tls_key, "tls_key", "", "path to the TLS private key file (PEM)")
+    fs.StringVar(&tls_ca, "tls_ca", "", "path to the CA certificates file (PEM) to verify TLS peers with")
+    fs.BoolVar(&tls_verify_client, "tls_verify_client", false, "require and verify the client certificate " +
+                                "on incoming TLS connections")
+    var sip_ws, sip_wss bool
+    var ws_port, wss_port int
+    fs.BoolVar(&sip_ws, "sip_ws", false, "enable SIP over WebSocket for the browser based clients")
+    fs.IntVar(&ws_port, "sip_ws_port", 8080, "local TCP port to listen for incoming SIP over WebSocket connections")
+    fs.BoolVar(&sip_wss, "sip_wss", false, "enable SIP over secure WebSocket, uses the TLS certificate settings")
+    fs.IntVar(&wss_port, "sip_wss_port", 8443, "local TCP port to listen for incoming SIP over secure WebSocket connections")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if config_file != "" {
+        // The options given on the command line take precedence
+        err := readConfigFile(fs, config_file)
+        if err != nil {
+            return err
+        }
+    }
+    if self.radiusclient_conf != "" {
+        err := readRadiusclientConf(fs, self.radiusclient_conf)
+        if err != nil {
+            return err
+        }
+    }
 
     if sip_port <= 0 || sip_port > 65535 {
         return errors.New("sip_port should be in the range 1-65535")
     }
+    if tls_port <= 0 || tls_port > 65535 {
+        return errors.New("sip_tls_port should be in the range 1-65535")
+    }
+    if ws_port <= 0 || ws_port > 65535 {
+        return errors.New("sip_ws_port should be in the range 1-65535")
+    }
+    if wss_port <= 0 || wss_port > 65535 {
+        return errors.New("sip_wss_port should be in the range 1-65535")
+    }
 
-    rtp_proxy_clients += "," + rtp_proxy_client
+    if rtp_proxy_client != "" {
+        // compatibility option
+        rtp_proxy_clients = strings.Trim(rtp_proxy_clients + "," + rtp_proxy_client, ",")
+    }
     arr := strings.Split(rtp_proxy_clients, ",")
     for _, s := range arr {
         s = strings.TrimSpace(s)
@@ -220,14 +517,163 @@ func (self *myConfigParser) Parse() error {
             self.rtp_proxy_clients = append(self.rtp_proxy_clients, s)
         }
     }
-    arr = strings.Split(accept_ips, ",")
-    for _, s := range arr {
+    for _, acl := range []struct { list string; allow bool }{
+            { accept_ips, true },
+            { deny_ips, false },
+          } {
+        for _, s := range strings.Split(acl.list, ",") {
+            if strings.TrimSpace(s) == "" {
+                continue
+            }
+            if err := self.accept_ips.add(s, acl.allow); err != nil {
+                return errors.New("accept_ips/deny_ips: " + err.Error())
+            }
+        }
+    }
+    for _, s := range strings.Split(radius_servers, ",") {
         s = strings.TrimSpace(s)
         if s != "" {
-            self.accept_ips[s] = true
+            self.radius_servers = append(self.radius_servers, s)
         }
     }
-    pass_headers += "," + pass_header
+    for _, s := range strings.Split(radius_acct_servers, ",") {
+        s = strings.TrimSpace(s)
+        if s != "" {
+            self.radius_acct_servers = append(self.radius_acct_servers, s)
+        }
+    }
+    if len(self.radius_acct_servers) == 0 {
+        for _, s := range self.radius_servers {
+            host, _, err := net.SplitHostPort(s)
+            if err != nil {
+                host = s
+            }
+            self.radius_acct_servers = append(self.radius_acct_servers, net.JoinHostPort(strings.Trim(host, "[]"), "1813"))
+        }
+    }
+    switch acct_level {
+    case -1:
+        // not specified
+    case 0:
+        self.acct_enable = false
+        self.start_acct_enable = false
+    case 1:
+        self.acct_enable = true
+        self.start_acct_enable = false
+    case 2:
+        self.acct_enable = true
+        self.start_acct_enable = true
+    default:
+        return errors.New("-A argument not in the range 0-2")
+    }
+    if alive_acct_int < 0 {
+        return errors.New("alive_acct_int should be non-negative")
+    }
+    self.alive_acct_int = time.Duration(alive_acct_int) * time.Second
+    if self.cdr_format != CDR_FORMAT_CSV && self.cdr_format != CDR_FORMAT_JSONL {
+        return errors.New("cdr_format should be either \"csv\" or \"jsonl\"")
+    }
+    if cdr_rotate_size < 0 || cdr_rotate_ival < 0 {
+        return errors.New("cdr_rotate_size and cdr_rotate_ival should be non-negative")
+    }
+    self.cdr_rotate_size = int64(cdr_rotate_size) * 1024
+    self.cdr_rotate_ival = time.Duration(cdr_rotate_ival) * time.Second
+    if self.acct_enable && len(self.radius_servers) == 0 {
+        return errors.New("radius_servers should be specified when Radius accounting is enabled")
+    }
+    if self.auth_enable && len(self.radius_servers) == 0 {
+        return errors.New("radius_servers should be specified when Radius auth is enabled")
+    }
+    if len(self.radius_servers) > 0 && self.radius_secret == "" {
+        return errors.New("radius_secret should be specified along with the radius_servers")
+    }
+    if radius_timeout <= 0 {
+        return errors.New("radius_timeout should be more than zero")
+    }
+    self.radius_timeout = time.Duration(radius_timeout) * time.Second
+    if http_route_timeout <= 0 {
+        return errors.New("http_route_timeout should be more than zero")
+    }
+    self.http_route_timeout = time.Duration(http_route_timeout) * time.Millisecond
+    for _, s := range strings.Split(http_route_headers, ",") {
+        s = strings.TrimSpace(s)
+        if s != "" {
+            self.http_route_headers = append(self.http_route_headers, s)
+        }
+    }
+    for _, tr := range []struct{ name, rules string; res **numberTranslator }{
+            { "static_tr_in", self.static_tr_in, &self.tr_in },
+            { "static_tr_out", self.static_tr_out, &self.tr_out },
+            { "static_tr_cli_in", self.static_tr_cli_in, &self.tr_cli_in },
+            { "static_tr_cli_out", self.static_tr_cli_out, &self.tr_cli_out },
+          } {
+        if tr.rules == "" {
+            continue
+        }
+        var err error
+        if *tr.res, err = NewNumberTranslator(tr.rules); err != nil {
+            return errors.New(tr.name + ": " + err.Error())
+        }
+    }
+    if max_credit_time < 0 {
+        return errors.New("max_credit_time should be non-negative")
+    }
+    self.max_credit_time = time.Duration(max_credit_time) * time.Second
+    if self.cac_max_calls < 0 || self.cac_src_max_calls < 0 || self.cac_user_max_calls < 0 || self.cac_route_max_calls < 0 ||
+      self.cac_cps < 0 || self.cac_src_cps < 0 || self.cac_user_cps < 0 || self.cac_route_cps < 0 {
+        return errors.New("the call admission limits should be non-negative")
+    }
+    if self.cac_reject_code != 503 && self.cac_reject_code != 486 {
+        return errors.New("cac_reject_code should be either 503 or 486")
+    }
+    if self.auth_challenge != 401 && self.auth_challenge != 407 {
+        return errors.New("auth_challenge should be either 401 or 407")
+    }
+    if auth_nonce_ttl <= 0 {
+        return errors.New("auth_nonce_ttl should be positive")
+    }
+    self.auth_nonce_ttl = time.Duration(auth_nonce_ttl) * time.Second
+    self.auth_algorithms = []string{}
+    for _, s := range strings.Split(auth_algorithms, ",") {
+        s = strings.ToUpper(strings.TrimSpace(s))
+        if s == "" {
+            continue
+        }
+        if strings.HasSuffix(s, "-SESS") || ! sippy_header.DigestAlgorithmSupported(s) {
+            return errors.New("unsupported digest algorithm in auth_algorithms: " + s)
+        }
+        self.auth_algorithms = append(self.auth_algorithms, s)
+    }
+    if len(self.auth_algorithms) == 0 {
+        return errors.New("auth_algorithms should not be empty")
+    }
+    if self.registrar_min_expires <= 0 || self.registrar_max_expires < self.registrar_min_expires {
+        return errors.New("registrar_max_expires should not be less than the positive registrar_min_expires")
+    }
+    if self.registrar_default_expires < self.registrar_min_expires || self.registrar_default_expires > self.registrar_max_expires {
+        return errors.New("registrar_default_expires should be between registrar_min_expires and registrar_max_expires")
+    }
+    if self.registrar_enable && self.auth_db == "" {
+        return errors.New("registrar_enable requires auth_db to authenticate the REGISTER requests")
+    }
+    if self.cac_retry_after < 0 {
+        return errors.New("cac_retry_after should be non-negative")
+    }
+    for _, s := range strings.Split(allowed_pts, ",") {
+        s = strings.TrimSpace(s)
+        if s == "" {
+            continue
+        }
+        pt, err := strconv.Atoi(s)
+        if err != nil || pt < 0 || pt > 127 {
+            return errors.New("allowed_pts: invalid payload type '" + s + "'")
+        }
+        self.allowed_pts = append(self.allowed_pts, pt)
+    }
+    if pass_header != "" {
+        // compatibility option
+        pass_headers = strings.Trim(pass_headers + "," + pass_header, ",")
+    }
     arr = strings.Split(pass_headers, ",")
     for _, s := range arr {
         s = strings.TrimSpace(s)
@@ -235,34 +681,103 @@ func (self *myConfigParser) Parse() error {
             self.pass_headers = append(self.pass_headers, s)
         }
     }
+    // The keepalive_ans and keepalive_orig take precedence over the -k
     switch ka_level {
     case 0:
         // do nothing
     case 1:
-        self.keepalive_ans = 32 * time.Second
+        if keepalive_ans == 0 { keepalive_ans = 32 }
     case 2:
-        self.keepalive_orig = 32 * time.Second
+        if keepalive_orig == 0 { keepalive_orig = 32 }
     case 3:
-        self.keepalive_ans = 32 * time.Second
-        self.keepalive_orig = 32 * time.Second
+        if keepalive_ans == 0 { keepalive_ans = 32 }
+        if keepalive_orig == 0 { keepalive_orig = 32 }
     default:
         return errors.New("-k argument not in the range 0-3")
     }
+    if keepalive_ans < 0 || keepalive_orig < 0 {
+        return errors.New("keepalive_ans and keepalive_orig should be non-negative")
+    }
+    self.keepalive_ans_method = strings.ToUpper(self.keepalive_ans_method)
+    self.keepalive_orig_method = strings.ToUpper(self.keepalive_orig_method)
+    for _, method := range []string{ self.keepalive_ans_method, self.keepalive_orig_method } {
+        switch method {
+        case "INVITE", "OPTIONS", "UPDATE":
+        default:
+            return errors.New("keepalive_ans_method and keepalive_orig_method should be INVITE, OPTIONS or UPDATE")
+        }
+    }
+    if self.keepalive_ans_failures < 1 || self.keepalive_orig_failures < 1 {
+        return errors.New("keepalive_ans_failures and keepalive_orig_failures should be positive")
+    }
     if keepalive_ans > 0 {
         self.keepalive_ans = time.Duration(keepalive_ans) * time.Second
     }
     if keepalive_orig > 0 {
         self.keepalive_orig = time.Duration(keepalive_orig) * time.Second
     }
+    if session_expires_ans < 0 || session_expires_orig < 0 {
+        return errors.New("session_expires_ans and session_expires_orig should be non-negative")
+    }
+    if session_min_se < 90 {
+        return errors.New("session_min_se should be at least 90")
+    }
+    self.session_expires_ans = time.Duration(session_expires_ans) * time.Second
+    self.session_expires_orig = time.Duration(session_expires_orig) * time.Second
+    self.session_min_se = time.Duration(session_min_se) * time.Second
+    self.hrtb_ival = time.Duration(hrtb_ival) * time.Second
+    self.hrtb_retr_ival = time.Duration(hrtb_retr_ival) * time.Second
+    switch self.sip_address {
+    case "*", "0.0.0.0", "::":
+        // listen on the system default address
+    default:
+        if net.ParseIP(strings.Trim(self.sip_address, "[]")) == nil {
+            if _, err := net.LookupHost(self.sip_address); err != nil {
+                return errors.New("sip_address: cannot resolve '" + self.sip_address + "': " + err.Error())
+            }
+        }
+    }
+    if self.loop_id == "" {
+        self.loop_id = defaultLoopId(self.sip_address, sip_port)
+    } else if strings.ContainsAny(self.loop_id, ", \t") {
+        return errors.New("loop_id should not contain commas or whitespace")
+    }
+    self.flags = fs
+    self.config_file = config_file
+    self.values = make(map[string]string)
+    fs.VisitAll(func(fl *flag.Flag) {
+        if len(fl.Name) > 1 {
+            self.values[fl.Name] = fl.Value.String()
+        }
+    })
+    if running != nil {
+        // The logging and the SIP stack can not be re-initialized on the fly
+        self.Config = running.Config
+        return nil
+    }
     error_logger := sippy_log.NewErrorLogger()
     sip_logger, err := sippy_log.NewSipLogger("b2bua", logfile)
     if err != nil {
         return err
     }
-    self.hrtb_ival = time.Duration(hrtb_ival) * time.Second
-    self.hrtb_retr_ival = time.Duration(hrtb_retr_ival) * time.Second
     self.Config = sippy_conf.NewConfig(error_logger, sip_logger)
     self.SetMyPort(sippy_net.NewMyPort(strconv.Itoa(sip_port)))
+    switch self.sip_address {
+    case "*", "0.0.0.0", "::":
+    default:
+        self.SetMyAddress(sippy_net.NewMyAddress(self.sip_address))
+    }
+    self.SetTcpEnabled(sip_tcp)
+    self.SetTlsEnabled(sip_tls)
+    self.SetTlsPort(sippy_net.NewMyPort(strconv.Itoa(tls_port)))
+    self.SetTlsCertFile(tls_cert)
+    self.SetTlsKeyFile(tls_key)
+    self.SetTlsCAFile(tls_ca)
+    self.SetTlsVerifyClient(tls_verify_client)
+    self.SetWsEnabled(sip_ws)
+    self.SetWsPort(sippy_net.NewMyPort(strconv.Itoa(ws_port)))
+    self.SetWssEnabled(sip_wss)
+    self.SetWssPort(sippy_net.NewMyPort(strconv.Itoa(wss_port)))
     return nil
 }
 /*
@@ -487,9 +1002,5 @@ if __name__ == '__main__':
 */
 
 func (self *myConfigParser) checkIP(ip string) bool {
-    if len(self.accept_ips) == 0 {
-        return true
-    }
-    _, ok := self.accept_ips[ip]
-    return ok
+    return self.accept_ips.check(ip)
 }
@@ -26,168 +26,235 @@
 // SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package main
 
-/*
-from time import time, strftime, gmtime
-from Timeout import Timeout
+import (
+    "fmt"
+    "math"
+    "strings"
+    "sync"
+    "time"
 
-sipErrToH323Err = {400:('7f', 'Interworking, unspecified'), 401:('39', 'Bearer capability not authorized'), \
-  402:('15', 'Call rejected'), 403:('39', 'Bearer capability not authorized'), 404:('1', 'Unallocated number'), \
-  405:('7f', 'Interworking, unspecified'), 406:('7f', 'Interworking, unspecified'), 407:('15', 'Call rejected'), \
-  408:('66', 'Recover on Expires timeout'), 409:('29', 'Temporary failure'), 410:('1', 'Unallocated number'), \
-  411:('7f', 'Interworking, unspecified'), 413:('7f', 'Interworking, unspecified'), 414:('7f', 'Interworking, unspecified'), \
-  415:('4f', 'Service or option not implemented'), 420:('7f', 'Interworking, unspecified'), 480:('12', 'No user response'), \
-  481:('7f', 'Interworking, unspecified'), 482:('7f', 'Interworking, unspecified'), 483:('7f', 'Interworking, unspecified'), \
-  484:('1c', 'Address incomplete'), 485:('1', 'Unallocated number'), 486:('11', 'User busy'), 487:('12', 'No user responding'), \
-  488:('7f', 'Interworking, unspecified'), 500:('29', 'Temporary failure'), 501:('4f', 'Service or option not implemented'), \
-  502:('26', 'Network out of order'), 503:('3f', 'Service or option unavailable'), 504:('66', 'Recover on Expires timeout'), \
-  505:('7f', 'Interworking, unspecified'), 580:('2f', 'Resource unavailable, unspecified'), 600:('11', 'User busy'), \
-  603:('15', 'Call rejected'), 604:('1',  'Unallocated number'), 606:('3a', 'Bearer capability not presently available')}
+    "sippy"
+    "sippy/headers"
+    "sippy/radius"
+    "sippy/time"
+    "sippy/types"
+)
 
-class RadiusAccounting(object):
-    global_config = nil
-    drec = nil
-    crec = nil
-    iTime = nil
-    cTime = nil
-    sip_cid = nil
-    origin = nil
-    lperiod = nil
-    el = nil
-    send_start = nil
-    complete = false
-    ms_precision = false
-    user_agent = nil
-    p1xx_ts = nil
-    p100_ts = nil
+var sipErrToH323Err = map[int][]string{
+    400 : { "7f", "Interworking, unspecified" }, 401 : { "39", "Bearer capability not authorized" },
+    402 : { "15", "Call rejected" }, 403 : { "39", "Bearer capability not authorized" }, 404 : { "1", "Unallocated number" },
+    405 : { "7f", "Interworking, unspecified" }, 406 : { "7f", "Interworking, unspecified" }, 407 : { "15", "Call rejected" },
+    408 : { "66", "Recover on Expires timeout" }, 409 : { "29", "Temporary failure" }, 410 : { "1", "Unallocated number" },
+    411 : { "7f", "Interworking, unspecified" }, 413 : { "7f", "Interworking, unspecified" }, 414 : { "7f", "Interworking, unspecified" },
+    415 : { "4f", "Service or option not implemented" }, 420 : { "7f", "Interworking, unspecified" }, 480 : { "12", "No user response" },
+    481 : { "7f", "Interworking, unspecified" }, 482 : { "7f", "Interworking, unspecified" }, 483 : { "7f", "Interworking, unspecified" },
+    484 : { "1c", "Address incomplete" }, 485 : { "1", "Unallocated number" }, 486 : { "11", "User busy" }, 487 : { "12", "No user responding" },
+    488 : { "7f", "Interworking, unspecified" }, 500 : { "29", "Temporary failure" }, 501 : { "4f", "Service or option not implemented" },
+    502 : { "26", "Network out of order" }, 503 : { "3f", "Service or option unavailable" }, 504 : { "66", "Recover on Expires timeout" },
+    505 : { "7f", "Interworking, unspecified" }, 580 : { "2f", "Resource unavailable, unspecified" }, 600 : { "11", "User busy" },
+    603 : { "15", "Call rejected" }, 604 : { "1",  "Unallocated number" }, 606 : { "3a", "Bearer capability not presently available" },
+}
 
-    def __init__(self, global_config, origin, lperiod = nil, send_start = false):
-        self.global_config = global_config
-        self._attributes = [('h323-call-origin', origin), ('h323-call-type', 'VoIP'), \
-          ('h323-session-protocol', 'sipv2')]
-        self.drec = false
-        self.crec = false
-        self.origin = origin
-        self.lperiod = lperiod
-        self.send_start = send_start
+type radiusAccounting struct {
+    global_config   *myConfigParser
+    attributes      []*sippy_radius.Attribute
+    drec            bool
+    crec            bool
+    iTime           *sippy_time.MonoTime
+    cTime           *sippy_time.MonoTime
+    sip_cid         string
+    origin          string
+    lperiod         time.Duration
+    el              *sippy.Timeout
+    send_start      bool
+    complete        bool
+    ms_precision    bool
+    user_agent      string
+    p1xx_ts         *sippy_time.MonoTime
+    p100_ts         *sippy_time.MonoTime
+    lock            sync.Locker
+}
 
-    def setParams(self, username, caller, callee, h323_cid, sip_cid, remote_ip, \
-      h323_in_cid = nil):
-        if caller == nil:
-            caller = ''
-        self._attributes.extend((('User-Name', username), ('Calling-Station-Id', caller), \
-          ('Called-Station-Id', callee), ('h323-conf-id', h323_cid), ('call-id', sip_cid), \
-          ('Acct-Session-Id', sip_cid), ('h323-remote-address', remote_ip)))
-        if h323_in_cid != nil and h323_in_cid != h323_cid:
-            self._attributes.append(('h323-incoming-conf-id', h323_in_cid))
-        self.sip_cid = str(sip_cid)
-        self.complete = true
+func NewRadiusAccounting(global_config *myConfigParser, origin string, lock sync.Locker, lperiod time.Duration, send_start bool) *radiusAccounting {
+    return &radiusAccounting{
+        global_config   : global_config,
+        attributes      : []*sippy_radius.Attribute{
+            sippy_radius.NewAttribute("h323-call-origin", origin),
+            sippy_radius.NewAttribute("h323-call-type", "VoIP"),
+            sippy_radius.NewAttribute("h323-session-protocol", "sipv2"),
+        },
+        origin          : origin,
+        lperiod         : lperiod,
+        send_start      : send_start,
+        lock            : lock,
+    }
+}
 
-    def conn(self, ua, rtime, origin):
-        if self.crec:
-            return
-        self.crec = true
-        self.iTime = ua.setup_ts
-        self.cTime = ua.connect_ts
-        if ua.remote_ua != nil and self.user_agent == nil:
-            self.user_agent = ua.remote_ua
-        if ua.p1xx_ts != nil:
-            self.p1xx_ts = ua.p1xx_ts
-        if ua.p100_ts != nil:
-            self.p100_ts = ua.p100_ts
-        if self.send_start:
-            self.asend('Start', rtime, origin, ua)
-        self._attributes.extend((('h323-voice-quality', 0), ('Acct-Terminate-Cause', 'User-Request')))
-        if self.lperiod != nil and self.lperiod > 0:
-            self.el = Timeout(self.asend, self.lperiod, -1, 'Alive')
+func (self *radiusAccounting) setParams(username, caller, callee string, h323_cid *sippy_header.SipCiscoGUID, sip_cid string, remote_ip string) {
+    h323_conf_id := ""
+    if h323_cid != nil {
+        h323_conf_id = h323_cid.HexForm()
+    }
+    self.attributes = append(self.attributes,
+        sippy_radius.NewAttribute("User-Name", username),
+        sippy_radius.NewAttribute("Calling-Station-Id", caller),
+        sippy_radius.NewAttribute("Called-Station-Id", callee),
+        sippy_radius.NewAttribute("h323-conf-id", h323_conf_id),
+        sippy_radius.NewAttribute("call-id", sip_cid),
+        sippy_radius.NewAttribute("Acct-Session-Id", sip_cid),
+        sippy_radius.NewAttribute("h323-remote-address", remote_ip))
+    self.sip_cid = sip_cid
+    self.complete = true
+}
 
-    def disc(self, ua, rtime, origin, result = 0):
-        if self.drec:
-            return
-        self.drec = true
-        if self.el != nil:
-            self.el.cancel()
-            self.el = nil
-        if self.iTime == nil:
-            self.iTime = ua.setup_ts
-        if self.cTime == nil:
-            self.cTime = rtime
-        if ua.remote_ua != nil and self.user_agent == nil:
-            self.user_agent = ua.remote_ua
-        if ua.p1xx_ts != nil:
-            self.p1xx_ts = ua.p1xx_ts
-        if ua.p100_ts != nil:
-            self.p100_ts = ua.p100_ts
-        self.asend('Stop', rtime, origin, result, ua)
+func (self *radiusAccounting) conn(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string) {
+    if self.crec {
+        return
+    }
+    self.crec = true
+    self.iTime = ua.GetSetupTs()
+    self.cTime = ua.GetConnectTs()
+    if self.cTime == nil {
+        self.cTime = rtime
+    }
+    self.updateFromUA(ua)
+    if self.send_start {
+        self.asend("Start", rtime, origin, 0, ua)
+    }
+    self.attributes = append(self.attributes,
+        sippy_radius.NewAttribute("h323-voice-quality", "0"),
+        sippy_radius.NewAttribute("Acct-Terminate-Cause", "User-Request"))
+    if self.lperiod > 0 {
+        self.el = sippy.StartTimeout(func() { self.asend("Alive", nil, "", 0, nil) }, self.lock, self.lperiod, -1, self.global_config.ErrorLogger())
+    }
+}
 
-    def asend(self, type, rtime = nil, origin = nil, result = 0, ua = nil):
-        if not self.complete:
-            return
-        if rtime == nil:
-            rtime = time()
-        if ua != nil:
-            duration, delay, connected = ua.getAcct()[:3]
-        else:
-            # Alive accounting
-            duration = rtime - self.cTime
-            delay = self.cTime - self.iTime
-            connected = true
-        if not(self.ms_precision):
-            duration = round(duration)
-            delay = round(delay)
-        attributes = self._attributes[:]
-        if type != 'Start':
-            if result >= 400:
-                try:
-                    dc = sipErrToH323Err[result][0]
-                except:
-                    dc = '7f'
-            elif result < 200:
-                dc = '10'
-            else:
-                dc = '0'
-            attributes.extend((('h323-disconnect-time', self.ftime(self.iTime + delay + duration)), \
-              ('Acct-Session-Time', '%d' % round(duration)), ('h323-disconnect-cause', dc)))
-        if type == 'Stop':
-            if origin == 'caller':
-                release_source = '2'
-            elif origin == 'callee':
-                release_source = '4'
-            else:
-                release_source = '8'
-            attributes.append(('release-source', release_source))
-        attributes.extend((('h323-connect-time', self.ftime(self.iTime + delay)), ('h323-setup-time', self.ftime(self.iTime)), \
-          ('Acct-Status-Type', type)))
-        if self.user_agent != nil:
-            attributes.append(('h323-ivr-out', 'sip_ua:' + self.user_agent))
-        if self.p1xx_ts != nil:
-            attributes.append(('Acct-Delay-Time', round(self.p1xx_ts)))
-        if self.p100_ts != nil:
-            attributes.append(('provisional-timepoint', self.ftime(self.p100_ts)))
-        pattributes = ['%-32s = \'%s\'\n' % (x[0], str(x[1])) for x in attributes]
-        pattributes.insert(0, 'sending Acct %s (%s):\n' % (type, self.origin.capitalize()))
-        self.global_config['_sip_logger'].write(call_id = self.sip_cid, *pattributes)
-        self.global_config['_radius_client'].do_acct(attributes, self._process_result, self.sip_cid, time())
+func (self *radiusAccounting) disc(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string, result int) {
+    if self.drec {
+        return
+    }
+    self.drec = true
+    if self.el != nil {
+        self.el.Cancel()
+        self.el = nil
+    }
+    if self.iTime == nil {
+        self.iTime = ua.GetSetupTs()
+    }
+    if self.cTime == nil {
+        self.cTime = rtime
+    }
+    self.updateFromUA(ua)
+    self.asend("Stop", rtime, origin, result, ua)
+}
 
-    def ftime(self, t):
-        gt = gmtime(t)
-        day = strftime('%d', gt)
-        if day[0] == '0':
-            day = day[1]
-        if self.ms_precision:
-            msec = (t % 1) * 1000
-        else:
-            msec = 0
-        return strftime('%%H:%%M:%%S.%.3d GMT %%a %%b %s %%Y' % (msec, day), gt)
+func (self *radiusAccounting) fail(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string, result int) {
+    self.disc(ua, rtime, origin, result)
+}
 
-    def _process_result(self, results, sip_cid, btime):
-        delay = time() - btime
-        rcode = results[1]
-        if rcode in (0, 1):
-            if rcode == 0:
-                message = 'Acct/%s request accepted (delay is %.3f)\n' % (self.origin, delay)
-            else:
-                message = 'Acct/%s request rejected (delay is %.3f)\n' % (self.origin, delay)
-        else:
-            message = 'Error sending Acct/%s request (delay is %.3f)\n' % (self.origin, delay)
-        self.global_config['_sip_logger'].write(message, call_id = sip_cid)
-*/
+func (self *radiusAccounting) updateFromUA(ua sippy_types.UA) {
+    if ua.GetRemoteUA() != "" && self.user_agent == "" {
+        self.user_agent = ua.GetRemoteUA()
+    }
+    if ua.GetP1xxTs() != nil {
+        self.p1xx_ts = ua.GetP1xxTs()
+    }
+    if ua.GetP100Ts() != nil {
+        self.p100_ts = ua.GetP100Ts()
+    }
+}
+
+func (self *radiusAccounting) asend(typ string, rtime *sippy_time.MonoTime, origin string, result int, ua sippy_types.UA) {
+    var duration, delay time.Duration
+
+    if ! self.complete || self.iTime == nil {
+        return
+    }
+    if rtime == nil {
+        rtime, _ = sippy_time.NewMonoTime()
+    }
+    if ua != nil {
+        duration, delay, _, _ = ua.GetAcct(rtime)
+    } else {
+        // Alive accounting
+        duration = rtime.Sub(self.cTime)
+        delay = self.cTime.Sub(self.iTime)
+    }
+    if ! self.ms_precision {
+        duration = duration.Round(time.Second)
+        delay = delay.Round(time.Second)
+    }
+    attributes := make([]*sippy_radius.Attribute, len(self.attributes))
+    copy(attributes, self.attributes)
+    if typ != "Start" {
+        var dc string
+        if result >= 400 {
+            if h323err, ok := sipErrToH323Err[result]; ok {
+                dc = h323err[0]
+            } else {
+                dc = "7f"
+            }
+        } else if result < 200 {
+            dc = "10"
+        } else {
+            dc = "0"
+        }
+        attributes = append(attributes,
+            sippy_radius.NewAttribute("h323-disconnect-time", self.ftime(self.iTime.Realt().Add(delay + duration))),
+            sippy_radius.NewAttribute("Acct-Session-Time", fmt.Sprintf("%d", int64(math.Round(duration.Seconds())))),
+            sippy_radius.NewAttribute("h323-disconnect-cause", dc))
+    }
+    if typ == "Stop" {
+        release_source := "8"
+        if origin == "caller" {
+            release_source = "2"
+        } else if origin == "callee" {
+            release_source = "4"
+        }
+        attributes = append(attributes, sippy_radius.NewAttribute("release-source", release_source))
+    }
+    attributes = append(attributes,
+        sippy_radius.NewAttribute("h323-connect-time", self.ftime(self.iTime.Realt().Add(delay))),
+        sippy_radius.NewAttribute("h323-setup-time", self.ftime(self.iTime.Realt())),
+        sippy_radius.NewAttribute("Acct-Status-Type", typ))
+    if self.user_agent != "" {
+        attributes = append(attributes, sippy_radius.NewAttribute("h323-ivr-out", "sip_ua:" + self.user_agent))
+    }
+    if self.p1xx_ts != nil {
+        // The post dial delay, i.e. the time till the first provisional response
+        attributes = append(attributes, sippy_radius.NewAttribute("Acct-Delay-Time", fmt.Sprintf("%d", int64(math.Round(self.p1xx_ts.Sub(self.iTime).Seconds())))))
+    }
+    if self.p100_ts != nil {
+        attributes = append(attributes, sippy_radius.NewAttribute("provisional-timepoint", self.ftime(self.p100_ts.Realt())))
+    }
+    message := fmt.Sprintf("sending Acct %s (%s):\n", typ, strings.ToUpper(self.origin[:1]) + self.origin[1:]) + formatAttributes(attributes)
+    self.global_config.SipLogger().Write(nil, self.sip_cid, message)
+    btime := time.Now()
+    global_radius_client.doAcct(attributes, func(results []*sippy_radius.Attribute, rcode int) {
+        self.processResult(rcode, btime)
+    })
+}
+
+func (self *radiusAccounting) ftime(t time.Time) string {
+    t = t.UTC()
+    msec := 0
+    if self.ms_precision {
+        msec = t.Nanosecond() / 1000000
+    }
+    return fmt.Sprintf("%02d:%02d:%02d.%03d GMT %s %s %d %d", t.Hour(), t.Minute(), t.Second(), msec,
+        t.Weekday().String()[:3], t.Month().String()[:3], t.Day(), t.Year())
+}
+
+func (self *radiusAccounting) processResult(rcode int, btime time.Time) {
+    delay := time.Since(btime).Seconds()
+    var message string
+    switch rcode {
+    case sippy_radius.RESULT_ACCEPT:
+        message = fmt.Sprintf("Acct/%s request accepted (delay is %.3f)\n", self.origin, delay)
+    case sippy_radius.RESULT_REJECT:
+        message = fmt.Sprintf("Acct/%s request rejected (delay is %.3f)\n", self.origin, delay)
+    default:
+        message = fmt.Sprintf("Error sending Acct/%s request (delay is %.3f)\n", self.origin, delay)
+    }
+    self.global_config.SipLogger().Write(nil, self.sip_cid, message)
+}
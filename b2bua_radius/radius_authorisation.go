@@ -24,45 +24,102 @@
 // (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
 // SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package main
-/*
-from Radius_client import Radius_client
-from time import time
 
-class RadiusAuthorisation(Radius_client):
-    def do_auth(self, username, caller, callee, h323_cid, sip_cid, remote_ip, res_cb, \
-      realm = nil, nonce = nil, uri = nil, response = nil, extra_attributes = nil):
-        sip_cid = str(sip_cid)
-        attributes = nil
-        if nil not in (realm, nonce, uri, response):
-            attributes = [('User-Name', username), ('Digest-Realm', realm), \
-              ('Digest-Nonce', nonce), ('Digest-Method', 'INVITE'), ('Digest-URI', uri), \
-              ('Digest-Algorithm', 'MD5'), ('Digest-User-Name', username), ('Digest-Response', response)]
-        else:
-            attributes = [('User-Name', remote_ip), ('Password', 'cisco')]
-        if caller == nil:
-            caller = ''
-        attributes.extend((('Calling-Station-Id', caller), ('Called-Station-Id', callee), ('h323-conf-id', h323_cid), \
-          ('call-id', sip_cid), ('h323-remote-address', remote_ip), ('h323-session-protocol', 'sipv2')))
-        if extra_attributes != nil:
-            for a, v in extra_attributes:
-                attributes.append((a, v))
-        message = 'sending AAA request:\n' 
-        message += reduce(lambda x, y: x + y, ['%-32s = \'%s\'\n' % (x[0], str(x[1])) for x in attributes])
-        self.global_config['_sip_logger'].write(message, call_id = sip_cid)
-        Radius_client.do_auth(self, attributes, self._process_result, res_cb, sip_cid, time())
+import (
+    "fmt"
+    "time"
 
-    def _process_result(self, results, res_cb, sip_cid, btime):
-        delay = time() - btime
-        rcode = results[1]
-        if rcode in (0, 1):
-            if rcode == 0:
-                message = 'AAA request accepted (delay is %.3f), processing response:\n' % delay
-            else:
-                message = 'AAA request rejected (delay is %.3f), processing response:\n' % delay
-            if len(results[0]) > 0:
-                message += reduce(lambda x, y: x + y, ['%-32s = \'%s\'\n' % x for x in results[0]])
-        else:
-            message = 'Error sending AAA request (delay is %.3f)\n' % delay
-        self.global_config['_sip_logger'].write(message, call_id = sip_cid)
-        res_cb(results)
-*/
+    "sippy/headers"
+    "sippy/radius"
+)
+
+type radiusAuthorisation struct {
+    *radiusClient
+}
+
+func NewRadiusAuthorisation(global_config *myConfigParser) *radiusAuthorisation {
+    return &radiusAuthorisation{
+        radiusClient : NewRadiusClient(global_config),
+    }
+}
+
+func (self *radiusAuthorisation) doAuth(username, caller, callee string, h323_cid *sippy_header.SipCiscoGUID, sip_cid *sippy_header.SipCallId, remote_ip string, res_cb func([]*sippy_radius.Attribute, int), auth *sippy_header.SipAuthorizationBody, extra_attributes ...*sippy_radius.Attribute) {
+    var attributes []*sippy_radius.Attribute
+    if auth != nil {
+        attributes = digestAttributes(username, auth)
+    } else {
+        attributes = []*sippy_radius.Attribute{
+            sippy_radius.NewAttribute("User-Name", remote_ip),
+            sippy_radius.NewAttribute("Password", "cisco"),
+        }
+    }
+    h323_conf_id := ""
+    if h323_cid != nil {
+        h323_conf_id = h323_cid.HexForm()
+    }
+    attributes = append(attributes,
+        sippy_radius.NewAttribute("Calling-Station-Id", caller),
+        sippy_radius.NewAttribute("Called-Station-Id", callee),
+        sippy_radius.NewAttribute("h323-conf-id", h323_conf_id),
+        sippy_radius.NewAttribute("call-id", sip_cid.CallId),
+        sippy_radius.NewAttribute("h323-remote-address", remote_ip),
+        sippy_radius.NewAttribute("h323-session-protocol", "sipv2"))
+    attributes = append(attributes, extra_attributes...)
+    message := "sending AAA request:\n" + formatAttributes(attributes)
+    self.global_config.SipLogger().Write(nil, sip_cid.CallId, message)
+    btime := time.Now()
+    self.radiusClient.doAuth(attributes, func(results []*sippy_radius.Attribute, rcode int) {
+        self.processAuthResult(results, rcode, res_cb, sip_cid.CallId, btime)
+    })
+}
+
+// Returns the RFC 5090 attributes of the digest credentials. The
+// algorithm is the one the client has used, MD5 if not given.
+func digestAttributes(username string, auth *sippy_header.SipAuthorizationBody) []*sippy_radius.Attribute {
+    algorithm := auth.GetAlgorithm()
+    if algorithm == "" {
+        algorithm = "MD5"
+    }
+    attributes := []*sippy_radius.Attribute{
+        sippy_radius.NewAttribute("User-Name", username),
+        sippy_radius.NewAttribute("Digest-Realm", auth.GetRealm()),
+        sippy_radius.NewAttribute("Digest-Nonce", auth.GetNonce()),
+        sippy_radius.NewAttribute("Digest-Method", "INVITE"),
+        sippy_radius.NewAttribute("Digest-URI", auth.GetURI()),
+        sippy_radius.NewAttribute("Digest-Algorithm", algorithm),
+        sippy_radius.NewAttribute("Digest-User-Name", username),
+        sippy_radius.NewAttribute("Digest-Response", auth.GetResponse()),
+    }
+    if auth.GetQop() != "" {
+        attributes = append(attributes,
+            sippy_radius.NewAttribute("Digest-QOP", auth.GetQop()),
+            sippy_radius.NewAttribute("Digest-CNonce", auth.GetCNonce()),
+            sippy_radius.NewAttribute("Digest-Nonce-Count", auth.GetNC()))
+    }
+    return attributes
+}
+
+func (self *radiusAuthorisation) processAuthResult(results []*sippy_radius.Attribute, rcode int, res_cb func([]*sippy_radius.Attribute, int), sip_cid string, btime time.Time) {
+    delay := time.Since(btime).Seconds()
+    var message string
+    if rcode == sippy_radius.RESULT_ACCEPT || rcode == sippy_radius.RESULT_REJECT {
+        if rcode == sippy_radius.RESULT_ACCEPT {
+            message = fmt.Sprintf("AAA request accepted (delay is %.3f), processing response:\n", delay)
+        } else {
+            message = fmt.Sprintf("AAA request rejected (delay is %.3f), processing response:\n", delay)
+        }
+        message += formatAttributes(results)
+    } else {
+        message = fmt.Sprintf("Error sending AAA request (delay is %.3f)\n", delay)
+    }
+    self.global_config.SipLogger().Write(nil, sip_cid, message)
+    res_cb(results, rcode)
+}
+
+func formatAttributes(attributes []*sippy_radius.Attribute) string {
+    s := ""
+    for _, attr := range attributes {
+        s += fmt.Sprintf("%-32s = '%s'\n", attr.Name, attr.Value)
+    }
+    return s
+}
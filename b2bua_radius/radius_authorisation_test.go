@@ -0,0 +1,38 @@
+package main
+
+import (
+    "testing"
+
+    "sippy/headers"
+)
+
+func Test_DigestAttributes(t *testing.T) {
+    for _, tc := range []struct {
+        algorithm   string
+        qop         string
+        expected    string
+        nattrs      int
+    }{
+        { "", "", "MD5", 8 },
+        { "MD5", "auth", "MD5", 11 },
+        { "SHA-256", "auth", "SHA-256", 11 },
+        { "SHA-512-256", "", "SHA-512-256", 8 },
+    } {
+        www := sippy_header.NewSipWWWAuthenticate("example.com", "abc")
+        challenge, _ := www.GetBody()
+        challenge.SetAlgorithm(tc.algorithm)
+        challenge.SetQop(tc.qop)
+        auth, err := sippy_header.NewSipAuthorizationWithChallenge(challenge, "INVITE", "sip:bob@example.com", "alice", "secret", "").GetBody()
+        if err != nil {
+            t.Fatal(err)
+        }
+        attrs := digestAttributes("alice", auth)
+        values := make(map[string]string)
+        for _, attr := range attrs {
+            values[attr.Name] = attr.Value
+        }
+        if len(attrs) != tc.nattrs || values["Digest-Algorithm"] != tc.expected || values["Digest-Response"] != auth.GetResponse() {
+            t.Errorf("%s/%s: unexpected attributes:\n%s", tc.algorithm, tc.qop, formatAttributes(attrs))
+        }
+    }
+}
@@ -25,58 +25,104 @@
 // (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
 // SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package main
-/*
-from External_command import External_command
 
-class Radius_client(External_command):
-    global_config = nil
-    _avpair_names = ('call-id', 'h323-session-protocol', 'h323-ivr-out', 'h323-incoming-conf-id', \
-      'release-source', 'alert-timepoint', 'provisional-timepoint')
-    _cisco_vsa_names = ('h323-remote-address', 'h323-conf-id', 'h323-setup-time', 'h323-call-origin', \
-      'h323-call-type', 'h323-connect-time', 'h323-disconnect-time', 'h323-disconnect-cause', \
-      'h323-voice-quality', 'h323-credit-time', 'h323-return-code', 'h323-redirect-number', \
-      'h323-preferred-lang', 'h323-billing-model', 'h323-currency')
+import (
+    "strings"
 
-    def __init__(self, global_config = {}):
-        self.global_config = global_config
-        command = global_config.getdefault('radiusclient', '/usr/local/sbin/radiusclient')
-        config = global_config.getdefault('radiusclient.conf', nil)
-        max_workers = global_config.getdefault('max_radiusclients', 20)
-        if config != nil:
-            External_command.__init__(self, (command, '-f', config, '-s'), max_workers = max_workers)
-        else:
-            External_command.__init__(self, (command, '-s'), max_workers = max_workers)
+    "sippy/radius"
+)
 
-    def _prepare_attributes(self, type, attributes):
-        data = [type]
-        for a, v in attributes:
-            if a in self._avpair_names:
-                v = '%s=%s' % (str(a), str(v))
-                a = 'Cisco-AVPair'
-            elif a in self._cisco_vsa_names:
-                v = '%s=%s' % (str(a), str(v))
-            data.append('%s="%s"' % (str(a), str(v)))
-        return data
+var _avpair_names = map[string]bool{
+    "call-id"               : true,
+    "h323-session-protocol" : true,
+    "h323-ivr-out"          : true,
+    "h323-incoming-conf-id" : true,
+    "release-source"        : true,
+    "alert-timepoint"       : true,
+    "provisional-timepoint" : true,
+}
 
-    def do_auth(self, attributes, result_callback, *callback_parameters):
-        return External_command.process_command(self, self._prepare_attributes('AUTH', attributes), result_callback, *callback_parameters)
+var _cisco_vsa_names = map[string]bool{
+    "h323-remote-address"   : true,
+    "h323-conf-id"          : true,
+    "h323-setup-time"       : true,
+    "h323-call-origin"      : true,
+    "h323-call-type"        : true,
+    "h323-connect-time"     : true,
+    "h323-disconnect-time"  : true,
+    "h323-disconnect-cause" : true,
+    "h323-voice-quality"    : true,
+    "h323-credit-time"      : true,
+    "h323-return-code"      : true,
+    "h323-redirect-number"  : true,
+    "h323-preferred-lang"   : true,
+    "h323-billing-model"    : true,
+    "h323-currency"         : true,
+}
 
-    def do_acct(self, attributes, result_callback = nil, *callback_parameters):
-        External_command.process_command(self, self._prepare_attributes('ACCT', attributes), result_callback, *callback_parameters)
+type radiusClient struct {
+    global_config   *myConfigParser
+    auth_client     *sippy_radius.Client
+    acct_client     *sippy_radius.Client
+}
 
-    def process_result(self, result_callback, result, *callback_parameters):
-        if result_callback == nil:
-            return
-        nav = []
-        for av in result[:-1]:
-            a, v = [x.strip() for x in av.split(' = ', 1)]
-            v = v.strip('\'')
-            if (a == 'Cisco-AVPair' or a in self._cisco_vsa_names):
-                t = v.split('=', 1)
-                if len(t) > 1:
-                    a, v = t
-            elif v.startswith(a + '='):
-                v = v[len(a) + 1:]
-            nav.append((a, v))
-        External_command.process_result(self, result_callback, (tuple(nav), int(result[-1])), *callback_parameters)
-*/
+func NewRadiusClient(global_config *myConfigParser) *radiusClient {
+    auth_servers := make([]*sippy_radius.Server, len(global_config.radius_servers))
+    for i, address := range global_config.radius_servers {
+        auth_servers[i] = sippy_radius.NewServer(address, "1812", global_config.radius_secret)
+    }
+    acct_servers := make([]*sippy_radius.Server, len(global_config.radius_acct_servers))
+    for i, address := range global_config.radius_acct_servers {
+        acct_servers[i] = sippy_radius.NewServer(address, "1813", global_config.radius_secret)
+    }
+    return &radiusClient{
+        global_config   : global_config,
+        auth_client     : sippy_radius.NewClient(auth_servers, global_config.radius_timeout, global_config.radius_retries),
+        acct_client     : sippy_radius.NewClient(acct_servers, global_config.radius_timeout, global_config.radius_retries),
+    }
+}
+
+// The Cisco attributes are sent as "name=value", either as a VSA of their
+// own or packed into the Cisco-AVPair.
+func (self *radiusClient) prepareAttributes(attributes []*sippy_radius.Attribute) []*sippy_radius.Attribute {
+    data := make([]*sippy_radius.Attribute, len(attributes))
+    for i, attr := range attributes {
+        if _avpair_names[attr.Name] {
+            data[i] = sippy_radius.NewAttribute("Cisco-AVPair", attr.Name + "=" + attr.Value)
+        } else if _cisco_vsa_names[attr.Name] {
+            data[i] = sippy_radius.NewAttribute(attr.Name, attr.Name + "=" + attr.Value)
+        } else {
+            data[i] = attr
+        }
+    }
+    return data
+}
+
+func (self *radiusClient) doAuth(attributes []*sippy_radius.Attribute, result_callback func([]*sippy_radius.Attribute, int)) {
+    self.auth_client.SendRequest(sippy_radius.ACCESS_REQUEST, self.prepareAttributes(attributes),
+        func(results []*sippy_radius.Attribute, rcode int) { self.processResult(result_callback, results, rcode) })
+}
+
+func (self *radiusClient) doAcct(attributes []*sippy_radius.Attribute, result_callback func([]*sippy_radius.Attribute, int)) {
+    self.acct_client.SendRequest(sippy_radius.ACCOUNTING_REQUEST, self.prepareAttributes(attributes),
+        func(results []*sippy_radius.Attribute, rcode int) { self.processResult(result_callback, results, rcode) })
+}
+
+func (self *radiusClient) processResult(result_callback func([]*sippy_radius.Attribute, int), results []*sippy_radius.Attribute, rcode int) {
+    if result_callback == nil {
+        return
+    }
+    nav := make([]*sippy_radius.Attribute, 0, len(results))
+    for _, av := range results {
+        a, v := av.Name, av.Value
+        if a == "Cisco-AVPair" || _cisco_vsa_names[a] {
+            if t := strings.SplitN(v, "=", 2); len(t) > 1 {
+                a, v = t[0], t[1]
+            }
+        } else if strings.HasPrefix(v, a + "=") {
+            v = v[len(a) + 1:]
+        }
+        nav = append(nav, sippy_radius.NewAttribute(a, v))
+    }
+    result_callback(nav, rcode)
+}
@@ -0,0 +1,202 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+
+    "sippy"
+    "sippy/types"
+)
+
+// The options that are applied to the new calls on reload. Changing
+// the rest of them requires a restart.
+var reloadableOptions = map[string]bool{
+    "accept_ips"        : true,
+    "deny_ips"          : true,
+    "static_route"      : true,
+    "pass_headers"      : true,
+    "keepalive_ans"     : true,
+    "keepalive_orig"    : true,
+    "keepalive_ans_method" : true,
+    "keepalive_orig_method" : true,
+    "keepalive_ans_failures" : true,
+    "keepalive_orig_failures" : true,
+    "session_expires_ans" : true,
+    "session_expires_orig" : true,
+    "session_min_se"    : true,
+    "rtp_proxy_clients" : true,
+    "allowed_pts"       : true,
+    "static_tr_in"      : true,
+    "static_tr_out"     : true,
+    "static_tr_cli_in"  : true,
+    "static_tr_cli_out" : true,
+    "hide_call_id"      : true,
+    "topology_hiding"   : true,
+    "cac_max_calls"     : true,
+    "cac_cps"           : true,
+    "cac_src_max_calls" : true,
+    "cac_src_cps"       : true,
+    "cac_user_max_calls" : true,
+    "cac_user_cps"      : true,
+    "cac_route_max_calls" : true,
+    "cac_route_cps"     : true,
+    "cac_reject_code"   : true,
+    "cac_retry_after"   : true,
+}
+
+// Creates the RTPproxy clients for the configured addresses. The clients
+// of the running configuration are reused if the address is the same.
+func newRtpProxyClients(global_config, running_config *myConfigParser, running []sippy_types.RtpProxyClient) ([]sippy_types.RtpProxyClient, error) {
+    existing := make(map[string]sippy_types.RtpProxyClient)
+    if running_config != nil {
+        for i, address := range running_config.rtp_proxy_clients {
+            existing[address] = running[i]
+        }
+    }
+    rtp_proxy_clients := make([]sippy_types.RtpProxyClient, len(global_config.rtp_proxy_clients))
+    for i, address := range global_config.rtp_proxy_clients {
+        if rtpp, ok := existing[address]; ok {
+            rtp_proxy_clients[i] = rtpp
+            continue
+        }
+        opts, err := sippy.NewRtpProxyClientOpts(address, nil /*bind_address*/, global_config, global_config.ErrorLogger())
+        if err != nil {
+            return nil, err
+        }
+        opts.SetHeartbeatInterval(global_config.hrtb_ival)
+        opts.SetHeartbeatRetryInterval(global_config.hrtb_retr_ival)
+        rtpp := sippy.NewRtpProxyClient(opts)
+        err = rtpp.Start()
+        if err != nil {
+            return nil, err
+        }
+        rtp_proxy_clients[i] = rtpp
+    }
+    return rtp_proxy_clients, nil
+}
+
+// Re-reads the configuration and applies the reloadable options to the
+// new calls, the calls in progress keep the configuration they have been
+// started with. The access list changes made over the CLI are kept.
+// Returns the report of the changed options.
+func (self *callMap) reload() (string, error) {
+    self.config_lock.Lock()
+    defer self.config_lock.Unlock()
+
+    running := self.global_config
+    if running.config_file == "" {
+        return "", errors.New("no configuration file has been given")
+    }
+    new_config := NewMyConfigParser()
+    err := new_config.Reparse(running)
+    if err != nil {
+        return "", err
+    }
+    applied, restart := []string{}, []string{}
+    for name, value := range new_config.values {
+        if running.values[name] == value {
+            continue
+        }
+        change := fmt.Sprintf("%s: '%s' -> '%s'", name, running.values[name], value)
+        if reloadableOptions[name] {
+            applied = append(applied, change)
+        } else {
+            restart = append(restart, change)
+        }
+    }
+    sort.Strings(applied)
+    sort.Strings(restart)
+
+    static_routes := []*B2BRoute(nil)
+    if new_config.static_route != "" {
+        static_routes, err = NewB2BRouteSet(new_config.static_route, new_config)
+        if err != nil {
+            return "", errors.New("Error parsing the static route: " + err.Error())
+        }
+    } else if ! running.auth_enable && running.http_route_url == "" {
+        return "", errors.New("static route should be specified when Radius auth is disabled")
+    }
+    rtp_proxy_clients, err := newRtpProxyClients(new_config, running, global_rtp_proxy_clients)
+    if err != nil {
+        return "", errors.New("Cannot initialize rtpproxy client: " + err.Error())
+    }
+
+    // Take over the reloadable options only, the rest stays as it was
+    // until the restart.
+    global_config := *running
+    global_config.accept_ips = new_config.accept_ips
+    self.replayAclEdits(global_config.accept_ips)
+    global_config.static_route = new_config.static_route
+    global_config.pass_headers = new_config.pass_headers
+    global_config.keepalive_ans = new_config.keepalive_ans
+    global_config.keepalive_orig = new_config.keepalive_orig
+    global_config.keepalive_ans_method = new_config.keepalive_ans_method
+    global_config.keepalive_orig_method = new_config.keepalive_orig_method
+    global_config.keepalive_ans_failures = new_config.keepalive_ans_failures
+    global_config.keepalive_orig_failures = new_config.keepalive_orig_failures
+    global_config.session_expires_ans = new_config.session_expires_ans
+    global_config.session_expires_orig = new_config.session_expires_orig
+    global_config.session_min_se = new_config.session_min_se
+    global_config.rtp_proxy_clients = new_config.rtp_proxy_clients
+    global_config.allowed_pts = new_config.allowed_pts
+    global_config.static_tr_in, global_config.tr_in = new_config.static_tr_in, new_config.tr_in
+    global_config.static_tr_out, global_config.tr_out = new_config.static_tr_out, new_config.tr_out
+    global_config.static_tr_cli_in, global_config.tr_cli_in = new_config.static_tr_cli_in, new_config.tr_cli_in
+    global_config.static_tr_cli_out, global_config.tr_cli_out = new_config.static_tr_cli_out, new_config.tr_cli_out
+    global_config.hide_call_id = new_config.hide_call_id
+    global_config.topology_hiding = new_config.topology_hiding
+    global_config.cac_max_calls, global_config.cac_cps = new_config.cac_max_calls, new_config.cac_cps
+    global_config.cac_src_max_calls, global_config.cac_src_cps = new_config.cac_src_max_calls, new_config.cac_src_cps
+    global_config.cac_user_max_calls, global_config.cac_user_cps = new_config.cac_user_max_calls, new_config.cac_user_cps
+    global_config.cac_route_max_calls, global_config.cac_route_cps = new_config.cac_route_max_calls, new_config.cac_route_cps
+    global_config.cac_reject_code = new_config.cac_reject_code
+    global_config.cac_retry_after = new_config.cac_retry_after
+    global_config.values = make(map[string]string)
+    for name, value := range running.values {
+        if reloadableOptions[name] {
+            value = new_config.values[name]
+        }
+        global_config.values[name] = value
+    }
+    self.global_config = &global_config
+    global_static_routes = static_routes
+    self.swapRtpProxyClients(rtp_proxy_clients)
+    global_cac.setLimits(&global_config)
+
+    res := "Configuration reloaded\n"
+    for _, change := range applied {
+        res += "Applied to new calls: " + change + "\n"
+    }
+    for _, change := range restart {
+        res += "Requires restart: " + change + "\n"
+    }
+    return res, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "sippy/types"
+)
+
+type test_rtpp struct {
+    sippy_types.RtpProxyClient
+    shut_down   bool
+}
+
+func (self *test_rtpp) Shutdown() {
+    self.shut_down = true
+}
+
+func Test_ReloadSwap(t *testing.T) {
+    fname := filepath.Join(t.TempDir(), "b2bua.conf")
+    writeConfig := func(body string) {
+        if err := ioutil.WriteFile(fname, []byte("[general]\nstatic_route = 1.2.3.4\n" + body), 0600); err != nil {
+            t.Fatal(err)
+        }
+    }
+    saved_args, saved_routes, saved_rtpps, saved_cac := os.Args, global_static_routes, global_rtp_proxy_clients, global_cac
+    defer func() {
+        os.Args, global_static_routes, global_rtp_proxy_clients, global_cac = saved_args, saved_routes, saved_rtpps, saved_cac
+    }()
+    os.Args = []string{ "b2bua", "-C", fname }
+
+    writeConfig("accept_ips = 10.0.0.0/8\nrtp_proxy_clients = udp:127.0.0.1:22222,udp:127.0.0.1:22223\n")
+    running := NewMyConfigParser()
+    if err := running.Reparse(testConfig(t)); err != nil {
+        t.Fatal(err)
+    }
+    rtpp1, rtpp2 := &test_rtpp{}, &test_rtpp{}
+    global_rtp_proxy_clients = []sippy_types.RtpProxyClient{ rtpp1, rtpp2 }
+    global_cac = NewCallAdmission(running)
+    cmap := &callMap{
+        global_config   : running,
+        ccmap           : make(map[int64]*callController),
+        rtpp_refs       : make(map[sippy_types.RtpProxyClient]int),
+        rtpp_retired    : make(map[sippy_types.RtpProxyClient]bool),
+    }
+    if res := cmap.aclCommand([]string{ "allow", "192.168.0.0/16" }); res != "OK\n" {
+        t.Fatal(res)
+    }
+    if res := cmap.aclCommand([]string{ "remove", "10.0.0.0/8" }); res != "OK\n" {
+        t.Fatal(res)
+    }
+    call1 := cmap.holdRtpProxyClients()
+
+    // The second RTPproxy is gone, the call in progress still uses it
+    writeConfig("accept_ips = 10.0.0.0/8,172.16.0.0/12\nrtp_proxy_clients = udp:127.0.0.1:22222\n")
+    if _, err := cmap.reload(); err != nil {
+        t.Fatal(err)
+    }
+    if len(global_rtp_proxy_clients) != 1 || global_rtp_proxy_clients[0] != rtpp1 {
+        t.Fatal("the RTPproxy client has not been reused")
+    }
+    if rtpp1.shut_down || rtpp2.shut_down {
+        t.Fatal("the RTPproxy client in use has been shut down")
+    }
+    call2 := cmap.holdRtpProxyClients()
+    cmap.releaseRtpProxyClients(call1)
+    if ! rtpp2.shut_down || rtpp1.shut_down {
+        t.Fatal("the retired RTPproxy client has not been shut down after the last call")
+    }
+    cmap.releaseRtpProxyClients(call2)
+    if rtpp1.shut_down || len(cmap.rtpp_refs) != 0 || len(cmap.rtpp_retired) != 0 {
+        t.Fatal("the RTPproxy client references have not been released")
+    }
+
+    // The CLI changes are kept on top of the reloaded access list
+    for ip, allowed := range map[string]bool{
+            "192.168.1.1"   : true,
+            "172.16.1.1"    : true,
+            "10.1.1.1"      : false,
+          } {
+        if cmap.global_config.checkIP(ip) != allowed {
+            t.Fatalf("%s: the access list has not been merged:\n%s", ip, cmap.global_config.accept_ips.String())
+        }
+    }
+
+    // Nothing holds the removed client, it is shut down on reload
+    writeConfig("accept_ips = 10.0.0.0/8\n")
+    if _, err := cmap.reload(); err != nil {
+        t.Fatal(err)
+    }
+    if ! rtpp1.shut_down || len(global_rtp_proxy_clients) != 0 {
+        t.Fatal("the unused RTPproxy client has not been shut down")
+    }
+}
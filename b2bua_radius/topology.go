@@ -0,0 +1,96 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "crypto/md5"
+    "encoding/hex"
+    "fmt"
+    "strings"
+
+    "sippy"
+    "sippy/headers"
+    "sippy/types"
+)
+
+// The headers that reveal the addresses or the software of the other
+// side and therefore are never passed between the legs in the
+// topology hiding mode.
+var topologyHeaders = map[string]bool{
+    "via"           : true,
+    "v"             : true,
+    "record-route"  : true,
+    "route"         : true,
+    "contact"       : true,
+    "m"             : true,
+    "user-agent"    : true,
+    "server"        : true,
+    "call-id"       : true,
+    "i"             : true,
+}
+
+func revealsTopology(name string) bool {
+    return topologyHeaders[strings.ToLower(name)]
+}
+
+// Generates the Call-ID of the egress call leg. The Call-ID of the
+// ingress leg is not exposed when the topology hiding is on.
+func (self *callController) egressCallId(rnum int) *sippy_header.SipCallId {
+    switch {
+    case self.global_config.topology_hiding:
+        return sippy_header.GenerateSipCallId(self.global_config)
+    case self.global_config.hide_call_id:
+        sum := md5.Sum([]byte(self.eTry.GetSipCallId().CallId))
+        return sippy_header.NewSipCallIdFromString(hex.EncodeToString(sum[:]) + fmt.Sprintf("-b2b_%d", rnum))
+    }
+    return sippy_header.NewSipCallIdFromString(self.eTry.GetSipCallId().CallId + fmt.Sprintf("-b2b_%d", rnum))
+}
+
+// Checks if the Call-ID belongs to any of the call legs.
+func (self *callController) hasCallId(call_id string) bool {
+    if self.cId != nil && self.cId.CallId == call_id {
+        return true
+    }
+    for _, ua := range self.legs {
+        if cid := ua.GetCallId(); cid != nil && cid.CallId == call_id {
+            return true
+        }
+    }
+    return false
+}
+
+// Strips the details of the egress side off the event before it is
+// passed to the ingress leg.
+func (self *callController) hideEgress(event sippy_types.CCEvent) sippy_types.CCEvent {
+    if ev, ok := event.(*sippy.CCEventRedirect); ok {
+        // The redirect targets are the addresses of the other side and
+        // a 3xx without them makes no sense to the caller.
+        event = sippy.NewCCEventFail(480, "Temporarily Unavailable", ev.GetRtime(), ev.GetOrigin())
+    }
+    return event
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+    "testing"
+
+    "sippy"
+    "sippy/headers"
+    "sippy/types"
+)
+
+func Test_HideEgress(t *testing.T) {
+    cc := &callController{}
+    contact := sippy_header.NewSipAddress("", sippy_header.NewSipURL("bob", nil, nil, false))
+    tests := []struct {
+        event   sippy_types.CCEvent
+        scode   int
+    }{
+        { sippy.NewCCEventRedirect(302, "Moved Temporarily", nil, []*sippy_header.SipAddress{ contact }, nil, ""), 480 },
+        { sippy.NewCCEventRedirect(301, "Moved Permanently", nil, nil, nil, ""), 480 },
+        { sippy.NewCCEventFail(486, "Busy Here", nil, ""), 486 },
+    }
+    for _, tc := range tests {
+        ev_fail, ok := cc.hideEgress(tc.event).(*sippy.CCEventFail)
+        if ! ok {
+            t.Errorf("%s: not turned into a failure", tc.event)
+            continue
+        }
+        if ev_fail.GetScode() != tc.scode {
+            t.Errorf("%s: %d expected, got %d", tc.event, tc.scode, ev_fail.GetScode())
+        }
+    }
+    ring := sippy.NewCCEventRing(180, "Ringing", nil, nil, "")
+    if cc.hideEgress(ring) != ring {
+        t.Error("the ringing event has been changed")
+    }
+}
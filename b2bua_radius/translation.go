@@ -0,0 +1,151 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "errors"
+    "regexp"
+    "strings"
+)
+
+type trRule struct {
+    re          *regexp.Regexp
+    repl        string
+    global      bool
+}
+
+// An ordered set of the sed-style "s/pattern/replacement/flags" rules
+// separated by ";". The "g" flag replaces all the matches rather than
+// the first one, the "i" flag makes the match case-insensitive. The \1
+// to \9 in the replacement refer to the submatches.
+type numberTranslator struct {
+    rules       []*trRule
+}
+
+func NewNumberTranslator(rules string) (*numberTranslator, error) {
+    self := &numberTranslator{
+        rules       : []*trRule{},
+    }
+    rest := strings.TrimSpace(rules)
+    for rest != "" {
+        if ! strings.HasPrefix(rest, "s/") {
+            return nil, errors.New("translation rule should start with \"s/\": " + rest)
+        }
+        fields, tail, err := splitTrRule(rest[2:])
+        if err != nil {
+            return nil, errors.New("malformed translation rule '" + rest + "': " + err.Error())
+        }
+        flags := tail
+        if idx := strings.IndexByte(tail, ';'); idx >= 0 {
+            flags, rest = tail[:idx], strings.TrimSpace(tail[idx + 1:])
+        } else {
+            rest = ""
+        }
+        rule := &trRule{}
+        pattern := fields[0]
+        for _, f := range strings.TrimSpace(flags) {
+            switch f {
+            case 'g':
+                rule.global = true
+            case 'i':
+                pattern = "(?i)" + pattern
+            default:
+                return nil, errors.New("unknown translation rule flag '" + string(f) + "'")
+            }
+        }
+        rule.re, err = regexp.Compile(pattern)
+        if err != nil {
+            return nil, err
+        }
+        rule.repl = convertTrReplacement(fields[1])
+        self.rules = append(self.rules, rule)
+    }
+    return self, nil
+}
+
+// Splits the "pattern/replacement/tail" honouring the escaped slashes
+func splitTrRule(s string) ([]string, string, error) {
+    fields := []string{}
+    buf := []byte{}
+    for i := 0; i < len(s); i++ {
+        switch {
+        case s[i] == '\\' && i + 1 < len(s) && s[i + 1] == '/':
+            buf = append(buf, '/')
+            i++
+        case s[i] == '/':
+            fields = append(fields, string(buf))
+            buf = buf[:0]
+            if len(fields) == 2 {
+                return fields, s[i + 1:], nil
+            }
+        default:
+            buf = append(buf, s[i])
+        }
+    }
+    return nil, "", errors.New("unterminated rule")
+}
+
+// Converts the \N back references into the ${N} form of the regexp package
+func convertTrReplacement(repl string) string {
+    res := []byte{}
+    for i := 0; i < len(repl); i++ {
+        switch {
+        case repl[i] == '\\' && i + 1 < len(repl) && repl[i + 1] >= '0' && repl[i + 1] <= '9':
+            res = append(res, '$', '{', repl[i + 1], '}')
+            i++
+        case repl[i] == '\\' && i + 1 < len(repl) && repl[i + 1] == '\\':
+            res = append(res, '\\')
+            i++
+        case repl[i] == '$':
+            res = append(res, '$', '$')
+        default:
+            res = append(res, repl[i])
+        }
+    }
+    return string(res)
+}
+
+// Applies the rules one after another
+func (self *numberTranslator) translate(s string) string {
+    if self == nil {
+        return s
+    }
+    for _, rule := range self.rules {
+        if rule.global {
+            s = rule.re.ReplaceAllString(s, rule.repl)
+            continue
+        }
+        loc := rule.re.FindStringSubmatchIndex(s)
+        if loc == nil {
+            continue
+        }
+        res := rule.re.ExpandString(nil, rule.repl, s, loc)
+        s = s[:loc[0]] + string(res) + s[loc[1]:]
+    }
+    return s
+}
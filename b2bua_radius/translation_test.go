@@ -0,0 +1,57 @@
+package main
+
+import (
+    "testing"
+)
+
+func Test_NumberTranslator(t *testing.T) {
+    for _, tc := range []struct {
+        rules   string
+        in      string
+        out     string
+    }{
+        { "s/^00/+/", "0049123", "+49123" },
+        { "s/^00/+/", "49123", "49123" },
+        { "s/0/x/", "1000", "1x00" },
+        { "s/0/x/g", "1000", "1xxx" },
+        { "s/^\\+1([0-9]{3})/\\1-/", "+12125551234", "212-5551234" },
+        { "s/^(.)(.)/\\2\\1/", "1234", "2134" },
+        { "s/abc/X/i", "ABCabc", "Xabc" },
+        { "s/abc/X/gi", "ABCabc", "XX" },
+        { "s/^/\\//", "123", "/123" },
+        { "s/\\//-/g", "1/2/3", "1-2-3" },
+        { "s/1/$2/", "123", "$223" },
+        { "s/1/a\\\\b/", "123", "a\\b23" },
+        { "s/^0/+44/; s/^\\+44/0044/", "0123", "0044123" },
+        { "s/;/,/g; s/,/./", "1;2;3", "1.2,3" },
+        { " s/^9// ;", "9123", "123" },
+        { "", "123", "123" },
+    } {
+        tr, err := NewNumberTranslator(tc.rules)
+        if err != nil {
+            t.Errorf("%q: %s", tc.rules, err.Error())
+            continue
+        }
+        if out := tr.translate(tc.in); out != tc.out {
+            t.Errorf("%q: %q -> expected %q, got %q", tc.rules, tc.in, tc.out, out)
+        }
+    }
+    if out := (*numberTranslator)(nil).translate("123"); out != "123" {
+        t.Errorf("the nil translator has changed the number: %q", out)
+    }
+}
+
+func Test_NumberTranslatorErrors(t *testing.T) {
+    for _, rules := range []string{
+            "^00/+/",
+            "s/^00/+",
+            "s/^00",
+            "s/^00/+/x",
+            "s/(/x/",
+            "s/0/1/; y/0/1/",
+          } {
+        if _, err := NewNumberTranslator(rules); err == nil {
+            t.Errorf("%q has been accepted", rules)
+        }
+    }
+}
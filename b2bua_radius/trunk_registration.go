@@ -0,0 +1,160 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "errors"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+
+    "sippy"
+    "sippy/conf"
+    "sippy/headers"
+    "sippy/net"
+    "sippy/types"
+)
+
+const DEFAULT_TRUNK_REG_EXPIRES = 3600 * time.Second
+
+// The registrations of the B2BUA with the upstream carriers, in the
+// order they have been configured.
+type trunkRegistrations struct {
+    names       []string
+    agents      map[string]*sippy.RegistrationAgent
+}
+
+// Parses the "|" separated list of registrations, each in the format
+// "user@domain[:port];param=value;...". The recognized params are:
+//   name      - the name to refer to the registration, the AOR by default
+//   registrar - the "host[:port]" to send REGISTER to, the AOR domain by default
+//   auth      - the "username:password" to answer the challenges with
+//   expires   - the requested registration time (seconds)
+//   contact   - the contact URI to register, the local address by default
+func NewTrunkRegistrations(sregs string, sip_tm sippy_types.SipTransactionManager, global_config sippy_conf.Config) (*trunkRegistrations, error) {
+    self := &trunkRegistrations{
+        names       : []string{},
+        agents      : make(map[string]*sippy.RegistrationAgent),
+    }
+    for _, sreg := range strings.Split(sregs, "|") {
+        sreg = strings.TrimSpace(sreg)
+        if sreg == "" {
+            continue
+        }
+        params := strings.Split(sreg, ";")
+        if strings.IndexRune(params[0], '@') == -1 {
+            return nil, errors.New("NewTrunkRegistrations: no user in the AOR '" + params[0] + "'")
+        }
+        aor, err := sippy_header.ParseSipURL("sip:" + strings.TrimPrefix(params[0], "sip:"), false, global_config)
+        if err != nil {
+            return nil, errors.New("NewTrunkRegistrations: error parsing the AOR '" + params[0] + "': " + err.Error())
+        }
+        name := params[0]
+        var registrar *sippy_net.HostPort
+        var contact *sippy_header.SipContact
+        username, password := "", ""
+        expires := DEFAULT_TRUNK_REG_EXPIRES
+        for _, x := range params[1:] {
+            av := strings.SplitN(x, "=", 2)
+            if len(av) != 2 {
+                return nil, errors.New("NewTrunkRegistrations: error parsing '" + x + "'")
+            }
+            switch av[0] {
+            case "name":
+                name = av[1]
+            case "registrar":
+                host, port, err := net.SplitHostPort(av[1])
+                if err != nil {
+                    // No port, the IPv6 address might be in brackets still
+                    host, port = strings.Trim(av[1], "[]"), "5060"
+                }
+                if host == "" {
+                    return nil, errors.New("NewTrunkRegistrations: error parsing the registrar '" + av[1] + "'")
+                }
+                registrar = sippy_net.NewHostPort(host, port)
+            case "auth":
+                tmp := strings.SplitN(av[1], ":", 2)
+                if len(tmp) != 2 {
+                    return nil, errors.New("NewTrunkRegistrations: error parsing the auth (no colon) '" + av[1] + "'")
+                }
+                username, password = tmp[0], tmp[1]
+            case "expires":
+                v, err := strconv.Atoi(av[1])
+                if err != nil || v <= 0 {
+                    return nil, errors.New("NewTrunkRegistrations: error parsing the expires '" + av[1] + "'")
+                }
+                expires = time.Duration(v) * time.Second
+            case "contact":
+                url, err := sippy_header.ParseSipURL(av[1], false, global_config)
+                if err != nil {
+                    return nil, errors.New("NewTrunkRegistrations: error parsing the contact '" + av[1] + "': " + err.Error())
+                }
+                contact = sippy_header.NewSipContactFromAddress(sippy_header.NewSipAddress("", url))
+            default:
+                return nil, errors.New("NewTrunkRegistrations: unknown parameter '" + av[0] + "'")
+            }
+        }
+        if _, ok := self.agents[name]; ok {
+            return nil, errors.New("NewTrunkRegistrations: duplicate registration name '" + name + "'")
+        }
+        self.names = append(self.names, name)
+        self.agents[name] = sippy.NewRegistrationAgent(sip_tm, global_config, aor, registrar, username, password, contact, expires)
+    }
+    return self, nil
+}
+
+func (self *trunkRegistrations) start() {
+    for _, name := range self.names {
+        self.agents[name].Start()
+    }
+}
+
+func (self *trunkRegistrations) get(name string) *sippy.RegistrationAgent {
+    if self == nil {
+        return nil
+    }
+    return self.agents[name]
+}
+
+// Tells whether the calls can be routed through the registration.
+func (self *trunkRegistrations) isRegistered(name string) bool {
+    agent := self.get(name)
+    return agent != nil && agent.IsRegistered()
+}
+
+func (self *trunkRegistrations) String() string {
+    if self == nil || len(self.names) == 0 {
+        return "No registrations configured\n"
+    }
+    res := ""
+    for _, name := range self.names {
+        res += name + ": " + self.agents[name].String() + "\n"
+    }
+    return res
+}
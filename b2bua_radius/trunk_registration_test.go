@@ -0,0 +1,37 @@
+package main
+
+import (
+    "testing"
+)
+
+func Test_TrunkRegistrar(t *testing.T) {
+    global_config := testConfig(t)
+    tests := []struct {
+        registrar   string
+        expected    string
+    }{
+        { "", "nil" },
+        { "proxy.example.com", "proxy.example.com:5060" },
+        { "proxy.example.com:5080", "proxy.example.com:5080" },
+        { "10.0.0.1:5070", "10.0.0.1:5070" },
+        { "[2001:db8::1]:5070", "[2001:db8::1]:5070" },
+        { "[2001:db8::1]", "[2001:db8::1]:5060" },
+    }
+    for _, tc := range tests {
+        sreg := "trunk@carrier.example.com"
+        if tc.registrar != "" {
+            sreg += ";registrar=" + tc.registrar
+        }
+        regs, err := NewTrunkRegistrations(sreg, nil, global_config)
+        if err != nil {
+            t.Errorf("%s: %s", tc.registrar, err.Error())
+            continue
+        }
+        if res := regs.get("trunk@carrier.example.com").GetRegistrar().String(); res != tc.expected {
+            t.Errorf("%s: expected %s, got %s", tc.registrar, tc.expected, res)
+        }
+    }
+    if _, err := NewTrunkRegistrations("trunk@carrier.example.com;registrar=:5060", nil, global_config); err == nil {
+        t.Error("the registrar without the host has been accepted")
+    }
+}
@@ -42,6 +42,7 @@ import (
 
     "sippy"
     "sippy/conf"
+    "sippy/headers"
     "sippy/log"
     "sippy/net"
     "sippy/types"
@@ -66,6 +67,7 @@ type callController struct {
     lock            *sync.Mutex // this must be a reference to prevent memory leak
     id              int64
     cmap            *callMap
+    aor             string
 }
 
 func NewCallController(cmap *callMap) *callController {
@@ -89,9 +91,15 @@ func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.
                 self.uaA.RecvEvent(sippy.NewCCEventDisconnect(nil, event.GetRtime(), ""))
                 return
             }
-            self.uaO = sippy.NewUA(self.cmap.sip_tm, self.cmap.config, self.cmap.config.nh_addr, self, self.lock, nil)
+            nh_addr, raddr := self.cmap.config.nh_addr, self.cmap.config.nh_addr
+            if b := self.cmap.lookupContact(self.aor); b != nil {
+                // The callee is registered with us, call the contact
+                // through the address it has registered from.
+                nh_addr, raddr = b.nh_addr, b.raddr
+            }
+            self.uaO = sippy.NewUA(self.cmap.sip_tm, self.cmap.config, nh_addr, self, self.lock, nil)
             self.uaO.SetDeadCb(self.oDead)
-            self.uaO.SetRAddr(self.cmap.config.nh_addr)
+            self.uaO.SetRAddr(raddr)
         }
         self.uaO.RecvEvent(event)
     } else {
@@ -126,6 +134,7 @@ type callMap struct {
     logger          sippy_log.ErrorLogger
     sip_tm          sippy_types.SipTransactionManager
     proxy           sippy_types.StatefulProxy
+    registrar       *sippy.Registrar
     ccmap           map[int64]*callController
     ccmap_lock      sync.Mutex
 }
@@ -151,6 +160,7 @@ func (self *callMap) OnNewDialog(req sippy_types.SipRequest, tr sippy_types.Serv
     if req.GetMethod() == "INVITE" {
         // New dialog
         cc := NewCallController(self)
+        cc.aor = req.GetRURI().Username + "@" + strings.ToLower(req.GetRURI().Host.String())
         self.ccmap_lock.Lock()
         self.ccmap[cc.id] = cc
         self.ccmap_lock.Unlock()
@@ -158,6 +168,9 @@ func (self *callMap) OnNewDialog(req sippy_types.SipRequest, tr sippy_types.Serv
     }
     if req.GetMethod() == "REGISTER" {
         // Registration
+        if self.registrar != nil {
+            return nil, self.registrar, nil
+        }
         return nil, self.proxy, nil
     }
     if req.GetMethod() == "NOTIFY" || req.GetMethod() == "PING" {
@@ -167,6 +180,38 @@ func (self *callMap) OnNewDialog(req sippy_types.SipRequest, tr sippy_types.Serv
     return nil, nil, req.GenResponse(501, "Not Implemented", nil, nil)
 }
 
+type contactAddr struct {
+    nh_addr     *sippy_net.HostPort
+    raddr       *sippy_net.HostPort
+}
+
+// Finds the most preferred registered contact of the AOR, nil if none.
+func (self *callMap) lookupContact(aor string) *contactAddr {
+    if self.registrar == nil {
+        return nil
+    }
+    var best *sippy.Binding
+    for _, b := range self.registrar.GetStore().Lookup(aor) {
+        if best == nil || b.Q > best.Q {
+            best = b
+        }
+    }
+    if best == nil {
+        return nil
+    }
+    url, err := sippy_header.ParseSipURL(best.Contact, false, self.config)
+    if err != nil {
+        self.logger.Error("CallMap::lookupContact: " + err.Error())
+        return nil
+    }
+    ret := &contactAddr{ nh_addr : url.GetAddr(self.config) }
+    ret.raddr = ret.nh_addr
+    if idx := strings.LastIndex(best.Received, ":"); idx > 0 {
+        ret.raddr = sippy_net.NewHostPort(best.Received[:idx], best.Received[idx + 1:])
+    }
+    return ret
+}
+
 func (self *callMap) Remove(ccid int64) {
     self.ccmap_lock.Lock()
     defer self.ccmap_lock.Unlock()
@@ -198,15 +243,19 @@ func main() {
     }
     mrand.Seed(salt)
 
-    var laddr, nh_addr, logfile string
+    var laddr, nh_addr, logfile, registrar_db, registrar_auth string
     var lport int
-    var foreground bool
+    var foreground, tcp, registrar bool
 
     flag.StringVar(&laddr, "l", "", "Local addr")
     flag.IntVar(&lport, "p", -1, "Local port")
     flag.StringVar(&nh_addr, "n", "", "Next hop address")
     flag.BoolVar(&foreground, "f", false, "Run in foreground")
     flag.StringVar(&logfile, "L", "/var/log/sip.log", "Log file")
+    flag.BoolVar(&tcp, "t", false, "Enable SIP over TCP")
+    flag.BoolVar(&registrar, "r", false, "Handle REGISTER locally and call the registered contacts")
+    flag.StringVar(&registrar_db, "R", "", "Registrations snapshot file")
+    flag.StringVar(&registrar_auth, "a", "", "Credentials file of the registered users, \"user:password\" or htdigest lines, required by -r and -R")
     flag.Parse()
 
     error_logger := sippy_log.NewErrorLogger()
@@ -250,6 +299,7 @@ func main() {
         config.SetMyPort(sippy_net.NewMyPort(strconv.Itoa(lport)))
     }
     config.SetSipPort(config.GetMyPort())
+    config.SetTcpEnabled(tcp)
     cmap := NewCallMap(config, error_logger)
     sip_tm, err := sippy.NewSipTransactionManager(config, cmap)
     if err != nil {
@@ -258,6 +308,27 @@ func main() {
     }
     cmap.sip_tm = sip_tm
     cmap.proxy = sippy.NewStatefulProxy(sip_tm, config.nh_addr, config)
+    if registrar || registrar_db != "" {
+        if registrar_auth == "" {
+            error_logger.Error("the registrar requires the credentials file (-a)")
+            return
+        }
+        creds, err := sippy.NewFileCredentialStore(registrar_auth)
+        if err != nil {
+            error_logger.Error(err)
+            return
+        }
+        var store sippy.LocationStore = sippy.NewMemoryLocationStore()
+        if registrar_db != "" {
+            store, err = sippy.NewFileLocationStore(registrar_db)
+            if err != nil {
+                error_logger.Error(err)
+                return
+            }
+        }
+        cmap.registrar = sippy.NewRegistrar(store, config)
+        cmap.registrar.SetAuthenticator(sippy.NewDigestAuth(creds).Authenticate)
+    }
     go sip_tm.Run()
 
     signal_chan := make(chan os.Signal, 1)
@@ -53,6 +53,9 @@ func NewCCEventRedirect(scode int, scode_reason string, body sippy_types.MsgBody
 
 func (self *CCEventRedirect) String() string { return "CCEventRedirect" }
 
+func (self *CCEventRedirect) GetScode() int { return self.scode }
+func (self *CCEventRedirect) GetScodeReason() string { return self.scode_reason }
+
 func (self *CCEventRedirect) GetRedirectURL() *sippy_header.SipAddress {
     return self.redirect_addresses[0]
 }
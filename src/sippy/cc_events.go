@@ -81,6 +81,22 @@ func (self *CCEventGeneric) SetMaxForwards(max_forwards *sippy_header.SipMaxForw
     self.sip_max_forwards = max_forwards
 }
 
+// Computes the Max-Forwards header for the request generated out of
+// the event. The ok is false when the hop count has been exhausted.
+func nextHopMaxForwards(event sippy_types.CCEvent) (ret *sippy_header.SipMaxForwards, ok bool, err error) {
+    if event.GetMaxForwards() == nil {
+        return nil, true, nil
+    }
+    max_forwards, err := event.GetMaxForwards().GetBody()
+    if err != nil {
+        return nil, false, err
+    }
+    if max_forwards.Number <= 0 {
+        return sippy_header.NewSipMaxForwards(0), false, nil
+    }
+    return sippy_header.NewSipMaxForwards(max_forwards.Number - 1), true, nil
+}
+
 func (self *CCEventGeneric) AppendExtraHeader(eh sippy_header.SipHeader) {
     self.extra_headers = append(self.extra_headers, eh)
 }
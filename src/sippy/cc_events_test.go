@@ -0,0 +1,30 @@
+package sippy
+
+import (
+    "testing"
+
+    "sippy/headers"
+)
+
+func Test_NextHopMaxForwards(t *testing.T) {
+    event := NewCCEventDisconnect(nil, nil, "")
+    mf, ok, err := nextHopMaxForwards(event)
+    if err != nil || ! ok || mf != nil {
+        t.Fatal("No Max-Forwards should be generated when the event has none")
+    }
+    for _, tc := range []struct { in, out int; ok bool }{
+        { 70, 69, true },
+        { 1, 0, true },
+        { 0, 0, false },
+    } {
+        event.SetMaxForwards(sippy_header.NewSipMaxForwards(tc.in))
+        mf, ok, err = nextHopMaxForwards(event)
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        num, _ := mf.GetBody()
+        if ok != tc.ok || num.Number != tc.out {
+            t.Errorf("Max-Forwards %d: got %d/%v, expected %d/%v", tc.in, num.Number, ok, tc.out, tc.ok)
+        }
+    }
+}
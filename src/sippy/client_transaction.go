@@ -58,6 +58,10 @@ type clientTransaction struct {
     on_send_complete func()
     seen_rseqs      map[sippy_header.RTID]bool
     last_rseq       int
+    req             sippy_types.SipRequest
+    failover        []*SipTarget
+    resolving       bool
+    transmit_pending bool
 }
 
 func NewClientTransactionObj(req sippy_types.SipRequest, tid *sippy_header.TID, userv sippy_net.Transport, data []byte, sip_tm *sipTransactionManager, resp_receiver sippy_types.ResponseReceiver, session_lock sync.Locker, address *sippy_net.HostPort, req_out_cb func(sippy_types.SipRequest)) (*clientTransaction, error) {
@@ -103,12 +107,107 @@ func NewClientTransactionObj(req sippy_types.SipRequest, tid *sippy_header.TID,
     return self, nil
 }
 
+func (self *clientTransaction) setFailover(req sippy_types.SipRequest, targets []*SipTarget) {
+    self.req = req
+    self.failover = targets
+}
+
+// Point the transaction to the new target. The request has got the new
+// branch so the ACK and CANCEL have to be regenerated.
+func (self *clientTransaction) retarget(req sippy_types.SipRequest, tid *sippy_header.TID, userv sippy_net.Transport, address *sippy_net.HostPort, data []byte) error {
+    var err error
+
+    if self.ack != nil {
+        if self.ack, err = req.GenACK(nil); err != nil {
+            return err
+        }
+    }
+    if self.cancel != nil {
+        if self.cancel, err = req.GenCANCEL(); err != nil {
+            return err
+        }
+    }
+    self.tid = tid
+    self.userv = userv
+    self.address = address
+    self.data = data
+    self.tout = time.Duration(0.5 * float64(time.Second))
+    return nil
+}
+
+// Try the next target if there was no response at all from the current one.
+func (self *clientTransaction) tryFailover() bool {
+    sip_tm := self.sip_tm
+    if self.state != TRYING || self.cancelPending {
+        return false
+    }
+    for len(self.failover) > 0 {
+        target := self.failover[0]
+        self.failover = self.failover[1:]
+        old_tid := self.tid
+        if err := sip_tm.failover(self, self.req, target); err != nil {
+            self.logger.Error("Failover to " + target.Address.String() + " failed: " + err.Error())
+            continue
+        }
+        sip_tm.tclient_del(old_tid)
+        self.logger.Debugf("No response from the previous target, failing over to %s:%s", target.Proto, target.Address)
+        self.StartTimers()
+        self.TransmitData()
+        return true
+    }
+    return false
+}
+
+// Called once the DNS lookup of the target is over to send the request
+// out if it has been already asked for.
+func (self *clientTransaction) located(targets []*SipTarget, laddress *sippy_net.HostPort, err error) {
+    sip_tm := self.sip_tm
+    if sip_tm == nil || ! self.resolving || self.state != TRYING {
+        // Timed out or cleaned up in the meantime
+        return
+    }
+    self.resolving = false
+    if err == nil {
+        self.failover = targets[1:]
+        err = sip_tm.retarget(self, self.req, targets[0], laddress)
+    }
+    if err != nil {
+        self.logger.Error("Cannot locate the target: " + err.Error())
+        // RFC 3263 section 4.3 treats it as the transport error
+        self.cancelTeB()
+        self.state = TERMINATED
+        self.startTeC()
+        if self.resp_receiver != nil {
+            resp := self.req.GenResponse(503, "Service Unavailable", /*body*/ nil, /*server*/ nil)
+            rtime, _ := sippy_time.NewMonoTime()
+            resp.SetRtime(rtime)
+            self.resp_receiver.RecvResponse(resp, self)
+        }
+        return
+    }
+    if self.teB != nil && ! self.userv.IsReliable() {
+        self.startTeA()
+    }
+    if self.transmit_pending {
+        self.transmit_pending = false
+        self.TransmitData()
+    }
+}
+
 func (self *clientTransaction) SetOnSendComplete(fn func()) {
     self.on_send_complete = fn
 }
 
 func (self *clientTransaction) StartTimers() {
-    self.startTeA()
+    if self.resolving {
+        // The retransmits are started once the transport is known
+        self.startTeB(32 * time.Second)
+        return
+    }
+    if ! self.userv.IsReliable() {
+        // no retransmits over reliable transports
+        self.startTeA()
+    }
     self.startTeB(32 * time.Second)
 }
 
@@ -121,6 +220,8 @@ func (self *clientTransaction) cleanup() {
     if teG := self.teG; teG != nil { teG.Cancel(); self.teG = nil }
     self.r408 = nil
     self.cancel = nil
+    self.req = nil
+    self.failover = nil
 }
 
 func (self *clientTransaction) SetOutboundProxy(outbound_proxy *sippy_net.HostPort) {
@@ -141,6 +242,9 @@ func (self *clientTransaction) timerB() {
     //println("timerB", self.tid.String())
     self.cancelTeA()
     self.cancelTeB()
+    if self.tryFailover() {
+        return
+    }
     self.state = TERMINATED
     self.startTeC()
     rtime, _ := sippy_time.NewMonoTime()
@@ -399,6 +503,10 @@ func (self *clientTransaction) BeforeRequestSent(req sippy_types.SipRequest) {
 }
 
 func (self *clientTransaction) TransmitData() {
+    if self.resolving {
+        self.transmit_pending = true
+        return
+    }
     if sip_tm := self.sip_tm; sip_tm != nil {
         sip_tm.transmitDataWithCb(self.userv, self.data, self.address, /*cachesum*/ "", /*call_id =*/ self.tid.CallId, 0, self.on_send_complete)
     }
@@ -31,7 +31,9 @@ package sippy_conf
 import (
     "net"
     "os"
+    "time"
 
+    "sippy/dns"
     "sippy/log"
     "sippy/net"
 )
@@ -59,6 +61,36 @@ type Config interface {
     SetAutoConvertTelUrl(bool)
     GetSipTransportFactory() sippy_net.SipTransportFactory
     SetSipTransportFactory(sippy_net.SipTransportFactory)
+
+    GetTcpEnabled() bool
+    SetTcpEnabled(bool)
+    GetTcpIdleTimeout() time.Duration
+    SetTcpIdleTimeout(time.Duration)
+
+    GetTlsEnabled() bool
+    SetTlsEnabled(bool)
+    GetTlsPort() *sippy_net.MyPort
+    SetTlsPort(*sippy_net.MyPort)
+    GetTlsCertFile() string
+    SetTlsCertFile(string)
+    GetTlsKeyFile() string
+    SetTlsKeyFile(string)
+    GetTlsCAFile() string
+    SetTlsCAFile(string)
+    GetTlsVerifyClient() bool
+    SetTlsVerifyClient(bool)
+
+    GetWsEnabled() bool
+    SetWsEnabled(bool)
+    GetWsPort() *sippy_net.MyPort
+    SetWsPort(*sippy_net.MyPort)
+    GetWssEnabled() bool
+    SetWssEnabled(bool)
+    GetWssPort() *sippy_net.MyPort
+    SetWssPort(*sippy_net.MyPort)
+
+    GetDnsResolver() sippy_net.DnsResolver
+    SetDnsResolver(sippy_net.DnsResolver)
 }
 
 type config struct {
@@ -75,6 +107,19 @@ type config struct {
     allow_formats   []int
     autoconvert_tel_url bool
     tfactory        sippy_net.SipTransportFactory
+    tcp_enabled     bool
+    tcp_idle_timeout time.Duration
+    tls_enabled     bool
+    tls_port        *sippy_net.MyPort
+    tls_cert_file   string
+    tls_key_file    string
+    tls_ca_file     string
+    tls_verify_client bool
+    ws_enabled      bool
+    ws_port         *sippy_net.MyPort
+    wss_enabled     bool
+    wss_port        *sippy_net.MyPort
+    dns_resolver    sippy_net.DnsResolver
 }
 
 func NewConfig(error_logger sippy_log.ErrorLogger, sip_logger sippy_log.SipLogger) Config {
@@ -94,6 +139,16 @@ func NewConfig(error_logger sippy_log.ErrorLogger, sip_logger sippy_log.SipLogge
         allow_formats : make([]int, 0),
         autoconvert_tel_url : false,
         default_port    : sippy_net.NewSystemPort("5060"),
+        tcp_enabled     : false,
+        tcp_idle_timeout : 300 * time.Second,
+        tls_enabled     : false,
+        tls_port        : sippy_net.NewMyPort("5061"),
+        tls_verify_client : false,
+        ws_enabled      : false,
+        ws_port         : sippy_net.NewMyPort("8080"),
+        wss_enabled     : false,
+        wss_port        : sippy_net.NewMyPort("8443"),
+        dns_resolver    : sippy_dns.NewCachingResolver(sippy_dns.NewStubResolver()),
     }
 }
 
@@ -182,3 +237,111 @@ func (self *config) SetSipTransportFactory(tfactory sippy_net.SipTransportFactor
 func (self *config) DefaultPort() *sippy_net.MyPort {
     return self.default_port
 }
+
+func (self *config) GetTcpEnabled() bool {
+    return self.tcp_enabled
+}
+
+func (self *config) SetTcpEnabled(v bool) {
+    self.tcp_enabled = v
+}
+
+func (self *config) GetTcpIdleTimeout() time.Duration {
+    return self.tcp_idle_timeout
+}
+
+func (self *config) SetTcpIdleTimeout(t time.Duration) {
+    self.tcp_idle_timeout = t
+}
+
+func (self *config) GetTlsEnabled() bool {
+    return self.tls_enabled
+}
+
+func (self *config) SetTlsEnabled(v bool) {
+    self.tls_enabled = v
+}
+
+func (self *config) GetTlsPort() *sippy_net.MyPort {
+    return self.tls_port
+}
+
+func (self *config) SetTlsPort(port *sippy_net.MyPort) {
+    self.tls_port = port
+}
+
+func (self *config) GetTlsCertFile() string {
+    return self.tls_cert_file
+}
+
+func (self *config) SetTlsCertFile(fname string) {
+    self.tls_cert_file = fname
+}
+
+func (self *config) GetTlsKeyFile() string {
+    return self.tls_key_file
+}
+
+func (self *config) SetTlsKeyFile(fname string) {
+    self.tls_key_file = fname
+}
+
+func (self *config) GetTlsCAFile() string {
+    return self.tls_ca_file
+}
+
+func (self *config) SetTlsCAFile(fname string) {
+    self.tls_ca_file = fname
+}
+
+// Require and verify the client certificate on incoming TLS connections.
+func (self *config) GetTlsVerifyClient() bool {
+    return self.tls_verify_client
+}
+
+func (self *config) SetTlsVerifyClient(v bool) {
+    self.tls_verify_client = v
+}
+
+func (self *config) GetWsEnabled() bool {
+    return self.ws_enabled
+}
+
+func (self *config) SetWsEnabled(v bool) {
+    self.ws_enabled = v
+}
+
+func (self *config) GetWsPort() *sippy_net.MyPort {
+    return self.ws_port
+}
+
+func (self *config) SetWsPort(port *sippy_net.MyPort) {
+    self.ws_port = port
+}
+
+// The secure WebSocket listener uses the TLS certificate settings.
+func (self *config) GetWssEnabled() bool {
+    return self.wss_enabled
+}
+
+func (self *config) SetWssEnabled(v bool) {
+    self.wss_enabled = v
+}
+
+func (self *config) GetWssPort() *sippy_net.MyPort {
+    return self.wss_port
+}
+
+func (self *config) SetWssPort(port *sippy_net.MyPort) {
+    self.wss_port = port
+}
+
+func (self *config) GetDnsResolver() sippy_net.DnsResolver {
+    return self.dns_resolver
+}
+
+// Replace the resolver the RFC 3263 next hop lookups are done with.
+// The resolver is expected to do its own caching.
+func (self *config) SetDnsResolver(resolver sippy_net.DnsResolver) {
+    self.dns_resolver = resolver
+}
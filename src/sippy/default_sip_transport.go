@@ -28,6 +28,11 @@
 package sippy
 
 import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "io/ioutil"
+
     "sippy/conf"
     "sippy/net"
 )
@@ -46,3 +51,66 @@ func (self *default_sip_transport_factory) NewSipTransport(laddress *sippy_net.H
     sopts := NewUdpServerOpts(laddress, handler)
     return NewUdpServer(self.config, sopts)
 }
+
+func (self *default_sip_transport_factory) NewSipStreamTransport(proto string, laddress *sippy_net.HostPort, handler sippy_net.DataPacketReceiver) (sippy_net.StreamTransport, error) {
+    switch proto {
+    case "tcp":
+        topts := NewTcpServerOpts(laddress, handler)
+        topts.idle_timeout = self.config.GetTcpIdleTimeout()
+        return NewTcpServer(self.config, topts)
+    case "tls":
+        tls_config, err := NewSipTlsConfig(self.config)
+        if err != nil {
+            return nil, err
+        }
+        topts := NewTcpServerOpts(laddress, handler)
+        topts.idle_timeout = self.config.GetTcpIdleTimeout()
+        topts.tls_config = tls_config
+        return NewTcpServer(self.config, topts)
+    case "ws":
+        return NewWsServer(self.config, NewWsServerOpts(laddress, handler))
+    case "wss":
+        tls_config, err := NewSipTlsConfig(self.config)
+        if err != nil {
+            return nil, err
+        }
+        wopts := NewWsServerOpts(laddress, handler)
+        wopts.tls_config = tls_config
+        return NewWsServer(self.config, wopts)
+    }
+    return nil, errors.New("Unsupported SIP transport: " + proto)
+}
+
+// NewSipTlsConfig builds the TLS configuration out of the certificate,
+// key and CA files set in the config. The same certificate is presented
+// both to the incoming and to the outgoing connection peers.
+func NewSipTlsConfig(config sippy_conf.Config) (*tls.Config, error) {
+    if config.GetTlsCertFile() == "" || config.GetTlsKeyFile() == "" {
+        return nil, errors.New("TLS certificate and key files must be configured")
+    }
+    cert, err := tls.LoadX509KeyPair(config.GetTlsCertFile(), config.GetTlsKeyFile())
+    if err != nil {
+        return nil, errors.New("Cannot load TLS certificate: " + err.Error())
+    }
+    tls_config := &tls.Config{
+        Certificates    : []tls.Certificate{ cert },
+        MinVersion      : tls.VersionTLS12,
+        ClientAuth      : tls.NoClientCert,
+    }
+    if config.GetTlsCAFile() != "" {
+        pem, err := ioutil.ReadFile(config.GetTlsCAFile())
+        if err != nil {
+            return nil, errors.New("Cannot read TLS CA file: " + err.Error())
+        }
+        pool := x509.NewCertPool()
+        if ! pool.AppendCertsFromPEM(pem) {
+            return nil, errors.New("No CA certificates found in " + config.GetTlsCAFile())
+        }
+        tls_config.RootCAs = pool
+        tls_config.ClientCAs = pool
+    }
+    if config.GetTlsVerifyClient() {
+        tls_config.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+    return tls_config, nil
+}
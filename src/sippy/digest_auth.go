@@ -0,0 +1,387 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "bufio"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "sippy/headers"
+    "sippy/types"
+)
+
+// How often the expired nonces are garbage collected
+const NONCE_SWEEP_IVAL = 60 * time.Second
+
+// How many nonces in use are tracked at most
+const MAX_NONCES = 65536
+
+// The default lifetime of the nonces
+const NONCE_TTL = 300 * time.Second
+
+// UserCredential is either the plain text password of the user or the
+// precomputed MD5 HA1 of it.
+type UserCredential struct {
+    password    string
+    ha1         string
+}
+
+func NewPasswordCredential(password string) *UserCredential {
+    return &UserCredential{ password : password }
+}
+
+func NewHA1Credential(ha1 string) *UserCredential {
+    return &UserCredential{ ha1 : strings.ToLower(ha1) }
+}
+
+// Returns the HA1 of the user either precomputed or out of the plain
+// text password. The precomputed one is MD5 so it is of no use with
+// the other algorithms.
+func (self *UserCredential) HA1(alg, username, realm string) string {
+    if self.ha1 != "" {
+        if alg != "" && strings.ToUpper(alg) != "MD5" {
+            return ""
+        }
+        return self.ha1
+    }
+    return sippy_header.DigestCalcHA1(alg, username, realm, self.password, "", "")
+}
+
+// CredentialStore is the source of the user credentials for the digest
+// authentication.
+type CredentialStore interface {
+    Lookup(username, realm string) (*UserCredential, bool)
+}
+
+// FileCredentialStore is the credential store backed by a text file. Each line is either
+// "username:password" or the htdigest style "username:realm:HA1".
+// The lines starting with '#' are comments.
+type FileCredentialStore struct {
+    users       map[string]*UserCredential
+}
+
+func NewFileCredentialStore(fname string) (*FileCredentialStore, error) {
+    fd, err := os.Open(fname)
+    if err != nil {
+        return nil, err
+    }
+    defer fd.Close()
+    self := &FileCredentialStore{
+        users       : make(map[string]*UserCredential),
+    }
+    scanner := bufio.NewScanner(fd)
+    lnum := 0
+    for scanner.Scan() {
+        lnum++
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || line[0] == '#' {
+            continue
+        }
+        arr := strings.Split(line, ":")
+        if len(arr) == 3 && isHexDigest(arr[2]) {
+            self.users[arr[0] + ":" + arr[1]] = NewHA1Credential(arr[2])
+            continue
+        }
+        arr = strings.SplitN(line, ":", 2)
+        if len(arr) != 2 || arr[0] == "" {
+            return nil, errors.New(fname + ":" + strconv.Itoa(lnum) + ": malformed line")
+        }
+        self.users[arr[0]] = NewPasswordCredential(arr[1])
+    }
+    if err = scanner.Err(); err != nil {
+        return nil, err
+    }
+    return self, nil
+}
+
+func isHexDigest(s string) bool {
+    _, err := hex.DecodeString(s)
+    return err == nil && len(s) == 32
+}
+
+func (self *FileCredentialStore) Lookup(username, realm string) (*UserCredential, bool) {
+    if cred, ok := self.users[username + ":" + realm]; ok {
+        return cred, true
+    }
+    cred, ok := self.users[username]
+    return cred, ok
+}
+
+type nonceState struct {
+    created     time.Time
+    last_nc     uint64
+    used        bool
+}
+
+// DigestAuth authenticates the incoming requests against the credential
+// store (RFC 3261 section 22, RFC 7616). The nonces are stateless, they carry the creation time signed with a per process
+// key. Only the nonces that have been used are tracked until expired
+// to reject the replayed credentials. When there are too many of them
+// the oldest ones are forgotten and the nonces created before them are
+// considered stale.
+type DigestAuth struct {
+    lock        sync.Mutex
+    store       CredentialStore
+    realm       string
+    proxy       bool
+    nonce_ttl   time.Duration
+    algorithms  []string
+    key         []byte
+    nonces      map[string]*nonceState
+    max_nonces  int
+    min_created time.Time
+    last_sweep  time.Time
+}
+
+// Creates the authenticator challenging with 401 and offering MD5 in
+// the realm of the Request-URI host.
+func NewDigestAuth(store CredentialStore) *DigestAuth {
+    self := &DigestAuth{
+        store       : store,
+        nonce_ttl   : NONCE_TTL,
+        algorithms  : []string{ "MD5" },
+        key         : make([]byte, 32),
+        nonces      : make(map[string]*nonceState),
+        max_nonces  : MAX_NONCES,
+        last_sweep  : time.Now(),
+    }
+    rand.Read(self.key)
+    return self
+}
+
+// Sets the fixed realm, the host of the Request-URI is used without one.
+func (self *DigestAuth) SetRealm(realm string) {
+    self.realm = realm
+}
+
+// Makes the authenticator to challenge with 407 and to take the
+// credentials out of the Proxy-Authorization.
+func (self *DigestAuth) SetProxy(proxy bool) {
+    self.proxy = proxy
+}
+
+func (self *DigestAuth) SetNonceTTL(nonce_ttl time.Duration) {
+    self.nonce_ttl = nonce_ttl
+}
+
+// Sets the algorithms offered in the order of preference.
+func (self *DigestAuth) SetAlgorithms(algorithms []string) {
+    self.algorithms = algorithms
+}
+
+func (self *DigestAuth) sign(payload string) string {
+    mac := hmac.New(sha256.New, self.key)
+    mac.Write([]byte(payload))
+    return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (self *DigestAuth) newNonce(now time.Time) string {
+    buf := make([]byte, 8)
+    rand.Read(buf)
+    payload := strconv.FormatInt(now.UnixNano(), 16) + "-" + hex.EncodeToString(buf)
+    return payload + "." + self.sign(payload)
+}
+
+// Returns the creation time of the nonce if it has been issued by us.
+func (self *DigestAuth) nonceCreated(nonce string) (time.Time, bool) {
+    arr := strings.SplitN(nonce, ".", 2)
+    if len(arr) != 2 || ! hmac.Equal([]byte(arr[1]), []byte(self.sign(arr[0]))) {
+        return time.Time{}, false
+    }
+    ts, err := strconv.ParseInt(strings.SplitN(arr[0], "-", 2)[0], 16, 64)
+    if err != nil {
+        return time.Time{}, false
+    }
+    return time.Unix(0, ts), true
+}
+
+// Builds the challenge offering each of the configured algorithms in
+// the order of preference, all of them sharing the same nonce.
+func (self *DigestAuth) challenge(req sippy_types.SipRequest, realm string, stale bool) sippy_types.SipResponse {
+    nonce := self.newNonce(time.Now())
+    var resp sippy_types.SipResponse
+    if self.proxy {
+        resp = req.GenResponse(407, "Proxy Authentication Required", nil, nil)
+    } else {
+        resp = req.GenResponse(401, "Unauthorized", nil, nil)
+    }
+    for _, alg := range self.algorithms {
+        var hdr sippy_header.SipHeader
+        var body *sippy_header.SipWWWAuthenticateBody
+        if self.proxy {
+            proxy_hdr := sippy_header.NewSipProxyAuthenticate(realm, nonce)
+            body, _ = proxy_hdr.GetBody()
+            hdr = proxy_hdr
+        } else {
+            www_hdr := sippy_header.NewSipWWWAuthenticate(realm, nonce)
+            body, _ = www_hdr.GetBody()
+            hdr = www_hdr
+        }
+        body.SetAlgorithm(alg)
+        body.SetQop("auth,auth-int")
+        body.SetStale(stale)
+        resp.AppendHeader(hdr)
+    }
+    return resp
+}
+
+func (self *DigestAuth) offers(alg string) bool {
+    if alg == "" {
+        alg = "MD5"
+    }
+    for _, offered := range self.algorithms {
+        if strings.EqualFold(offered, alg) {
+            return true
+        }
+    }
+    return false
+}
+
+// Verifies the credentials of the request. Returns the name of the
+// authenticated user or the response to send back. Can be used as the
+// RegistrarAuthenticator.
+func (self *DigestAuth) Authenticate(req sippy_types.SipRequest) (string, sippy_types.SipResponse) {
+    realm := self.realm
+    if realm == "" {
+        realm = req.GetRURI().Host.String()
+    }
+    var hdr *sippy_header.SipAuthorization
+    if self.proxy {
+        if req.GetSipProxyAuthorization() != nil {
+            hdr = req.GetSipProxyAuthorization().SipAuthorization
+        }
+    } else {
+        hdr = req.GetSipAuthorization()
+    }
+    if hdr == nil {
+        return "", self.challenge(req, realm, false)
+    }
+    auth, err := hdr.GetBody()
+    if err != nil {
+        return "", req.GenResponse(400, "Malformed Authorization", nil, nil)
+    }
+    if auth.GetRealm() != realm || ! self.offers(auth.GetAlgorithm()) {
+        return "", self.challenge(req, realm, false)
+    }
+    if auth.GetQop() != "" && auth.GetQop() != "auth" && auth.GetQop() != "auth-int" {
+        return "", req.GenResponse(400, "Unsupported qop", nil, nil)
+    }
+    entity_body := ""
+    if req.GetBody() != nil {
+        entity_body = req.GetBody().String()
+    }
+    cred, ok := self.store.Lookup(auth.GetUsername(), realm)
+    if ! ok || ! auth.VerifyHA1WithBody(cred.HA1(auth.GetAlgorithm(), auth.GetUsername(), realm), req.GetMethod(), entity_body) {
+        return "", self.challenge(req, realm, false)
+    }
+    var nc uint64
+    if auth.GetQop() != "" {
+        nc, err = strconv.ParseUint(auth.GetNC(), 16, 64)
+        if err != nil || nc == 0 {
+            return "", req.GenResponse(400, "Malformed Authorization", nil, nil)
+        }
+    }
+    switch self.useNonce(auth.GetNonce(), auth.GetQop() != "", nc, time.Now()) {
+    case NONCE_STALE:
+        // The credentials are right but the nonce has expired
+        return "", self.challenge(req, realm, true)
+    case NONCE_REUSED:
+        return "", req.GenResponse(403, "Nonce Reused", nil, nil)
+    }
+    return auth.GetUsername(), nil
+}
+
+const (
+    NONCE_OK = iota
+    NONCE_STALE
+    NONCE_REUSED
+)
+
+// Checks that the nonce is ours and not expired and that the nonce
+// count has not been seen with it yet.
+func (self *DigestAuth) useNonce(nonce string, has_nc bool, nc uint64, now time.Time) int {
+    created, ok := self.nonceCreated(nonce)
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if ! ok || now.Sub(created) > self.nonce_ttl || created.Before(self.min_created) {
+        delete(self.nonces, nonce)
+        return NONCE_STALE
+    }
+    state, ok := self.nonces[nonce]
+    if ! ok {
+        self.sweep(now)
+        state = &nonceState{ created : created }
+        self.nonces[nonce] = state
+    }
+    if ! has_nc {
+        // No nonce count to track, so the nonce is good for one use only
+        if state.used {
+            return NONCE_REUSED
+        }
+        state.used = true
+        return NONCE_OK
+    }
+    if nc <= state.last_nc {
+        return NONCE_REUSED
+    }
+    state.last_nc = nc
+    return NONCE_OK
+}
+
+// Forgets the expired nonces and, if there are still too many of them,
+// the oldest ones. Must be called with the lock held.
+func (self *DigestAuth) sweep(now time.Time) {
+    if now.Sub(self.last_sweep) > NONCE_SWEEP_IVAL || len(self.nonces) >= self.max_nonces {
+        self.last_sweep = now
+        for n, state := range self.nonces {
+            if now.Sub(state.created) > self.nonce_ttl {
+                delete(self.nonces, n)
+            }
+        }
+    }
+    for age := self.nonce_ttl / 2; len(self.nonces) >= self.max_nonces; age /= 2 {
+        self.min_created = now.Add(-age)
+        for n, state := range self.nonces {
+            if state.created.Before(self.min_created) {
+                delete(self.nonces, n)
+            }
+        }
+        if age == 0 {
+            break
+        }
+    }
+}
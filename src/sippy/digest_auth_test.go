@@ -0,0 +1,105 @@
+package sippy
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func testDigestAuth() *DigestAuth {
+    return NewDigestAuth(nil)
+}
+
+func Test_DigestAuthNonce(t *testing.T) {
+    da := testDigestAuth()
+    now := time.Now()
+    for i := 0; i < 10; i++ {
+        da.newNonce(now)
+    }
+    if len(da.nonces) != 0 {
+        t.Fatal("the nonces are tracked before being used")
+    }
+    nonce := da.newNonce(now)
+    for _, forged := range []string{
+            "",
+            "abc",
+            nonce[:strings.Index(nonce, ".")],
+            nonce[:len(nonce) - 1] + "x",
+            testDigestAuth().newNonce(now),
+          } {
+        if res := da.useNonce(forged, false, 0, now); res != NONCE_STALE {
+            t.Errorf("%q: the forged nonce has been accepted: %d", forged, res)
+        }
+    }
+
+    for _, tc := range []struct {
+        name    string
+        has_nc  bool
+        nc      uint64
+        age     time.Duration
+        res     int
+    }{
+        { "first use", false, 0, 0, NONCE_OK },
+        { "replay", false, 0, time.Second, NONCE_REUSED },
+    } {
+        if res := da.useNonce(nonce, tc.has_nc, tc.nc, now.Add(tc.age)); res != tc.res {
+            t.Errorf("%s: expected %d, got %d", tc.name, tc.res, res)
+        }
+    }
+
+    nonce = da.newNonce(now)
+    for _, tc := range []struct {
+        name    string
+        has_nc  bool
+        nc      uint64
+        age     time.Duration
+        res     int
+    }{
+        { "nc 1", true, 1, 0, NONCE_OK },
+        { "nc 1 replayed", true, 1, time.Second, NONCE_REUSED },
+        { "nc 3", true, 3, time.Second, NONCE_OK },
+        { "nc 2 out of order", true, 2, time.Second, NONCE_REUSED },
+        { "nc 4", true, 4, 300 * time.Second, NONCE_OK },
+        { "nc 5 expired", true, 5, 301 * time.Second, NONCE_STALE },
+    } {
+        if res := da.useNonce(nonce, tc.has_nc, tc.nc, now.Add(tc.age)); res != tc.res {
+            t.Errorf("%s: expected %d, got %d", tc.name, tc.res, res)
+        }
+    }
+
+    // The expired nonces are swept
+    later := now.Add(da.nonce_ttl + NONCE_SWEEP_IVAL + time.Second)
+    if res := da.useNonce(da.newNonce(later), false, 0, later); res != NONCE_OK || len(da.nonces) != 1 {
+        t.Fatalf("the expired nonces have not been swept: %d, %d nonces", res, len(da.nonces))
+    }
+}
+
+func Test_DigestAuthNonceEviction(t *testing.T) {
+    da := testDigestAuth()
+    da.max_nonces = 4
+    now := time.Now()
+    nonces := []string{}
+    for i := 4; i > 0; i-- {
+        nonce := da.newNonce(now.Add(-time.Duration(i) * time.Second))
+        if res := da.useNonce(nonce, true, 1, now); res != NONCE_OK {
+            t.Fatalf("the nonce has not been accepted: %d", res)
+        }
+        nonces = append(nonces, nonce)
+    }
+    if res := da.useNonce(da.newNonce(now), true, 1, now); res != NONCE_OK {
+        t.Fatalf("the nonce has not been accepted: %d", res)
+    }
+    if len(da.nonces) >= da.max_nonces {
+        t.Fatalf("the nonce table has not been trimmed: %d nonces", len(da.nonces))
+    }
+    // The forgotten nonces can not be replayed, the tracked ones either
+    if res := da.useNonce(nonces[0], true, 1, now); res != NONCE_STALE {
+        t.Errorf("the forgotten nonce has been accepted: %d", res)
+    }
+    if res := da.useNonce(nonces[3], true, 1, now); res != NONCE_REUSED {
+        t.Errorf("the tracked nonce has been replayed: %d", res)
+    }
+    if res := da.useNonce(nonces[3], true, 2, now); res != NONCE_OK {
+        t.Errorf("the tracked nonce has not been accepted: %d", res)
+    }
+}
@@ -0,0 +1,55 @@
+package sippy
+
+import (
+    "fmt"
+    "testing"
+
+    "sippy/headers"
+)
+
+// The examples from the RFC 7616 section 3.9.1.
+func Test_DigestAlgorithms(t *testing.T) {
+    for _, tc := range []struct { alg, response string }{
+        { "MD5", "8ca523f5e9506fed4657c9700eebdbec" },
+        { "SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1" },
+    } {
+        nonce := "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+        cnonce := "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+        HA1 := sippy_header.DigestCalcHA1(tc.alg, "Mufasa", "http-auth@example.org", "Circle of Life", nonce, cnonce)
+        response := sippy_header.DigestCalcResponseAlg(tc.alg, HA1, nonce, "00000001", cnonce, "auth", "GET", "/dir/index.html", "")
+        if response != tc.response {
+            t.Errorf("%s: got %s, expected %s", tc.alg, response, tc.response)
+        }
+    }
+}
+
+func Test_SelectChallenge(t *testing.T) {
+    challenges := []*sippy_header.SipWWWAuthenticateBody{}
+    for _, s := range []string{
+        `Digest realm="example.org",nonce="n1",algorithm=MD5,qop="auth"`,
+        `Digest realm="example.org",nonce="n2",algorithm=SHA-256,qop="auth,auth-int",opaque="x,y"`,
+        `Digest realm="example.org",nonce="n3",algorithm=UNKNOWN`,
+    } {
+        hf := sippy_header.CreateSipWWWAuthenticate(s)[0].(*sippy_header.SipWWWAuthenticate)
+        body, err := hf.GetBody()
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        challenges = append(challenges, body)
+    }
+    challenge := sippy_header.SelectChallenge(challenges)
+    if challenge == nil || challenge.GetNonce() != "n2" || challenge.GetOpaque() != "x,y" {
+        t.Fatal("The SHA-256 challenge should have been selected")
+    }
+    for i := 1; i <= 2; i++ {
+        auth := sippy_header.NewSipAuthorizationWithChallenge(challenge, "INVITE", "sip:bob@example.org", "alice", "secret", "")
+        body, _ := auth.GetBody()
+        if body.GetQop() != "auth" || body.GetNC() != fmt.Sprintf("%08x", i) {
+            t.Errorf("Unexpected credentials: %s", body.String())
+        }
+        HA1 := sippy_header.DigestCalcHA1("SHA-256", "alice", "example.org", "secret", "", "")
+        if ! body.VerifyHA1(HA1, "INVITE") {
+            t.Errorf("The credentials do not verify: %s", body.String())
+        }
+    }
+}
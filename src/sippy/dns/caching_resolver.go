@@ -0,0 +1,165 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_dns
+
+import (
+    "sync"
+    "time"
+
+    "sippy/net"
+)
+
+const (
+    NEGATIVE_TTL = 30 * time.Second
+    MAX_TTL = 24 * time.Hour
+    PURGE_INTERVAL = 60 * time.Second
+)
+
+type cacheEntry struct {
+    expires     time.Time
+    value       interface{}
+}
+
+// The resolver caches the results of the upstream resolver for the
+// minimal TTL of the records returned. Empty answers are cached for
+// the NEGATIVE_TTL, the errors are not cached.
+type cachingResolver struct {
+    upstream    sippy_net.DnsResolver
+    lock        sync.Mutex
+    entries     map[string]*cacheEntry
+    last_purge  time.Time
+    now         func() time.Time
+}
+
+func NewCachingResolver(upstream sippy_net.DnsResolver) *cachingResolver {
+    return &cachingResolver{
+        upstream    : upstream,
+        entries     : make(map[string]*cacheEntry),
+        last_purge  : time.Now(),
+        now         : time.Now,
+    }
+}
+
+func (self *cachingResolver) get(key string) (interface{}, bool) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    entry, ok := self.entries[key]
+    if ! ok {
+        return nil, false
+    }
+    if ! self.now().Before(entry.expires) {
+        delete(self.entries, key)
+        return nil, false
+    }
+    return entry.value, true
+}
+
+func (self *cachingResolver) put(key string, value interface{}, ttl time.Duration) {
+    now := self.now()
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if now.Sub(self.last_purge) > PURGE_INTERVAL {
+        for k, entry := range self.entries {
+            if ! now.Before(entry.expires) {
+                delete(self.entries, k)
+            }
+        }
+        self.last_purge = now
+    }
+    if ttl <= 0 {
+        return
+    }
+    self.entries[key] = &cacheEntry{ expires : now.Add(ttl), value : value }
+}
+
+func minTTL(ttl, rec_ttl time.Duration) time.Duration {
+    if rec_ttl < ttl {
+        return rec_ttl
+    }
+    return ttl
+}
+
+func (self *cachingResolver) LookupNAPTR(name string) ([]*sippy_net.DnsNAPTR, error) {
+    key := "NAPTR:" + name
+    if v, ok := self.get(key); ok {
+        return v.([]*sippy_net.DnsNAPTR), nil
+    }
+    ret, err := self.upstream.LookupNAPTR(name)
+    if err != nil {
+        return nil, err
+    }
+    ttl := MAX_TTL
+    if len(ret) == 0 {
+        ttl = NEGATIVE_TTL
+    }
+    for _, rec := range ret {
+        ttl = minTTL(ttl, rec.TTL)
+    }
+    self.put(key, ret, ttl)
+    return ret, nil
+}
+
+func (self *cachingResolver) LookupSRV(name string) ([]*sippy_net.DnsSRV, error) {
+    key := "SRV:" + name
+    if v, ok := self.get(key); ok {
+        return v.([]*sippy_net.DnsSRV), nil
+    }
+    ret, err := self.upstream.LookupSRV(name)
+    if err != nil {
+        return nil, err
+    }
+    ttl := MAX_TTL
+    if len(ret) == 0 {
+        ttl = NEGATIVE_TTL
+    }
+    for _, rec := range ret {
+        ttl = minTTL(ttl, rec.TTL)
+    }
+    self.put(key, ret, ttl)
+    return ret, nil
+}
+
+func (self *cachingResolver) LookupIP(name string) ([]*sippy_net.DnsIP, error) {
+    key := "IP:" + name
+    if v, ok := self.get(key); ok {
+        return v.([]*sippy_net.DnsIP), nil
+    }
+    ret, err := self.upstream.LookupIP(name)
+    if err != nil {
+        return nil, err
+    }
+    ttl := MAX_TTL
+    if len(ret) == 0 {
+        ttl = NEGATIVE_TTL
+    }
+    for _, rec := range ret {
+        ttl = minTTL(ttl, rec.TTL)
+    }
+    self.put(key, ret, ttl)
+    return ret, nil
+}
@@ -0,0 +1,400 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_dns
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math/rand"
+    "net"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "sippy/net"
+)
+
+const (
+    TYPE_A      = 1
+    TYPE_AAAA   = 28
+    TYPE_SRV    = 33
+    TYPE_NAPTR  = 35
+
+    CLASS_IN    = 1
+
+    RCODE_NXDOMAIN = 3
+
+    RESOLV_CONF = "/etc/resolv.conf"
+    DNS_TIMEOUT = 2 * time.Second
+    DNS_ATTEMPTS = 2
+)
+
+// The minimal stub resolver talking to the recursive name servers from
+// the resolv.conf. It is needed since the standard library does not
+// support NAPTR and does not report the record TTLs.
+type stubResolver struct {
+    lock        sync.Mutex
+    servers     []string
+    timeout     time.Duration
+}
+
+func NewStubResolver(servers ...string) *stubResolver {
+    self := &stubResolver{
+        servers     : servers,
+        timeout     : DNS_TIMEOUT,
+    }
+    return self
+}
+
+func (self *stubResolver) getServers() []string {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if len(self.servers) == 0 {
+        self.servers = readResolvConf(RESOLV_CONF)
+    }
+    return self.servers
+}
+
+func readResolvConf(fname string) []string {
+    servers := []string{}
+    if f, err := os.Open(fname); err == nil {
+        defer f.Close()
+        scanner := bufio.NewScanner(f)
+        for scanner.Scan() {
+            fields := strings.Fields(scanner.Text())
+            if len(fields) >= 2 && fields[0] == "nameserver" {
+                servers = append(servers, net.JoinHostPort(strings.SplitN(fields[1], "%", 2)[0], "53"))
+            }
+        }
+    }
+    if len(servers) == 0 {
+        servers = append(servers, "127.0.0.1:53")
+    }
+    return servers
+}
+
+func (self *stubResolver) LookupNAPTR(name string) ([]*sippy_net.DnsNAPTR, error) {
+    rrs, err := self.query(name, TYPE_NAPTR)
+    if err != nil {
+        return nil, err
+    }
+    ret := []*sippy_net.DnsNAPTR{}
+    for _, rr := range rrs {
+        if naptr, err := rr.naptr(); err == nil {
+            ret = append(ret, naptr)
+        }
+    }
+    return ret, nil
+}
+
+func (self *stubResolver) LookupSRV(name string) ([]*sippy_net.DnsSRV, error) {
+    rrs, err := self.query(name, TYPE_SRV)
+    if err != nil {
+        return nil, err
+    }
+    ret := []*sippy_net.DnsSRV{}
+    for _, rr := range rrs {
+        if srv, err := rr.srv(); err == nil {
+            ret = append(ret, srv)
+        }
+    }
+    return ret, nil
+}
+
+func (self *stubResolver) LookupIP(name string) ([]*sippy_net.DnsIP, error) {
+    ret := []*sippy_net.DnsIP{}
+    var last_err error
+    for _, qtype := range []uint16{ TYPE_A, TYPE_AAAA } {
+        rrs, err := self.query(name, qtype)
+        if err != nil {
+            last_err = err
+            continue
+        }
+        for _, rr := range rrs {
+            if len(rr.rdata) == 4 || len(rr.rdata) == 16 {
+                ret = append(ret, &sippy_net.DnsIP{ IP : net.IP(rr.rdata), TTL : rr.ttl })
+            }
+        }
+    }
+    if len(ret) == 0 && last_err != nil {
+        return nil, last_err
+    }
+    return ret, nil
+}
+
+// Send the query to the servers in turn until one of them answers.
+func (self *stubResolver) query(name string, qtype uint16) ([]*resourceRecord, error) {
+    name = strings.TrimSuffix(name, ".")
+    msg, id, err := buildQuery(name, qtype)
+    if err != nil {
+        return nil, err
+    }
+    var last_err error
+    for i := 0; i < DNS_ATTEMPTS; i++ {
+        for _, server := range self.getServers() {
+            resp, err := self.exchange("udp", server, msg)
+            if err == nil && len(resp) > 2 && resp[2] & 0x02 != 0 {
+                // truncated, retry over TCP
+                resp, err = self.exchange("tcp", server, msg)
+            }
+            if err != nil {
+                last_err = err
+                continue
+            }
+            rrs, err := parseResponse(resp, id, qtype)
+            if err != nil {
+                last_err = err
+                continue
+            }
+            return rrs, nil
+        }
+    }
+    return nil, fmt.Errorf("DNS query for %s failed: %s", name, last_err)
+}
+
+func (self *stubResolver) exchange(network, server string, msg []byte) ([]byte, error) {
+    conn, err := net.DialTimeout(network, server, self.timeout)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(self.timeout))
+    if network == "tcp" {
+        buf := make([]byte, 2, 2 + len(msg))
+        binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+        if _, err = conn.Write(append(buf, msg...)); err != nil {
+            return nil, err
+        }
+        if _, err = readFull(conn, buf[:2]); err != nil {
+            return nil, err
+        }
+        resp := make([]byte, binary.BigEndian.Uint16(buf[:2]))
+        _, err = readFull(conn, resp)
+        return resp, err
+    }
+    if _, err = conn.Write(msg); err != nil {
+        return nil, err
+    }
+    resp := make([]byte, 65535)
+    n, err := conn.Read(resp)
+    if err != nil {
+        return nil, err
+    }
+    return resp[:n], nil
+}
+
+func readFull(conn net.Conn, buf []byte) (int, error) {
+    n := 0
+    for n < len(buf) {
+        m, err := conn.Read(buf[n:])
+        if err != nil {
+            return n, err
+        }
+        n += m
+    }
+    return n, nil
+}
+
+func buildQuery(name string, qtype uint16) ([]byte, uint16, error) {
+    id := uint16(rand.Uint32())
+    msg := make([]byte, 12, 512)
+    binary.BigEndian.PutUint16(msg[0:], id)
+    msg[2] = 0x01 // RD
+    binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+    for _, label := range strings.Split(name, ".") {
+        if len(label) == 0 || len(label) > 63 {
+            return nil, 0, errors.New("bad domain name: " + name)
+        }
+        msg = append(msg, byte(len(label)))
+        msg = append(msg, label...)
+    }
+    msg = append(msg, 0, 0, 0, 0, 0)
+    binary.BigEndian.PutUint16(msg[len(msg) - 4:], qtype)
+    binary.BigEndian.PutUint16(msg[len(msg) - 2:], CLASS_IN)
+    return msg, id, nil
+}
+
+type resourceRecord struct {
+    msg         []byte
+    rtype       uint16
+    ttl         time.Duration
+    rdata       []byte
+    rdata_off   int
+}
+
+// Parse the answer section of the response and return the records of
+// the requested type. The CNAME chains are expected to be resolved by
+// the recursive server, so the owner names are not checked.
+func parseResponse(msg []byte, id uint16, qtype uint16) ([]*resourceRecord, error) {
+    if len(msg) < 12 {
+        return nil, errors.New("DNS response is too short")
+    }
+    if binary.BigEndian.Uint16(msg[0:]) != id {
+        return nil, errors.New("DNS response ID mismatch")
+    }
+    if msg[2] & 0x80 == 0 {
+        return nil, errors.New("not a DNS response")
+    }
+    switch rcode := msg[3] & 0x0f; rcode {
+    case 0:
+    case RCODE_NXDOMAIN:
+        return []*resourceRecord{}, nil
+    default:
+        return nil, fmt.Errorf("DNS server returned error code %d", rcode)
+    }
+    qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+    ancount := int(binary.BigEndian.Uint16(msg[6:]))
+    off := 12
+    var err error
+    for i := 0; i < qdcount; i++ {
+        if _, off, err = readName(msg, off); err != nil {
+            return nil, err
+        }
+        off += 4
+    }
+    ret := []*resourceRecord{}
+    for i := 0; i < ancount; i++ {
+        if _, off, err = readName(msg, off); err != nil {
+            return nil, err
+        }
+        if off + 10 > len(msg) {
+            return nil, errors.New("truncated DNS resource record")
+        }
+        rtype := binary.BigEndian.Uint16(msg[off:])
+        ttl := binary.BigEndian.Uint32(msg[off + 4:])
+        rdlen := int(binary.BigEndian.Uint16(msg[off + 8:]))
+        off += 10
+        if off + rdlen > len(msg) {
+            return nil, errors.New("truncated DNS resource record")
+        }
+        if rtype == qtype {
+            ret = append(ret, &resourceRecord{
+                msg         : msg,
+                rtype       : rtype,
+                ttl         : time.Duration(ttl) * time.Second,
+                rdata       : msg[off:off + rdlen],
+                rdata_off   : off,
+            })
+        }
+        off += rdlen
+    }
+    return ret, nil
+}
+
+// Read the possibly compressed domain name at the offset. Returns the
+// name and the offset right past it.
+func readName(msg []byte, off int) (string, int, error) {
+    labels := []string{}
+    next := -1
+    for jumps := 0; ; {
+        if off >= len(msg) {
+            return "", 0, errors.New("truncated domain name")
+        }
+        l := int(msg[off])
+        switch {
+        case l == 0:
+            off++
+            if next < 0 {
+                next = off
+            }
+            return strings.Join(labels, "."), next, nil
+        case l & 0xc0 == 0xc0:
+            if off + 1 >= len(msg) {
+                return "", 0, errors.New("truncated domain name")
+            }
+            if next < 0 {
+                next = off + 2
+            }
+            jumps++
+            if jumps > 32 {
+                return "", 0, errors.New("domain name compression loop")
+            }
+            off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+        default:
+            if off + 1 + l > len(msg) {
+                return "", 0, errors.New("truncated domain name")
+            }
+            labels = append(labels, string(msg[off + 1:off + 1 + l]))
+            off += 1 + l
+        }
+    }
+}
+
+func readCharString(msg []byte, off int) (string, int, error) {
+    if off >= len(msg) || off + 1 + int(msg[off]) > len(msg) {
+        return "", 0, errors.New("truncated character string")
+    }
+    l := int(msg[off])
+    return string(msg[off + 1:off + 1 + l]), off + 1 + l, nil
+}
+
+func (self *resourceRecord) srv() (*sippy_net.DnsSRV, error) {
+    if len(self.rdata) < 7 {
+        return nil, errors.New("bad SRV record")
+    }
+    target, _, err := readName(self.msg, self.rdata_off + 6)
+    if err != nil {
+        return nil, err
+    }
+    return &sippy_net.DnsSRV{
+        Priority    : binary.BigEndian.Uint16(self.rdata[0:]),
+        Weight      : binary.BigEndian.Uint16(self.rdata[2:]),
+        Port        : binary.BigEndian.Uint16(self.rdata[4:]),
+        Target      : target,
+        TTL         : self.ttl,
+    }, nil
+}
+
+func (self *resourceRecord) naptr() (*sippy_net.DnsNAPTR, error) {
+    if len(self.rdata) < 8 {
+        return nil, errors.New("bad NAPTR record")
+    }
+    var err error
+    ret := &sippy_net.DnsNAPTR{
+        Order       : binary.BigEndian.Uint16(self.rdata[0:]),
+        Preference  : binary.BigEndian.Uint16(self.rdata[2:]),
+        TTL         : self.ttl,
+    }
+    off := self.rdata_off + 4
+    if ret.Flags, off, err = readCharString(self.msg, off); err != nil {
+        return nil, err
+    }
+    if ret.Service, off, err = readCharString(self.msg, off); err != nil {
+        return nil, err
+    }
+    if ret.Regexp, off, err = readCharString(self.msg, off); err != nil {
+        return nil, err
+    }
+    if ret.Replacement, _, err = readName(self.msg, off); err != nil {
+        return nil, err
+    }
+    return ret, nil
+}
@@ -0,0 +1,134 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_header
+
+import (
+    "crypto/md5"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/hex"
+    "errors"
+    "strings"
+)
+
+// Returns the hash function of the digest algorithm as defined by the
+// RFC 7616 and RFC 8760 and whether it is the session variant.
+func digestHash(alg string) (func(string) string, bool, error) {
+    alg = strings.ToLower(alg)
+    sess := strings.HasSuffix(alg, "-sess")
+    switch strings.TrimSuffix(alg, "-sess") {
+    case "", "md5":
+        return func(s string) string { sum := md5.Sum([]byte(s)); return hex.EncodeToString(sum[:]) }, sess, nil
+    case "sha-256":
+        return func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) }, sess, nil
+    case "sha-512-256":
+        return func(s string) string { sum := sha512.Sum512_256([]byte(s)); return hex.EncodeToString(sum[:]) }, sess, nil
+    }
+    return nil, false, errors.New("Unsupported digest algorithm: " + alg)
+}
+
+// The strength of the algorithm, zero for the unsupported ones.
+func digestRank(alg string) int {
+    if _, _, err := digestHash(alg); err != nil {
+        return 0
+    }
+    switch strings.TrimSuffix(strings.ToLower(alg), "-sess") {
+    case "sha-512-256":
+        return 3
+    case "sha-256":
+        return 2
+    }
+    return 1
+}
+
+func DigestAlgorithmSupported(alg string) bool {
+    return digestRank(alg) > 0
+}
+
+// Calculates the hash of the message body for the qop=auth-int.
+func DigestCalcHEntity(alg, entity_body string) string {
+    h, _, err := digestHash(alg)
+    if err != nil {
+        return ""
+    }
+    return h(entity_body)
+}
+
+func DigestCalcResponseAlg(alg, HA1, pszNonce, pszNonceCount, pszCNonce, pszQop, pszMethod, pszDigestUri, pszHEntity string) string {
+    h, _, err := digestHash(alg)
+    if err != nil {
+        return ""
+    }
+    s := pszMethod + ":" + pszDigestUri
+    if pszQop == "auth-int" {
+        s += ":" + pszHEntity
+    }
+    HA2 := h(s)
+    s = HA1 + ":" + pszNonce + ":"
+    if pszNonceCount != "" && pszCNonce != "" { // pszQop:
+        s += pszNonceCount + ":" + pszCNonce + ":" + pszQop + ":"
+    }
+    s += HA2
+    return h(s)
+}
+
+// Picks the strongest of the challenges that can be answered, nil if
+// none can.
+func SelectChallenge(challenges []*SipWWWAuthenticateBody) *SipWWWAuthenticateBody {
+    var best *SipWWWAuthenticateBody
+    for _, challenge := range challenges {
+        if digestRank(challenge.algorithm) == 0 {
+            continue
+        }
+        if challenge.qop != "" && challenge.pickQop() == "" {
+            continue
+        }
+        if best == nil || digestRank(challenge.algorithm) > digestRank(best.algorithm) {
+            best = challenge
+        }
+    }
+    return best
+}
+
+// Splits the comma separated auth-params leaving the commas inside the
+// quoted strings alone.
+func splitAuthParams(s string) []string {
+    ret := []string{}
+    quoted := false
+    start := 0
+    for i, r := range s {
+        switch {
+        case r == '"':
+            quoted = ! quoted
+        case r == ',' && ! quoted:
+            ret = append(ret, s[start:i])
+            start = i + 1
+        }
+    }
+    return append(ret, s[start:])
+}
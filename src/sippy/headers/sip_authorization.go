@@ -27,7 +27,8 @@
 package sippy_header
 
 import (
-    "crypto/md5"
+    "crypto/rand"
+    "encoding/hex"
     "errors"
     "fmt"
     "strings"
@@ -47,6 +48,8 @@ type SipAuthorizationBody struct {
     qop         string
     nc          string
     cnonce      string
+    algorithm   string
+    opaque      string
     otherparams string
 }
 
@@ -73,6 +76,41 @@ func NewSipAuthorization(realm, nonce, method, uri, username, password string) *
     }
 }
 
+// Builds the credentials answering the challenge. The entity_body is
+// only used when the qop=auth-int is chosen.
+func NewSipAuthorizationWithChallenge(challenge *SipWWWAuthenticateBody, method, uri, username, password, entity_body string) *SipAuthorization {
+    return &SipAuthorization{
+        normalName : _sip_authorization_name,
+        body    : newSipAuthorizationBodyWithChallenge(challenge, method, uri, username, password, entity_body),
+    }
+}
+
+func newSipAuthorizationBodyWithChallenge(challenge *SipWWWAuthenticateBody, method, uri, username, password, entity_body string) *SipAuthorizationBody {
+    self := &SipAuthorizationBody{
+        realm       : challenge.GetRealm(),
+        nonce       : challenge.GetNonce(),
+        uri         : uri,
+        username    : username,
+        algorithm   : challenge.algorithm,
+        opaque      : challenge.opaque,
+        qop         : challenge.pickQop(),
+    }
+    if self.qop != "" {
+        challenge.nc++
+        self.nc = fmt.Sprintf("%08x", challenge.nc)
+        buf := make([]byte, 8)
+        rand.Read(buf)
+        self.cnonce = hex.EncodeToString(buf)
+    }
+    HA1 := DigestCalcHA1(self.algorithm, username, self.realm, password, self.nonce, self.cnonce)
+    hentity := ""
+    if self.qop == "auth-int" {
+        hentity = DigestCalcHEntity(self.algorithm, entity_body)
+    }
+    self.response = DigestCalcResponseAlg(self.algorithm, HA1, self.nonce, self.nc, self.cnonce, self.qop, method, uri, hentity)
+    return self
+}
+
 func CreateSipAuthorization(body string) []SipHeader {
     self := createSipAuthorizationObj(body)
     return []SipHeader{ self }
@@ -92,7 +130,7 @@ func newSipAuthorizationBody(body string) (*SipAuthorizationBody, error) {
     if len(arr) != 2 {
         return nil, errors.New("Error parsing authorization (1)")
     }
-    for _, param := range strings.Split(arr[1], ",") {
+    for _, param := range splitAuthParams(arr[1]) {
         kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
         if len(kv) != 2 {
             return nil, errors.New("Error parsing authorization (2)")
@@ -115,6 +153,10 @@ func newSipAuthorizationBody(body string) (*SipAuthorizationBody, error) {
             self.cnonce = strings.Trim(value, "\"")
         case "nc":
             self.nc = strings.Trim(value, "\"")
+        case "algorithm":
+            self.algorithm = strings.Trim(value, "\"")
+        case "opaque":
+            self.opaque = strings.Trim(value, "\"")
         default:
             self.otherparams += "," + param
         }
@@ -125,8 +167,14 @@ func newSipAuthorizationBody(body string) (*SipAuthorizationBody, error) {
 func (self *SipAuthorizationBody) String() string {
     rval := "Digest username=\"" + self.username + "\",realm=\"" + self.realm + "\",nonce=\"" + self.nonce +
         "\",uri=\"" + self.uri + "\",response=\"" + self.response + "\""
+    if self.algorithm != "" {
+        rval += ",algorithm=" + self.algorithm
+    }
+    if self.opaque != "" {
+        rval += ",opaque=\"" + self.opaque + "\""
+    }
     if self.qop != "" {
-        rval += ",nc=\"" + self.nc + "\",cnonce=\"" + self.cnonce + "\",qop=" + self.qop
+        rval += ",nc=" + self.nc + ",cnonce=\"" + self.cnonce + "\",qop=" + self.qop
     }
     return rval + self.otherparams
 }
@@ -140,9 +188,58 @@ func (self *SipAuthorizationBody) GetUsername() string {
     return self.username
 }
 
+func (self *SipAuthorizationBody) GetRealm() string {
+    return self.realm
+}
+
+func (self *SipAuthorizationBody) GetNonce() string {
+    return self.nonce
+}
+
+func (self *SipAuthorizationBody) GetURI() string {
+    return self.uri
+}
+
+func (self *SipAuthorizationBody) GetResponse() string {
+    return self.response
+}
+
+func (self *SipAuthorizationBody) GetQop() string {
+    return self.qop
+}
+
+func (self *SipAuthorizationBody) GetNC() string {
+    return self.nc
+}
+
+func (self *SipAuthorizationBody) GetCNonce() string {
+    return self.cnonce
+}
+
+func (self *SipAuthorizationBody) GetAlgorithm() string {
+    return self.algorithm
+}
+
+func (self *SipAuthorizationBody) GetOpaque() string {
+    return self.opaque
+}
+
 func (self *SipAuthorizationBody) VerifyHA1(HA1, method string) bool {
-    response := DigestCalcResponse(HA1, self.nonce, self.nc, self.cnonce, self.qop, method, self.uri, "")
-    return response == self.response
+    return self.VerifyHA1WithBody(HA1, method, "")
+}
+
+// Verifies the response, the entity_body is only used when the
+// qop=auth-int is in effect.
+func (self *SipAuthorizationBody) VerifyHA1WithBody(HA1, method, entity_body string) bool {
+    if HA1 == "" {
+        return false
+    }
+    hentity := ""
+    if self.qop == "auth-int" {
+        hentity = DigestCalcHEntity(self.algorithm, entity_body)
+    }
+    response := DigestCalcResponseAlg(self.algorithm, HA1, self.nonce, self.nc, self.cnonce, self.qop, method, self.uri, hentity)
+    return response != "" && response == strings.ToLower(self.response)
 }
 
 func (self *SipAuthorization) String() string {
@@ -194,29 +291,17 @@ func (self *SipAuthorization) GetCopyAsIface() SipHeader {
 }
 
 func DigestCalcHA1(pszAlg, pszUserName, pszRealm, pszPassword, pszNonce, pszCNonce string) string {
-    s := pszUserName + ":" + pszRealm + ":" + pszPassword
-    HA1 := md5.Sum([]byte(s))
-    if pszAlg == "md5-sess" {
-        s2 := make([]byte, len(HA1))
-        for i, b := range HA1 {
-            s2[i] = b
-        }
-        s2 = append(s2, []byte(":" + pszNonce + ":" + pszCNonce)...)
-        HA1 = md5.Sum(s2)
+    h, sess, err := digestHash(pszAlg)
+    if err != nil {
+        return ""
+    }
+    HA1 := h(pszUserName + ":" + pszRealm + ":" + pszPassword)
+    if sess {
+        HA1 = h(HA1 + ":" + pszNonce + ":" + pszCNonce)
     }
-    return fmt.Sprintf("%x", HA1)
+    return HA1
 }
 
 func DigestCalcResponse(HA1, pszNonce string, pszNonceCount, pszCNonce, pszQop, pszMethod, pszDigestUri, pszHEntity string) string {
-    s := pszMethod + ":" + pszDigestUri
-    if pszQop == "auth-int" {
-        s += ":" + pszHEntity
-    }
-    HA2 := fmt.Sprintf("%x", md5.Sum([]byte(s)))
-    s = HA1 + ":" + pszNonce + ":"
-    if pszNonceCount != "" && pszCNonce != "" { // pszQop:
-        s += pszNonceCount + ":" + pszCNonce + ":" + pszQop + ":"
-    }
-    s += HA2
-    return fmt.Sprintf("%x", md5.Sum([]byte(s)))
+    return DigestCalcResponseAlg("md5", HA1, pszNonce, pszNonceCount, pszCNonce, pszQop, pszMethod, pszDigestUri, pszHEntity)
 }
@@ -28,7 +28,9 @@ package sippy_header
 
 import (
     "crypto/rand"
+    "fmt"
     "strconv"
+    "strings"
 
     "sippy/net"
 )
@@ -68,6 +70,25 @@ func (self *SipCiscoGUID) AsH323ConfId() *SipH323ConfId {
     }
 }
 
+// HexForm returns the GUID in the format used by the h323-conf-id
+// attribute, i.e. four space separated 32-bit hex numbers.
+func (self *SipCiscoGUID) HexForm() string {
+    arr := strings.Split(self.body, "-")
+    if len(arr) != 4 {
+        return self.body
+    }
+    s := ""
+    for i, part := range arr {
+        x, err := strconv.ParseUint(part, 10, 32)
+        if err != nil {
+            return self.body
+        }
+        if i != 0 { s += " " }
+        s += fmt.Sprintf("%08X", x)
+    }
+    return s
+}
+
 func (self *SipCiscoGUID) GetCopy() *SipCiscoGUID {
     tmp := *self
     return &tmp
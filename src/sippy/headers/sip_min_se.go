@@ -0,0 +1,70 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_header
+
+import (
+    "sippy/net"
+)
+
+type SipMinSE struct {
+    normalName
+    SipNumericHF
+}
+
+var _sip_min_se_name normalName = newNormalName("Min-SE")
+
+func NewSipMinSE(delta int) *SipMinSE {
+    return &SipMinSE{
+        normalName  : _sip_min_se_name,
+        SipNumericHF : newSipNumericHF(delta),
+    }
+}
+
+func CreateSipMinSE(body string) []SipHeader {
+    return []SipHeader{ &SipMinSE{
+        normalName      : _sip_min_se_name,
+        SipNumericHF    : createSipNumericHF(body),
+    } }
+}
+
+func (self *SipMinSE) String() string {
+    return self.Name() + ": " + self.StringBody()
+}
+
+func (self *SipMinSE) LocalStr(hostport *sippy_net.HostPort, compact bool) string {
+    return self.String()
+}
+
+func (self *SipMinSE) GetCopy() *SipMinSE {
+    tmp := *self
+    return &tmp
+}
+
+func (self *SipMinSE) GetCopyAsIface() SipHeader {
+    return self.GetCopy()
+}
@@ -40,6 +40,14 @@ func NewSipProxyAuthorization(realm, nonce, method, uri, username, password stri
     }
 }
 
+func NewSipProxyAuthorizationWithChallenge(challenge *SipWWWAuthenticateBody, method, uri, username, password, entity_body string) *SipProxyAuthorization {
+    super := NewSipAuthorizationWithChallenge(challenge, method, uri, username, password, entity_body)
+    super.normalName = _sip_proxy_authorization_name
+    return &SipProxyAuthorization{
+        SipAuthorization : super,
+    }
+}
+
 func CreateSipProxyAuthorization(body string) []SipHeader {
     super := createSipAuthorizationObj(body)
     super.normalName = _sip_proxy_authorization_name
@@ -0,0 +1,136 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_header
+
+import (
+    "strconv"
+    "strings"
+
+    "sippy/net"
+)
+
+type SipSessionExpiresBody struct {
+    Delta       int
+    Refresher   string
+    otherparams string
+}
+
+func newSipSessionExpiresBody(body string) (*SipSessionExpiresBody, error) {
+    arr := strings.Split(body, ";")
+    delta, err := strconv.Atoi(strings.TrimSpace(arr[0]))
+    if err != nil {
+        return nil, err
+    }
+    self := &SipSessionExpiresBody{
+        Delta       : delta,
+    }
+    for _, param := range arr[1:] {
+        kv := strings.SplitN(param, "=", 2)
+        if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "refresher" {
+            self.Refresher = strings.ToLower(strings.TrimSpace(kv[1]))
+        } else {
+            self.otherparams += ";" + strings.TrimSpace(param)
+        }
+    }
+    return self, nil
+}
+
+func (self *SipSessionExpiresBody) String() string {
+    rval := strconv.Itoa(self.Delta)
+    if self.Refresher != "" {
+        rval += ";refresher=" + self.Refresher
+    }
+    return rval + self.otherparams
+}
+
+type SipSessionExpires struct {
+    compactName
+    string_body     string
+    body            *SipSessionExpiresBody
+}
+
+var _sip_session_expires_name compactName = newCompactName("Session-Expires", "x")
+
+func NewSipSessionExpires(delta int, refresher string) *SipSessionExpires {
+    return &SipSessionExpires{
+        compactName : _sip_session_expires_name,
+        body        : &SipSessionExpiresBody{
+            Delta       : delta,
+            Refresher   : refresher,
+        },
+    }
+}
+
+func CreateSipSessionExpires(body string) []SipHeader {
+    return []SipHeader{ &SipSessionExpires{
+        compactName : _sip_session_expires_name,
+        string_body : body,
+    } }
+}
+
+func (self *SipSessionExpires) GetBody() (*SipSessionExpiresBody, error) {
+    if self.body == nil {
+        body, err := newSipSessionExpiresBody(self.string_body)
+        if err != nil {
+            return nil, err
+        }
+        self.body = body
+    }
+    return self.body, nil
+}
+
+func (self *SipSessionExpires) StringBody() string {
+    if self.body != nil {
+        return self.body.String()
+    }
+    return self.string_body
+}
+
+func (self *SipSessionExpires) String() string {
+    return self.Name() + ": " + self.StringBody()
+}
+
+func (self *SipSessionExpires) LocalStr(hostport *sippy_net.HostPort, compact bool) string {
+    if compact {
+        return self.CompactName() + ": " + self.StringBody()
+    }
+    return self.String()
+}
+
+func (self *SipSessionExpires) GetCopy() *SipSessionExpires {
+    tmp := *self
+    if self.body != nil {
+        body := *self.body
+        tmp.body = &body
+    }
+    return &tmp
+}
+
+func (self *SipSessionExpires) GetCopyAsIface() SipHeader {
+    return self.GetCopy()
+}
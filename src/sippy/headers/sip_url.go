@@ -356,6 +356,9 @@ func (self *SipURL) GetAddr(config sippy_conf.Config) *sippy_net.HostPort {
     if self.Port != nil {
         return sippy_net.NewHostPort(self.Host.String(), self.Port.String())
     }
+    if self.scheme == "sips" || strings.EqualFold(self.transport, "tls") {
+        return sippy_net.NewHostPort(self.Host.String(), "5061")
+    }
     return sippy_net.NewHostPort(self.Host.String(), config.DefaultPort().String())
 }
 
@@ -366,3 +369,38 @@ func (self *SipURL) SetUserparams(userparams []string) {
 func (self *SipURL) GetUserparams() []string {
     return self.userparams
 }
+
+func (self *SipURL) GetTransport() string {
+    return self.transport
+}
+
+func (self *SipURL) SetTransport(transport string) {
+    self.transport = transport
+}
+
+// GetProto returns the lower-cased transport protocol the URL
+// is to be reached with. The sips: URLs are always reached over TLS
+// or secure WebSocket (RFC 7118).
+func (self *SipURL) GetProto() string {
+    proto := strings.ToLower(self.transport)
+    if self.scheme == "sips" {
+        switch proto {
+        case "", "tcp":
+            return "tls"
+        case "ws":
+            return "wss"
+        }
+    }
+    if proto != "" {
+        return proto
+    }
+    return "udp"
+}
+
+func (self *SipURL) GetScheme() string {
+    return self.scheme
+}
+
+func (self *SipURL) SetScheme(scheme string) {
+    self.scheme = scheme
+}
@@ -294,3 +294,21 @@ func (self *SipViaBody) SetReceived(v string) {
 func (self *SipViaBody) HasRport() bool {
     return self.rport_exists
 }
+
+// GetTransport returns the lower-cased transport protocol from the
+// sent-protocol part of the Via.
+func (self *SipViaBody) GetTransport() string {
+    arr := strings.Split(self.sipver, "/")
+    if len(arr) != 3 {
+        return "udp"
+    }
+    return strings.ToLower(strings.TrimSpace(arr[2]))
+}
+
+func (self *SipViaBody) SetTransport(proto string) {
+    self.sipver = "SIP/2.0/" + strings.ToUpper(proto)
+}
+
+func (self *SipViaBody) SetPort(port *sippy_net.MyPort) {
+    self.port = port
+}
@@ -39,6 +39,11 @@ import (
 type SipWWWAuthenticateBody struct {
     realm *sippy_net.MyAddress
     nonce string
+    qop   string
+    stale bool
+    algorithm string
+    opaque string
+    nc    int
 }
 
 type SipWWWAuthenticate struct {
@@ -60,6 +65,16 @@ func NewSipWWWAuthenticateWithRealm(realm string) *SipWWWAuthenticate {
     }
 }
 
+func NewSipWWWAuthenticate(realm, nonce string) *SipWWWAuthenticate {
+    return &SipWWWAuthenticate{
+        normalName  : _sip_www_authenticate_name,
+        body        : &SipWWWAuthenticateBody{
+            realm : sippy_net.NewMyAddress(realm),
+            nonce : nonce,
+        },
+    }
+}
+
 func newSipWWWAutenticateBody(realm string) *SipWWWAuthenticateBody {
     buf := make([]byte, 20)
     rand.Read(buf)
@@ -82,7 +97,7 @@ func (self *SipWWWAuthenticate) parse() error {
         return errors.New("Error parsing authentication (1)")
     }
     body := &SipWWWAuthenticateBody{}
-    for _, part := range strings.Split(tmp[1], ",") {
+    for _, part := range splitAuthParams(tmp[1]) {
         arr := strings.SplitN(strings.TrimSpace(part), "=", 2)
         if len(arr) != 2 { continue }
         switch arr[0] {
@@ -90,13 +105,21 @@ func (self *SipWWWAuthenticate) parse() error {
             body.realm = sippy_net.NewMyAddress(strings.Trim(arr[1], "\""))
         case "nonce":
             body.nonce = strings.Trim(arr[1], "\"")
+        case "qop":
+            body.qop = strings.Trim(arr[1], "\"")
+        case "stale":
+            body.stale = strings.ToLower(strings.Trim(arr[1], "\"")) == "true"
+        case "algorithm":
+            body.algorithm = strings.Trim(arr[1], "\"")
+        case "opaque":
+            body.opaque = strings.Trim(arr[1], "\"")
         }
     }
     self.body = body
     return nil
 }
 
-func (self SipWWWAuthenticate) GetBody() (*SipWWWAuthenticateBody, error) {
+func (self *SipWWWAuthenticate) GetBody() (*SipWWWAuthenticateBody, error) {
     if self.body == nil {
         if err := self.parse(); err != nil {
             return nil, err
@@ -125,10 +148,24 @@ func (self *SipWWWAuthenticate) LocalStringBody(hostport *sippy_net.HostPort) st
 }
 
 func (self *SipWWWAuthenticateBody) localString(hostport *sippy_net.HostPort) string {
+    realm := self.realm.String()
     if hostport != nil && self.realm.IsSystemDefault() {
-        return "Digest realm=\"" + hostport.Host.String() + "\",nonce=\"" + self.nonce + "\""
+        realm = hostport.Host.String()
+    }
+    rval := "Digest realm=\"" + realm + "\",nonce=\"" + self.nonce + "\""
+    if self.algorithm != "" {
+        rval += ",algorithm=" + self.algorithm
+    }
+    if self.opaque != "" {
+        rval += ",opaque=\"" + self.opaque + "\""
+    }
+    if self.qop != "" {
+        rval += ",qop=\"" + self.qop + "\""
     }
-    return "Digest realm=\"" + self.realm.String() + "\",nonce=\"" + self.nonce + "\""
+    if self.stale {
+        rval += ",stale=true"
+    }
+    return rval
 }
 
 func (self *SipWWWAuthenticateBody) GetRealm() string {
@@ -139,10 +176,57 @@ func (self *SipWWWAuthenticateBody) GetNonce() string {
     return self.nonce
 }
 
+func (self *SipWWWAuthenticateBody) GetQop() string {
+    return self.qop
+}
+
+func (self *SipWWWAuthenticateBody) SetQop(qop string) {
+    self.qop = qop
+}
+
+func (self *SipWWWAuthenticateBody) GetAlgorithm() string {
+    return self.algorithm
+}
+
+func (self *SipWWWAuthenticateBody) SetAlgorithm(algorithm string) {
+    self.algorithm = algorithm
+}
+
+func (self *SipWWWAuthenticateBody) GetOpaque() string {
+    return self.opaque
+}
+
+func (self *SipWWWAuthenticateBody) SetOpaque(opaque string) {
+    self.opaque = opaque
+}
+
+// Chooses the quality of protection out of the offered ones, the
+// auth is preferred as it does not depend on the message body.
+func (self *SipWWWAuthenticateBody) pickQop() string {
+    ret := ""
+    for _, qop := range strings.Split(self.qop, ",") {
+        switch strings.TrimSpace(qop) {
+        case "auth":
+            return "auth"
+        case "auth-int":
+            ret = "auth-int"
+        }
+    }
+    return ret
+}
+
+func (self *SipWWWAuthenticateBody) GetStale() bool {
+    return self.stale
+}
+
+func (self *SipWWWAuthenticateBody) SetStale(stale bool) {
+    self.stale = stale
+}
+
 func (self *SipWWWAuthenticate) GetCopy() *SipWWWAuthenticate {
     tmp := *self
     if self.body != nil {
-        self.body = self.body.getCopy()
+        tmp.body = self.body.getCopy()
     }
     return &tmp
 }
@@ -27,6 +27,9 @@
 package sippy
 
 import (
+    "fmt"
+    "time"
+
     "sippy/log"
     "sippy/headers"
     "sippy/types"
@@ -34,27 +37,43 @@ import (
 
 type keepaliveController struct {
     ua          sippy_types.UA
+    method      string
     triedauth   bool
     ka_tr       sippy_types.ClientTransaction
+    ka_timer    *Timeout
     keepalives  int
+    failures    int
     logger      sippy_log.ErrorLogger
 }
 
 func newKeepaliveController(ua sippy_types.UA, logger sippy_log.ErrorLogger) *keepaliveController {
-    if ua.GetKaInterval() <= 0 {
+    if ua.GetKaInterval() <= 0 || ua.SessionTimerActive() {
+        // The session timer refreshes take care of the peers that
+        // support them.
         return nil
     }
     self := &keepaliveController{
         ua          : ua,
+        method      : ua.GetKaMethod(),
         triedauth   : false,
         keepalives  : 0,
+        failures    : 0,
         logger      : logger,
     }
     return self
 }
 
 func (self *keepaliveController) Start() {
-    StartTimeout(self.keepAlive, self.ua.GetSessionLock(), self.ua.GetKaInterval(), 1, self.logger)
+    self.ka_timer = StartTimeout(self.keepAlive, self.ua.GetSessionLock(), self.ua.GetKaInterval(), 1, self.logger)
+}
+
+// Only the re-INVITE keep-alive carries the SDP, the OPTIONS and UPDATE
+// ones leave the media alone.
+func (self *keepaliveController) body() sippy_types.MsgBody {
+    if self.method == "INVITE" {
+        return self.ua.GetLSDP()
+    }
+    return nil
 }
 
 func (self *keepaliveController) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
@@ -63,41 +82,28 @@ func (self *keepaliveController) RecvResponse(resp sippy_types.SipResponse, tr s
     var req sippy_types.SipRequest
     var new_auth_fn sippy_header.NewSipXXXAuthorizationFunc
 
-    if self.ua.GetState() != sippy_types.UA_STATE_CONNECTED {
+    if tr != self.ka_tr || self.ua.GetState() != sippy_types.UA_STATE_CONNECTED {
+        // Either stopped or not ours anymore
         return
     }
     code, _ := resp.GetSCode()
     if self.ua.GetUsername() != "" && self.ua.GetPassword() != "" && ! self.triedauth {
-        if code == 401 && resp.GetSipWWWAuthenticate() != nil {
-            challenge, err = resp.GetSipWWWAuthenticate().GetBody()
-            if err != nil {
-                self.logger.Error("error parsing 401 auth: " + err.Error())
-                return
-            }
-            new_auth_fn = func(realm, nonce, method, uri, username, password string) sippy_header.SipHeader {
-                return sippy_header.NewSipAuthorization(realm, nonce, method, uri, username, password)
-            }
-        } else if code == 407 && resp.GetSipProxyAuthenticate() != nil {
-            challenge, err = resp.GetSipProxyAuthenticate().GetBody()
-            if err != nil {
-                self.logger.Error("error parsing 407 auth: " + err.Error())
-                return
-            }
-            new_auth_fn = func(realm, nonce, method, uri, username, password string) sippy_header.SipHeader {
-                return sippy_header.NewSipProxyAuthorization(realm, nonce, method, uri, username, password)
-            }
+        challenge, new_auth_fn, err = challengeAuthFn(resp, code, self.body())
+        if err != nil {
+            self.logger.Error(fmt.Sprintf("error parsing %d auth: %s", code, err.Error()))
+            return
         }
         if challenge != nil {
-            req, err = self.ua.GenRequest("INVITE", self.ua.GetLSDP(), challenge.GetNonce(), challenge.GetRealm(), new_auth_fn)
+            req, err = self.ua.GenRequest(self.method, self.body(), challenge.GetNonce(), challenge.GetRealm(), new_auth_fn)
             if err != nil {
-                self.logger.Error("Cannot create INVITE: " + err.Error())
+                self.logger.Error("Cannot create " + self.method + ": " + err.Error())
                 return
             }
-            self.ka_tr, err = self.ua.PrepTr(req)
+            self.ka_tr, err = self.ua.PrepTrWithReceiver(req, self)
             if err == nil {
                 self.triedauth = true
+                self.ua.SipTM().BeginClientTransaction(req, self.ka_tr)
             }
-            self.ua.SipTM().BeginClientTransaction(req, self.ka_tr)
             return
         }
     }
@@ -106,41 +112,57 @@ func (self *keepaliveController) RecvResponse(resp sippy_types.SipResponse, tr s
     }
     self.ka_tr = nil
     self.keepalives += 1
-    if code == 408 || code == 481 || code == 486 {
-        if self.keepalives == 1 {
+    switch code {
+    case 405, 501:
+        //print "%s: Remote UAS at %s:%d does not support %s, disabling keep alives" % (self.ua.cId, self.ua.rAddr[0], self.ua.rAddr[1], self.method)
+        return
+    case 408, 481, 486:
+        if self.keepalives == 1 && self.method == "INVITE" {
             //print "%s: Remote UAS at %s:%d does not support re-INVITES, disabling keep alives" % (self.ua.cId, self.ua.rAddr[0], self.ua.rAddr[1])
-            StartTimeout(func() { self.ua.Disconnect(nil, "") }, self.ua.GetSessionLock(), 600, 1, self.logger)
+            StartTimeout(func() { self.ua.Disconnect(nil, "") }, self.ua.GetSessionLock(), 600 * time.Second, 1, self.logger)
             return
         }
-        //print "%s: Received %d response to keep alive from %s:%d, disconnecting the call" % (self.ua.cId, code, self.ua.rAddr[0], self.ua.rAddr[1])
-        self.ua.Disconnect(nil, "")
-        return
+        self.failures += 1
+        if self.failures >= self.ua.GetKaMaxFailures() {
+            //print "%s: Received %d response to keep alive from %s:%d, disconnecting the call" % (self.ua.cId, code, self.ua.rAddr[0], self.ua.rAddr[1])
+            self.ua.Disconnect(nil, "")
+            return
+        }
+    default:
+        self.failures = 0
     }
-    StartTimeout(self.keepAlive, self.ua.GetSessionLock(), self.ua.GetKaInterval(), 1, self.logger)
+    self.ka_timer = StartTimeout(self.keepAlive, self.ua.GetSessionLock(), self.ua.GetKaInterval(), 1, self.logger)
 }
 
 func (self *keepaliveController) keepAlive() {
     var err error
     var req sippy_types.SipRequest
 
+    self.ka_timer = nil
     if self.ua.GetState() != sippy_types.UA_STATE_CONNECTED {
         return
     }
-    req, err = self.ua.GenRequest("INVITE", self.ua.GetLSDP(), "", "", nil)
+    req, err = self.ua.GenRequest(self.method, self.body(), "", "", nil)
     if err != nil {
-        self.logger.Error("Cannot create INVITE: " + err.Error())
+        self.logger.Error("Cannot create " + self.method + ": " + err.Error())
         return
     }
     self.triedauth = false
-    self.ka_tr, err = self.ua.PrepTr(req)
+    self.ka_tr, err = self.ua.PrepTrWithReceiver(req, self)
     if err == nil {
         self.ua.SipTM().BeginClientTransaction(req, self.ka_tr)
     }
 }
 
 func (self *keepaliveController) Stop() {
+    if ka_timer := self.ka_timer; ka_timer != nil {
+        ka_timer.Cancel()
+        self.ka_timer = nil
+    }
     if ka_tr := self.ka_tr; ka_tr != nil {
-        ka_tr.Cancel()
+        if self.method == "INVITE" {
+            ka_tr.Cancel()
+        }
         self.ka_tr = nil
     }
 }
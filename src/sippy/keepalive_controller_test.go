@@ -0,0 +1,117 @@
+package sippy
+
+import (
+    "sync"
+    "testing"
+    "time"
+
+    "sippy/conf"
+    "sippy/headers"
+    "sippy/log"
+    "sippy/net"
+    "sippy/time"
+    "sippy/types"
+)
+
+type test_ka_ua struct {
+    sippy_types.UA
+    t           *testing.T
+    config      sippy_conf.Config
+    lock        sync.Mutex
+    method      string
+    reqs        []sippy_types.SipRequest
+    disconnected bool
+}
+
+func (self *test_ka_ua) GetState() sippy_types.UaStateID { return sippy_types.UA_STATE_CONNECTED }
+func (self *test_ka_ua) GetKaInterval() time.Duration { return time.Hour }
+func (self *test_ka_ua) GetKaMethod() string { return self.method }
+func (self *test_ka_ua) GetKaMaxFailures() int { return 2 }
+func (self *test_ka_ua) SessionTimerActive() bool { return false }
+func (self *test_ka_ua) GetSessionLock() sync.Locker { return &self.lock }
+func (self *test_ka_ua) GetUsername() string { return "" }
+func (self *test_ka_ua) GetPassword() string { return "" }
+func (self *test_ka_ua) GetLSDP() sippy_types.MsgBody { return nil }
+func (self *test_ka_ua) Disconnect(*sippy_time.MonoTime, string) { self.disconnected = true }
+
+func (self *test_ka_ua) GenRequest(method string, body sippy_types.MsgBody, nonce string, realm string, SipXXXAuthorization sippy_header.NewSipXXXAuthorizationFunc, extra_headers ...sippy_header.SipHeader) (sippy_types.SipRequest, error) {
+    req := testStRequest(self.t, self.config, method)
+    self.reqs = append(self.reqs, req)
+    return req, nil
+}
+
+func (self *test_ka_ua) PrepTrWithReceiver(sippy_types.SipRequest, sippy_types.ResponseReceiver) (sippy_types.ClientTransaction, error) {
+    return nil, nil
+}
+
+func (self *test_ka_ua) SipTM() sippy_types.SipTransactionManager {
+    return &test_reg_tm{}
+}
+
+func Test_KeepaliveOptions(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    ua := &test_ka_ua{ t : t, config : config, method : "OPTIONS" }
+    ka := newKeepaliveController(ua, config.ErrorLogger())
+    defer ka.Stop()
+
+    ka.keepAlive()
+    if len(ua.reqs) != 1 || ua.reqs[0].GetMethod() != "OPTIONS" {
+        t.Fatal("No OPTIONS keep-alive has been sent")
+    }
+    req := ua.reqs[0]
+    for _, code := range []int{ 408, 200, 481 } {
+        ka.RecvResponse(req.GenResponse(code, "", nil, nil), nil)
+        if ua.disconnected || ka.ka_timer == nil {
+            t.Fatalf("The failure threshold is not honoured on %d", code)
+        }
+    }
+    ka.RecvResponse(req.GenResponse(408, "Request Timeout", nil, nil), nil)
+    if ! ua.disconnected {
+        t.Fatal("The call has not been disconnected")
+    }
+}
+
+func Test_KeepaliveUnsupported(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    ua := &test_ka_ua{ t : t, config : config, method : "UPDATE" }
+    ka := newKeepaliveController(ua, config.ErrorLogger())
+    defer ka.Stop()
+
+    ka.keepAlive()
+    if len(ua.reqs) != 1 || ua.reqs[0].GetMethod() != "UPDATE" || ua.reqs[0].GetBody() != nil {
+        t.Fatal("No UPDATE keep-alive has been sent")
+    }
+    ka.RecvResponse(ua.reqs[0].GenResponse(501, "Not Implemented", nil, nil), nil)
+    if ua.disconnected || ka.ka_timer != nil {
+        t.Fatal("The keep-alives should have been disabled")
+    }
+}
+
+type test_ua_tr struct {
+    sippy_types.ClientTransaction
+}
+
+func (self *test_ua_tr) SetOutboundProxy(*sippy_net.HostPort) {}
+
+type test_ua_tm struct {
+    sippy_types.SipTransactionManager
+}
+
+func (self *test_ua_tm) CreateClientTransaction(req sippy_types.SipRequest, resp_receiver sippy_types.ResponseReceiver, session_lock sync.Locker, laddress *sippy_net.HostPort, userv sippy_net.Transport, req_out_cb func(sippy_types.SipRequest)) (sippy_types.ClientTransaction, error) {
+    return &test_ua_tr{}, nil
+}
+
+func Test_UaRequestReceiver(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    ua := NewUA(&test_ua_tm{}, config, nil, nil, new(sync.Mutex), nil)
+    req := testStRequest(t, config, "OPTIONS")
+    cseq, _ := req.GetCSeq().GetBody()
+    ua.reqs[cseq.CSeq] = req.(*sipRequest)
+    if _, err := ua.PrepTr(req); err != nil || ua.reqs[cseq.CSeq] == nil {
+        t.Fatal("the request for the UA has not been kept")
+    }
+    // The keep-alive responses never reach the UA
+    if _, err := ua.PrepTrWithReceiver(req, &keepaliveController{}); err != nil || len(ua.reqs) != 0 {
+        t.Fatal("the request for the other receiver has been kept")
+    }
+}
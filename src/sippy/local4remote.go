@@ -27,6 +27,7 @@
 package sippy
 
 import (
+    "errors"
     "net"
 
     "sippy/conf"
@@ -38,6 +39,7 @@ type local4remote struct {
     cache_r2l       map[string]*sippy_net.HostPort
     cache_r2l_old   map[string]*sippy_net.HostPort
     cache_l2s       map[string]sippy_net.Transport
+    streams         map[string][]sippy_net.StreamTransport
     handleIncoming  sippy_net.DataPacketReceiver
     fixed           bool
     tfactory        sippy_net.SipTransportFactory
@@ -49,6 +51,7 @@ func NewLocal4Remote(config sippy_conf.Config, handleIncoming sippy_net.DataPack
         cache_r2l       : make(map[string]*sippy_net.HostPort),
         cache_r2l_old   : make(map[string]*sippy_net.HostPort),
         cache_l2s       : make(map[string]sippy_net.Transport),
+        streams         : make(map[string][]sippy_net.StreamTransport),
         handleIncoming  : handleIncoming,
         fixed           : false,
         tfactory        : config.GetSipTransportFactory(),
@@ -86,9 +89,94 @@ func NewLocal4Remote(config sippy_conf.Config, handleIncoming sippy_net.DataPack
     if len(self.cache_l2s) == 0 && last_error != nil {
         return nil, last_error
     }
+    if config.GetTcpEnabled() {
+        if err := self.startStreams("tcp", laddresses); err != nil {
+            self.shutdown()
+            return nil, err
+        }
+    }
+    streams := []struct {
+        proto   string
+        enabled bool
+        port    *sippy_net.MyPort
+    } {
+        { "tls", config.GetTlsEnabled(), config.GetTlsPort() },
+        { "ws", config.GetWsEnabled(), config.GetWsPort() },
+        { "wss", config.GetWssEnabled(), config.GetWssPort() },
+    }
+    for _, it := range streams {
+        if ! it.enabled {
+            continue
+        }
+        if err := self.startStreams(it.proto, withPort(laddresses, it.port)); err != nil {
+            self.shutdown()
+            return nil, err
+        }
+    }
     return self, nil
 }
 
+func withPort(laddresses []*sippy_net.HostPort, port *sippy_net.MyPort) []*sippy_net.HostPort {
+    ret := make([]*sippy_net.HostPort, len(laddresses))
+    for i, laddress := range laddresses {
+        ret[i] = sippy_net.NewHostPort(laddress.Host.String(), port.String())
+    }
+    return ret
+}
+
+func (self *local4remote) startStreams(proto string, laddresses []*sippy_net.HostPort) error {
+    sfactory, ok := self.tfactory.(sippy_net.SipStreamTransportFactory)
+    if ! ok {
+        return errors.New("The SIP transport factory does not support " + proto)
+    }
+    var last_error error
+    for _, laddress := range laddresses {
+        server, err := sfactory.NewSipStreamTransport(proto, laddress, self.handleIncoming)
+        if err != nil {
+            if ! self.config.SipAddress().IsSystemDefault() {
+                return err
+            }
+            last_error = err
+        } else {
+            self.streams[proto] = append(self.streams[proto], server)
+        }
+    }
+    if len(self.streams[proto]) == 0 {
+        return last_error
+    }
+    return nil
+}
+
+// Get the list of transports the requests can be sent over. The
+// WebSocket ones are not there since we cannot initiate them.
+func (self *local4remote) getOutboundProtos() []string {
+    protos := []string{ "udp" }
+    for _, proto := range []string{ "tcp", "tls" } {
+        if len(self.streams[proto]) > 0 {
+            protos = append(protos, proto)
+        }
+    }
+    return protos
+}
+
+// Get the connection oriented transport to reach the address with.
+func (self *local4remote) getStreamConnection(proto string, address *sippy_net.HostPort) (sippy_net.Transport, error) {
+    servers, ok := self.streams[proto]
+    if ! ok || len(servers) == 0 {
+        return nil, errors.New("SIP transport is not enabled: " + proto)
+    }
+    server := servers[0]
+    if ip := address.ParseIP(); ip != nil {
+        for _, s := range servers {
+            if lip := s.GetLAddress().ParseIP(); lip != nil && sippy_net.IsIP4(lip) == sippy_net.IsIP4(ip) {
+                server = s
+                break
+            }
+        }
+    }
+    return server.GetConnection(address)
+}
+
 func (self *local4remote) getServer(address *sippy_net.HostPort, is_local bool /*= false*/) sippy_net.Transport {
     var laddress *sippy_net.HostPort
     var ok bool
@@ -172,5 +260,11 @@ func (self *local4remote) shutdown() {
         userv.Shutdown()
     }
     self.cache_l2s = make(map[string]sippy_net.Transport)
+    for _, servers := range self.streams {
+        for _, server := range servers {
+            server.Shutdown()
+        }
+    }
+    self.streams = make(map[string][]sippy_net.StreamTransport)
 }
 
@@ -0,0 +1,204 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Binding is the registered contact of an AOR.
+type Binding struct {
+    Contact     string      `json:"contact"`
+    Expires     time.Time   `json:"expires"`
+    Q           float64     `json:"q"`
+    Received    string      `json:"received,omitempty"`
+    Path        []string    `json:"path,omitempty"`
+    InstanceId  string      `json:"instance_id,omitempty"`
+    CallId      string      `json:"call_id"`
+    CSeq        int         `json:"cseq"`
+}
+
+func (self *Binding) GetCopy() *Binding {
+    cself := *self
+    cself.Path = append([]string(nil), self.Path...)
+    return &cself
+}
+
+// LocationStore keeps the bindings of the registrar.
+type LocationStore interface {
+    // Returns the copies of the bindings that have not expired yet.
+    Lookup(aor string) []*Binding
+    // Replaces the bindings of the AOR, none removes the AOR.
+    Update(aor string, bindings []*Binding) error
+    // Atomically replaces the bindings of the AOR with the ones returned
+    // by the fn, unless it returns false. The fn is given the copies of
+    // the current bindings and must not call back into the store.
+    Modify(aor string, fn func([]*Binding) ([]*Binding, bool)) error
+    AORs() []string
+}
+
+type memoryLocationStore struct {
+    lock        sync.Mutex
+    bindings    map[string][]*Binding
+}
+
+func NewMemoryLocationStore() *memoryLocationStore {
+    return &memoryLocationStore{
+        bindings    : make(map[string][]*Binding),
+    }
+}
+
+func (self *memoryLocationStore) Lookup(aor string) []*Binding {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    return self.lookup(aor)
+}
+
+func (self *memoryLocationStore) lookup(aor string) []*Binding {
+    now := time.Now()
+    ret := []*Binding{}
+    for _, b := range self.bindings[aor] {
+        if b.Expires.After(now) {
+            ret = append(ret, b.GetCopy())
+        }
+    }
+    return ret
+}
+
+func (self *memoryLocationStore) Update(aor string, bindings []*Binding) error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.update(aor, bindings)
+    return nil
+}
+
+func (self *memoryLocationStore) Modify(aor string, fn func([]*Binding) ([]*Binding, bool)) error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if bindings, ok := fn(self.lookup(aor)); ok {
+        self.update(aor, bindings)
+    }
+    return nil
+}
+
+func (self *memoryLocationStore) update(aor string, bindings []*Binding) {
+    now := time.Now()
+    alive := []*Binding{}
+    for _, b := range bindings {
+        if b.Expires.After(now) {
+            alive = append(alive, b.GetCopy())
+        }
+    }
+    if len(alive) == 0 {
+        delete(self.bindings, aor)
+        return
+    }
+    self.bindings[aor] = alive
+}
+
+func (self *memoryLocationStore) AORs() []string {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    now := time.Now()
+    ret := []string{}
+    for aor, bindings := range self.bindings {
+        for _, b := range bindings {
+            if b.Expires.After(now) {
+                ret = append(ret, aor)
+                break
+            }
+        }
+    }
+    sort.Strings(ret)
+    return ret
+}
+
+// The in-memory store that writes the snapshot of all the bindings into
+// the file on each change so that the registrations survive a restart.
+type fileLocationStore struct {
+    *memoryLocationStore
+    fname       string
+}
+
+func NewFileLocationStore(fname string) (*fileLocationStore, error) {
+    self := &fileLocationStore{
+        memoryLocationStore : NewMemoryLocationStore(),
+        fname               : fname,
+    }
+    buf, err := ioutil.ReadFile(fname)
+    if os.IsNotExist(err) {
+        return self, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    snapshot := make(map[string][]*Binding)
+    if err = json.Unmarshal(buf, &snapshot); err != nil {
+        return nil, err
+    }
+    for aor, bindings := range snapshot {
+        self.update(aor, bindings)
+    }
+    return self, nil
+}
+
+func (self *fileLocationStore) Update(aor string, bindings []*Binding) error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.update(aor, bindings)
+    return self.save()
+}
+
+func (self *fileLocationStore) Modify(aor string, fn func([]*Binding) ([]*Binding, bool)) error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    bindings, ok := fn(self.lookup(aor))
+    if ! ok {
+        return nil
+    }
+    self.update(aor, bindings)
+    return self.save()
+}
+
+func (self *fileLocationStore) save() error {
+    buf, err := json.Marshal(self.bindings)
+    if err != nil {
+        return err
+    }
+    // Write the new snapshot aside and then replace the old one
+    tmp := self.fname + ".tmp"
+    if err = ioutil.WriteFile(tmp, buf, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, self.fname)
+}
@@ -0,0 +1,66 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_net
+
+import (
+    "net"
+    "time"
+)
+
+type DnsNAPTR struct {
+    Order       uint16
+    Preference  uint16
+    Flags       string
+    Service     string
+    Regexp      string
+    Replacement string
+    TTL         time.Duration
+}
+
+type DnsSRV struct {
+    Priority    uint16
+    Weight      uint16
+    Port        uint16
+    Target      string
+    TTL         time.Duration
+}
+
+type DnsIP struct {
+    IP          net.IP
+    TTL         time.Duration
+}
+
+// DnsResolver is the source of the DNS records the RFC 3263 server
+// location is done with. The record TTLs are preserved so that the
+// results can be cached. A name that does not exist is not an error,
+// an empty list is returned.
+type DnsResolver interface {
+    LookupNAPTR(name string) ([]*DnsNAPTR, error)
+    LookupSRV(name string) ([]*DnsSRV, error)
+    LookupIP(name string) ([]*DnsIP, error)
+}
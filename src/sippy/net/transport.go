@@ -42,4 +42,18 @@ type Transport interface {
     GetLAddress() *HostPort
     SendTo([]byte, *HostPort)
     SendToWithCb([]byte, *HostPort, func())
+    GetProto() string
+    IsReliable() bool
+}
+
+// Connection oriented transports (TCP and friends) keep a connection per
+// remote peer. GetConnection returns the transport bound to the existing
+// connection to the peer or initiates a new one.
+type StreamTransport interface {
+    Transport
+    GetConnection(*HostPort) (Transport, error)
+}
+
+type SipStreamTransportFactory interface {
+    NewSipStreamTransport(string, *HostPort, DataPacketReceiver) (StreamTransport, error)
 }
@@ -0,0 +1,179 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_radius
+
+import (
+    "errors"
+    "net"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    RESULT_ACCEPT   = 0
+    RESULT_REJECT   = 1
+    RESULT_ERROR    = -1
+
+    DEFAULT_TIMEOUT = 2 * time.Second
+    DEFAULT_RETRIES = 3
+)
+
+type Server struct {
+    address     string
+    secret      string
+}
+
+// NewServer takes the address in the "host[:port]" format, the
+// default_port is used when the port is omitted.
+func NewServer(address, default_port, secret string) *Server {
+    if _, _, err := net.SplitHostPort(address); err != nil {
+        address = net.JoinHostPort(strings.Trim(address, "[]"), default_port)
+    }
+    return &Server{
+        address     : address,
+        secret      : secret,
+    }
+}
+
+func (self *Server) String() string {
+    return self.address
+}
+
+// Client sends the requests to the first server that is alive. Every
+// request is retransmitted for the configured number of times before the
+// client fails over to the next server in the list. The server that has
+// answered last is tried first with the subsequent requests.
+type Client struct {
+    lock        sync.Mutex
+    servers     []*Server
+    current     int
+    next_id     byte
+    timeout     time.Duration
+    retries     int
+}
+
+func NewClient(servers []*Server, timeout time.Duration, retries int) *Client {
+    if timeout <= 0 {
+        timeout = DEFAULT_TIMEOUT
+    }
+    if retries <= 0 {
+        retries = DEFAULT_RETRIES
+    }
+    return &Client{
+        servers     : servers,
+        timeout     : timeout,
+        retries     : retries,
+    }
+}
+
+// SendRequest does the request in the background and passes the reply
+// attributes and the result code to the result_callback.
+func (self *Client) SendRequest(code byte, attrs []*Attribute, result_callback func([]*Attribute, int)) {
+    go func() {
+        reply, rcode, _ := self.Exchange(code, attrs)
+        if result_callback != nil {
+            result_callback(reply, rcode)
+        }
+    }()
+}
+
+func (self *Client) Exchange(code byte, attrs []*Attribute) ([]*Attribute, int, error) {
+    self.lock.Lock()
+    first := self.current
+    self.lock.Unlock()
+    if len(self.servers) == 0 {
+        return nil, RESULT_ERROR, errors.New("No RADIUS servers configured")
+    }
+    err := errors.New("No reply from the RADIUS servers")
+    for i := 0; i < len(self.servers); i++ {
+        idx := (first + i) % len(self.servers)
+        var reply *packet
+        reply, err = self.exchange(self.servers[idx], code, attrs)
+        if err != nil {
+            continue
+        }
+        self.lock.Lock()
+        self.current = idx
+        self.lock.Unlock()
+        rattrs, err := decodeAttributes(reply.attributes, self.servers[idx].secret, nil)
+        if err != nil {
+            return nil, RESULT_ERROR, err
+        }
+        switch reply.code {
+        case ACCESS_ACCEPT, ACCOUNTING_RESPONSE:
+            return rattrs, RESULT_ACCEPT, nil
+        }
+        return rattrs, RESULT_REJECT, nil
+    }
+    return nil, RESULT_ERROR, err
+}
+
+func (self *Client) newId() byte {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.next_id++
+    return self.next_id
+}
+
+func (self *Client) exchange(server *Server, code byte, attrs []*Attribute) (*packet, error) {
+    req, err := newRequest(code, self.newId(), attrs, server.secret)
+    if err != nil {
+        return nil, err
+    }
+    conn, err := net.Dial("udp", server.address)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    data := req.bytes()
+    buf := make([]byte, MAX_PACKET_LEN)
+    for attempt := 0; attempt < self.retries; attempt++ {
+        if _, err = conn.Write(data); err != nil {
+            return nil, err
+        }
+        conn.SetReadDeadline(time.Now().Add(self.timeout))
+        for {
+            var n int
+            n, err = conn.Read(buf)
+            if err != nil {
+                break
+            }
+            reply, perr := parsePacket(buf[:n])
+            if perr != nil || ! reply.isReplyTo(req, server.secret) {
+                // Stray or forged reply, keep waiting for the right one
+                continue
+            }
+            return reply, nil
+        }
+        if nerr, ok := err.(net.Error); ! ok || ! nerr.Timeout() {
+            return nil, err
+        }
+    }
+    return nil, errors.New("No reply from the RADIUS server " + server.address)
+}
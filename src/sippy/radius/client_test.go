@@ -0,0 +1,174 @@
+package sippy_radius
+
+import (
+    "net"
+    "testing"
+    "time"
+)
+
+type test_radius_server struct {
+    conn        net.PacketConn
+    secret      string
+    drop        int
+    requests    chan []*Attribute
+    reply_code  byte
+    reply_attrs []*Attribute
+}
+
+func newTestRadiusServer(t *testing.T, secret string, reply_code byte, drop int, reply_attrs ...*Attribute) *test_radius_server {
+    conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    self := &test_radius_server{
+        conn        : conn,
+        secret      : secret,
+        requests    : make(chan []*Attribute, 10),
+        reply_code  : reply_code,
+        drop        : drop,
+        reply_attrs : reply_attrs,
+    }
+    go self.run()
+    return self
+}
+
+func (self *test_radius_server) run() {
+    buf := make([]byte, MAX_PACKET_LEN)
+    for {
+        n, addr, err := self.conn.ReadFrom(buf)
+        if err != nil {
+            return
+        }
+        req, err := parsePacket(buf[:n])
+        if err != nil {
+            continue
+        }
+        if req.code == ACCOUNTING_REQUEST {
+            expected := &packet{ code : req.code, id : req.id, attributes : req.attributes }
+            if string(expected.digest(make([]byte, 16), self.secret)) != string(req.authenticator) {
+                continue
+            }
+        }
+        attrs, err := decodeAttributes(req.attributes, self.secret, req.authenticator)
+        if err != nil {
+            continue
+        }
+        if self.drop > 0 {
+            self.drop--
+            continue
+        }
+        self.requests <- attrs
+        reply := &packet{ code : self.reply_code, id : req.id }
+        reply.attributes, _ = encodeAttributes(self.reply_attrs, self.secret, nil)
+        reply.authenticator = reply.digest(req.authenticator, self.secret)
+        self.conn.WriteTo(reply.bytes(), addr)
+    }
+}
+
+func (self *test_radius_server) address() string {
+    return self.conn.LocalAddr().String()
+}
+
+func (self *test_radius_server) close() {
+    self.conn.Close()
+}
+
+func findAttribute(attrs []*Attribute, name string) string {
+    for _, attr := range attrs {
+        if attr.Name == name {
+            return attr.Value
+        }
+    }
+    return "<none>"
+}
+
+func Test_RadiusAuth(t *testing.T) {
+    srv := newTestRadiusServer(t, "testing123", ACCESS_ACCEPT, 0,
+        NewAttribute("Cisco-AVPair", "h323-ivr-in=Routing:1.2.3.4"),
+        NewAttribute("h323-credit-time", "h323-credit-time=60"),
+        NewAttribute("h323-return-code", "h323-return-code=0"))
+    defer srv.close()
+    client := NewClient([]*Server{ NewServer(srv.address(), "1812", "testing123") }, time.Second, 3)
+    done := make(chan bool)
+    var reply []*Attribute
+    var rcode int
+    client.SendRequest(ACCESS_REQUEST, []*Attribute{
+        NewAttribute("User-Name", "alice"),
+        NewAttribute("Password", "cisco"),
+        NewAttribute("Digest-Realm", "example.com"),
+        NewAttribute("h323-conf-id", "h323-conf-id=123 456"),
+        NewAttribute("Cisco-AVPair", "call-id=abc@host"),
+    }, func(attrs []*Attribute, res int) { reply, rcode = attrs, res; done <- true })
+    <-done
+    if rcode != RESULT_ACCEPT {
+        t.Fatalf("Unexpected result: %d", rcode)
+    }
+    req := <-srv.requests
+    for name, expected := range map[string]string{
+        "User-Name"     : "alice",
+        "User-Password" : "cisco",
+        "Digest-Realm"  : "example.com",
+        "h323-conf-id"  : "h323-conf-id=123 456",
+        "Cisco-AVPair"  : "call-id=abc@host",
+    } {
+        if v := findAttribute(req, name); v != expected {
+            t.Fatalf("%s: expected '%s', got '%s'", name, expected, v)
+        }
+    }
+    if v := findAttribute(reply, "Cisco-AVPair"); v != "h323-ivr-in=Routing:1.2.3.4" {
+        t.Fatal("Bad Cisco-AVPair in the reply: " + v)
+    }
+    if v := findAttribute(reply, "h323-credit-time"); v != "h323-credit-time=60" {
+        t.Fatal("Bad h323-credit-time in the reply: " + v)
+    }
+    rsrv := newTestRadiusServer(t, "testing123", ACCESS_REJECT, 0)
+    defer rsrv.close()
+    client = NewClient([]*Server{ NewServer(rsrv.address(), "1812", "testing123") }, time.Second, 3)
+    if _, rcode, _ := client.Exchange(ACCESS_REQUEST, []*Attribute{ NewAttribute("User-Name", "bob") }); rcode != RESULT_REJECT {
+        t.Fatalf("Unexpected result: %d", rcode)
+    }
+}
+
+func Test_RadiusFailover(t *testing.T) {
+    // The first server is silent and the second one has a wrong secret
+    silent := newTestRadiusServer(t, "testing123", ACCOUNTING_RESPONSE, 1000)
+    defer silent.close()
+    wrong := newTestRadiusServer(t, "wrong", ACCOUNTING_RESPONSE, 0)
+    defer wrong.close()
+    // The first copy of the request is lost
+    srv := newTestRadiusServer(t, "testing123", ACCOUNTING_RESPONSE, 1)
+    defer srv.close()
+    client := NewClient([]*Server{
+        NewServer(silent.address(), "1813", "testing123"),
+        NewServer(wrong.address(), "1813", "testing123"),
+        NewServer(srv.address(), "1813", "testing123"),
+    }, 100 * time.Millisecond, 2)
+    attrs := []*Attribute{
+        NewAttribute("Acct-Status-Type", "Stop"),
+        NewAttribute("Acct-Session-Time", "42"),
+        NewAttribute("h323-disconnect-cause", "h323-disconnect-cause=10"),
+    }
+    _, rcode, err := client.Exchange(ACCOUNTING_REQUEST, attrs)
+    if rcode != RESULT_ACCEPT {
+        t.Fatalf("Unexpected result: %d (%v)", rcode, err)
+    }
+    req := <-srv.requests
+    if v := findAttribute(req, "Acct-Status-Type"); v != "Stop" {
+        t.Fatal("Bad Acct-Status-Type: " + v)
+    }
+    if v := findAttribute(req, "Acct-Session-Time"); v != "42" {
+        t.Fatal("Bad Acct-Session-Time: " + v)
+    }
+    // The server that has answered is tried first now
+    start := time.Now()
+    if _, rcode, _ := client.Exchange(ACCOUNTING_REQUEST, attrs); rcode != RESULT_ACCEPT {
+        t.Fatalf("Unexpected result: %d", rcode)
+    }
+    if time.Since(start) > 100 * time.Millisecond {
+        t.Fatal("The last good server has not been tried first")
+    }
+    srv.close()
+    if _, rcode, _ := client.Exchange(ACCOUNTING_REQUEST, attrs); rcode != RESULT_ERROR {
+        t.Fatalf("Unexpected result: %d", rcode)
+    }
+}
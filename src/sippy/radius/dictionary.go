@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_radius
+
+const (
+    VENDOR_NONE     = 0
+    VENDOR_CISCO    = 9
+
+    ATTR_DIGEST_ATTRIBUTES = 207
+)
+
+const (
+    TYPE_STRING = iota
+    TYPE_INTEGER
+    TYPE_IPADDR
+    TYPE_PASSWORD
+    TYPE_DIGEST
+)
+
+type attrDef struct {
+    name        string
+    code        byte
+    vendor      uint32
+    vtype       int
+    values      map[string]uint32
+}
+
+// The subset of the RFC 2865/2866 dictionary, the draft-sterman digest
+// attributes and the Cisco VSAs the B2BUA is using.
+var dictionary = []*attrDef{
+    { name : "User-Name", code : 1 },
+    { name : "User-Password", code : 2, vtype : TYPE_PASSWORD },
+    { name : "NAS-IP-Address", code : 4, vtype : TYPE_IPADDR },
+    { name : "NAS-Port", code : 5, vtype : TYPE_INTEGER },
+    { name : "Service-Type", code : 6, vtype : TYPE_INTEGER },
+    { name : "Reply-Message", code : 18 },
+    { name : "Class", code : 25 },
+    { name : "Session-Timeout", code : 27, vtype : TYPE_INTEGER },
+    { name : "Called-Station-Id", code : 30 },
+    { name : "Calling-Station-Id", code : 31 },
+    { name : "NAS-Identifier", code : 32 },
+    { name : "Acct-Status-Type", code : 40, vtype : TYPE_INTEGER, values : map[string]uint32{
+        "Start" : 1, "Stop" : 2, "Alive" : 3, "Interim-Update" : 3, "Accounting-On" : 7, "Accounting-Off" : 8,
+    } },
+    { name : "Acct-Delay-Time", code : 41, vtype : TYPE_INTEGER },
+    { name : "Acct-Input-Octets", code : 42, vtype : TYPE_INTEGER },
+    { name : "Acct-Output-Octets", code : 43, vtype : TYPE_INTEGER },
+    { name : "Acct-Session-Id", code : 44 },
+    { name : "Acct-Authentic", code : 45, vtype : TYPE_INTEGER },
+    { name : "Acct-Session-Time", code : 46, vtype : TYPE_INTEGER },
+    { name : "Acct-Terminate-Cause", code : 49, vtype : TYPE_INTEGER, values : map[string]uint32{
+        "User-Request" : 1, "Lost-Carrier" : 2, "Idle-Timeout" : 4, "Session-Timeout" : 5,
+        "Admin-Reset" : 6, "NAS-Error" : 9, "NAS-Request" : 10,
+    } },
+    { name : "Digest-Response", code : 206 },
+    { name : "Digest-Realm", code : 1, vtype : TYPE_DIGEST },
+    { name : "Digest-Nonce", code : 2, vtype : TYPE_DIGEST },
+    { name : "Digest-Method", code : 3, vtype : TYPE_DIGEST },
+    { name : "Digest-URI", code : 4, vtype : TYPE_DIGEST },
+    { name : "Digest-QOP", code : 5, vtype : TYPE_DIGEST },
+    { name : "Digest-Algorithm", code : 6, vtype : TYPE_DIGEST },
+    { name : "Digest-Body-Digest", code : 7, vtype : TYPE_DIGEST },
+    { name : "Digest-CNonce", code : 8, vtype : TYPE_DIGEST },
+    { name : "Digest-Nonce-Count", code : 9, vtype : TYPE_DIGEST },
+    { name : "Digest-User-Name", code : 10, vtype : TYPE_DIGEST },
+
+    { name : "Cisco-AVPair", code : 1, vendor : VENDOR_CISCO },
+    { name : "h323-remote-address", code : 23, vendor : VENDOR_CISCO },
+    { name : "h323-conf-id", code : 24, vendor : VENDOR_CISCO },
+    { name : "h323-setup-time", code : 25, vendor : VENDOR_CISCO },
+    { name : "h323-call-origin", code : 26, vendor : VENDOR_CISCO },
+    { name : "h323-call-type", code : 27, vendor : VENDOR_CISCO },
+    { name : "h323-connect-time", code : 28, vendor : VENDOR_CISCO },
+    { name : "h323-disconnect-time", code : 29, vendor : VENDOR_CISCO },
+    { name : "h323-disconnect-cause", code : 30, vendor : VENDOR_CISCO },
+    { name : "h323-voice-quality", code : 31, vendor : VENDOR_CISCO },
+    { name : "h323-gw-id", code : 33, vendor : VENDOR_CISCO },
+    { name : "h323-incoming-conf-id", code : 35, vendor : VENDOR_CISCO },
+    { name : "h323-credit-amount", code : 101, vendor : VENDOR_CISCO },
+    { name : "h323-credit-time", code : 102, vendor : VENDOR_CISCO },
+    { name : "h323-return-code", code : 103, vendor : VENDOR_CISCO },
+    { name : "h323-prompt-id", code : 104, vendor : VENDOR_CISCO },
+    { name : "h323-time-and-day", code : 105, vendor : VENDOR_CISCO },
+    { name : "h323-redirect-number", code : 106, vendor : VENDOR_CISCO },
+    { name : "h323-preferred-lang", code : 107, vendor : VENDOR_CISCO },
+    { name : "h323-redirect-ip-address", code : 108, vendor : VENDOR_CISCO },
+    { name : "h323-billing-model", code : 109, vendor : VENDOR_CISCO },
+    { name : "h323-currency", code : 110, vendor : VENDOR_CISCO },
+}
+
+var attrs_by_name map[string]*attrDef
+var attrs_by_code map[uint64]*attrDef
+
+func attrKey(vendor uint32, vtype int, code byte) uint64 {
+    if vtype != TYPE_DIGEST {
+        vtype = 0
+    }
+    return uint64(vendor) << 16 | uint64(vtype) << 8 | uint64(code)
+}
+
+func init() {
+    attrs_by_name = make(map[string]*attrDef)
+    attrs_by_code = make(map[uint64]*attrDef)
+    for _, def := range dictionary {
+        attrs_by_name[def.name] = def
+        attrs_by_code[attrKey(def.vendor, def.vtype, def.code)] = def
+    }
+    // The radiusclient dictionary alias
+    attrs_by_name["Password"] = attrs_by_name["User-Password"]
+}
@@ -0,0 +1,282 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_radius
+
+import (
+    "bytes"
+    "crypto/md5"
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "net"
+    "strconv"
+)
+
+const (
+    ACCESS_REQUEST      = 1
+    ACCESS_ACCEPT       = 2
+    ACCESS_REJECT       = 3
+    ACCOUNTING_REQUEST  = 4
+    ACCOUNTING_RESPONSE = 5
+    ACCESS_CHALLENGE    = 11
+
+    MAX_PACKET_LEN      = 4096
+)
+
+type Attribute struct {
+    Name    string
+    Value   string
+}
+
+func NewAttribute(name, value string) *Attribute {
+    return &Attribute{
+        Name    : name,
+        Value   : value,
+    }
+}
+
+type packet struct {
+    code            byte
+    id              byte
+    authenticator   []byte
+    attributes      []byte
+}
+
+// newRequest builds the request ready to be sent. The Access-Request
+// carries a random authenticator that the password is hidden with, the
+// Accounting-Request authenticator is the digest of the whole packet.
+func newRequest(code, id byte, attrs []*Attribute, secret string) (*packet, error) {
+    self := &packet{
+        code            : code,
+        id              : id,
+        authenticator   : make([]byte, 16),
+    }
+    if code == ACCESS_REQUEST {
+        rand.Read(self.authenticator)
+    }
+    data, err := encodeAttributes(attrs, secret, self.authenticator)
+    if err != nil {
+        return nil, err
+    }
+    self.attributes = data
+    if code != ACCESS_REQUEST {
+        self.authenticator = self.digest(make([]byte, 16), secret)
+    }
+    return self, nil
+}
+
+func parsePacket(data []byte) (*packet, error) {
+    if len(data) < 20 {
+        return nil, errors.New("RADIUS packet is too short")
+    }
+    length := int(binary.BigEndian.Uint16(data[2:4]))
+    if length < 20 || length > len(data) {
+        return nil, errors.New("Bad RADIUS packet length")
+    }
+    return &packet{
+        code            : data[0],
+        id              : data[1],
+        authenticator   : data[4:20],
+        attributes      : data[20:length],
+    }, nil
+}
+
+func (self *packet) bytes() []byte {
+    buf := make([]byte, 20, 20 + len(self.attributes))
+    buf[0] = self.code
+    buf[1] = self.id
+    binary.BigEndian.PutUint16(buf[2:4], uint16(20 + len(self.attributes)))
+    copy(buf[4:20], self.authenticator)
+    return append(buf, self.attributes...)
+}
+
+func (self *packet) digest(authenticator []byte, secret string) []byte {
+    h := md5.New()
+    hdr := []byte{ self.code, self.id, 0, 0 }
+    binary.BigEndian.PutUint16(hdr[2:4], uint16(20 + len(self.attributes)))
+    h.Write(hdr)
+    h.Write(authenticator)
+    h.Write(self.attributes)
+    h.Write([]byte(secret))
+    return h.Sum(nil)
+}
+
+// isReplyTo checks that the reply matches the request and has been
+// signed with the same shared secret.
+func (self *packet) isReplyTo(req *packet, secret string) bool {
+    return self.id == req.id && bytes.Equal(self.authenticator, self.digest(req.authenticator, secret))
+}
+
+func encodeAttributes(attrs []*Attribute, secret string, authenticator []byte) ([]byte, error) {
+    buf := []byte{}
+    for _, attr := range attrs {
+        def, ok := attrs_by_name[attr.Name]
+        if ! ok {
+            return nil, errors.New("Unknown RADIUS attribute: " + attr.Name)
+        }
+        var value []byte
+        switch def.vtype {
+        case TYPE_INTEGER:
+            n, ok := def.values[attr.Value]
+            if ! ok {
+                v, err := strconv.ParseUint(attr.Value, 10, 32)
+                if err != nil {
+                    return nil, fmt.Errorf("Bad value of the %s RADIUS attribute: %s", attr.Name, attr.Value)
+                }
+                n = uint32(v)
+            }
+            value = make([]byte, 4)
+            binary.BigEndian.PutUint32(value, n)
+        case TYPE_IPADDR:
+            ip := net.ParseIP(attr.Value).To4()
+            if ip == nil {
+                return nil, fmt.Errorf("Bad value of the %s RADIUS attribute: %s", attr.Name, attr.Value)
+            }
+            value = ip
+        case TYPE_PASSWORD:
+            value = hidePassword([]byte(attr.Value), secret, authenticator)
+        case TYPE_DIGEST:
+            value = append([]byte{ def.code, byte(len(attr.Value) + 2) }, attr.Value...)
+        default:
+            value = []byte(attr.Value)
+        }
+        code := def.code
+        if def.vtype == TYPE_DIGEST {
+            code = ATTR_DIGEST_ATTRIBUTES
+        }
+        if def.vendor != VENDOR_NONE {
+            vsa := make([]byte, 6, 6 + len(value))
+            binary.BigEndian.PutUint32(vsa[0:4], def.vendor)
+            vsa[4] = def.code
+            vsa[5] = byte(len(value) + 2)
+            value = append(vsa, value...)
+            code = 26
+        }
+        if len(value) > 253 {
+            return nil, errors.New("The value of the RADIUS attribute is too long: " + attr.Name)
+        }
+        buf = append(buf, code, byte(len(value) + 2))
+        buf = append(buf, value...)
+    }
+    return buf, nil
+}
+
+func decodeAttributes(data []byte, secret string, authenticator []byte) ([]*Attribute, error) {
+    attrs := []*Attribute{}
+    for len(data) > 0 {
+        if len(data) < 2 || int(data[1]) < 2 || int(data[1]) > len(data) {
+            return nil, errors.New("Malformed RADIUS attribute")
+        }
+        code, value := data[0], data[2:data[1]]
+        data = data[data[1]:]
+        var vendor uint32
+        vtype := TYPE_STRING
+        switch code {
+        case 26:
+            if len(value) < 6 || int(value[5]) < 2 || int(value[5]) > len(value) - 4 {
+                return nil, errors.New("Malformed RADIUS vendor specific attribute")
+            }
+            vendor = binary.BigEndian.Uint32(value[0:4])
+            code, value = value[4], value[6:value[5] + 4]
+        case ATTR_DIGEST_ATTRIBUTES:
+            if len(value) < 2 || int(value[1]) != len(value) {
+                return nil, errors.New("Malformed RADIUS digest attribute")
+            }
+            vtype = TYPE_DIGEST
+            code, value = value[0], value[2:]
+        }
+        def, ok := attrs_by_code[attrKey(vendor, vtype, code)]
+        if ! ok {
+            if vendor != VENDOR_NONE {
+                attrs = append(attrs, NewAttribute(fmt.Sprintf("Vendor-%d-Attr-%d", vendor, code), string(value)))
+            } else {
+                attrs = append(attrs, NewAttribute(fmt.Sprintf("Attr-%d", code), string(value)))
+            }
+            continue
+        }
+        var s string
+        switch def.vtype {
+        case TYPE_INTEGER:
+            if len(value) != 4 {
+                return nil, errors.New("Malformed RADIUS integer attribute: " + def.name)
+            }
+            n := binary.BigEndian.Uint32(value)
+            s = strconv.FormatUint(uint64(n), 10)
+            for name, v := range def.values {
+                if v == n {
+                    s = name
+                    break
+                }
+            }
+        case TYPE_IPADDR:
+            if len(value) != 4 {
+                return nil, errors.New("Malformed RADIUS address attribute: " + def.name)
+            }
+            s = net.IP(value).String()
+        case TYPE_PASSWORD:
+            s = string(revealPassword(value, secret, authenticator))
+        default:
+            s = string(value)
+        }
+        attrs = append(attrs, NewAttribute(def.name, s))
+    }
+    return attrs, nil
+}
+
+// RFC 2865 section 5.2
+func hidePassword(passwd []byte, secret string, authenticator []byte) []byte {
+    plen := (len(passwd) + 15) / 16 * 16
+    if plen == 0 {
+        plen = 16
+    }
+    buf := make([]byte, plen)
+    copy(buf, passwd)
+    prev := authenticator
+    for i := 0; i < plen; i += 16 {
+        b := md5.Sum(append([]byte(secret), prev...))
+        for j := 0; j < 16; j++ {
+            buf[i + j] ^= b[j]
+        }
+        prev = buf[i:i + 16]
+    }
+    return buf
+}
+
+func revealPassword(data []byte, secret string, authenticator []byte) []byte {
+    buf := make([]byte, len(data))
+    prev := authenticator
+    for i := 0; i + 16 <= len(data); i += 16 {
+        b := md5.Sum(append([]byte(secret), prev...))
+        for j := 0; j < 16; j++ {
+            buf[i + j] = data[i + j] ^ b[j]
+        }
+        prev = data[i:i + 16]
+    }
+    return bytes.TrimRight(buf, "\x00")
+}
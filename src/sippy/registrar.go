@@ -0,0 +1,290 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "strconv"
+    "strings"
+    "time"
+
+    "sippy/conf"
+    "sippy/headers"
+    "sippy/types"
+)
+
+// Verifies the credentials of the request. Returns the name of the
+// authenticated user or the response to send back.
+type RegistrarAuthenticator func(req sippy_types.SipRequest) (string, sippy_types.SipResponse)
+
+// Registrar handles REGISTER requests storing the bindings into the
+// location store (RFC 3261 section 10.3).
+type Registrar struct {
+    store           LocationStore
+    config          sippy_conf.Config
+    authenticate    RegistrarAuthenticator
+    min_expires     int
+    max_expires     int
+    default_expires int
+}
+
+func NewRegistrar(store LocationStore, config sippy_conf.Config) *Registrar {
+    return &Registrar{
+        store           : store,
+        config          : config,
+        min_expires     : 60,
+        max_expires     : 7200,
+        default_expires : 3600,
+    }
+}
+
+func (self *Registrar) SetExpiresLimits(min_expires, max_expires, default_expires int) {
+    self.min_expires = min_expires
+    self.max_expires = max_expires
+    self.default_expires = default_expires
+}
+
+// Sets the authenticator of the REGISTER requests. Without one all of
+// them are refused.
+func (self *Registrar) SetAuthenticator(authenticate RegistrarAuthenticator) {
+    self.authenticate = authenticate
+}
+
+func (self *Registrar) GetStore() LocationStore {
+    return self.store
+}
+
+// Returns the key the bindings of the URL are stored under.
+func AORKey(url *sippy_header.SipURL) string {
+    return url.Username + "@" + strings.ToLower(url.Host.String())
+}
+
+func (self *Registrar) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTransaction) *sippy_types.Ua_context {
+    return &sippy_types.Ua_context{
+        Response : self.handleRegister(req),
+    }
+}
+
+func (self *Registrar) handleRegister(req sippy_types.SipRequest) sippy_types.SipResponse {
+    if req.GetMethod() != "REGISTER" {
+        return req.GenResponse(405, "Method Not Allowed", nil, nil)
+    }
+    to, err := req.GetTo().GetBody(self.config)
+    if err != nil {
+        return req.GenResponse(400, "Bad Request", nil, nil)
+    }
+    if to.GetUrl().Username == "" {
+        return req.GenResponse(404, "Not Found", nil, nil)
+    }
+    if self.authenticate == nil {
+        return req.GenResponse(403, "Forbidden", nil, nil)
+    }
+    username, resp := self.authenticate(req)
+    if resp != nil {
+        return resp
+    }
+    if username != to.GetUrl().Username {
+        // Only the user itself may change its bindings
+        return req.GenResponse(403, "Forbidden", nil, nil)
+    }
+    aor := AORKey(to.GetUrl())
+    cseq, err := req.GetCSeq().GetBody()
+    if err != nil {
+        return req.GenResponse(400, "Bad Request", nil, nil)
+    }
+    call_id := req.GetCallId().CallId
+    expires := self.default_expires
+    for _, hf := range req.GetHFs("Expires") {
+        expires, err = strconv.Atoi(strings.TrimSpace(hf.StringBody()))
+        if err != nil || expires < 0 {
+            return req.GenResponse(400, "Bad Expires", nil, nil)
+        }
+    }
+    path := []string{}
+    for _, hf := range req.GetHFs("Path") {
+        path = append(path, splitAddressList(hf.StringBody())...)
+    }
+    if req.HasContactAsterisk() {
+        // Remove all the bindings
+        if len(req.GetContacts()) != 0 || expires != 0 {
+            return req.GenResponse(400, "Invalid Wildcard", nil, nil)
+        }
+        err = self.store.Modify(aor, func(bindings []*Binding) ([]*Binding, bool) {
+            for _, b := range bindings {
+                if b.CallId == call_id && cseq.CSeq <= b.CSeq {
+                    resp = req.GenResponse(500, "Out of Order", nil, nil)
+                    return nil, false
+                }
+            }
+            return nil, true
+        })
+        if err != nil {
+            self.config.ErrorLogger().Error("Registrar: cannot store the bindings of " + aor + ": " + err.Error())
+            return req.GenResponse(500, "Server Internal Error", nil, nil)
+        }
+        if resp != nil {
+            return resp
+        }
+        return self.okResponse(req, nil, path)
+    }
+    if len(req.GetContacts()) == 0 {
+        // Just a query of the current bindings
+        return self.okResponse(req, self.store.Lookup(aor), path)
+    }
+    received := ""
+    if req.GetSource() != nil {
+        received = req.GetSource().String()
+    }
+    // Check the contacts before touching the store
+    contacts := []*sippy_header.SipAddress{}
+    cexpires := []int{}
+    for _, contact := range req.GetContacts() {
+        addr, err := contact.GetBody(self.config)
+        if err != nil {
+            return req.GenResponse(400, "Bad Contact", nil, nil)
+        }
+        ce := expires
+        if s := addr.GetParam("expires"); s != "" {
+            ce, err = strconv.Atoi(s)
+            if err != nil || ce < 0 {
+                return req.GenResponse(400, "Bad Contact Expires", nil, nil)
+            }
+        }
+        if ce > 0 && ce < self.min_expires {
+            resp := req.GenResponse(423, "Interval Too Brief", nil, nil)
+            resp.AppendHeader(sippy_header.NewSipGenericHF("Min-Expires", strconv.Itoa(self.min_expires)))
+            return resp
+        }
+        if ce > self.max_expires {
+            ce = self.max_expires
+        }
+        if q := addr.GetQ(); q < 0 || q > 1 {
+            return req.GenResponse(400, "Bad Contact q-value", nil, nil)
+        }
+        contacts = append(contacts, addr)
+        cexpires = append(cexpires, ce)
+    }
+    var result []*Binding
+    now := time.Now()
+    err = self.store.Modify(aor, func(bindings []*Binding) ([]*Binding, bool) {
+        for i, addr := range contacts {
+            instance_id := addr.GetParam("+sip.instance")
+            uri := addr.GetUrl().String()
+            idx := -1
+            for j, b := range bindings {
+                if (instance_id != "" && b.InstanceId == instance_id) || (instance_id == "" && b.InstanceId == "" && b.Contact == uri) {
+                    idx = j
+                    break
+                }
+            }
+            if idx >= 0 && bindings[idx].CallId == call_id && cseq.CSeq <= bindings[idx].CSeq {
+                resp = req.GenResponse(500, "Out of Order", nil, nil)
+                return nil, false
+            }
+            if cexpires[i] == 0 {
+                if idx >= 0 {
+                    bindings = append(bindings[:idx], bindings[idx + 1:]...)
+                }
+                continue
+            }
+            binding := &Binding{
+                Contact     : uri,
+                Expires     : now.Add(time.Duration(cexpires[i]) * time.Second),
+                Q           : addr.GetQ(),
+                Received    : received,
+                Path        : path,
+                InstanceId  : instance_id,
+                CallId      : call_id,
+                CSeq        : cseq.CSeq,
+            }
+            if idx >= 0 {
+                bindings[idx] = binding
+            } else {
+                bindings = append(bindings, binding)
+            }
+        }
+        result = bindings
+        return bindings, true
+    })
+    if err != nil {
+        self.config.ErrorLogger().Error("Registrar: cannot store the bindings of " + aor + ": " + err.Error())
+        return req.GenResponse(500, "Server Internal Error", nil, nil)
+    }
+    if resp != nil {
+        return resp
+    }
+    return self.okResponse(req, result, path)
+}
+
+// Builds the 200 OK listing all the current bindings of the AOR.
+func (self *Registrar) okResponse(req sippy_types.SipRequest, bindings []*Binding, path []string) sippy_types.SipResponse {
+    resp := req.GenResponse(200, "OK", nil, nil)
+    now := time.Now()
+    for _, b := range bindings {
+        url, err := sippy_header.ParseSipURL(b.Contact, false, self.config)
+        if err != nil {
+            continue
+        }
+        addr := sippy_header.NewSipAddress("", url)
+        addr.SetParam("expires", strconv.Itoa(int(b.Expires.Sub(now).Seconds() + 0.5)))
+        if b.InstanceId != "" {
+            addr.SetParam("+sip.instance", b.InstanceId)
+        }
+        resp.AppendHeader(sippy_header.NewSipContactFromAddress(addr))
+    }
+    for _, p := range path {
+        resp.AppendHeader(sippy_header.NewSipGenericHF("Path", p))
+    }
+    return resp
+}
+
+// Splits the comma separated list of name-addrs leaving the commas
+// inside the angle brackets and the quoted strings alone.
+func splitAddressList(s string) []string {
+    ret := []string{}
+    quoted, bracketed := false, false
+    start := 0
+    for i, r := range s {
+        switch {
+        case r == '"':
+            quoted = ! quoted
+        case r == '<' && ! quoted:
+            bracketed = true
+        case r == '>' && ! quoted:
+            bracketed = false
+        case r == ',' && ! quoted && ! bracketed:
+            if v := strings.TrimSpace(s[start:i]); v != "" {
+                ret = append(ret, v)
+            }
+            start = i + 1
+        }
+    }
+    if v := strings.TrimSpace(s[start:]); v != "" {
+        ret = append(ret, v)
+    }
+    return ret
+}
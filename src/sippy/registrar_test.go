@@ -0,0 +1,139 @@
+package sippy
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "sippy/conf"
+    "sippy/log"
+    "sippy/types"
+)
+
+func testRegister(t *testing.T, registrar *Registrar, config sippy_conf.Config, cseq string, hfs ...string) sippy_types.SipResponse {
+    buf := "REGISTER sip:example.com SIP/2.0\r\n" +
+        "Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK" + cseq + "\r\n" +
+        "From: <sip:alice@example.com>;tag=1\r\n" +
+        "To: <sip:alice@Example.COM>\r\n" +
+        "Call-ID: reg1\r\n" +
+        "CSeq: " + cseq + " REGISTER\r\n" +
+        "Max-Forwards: 70\r\n"
+    buf += strings.Join(append(hfs, "Content-Length: 0"), "\r\n") + "\r\n\r\n"
+    req, err := ParseSipRequest([]byte(buf), nil, config)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    return registrar.RecvRequest(req, nil).Response
+}
+
+func testRegistrar(config sippy_conf.Config, username string) *Registrar {
+    registrar := NewRegistrar(NewMemoryLocationStore(), config)
+    registrar.SetAuthenticator(func(req sippy_types.SipRequest) (string, sippy_types.SipResponse) {
+        return username, nil
+    })
+    return registrar
+}
+
+func Test_RegistrarAuth(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    registrar := NewRegistrar(NewMemoryLocationStore(), config)
+    resp := testRegister(t, registrar, config, "1", "Contact: <sip:alice@192.0.2.1:5060>")
+    if code, _ := resp.GetSCode(); code != 403 {
+        t.Fatal("The REGISTER has been accepted without an authenticator")
+    }
+    registrar.SetAuthenticator(func(req sippy_types.SipRequest) (string, sippy_types.SipResponse) {
+        return "", req.GenResponse(401, "Unauthorized", nil, nil)
+    })
+    resp = testRegister(t, registrar, config, "2", "Contact: <sip:alice@192.0.2.1:5060>")
+    if code, _ := resp.GetSCode(); code != 401 {
+        t.Fatal("The REGISTER has not been challenged")
+    }
+    registrar = testRegistrar(config, "mallory")
+    resp = testRegister(t, registrar, config, "3", "Contact: <sip:mallory@192.0.2.6>")
+    if code, _ := resp.GetSCode(); code != 403 || len(registrar.GetStore().AORs()) != 0 {
+        t.Fatal("Somebody else's AOR has been bound")
+    }
+}
+
+func Test_RegistrarConcurrent(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    registrar := testRegistrar(config, "alice")
+    var wg sync.WaitGroup
+    for i := 1; i <= 50; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            testRegister(t, registrar, config, strconv.Itoa(i), "Contact: <sip:alice@192.0.2." + strconv.Itoa(i) + ">")
+        }(i)
+    }
+    wg.Wait()
+    if n := len(registrar.GetStore().Lookup("alice@example.com")); n != 50 {
+        t.Fatalf("%d bindings out of 50 have been stored", n)
+    }
+}
+
+func Test_Registrar(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    registrar := testRegistrar(config, "alice")
+    resp := testRegister(t, registrar, config, "1",
+      "Contact: <sip:alice@192.0.2.1:5060>;q=0.5, <sip:alice@192.0.2.2>;+sip.instance=\"<urn:uuid:1>\"",
+      "Path: <sip:edge.example.com;lr>", "Expires: 600")
+    if code, _ := resp.GetSCode(); code != 200 || len(resp.GetContacts()) != 2 || len(resp.GetHFs("Path")) != 1 {
+        t.Fatal("Unexpected response: " + resp.LocalStr(nil, false))
+    }
+    bindings := registrar.GetStore().Lookup("alice@example.com")
+    if len(bindings) != 2 || bindings[0].Q != 0.5 || bindings[1].InstanceId != "\"<urn:uuid:1>\"" ||
+      len(bindings[0].Path) != 1 || bindings[0].Expires.Sub(time.Now()) > 600 * time.Second {
+        t.Fatal("Unexpected bindings")
+    }
+    resp = testRegister(t, registrar, config, "1", "Contact: <sip:alice@192.0.2.1:5060>")
+    if code, _ := resp.GetSCode(); code != 500 {
+        t.Fatal("The out of order request has been accepted")
+    }
+    resp = testRegister(t, registrar, config, "2", "Contact: <sip:alice@192.0.2.1:5060>;expires=10")
+    if code, _ := resp.GetSCode(); code != 423 || len(resp.GetHFs("Min-Expires")) != 1 {
+        t.Fatal("The too brief interval has been accepted")
+    }
+    resp = testRegister(t, registrar, config, "3", "Contact: <sip:alice@192.0.2.9>;+sip.instance=\"<urn:uuid:1>\"")
+    bindings = registrar.GetStore().Lookup("alice@example.com")
+    if len(bindings) != 2 || bindings[1].Contact != "sip:alice@192.0.2.9" {
+        t.Fatal("The instance binding has not been replaced")
+    }
+    resp = testRegister(t, registrar, config, "4", "Contact: *", "Expires: 0")
+    if code, _ := resp.GetSCode(); code != 200 || len(registrar.GetStore().AORs()) != 0 {
+        t.Fatal("The bindings have not been removed")
+    }
+}
+
+func Test_FileLocationStore(t *testing.T) {
+    dir, err := ioutil.TempDir("", "location")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer os.RemoveAll(dir)
+    fname := filepath.Join(dir, "location.json")
+    store, err := NewFileLocationStore(fname)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    err = store.Update("bob@example.com", []*Binding{
+        { Contact : "sip:bob@192.0.2.3", Expires : time.Now().Add(time.Minute), Q : 1 },
+        { Contact : "sip:bob@192.0.2.4", Expires : time.Now().Add(-time.Minute), Q : 1 },
+    })
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    store, err = NewFileLocationStore(fname)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    bindings := store.Lookup("bob@example.com")
+    if len(bindings) != 1 || bindings[0].Contact != "sip:bob@192.0.2.3" {
+        t.Fatal("The snapshot has not been restored")
+    }
+}
@@ -0,0 +1,328 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "sippy/conf"
+    "sippy/headers"
+    "sippy/net"
+    "sippy/types"
+)
+
+const (
+    REG_STATE_UNREGISTERED = "unregistered"
+    REG_STATE_REGISTERING  = "registering"
+    REG_STATE_REGISTERED   = "registered"
+    REG_STATE_FAILED       = "failed"
+)
+
+// The retry interval after a failure doubles with each consecutive
+// failure up to the maximum.
+const (
+    REG_RETRY_MIN = 10 * time.Second
+    REG_RETRY_MAX = 10 * time.Minute
+)
+
+// RegistrationAgent keeps the binding of the contact to the AOR with
+// the registrar by sending REGISTER periodically.
+type RegistrationAgent struct {
+    sip_tm      sippy_types.SipTransactionManager
+    config      sippy_conf.Config
+    lock        sync.Mutex
+    aor         *sippy_header.SipURL
+    registrar   *sippy_net.HostPort
+    username    string
+    password    string
+    contact     *sippy_header.SipContact
+    expires     int
+    call_id     *sippy_header.SipCallId
+    from_tag    string
+    cseq        int
+    state       string
+    timer       *Timeout
+    failures    int
+    triedauth   bool
+    running     bool
+    expires_at  time.Time
+    last_scode  int
+    last_reason string
+}
+
+// Creates the agent registering the aor. The REGISTER requests are sent
+// to the registrar when given, otherwise to the host of the aor. The
+// contact defaults to the user of the aor at the local address.
+func NewRegistrationAgent(sip_tm sippy_types.SipTransactionManager, config sippy_conf.Config, aor *sippy_header.SipURL,
+  registrar *sippy_net.HostPort, username, password string, contact *sippy_header.SipContact, expires time.Duration) *RegistrationAgent {
+    if contact == nil {
+        contact = sippy_header.NewSipContactFromAddress(sippy_header.NewSipAddress("",
+                    sippy_header.NewSipURL(aor.Username, config.GetMyAddress(), config.GetMyPort(), false)))
+    }
+    from := sippy_header.NewSipAddress("", aor)
+    from.GenTag()
+    return &RegistrationAgent{
+        sip_tm      : sip_tm,
+        config      : config,
+        aor         : aor,
+        registrar   : registrar,
+        username    : username,
+        password    : password,
+        contact     : contact,
+        expires     : int(expires / time.Second),
+        call_id     : sippy_header.GenerateSipCallId(config),
+        from_tag    : from.GetTag(),
+        cseq        : 1,
+        state       : REG_STATE_UNREGISTERED,
+    }
+}
+
+func (self *RegistrationAgent) Start() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if self.running {
+        return
+    }
+    self.running = true
+    self.register(nil)
+}
+
+// Stops the refreshes and removes the binding from the registrar.
+func (self *RegistrationAgent) Stop() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if ! self.running {
+        return
+    }
+    self.running = false
+    self.cancelTimer()
+    if self.state == REG_STATE_REGISTERED {
+        self.sendRegister(0, nil)
+    }
+    self.state = REG_STATE_UNREGISTERED
+}
+
+func (self *RegistrationAgent) IsRegistered() bool {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    return self.state == REG_STATE_REGISTERED && time.Now().Before(self.expires_at)
+}
+
+func (self *RegistrationAgent) GetState() string {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    return self.state
+}
+
+func (self *RegistrationAgent) GetUsername() string {
+    return self.username
+}
+
+func (self *RegistrationAgent) GetPassword() string {
+    return self.password
+}
+
+func (self *RegistrationAgent) GetRegistrar() *sippy_net.HostPort {
+    return self.registrar
+}
+
+func (self *RegistrationAgent) GetAOR() *sippy_header.SipURL {
+    return self.aor
+}
+
+func (self *RegistrationAgent) String() string {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    res := "sip:" + self.aor.Username + "@" + self.aor.Host.String() + " " + self.state
+    switch self.state {
+    case REG_STATE_REGISTERED:
+        res += fmt.Sprintf(" expires=%d", int(time.Until(self.expires_at).Seconds()))
+        if self.failures > 0 {
+            res += fmt.Sprintf(" failures=%d", self.failures)
+        }
+    case REG_STATE_FAILED:
+        res += fmt.Sprintf(" failures=%d", self.failures)
+    }
+    if self.last_scode != 0 {
+        res += fmt.Sprintf(" last=\"%d %s\"", self.last_scode, self.last_reason)
+    }
+    return res
+}
+
+func (self *RegistrationAgent) register(auth sippy_header.SipHeader) {
+    self.timer = nil
+    if ! self.running {
+        return
+    }
+    if self.state != REG_STATE_REGISTERED {
+        self.state = REG_STATE_REGISTERING
+    }
+    if ! self.sendRegister(self.expires, auth) {
+        self.failed(0, "")
+    }
+}
+
+func (self *RegistrationAgent) sendRegister(expires int, auth sippy_header.SipHeader) bool {
+    ruri := sippy_header.NewSipURL("", self.aor.Host, self.aor.Port, false)
+    from := sippy_header.NewSipAddress("", self.aor.GetCopy())
+    from.SetTag(self.from_tag)
+    to := sippy_header.NewSipAddress("", self.aor.GetCopy())
+    expires_hf := sippy_header.NewSipExpires()
+    expires_hf.Number = expires
+    req, err := NewSipRequest("REGISTER", ruri, /*sipver*/ "", sippy_header.NewSipTo(to, self.config),
+                    sippy_header.NewSipFrom(from, self.config), /*via*/ nil, self.cseq, self.call_id,
+                    /*maxforwards*/ nil, /*body*/ nil, self.contact.GetCopy(), /*routes*/ nil, self.registrar,
+                    /*cguid*/ nil, sippy_header.NewSipUserAgent(self.config.GetMyUAName()), expires_hf, self.config)
+    if err != nil {
+        self.config.ErrorLogger().Error("RegistrationAgent: cannot create REGISTER: " + err.Error())
+        return false
+    }
+    self.cseq++
+    if auth != nil {
+        req.AppendHeader(auth)
+    }
+    tr, err := self.sip_tm.CreateClientTransaction(req, self, &self.lock, /*laddress*/ nil, /*userv*/ nil, /*req_out_cb*/ nil)
+    if err != nil {
+        self.config.ErrorLogger().Error("RegistrationAgent: cannot send REGISTER: " + err.Error())
+        return false
+    }
+    self.sip_tm.BeginClientTransaction(req, tr)
+    return true
+}
+
+func (self *RegistrationAgent) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+    code, reason := resp.GetSCode()
+    if code < 200 || ! self.running {
+        return
+    }
+    self.last_scode, self.last_reason = code, reason
+    if (code == 401 || code == 407) && self.username != "" && self.password != "" && ! self.triedauth {
+        challenge, new_auth_fn, err := challengeAuthFn(resp, code, nil)
+        if err != nil {
+            self.config.ErrorLogger().Error("RegistrationAgent: cannot parse the challenge: " + err.Error())
+        } else if challenge != nil {
+            self.triedauth = true
+            self.register(new_auth_fn(challenge.GetRealm(), challenge.GetNonce(), "REGISTER",
+              sippy_header.NewSipURL("", self.aor.Host, self.aor.Port, false).String(), self.username, self.password))
+            return
+        }
+    }
+    self.triedauth = false
+    switch {
+    case code >= 200 && code < 300:
+        granted := self.grantedExpires(resp)
+        if granted <= 0 {
+            self.failed(code, reason)
+            return
+        }
+        self.state = REG_STATE_REGISTERED
+        self.failures = 0
+        self.expires_at = time.Now().Add(time.Duration(granted) * time.Second)
+        // Refresh well ahead of the expiration
+        self.cancelTimer()
+        refresh := time.Duration(granted) * time.Second * 4 / 5
+        self.timer = StartTimeout(func() { self.register(nil) }, &self.lock, refresh, 1, self.config.ErrorLogger())
+    case code == 423:
+        // Interval Too Brief
+        for _, hf := range resp.GetHFs("Min-Expires") {
+            min_expires, err := strconv.Atoi(strings.TrimSpace(hf.StringBody()))
+            if err == nil && min_expires > self.expires {
+                self.expires = min_expires
+                self.register(nil)
+                return
+            }
+        }
+        self.failed(code, reason)
+    default:
+        self.failed(code, reason)
+    }
+}
+
+// Returns the expiration granted to our contact by the registrar.
+func (self *RegistrationAgent) grantedExpires(resp sippy_types.SipResponse) int {
+    contact, err := self.contact.GetBody(self.config)
+    if err != nil {
+        return self.expires
+    }
+    for _, hf := range resp.GetContacts() {
+        addr, err := hf.GetBody(self.config)
+        if err != nil {
+            continue
+        }
+        url := addr.GetUrl()
+        if url.Username != contact.GetUrl().Username ||
+          url.GetAddr(self.config).String() != contact.GetUrl().GetAddr(self.config).String() {
+            continue
+        }
+        if v, err := strconv.Atoi(addr.GetParam("expires")); err == nil {
+            return v
+        }
+    }
+    for _, hf := range resp.GetHFs("Expires") {
+        if v, err := strconv.Atoi(strings.TrimSpace(hf.StringBody())); err == nil {
+            return v
+        }
+    }
+    return self.expires
+}
+
+// Schedules the retry after a failure. The binding made by the last
+// successful registration stays valid until it expires, so a failed
+// refresh does not take the registration down before that.
+func (self *RegistrationAgent) failed(code int, reason string) {
+    self.failures++
+    retry := REG_RETRY_MIN
+    for i := 1; i < self.failures && retry < REG_RETRY_MAX; i++ {
+        retry *= 2
+    }
+    if retry > REG_RETRY_MAX {
+        retry = REG_RETRY_MAX
+    }
+    if left := time.Until(self.expires_at); self.state == REG_STATE_REGISTERED && left > 0 {
+        if retry > left {
+            retry = left
+        }
+    } else {
+        self.state = REG_STATE_FAILED
+    }
+    self.cancelTimer()
+    self.config.ErrorLogger().Error(fmt.Sprintf("RegistrationAgent: registration of %s@%s has failed (%d %s), retrying in %s",
+      self.aor.Username, self.aor.Host.String(), code, reason, retry))
+    self.timer = StartTimeout(func() { self.register(nil) }, &self.lock, retry, 1, self.config.ErrorLogger())
+}
+
+func (self *RegistrationAgent) cancelTimer() {
+    if self.timer != nil {
+        self.timer.Cancel()
+        self.timer = nil
+    }
+}
@@ -0,0 +1,88 @@
+package sippy
+
+import (
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "sippy/conf"
+    "sippy/headers"
+    "sippy/log"
+    "sippy/net"
+    "sippy/types"
+)
+
+type test_reg_tm struct {
+    sippy_types.SipTransactionManager
+    reqs    []sippy_types.SipRequest
+}
+
+func (self *test_reg_tm) CreateClientTransaction(req sippy_types.SipRequest, resp_receiver sippy_types.ResponseReceiver, session_lock sync.Locker, laddress *sippy_net.HostPort, userv sippy_net.Transport, req_out_cb func(sippy_types.SipRequest)) (sippy_types.ClientTransaction, error) {
+    return nil, nil
+}
+
+func (self *test_reg_tm) BeginClientTransaction(req sippy_types.SipRequest, tr sippy_types.ClientTransaction) {
+    self.reqs = append(self.reqs, req)
+}
+
+func testRegResponse(t *testing.T, config sippy_conf.Config, req sippy_types.SipRequest, status string, hfs ...string) sippy_types.SipResponse {
+    buf := "SIP/2.0 " + status + "\r\n"
+    for _, hf := range []string{ "Via", "From", "To", "Call-ID", "CSeq" } {
+        for _, h := range req.GetHFs(hf) {
+            buf += h.String() + "\r\n"
+        }
+    }
+    buf += strings.Join(append(hfs, "Content-Length: 0"), "\r\n") + "\r\n\r\n"
+    resp, err := ParseSipResponse([]byte(buf), nil, config)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    return resp
+}
+
+func Test_RegistrationAgent(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    tm := &test_reg_tm{}
+    aor, _ := sippy_header.ParseSipURL("sip:trunk@carrier.example.com", false, config)
+    agent := NewRegistrationAgent(tm, config, aor, nil, "trunk", "secret", nil, 600 * time.Second)
+    agent.Start()
+    defer agent.Stop()
+    if len(tm.reqs) != 1 || tm.reqs[0].GetMethod() != "REGISTER" || agent.GetState() != REG_STATE_REGISTERING {
+        t.Fatal("The REGISTER has not been sent")
+    }
+    agent.lock.Lock()
+    agent.RecvResponse(testRegResponse(t, config, tm.reqs[0], "401 Unauthorized",
+      `WWW-Authenticate: Digest realm="carrier.example.com",nonce="abc",algorithm=SHA-256,qop="auth"`), nil)
+    agent.lock.Unlock()
+    if len(tm.reqs) != 2 || tm.reqs[1].GetSipAuthorization() == nil {
+        t.Fatal("The challenge has not been answered")
+    }
+    auth, _ := tm.reqs[1].GetSipAuthorization().GetBody()
+    HA1 := sippy_header.DigestCalcHA1("SHA-256", "trunk", "carrier.example.com", "secret", "", "")
+    if ! auth.VerifyHA1(HA1, "REGISTER") {
+        t.Fatal("Bad credentials: " + auth.String())
+    }
+    contact := tm.reqs[1].GetContacts()[0].StringBody()
+    agent.lock.Lock()
+    agent.RecvResponse(testRegResponse(t, config, tm.reqs[1], "200 OK", "Contact: " + contact + ";expires=120"), nil)
+    agent.lock.Unlock()
+    if ! agent.IsRegistered() || agent.expires_at.Sub(time.Now()) > 120 * time.Second {
+        t.Fatal("The registration has not been completed: " + agent.String())
+    }
+    // The failed refreshes keep the registration until it expires
+    agent.lock.Lock()
+    agent.RecvResponse(testRegResponse(t, config, tm.reqs[1], "503 Service Unavailable"), nil)
+    agent.RecvResponse(testRegResponse(t, config, tm.reqs[1], "503 Service Unavailable"), nil)
+    agent.lock.Unlock()
+    if ! agent.IsRegistered() || agent.failures != 2 || agent.timer == nil {
+        t.Fatal("The failed refresh has taken the registration down: " + agent.String())
+    }
+    agent.lock.Lock()
+    agent.expires_at = time.Now().Add(-time.Second)
+    agent.RecvResponse(testRegResponse(t, config, tm.reqs[1], "503 Service Unavailable"), nil)
+    agent.lock.Unlock()
+    if agent.GetState() != REG_STATE_FAILED || agent.failures != 3 {
+        t.Fatal("The failure has not been accounted: " + agent.String())
+    }
+}
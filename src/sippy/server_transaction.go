@@ -353,6 +353,7 @@ func (self *serverTransaction) SendResponseWithLossEmul(resp sippy_types.SipResp
         sip_tm.rtid_put(rtid, tid)
     }
     sip_tm.beforeResponseSent(resp)
+    sip_tm.setLocalProto(resp, self.userv)
     self.data = []byte(resp.LocalStr(self.userv.GetLAddress(), /*compact*/ false))
     via0, err = resp.GetVias()[0].GetBody()
     if err != nil {
@@ -363,7 +364,7 @@ func (self *serverTransaction) SendResponseWithLossEmul(resp sippy_types.SipResp
     need_cleanup := false
     if resp.GetSCodeNum() < 200 {
         self.state = RINGING
-        if sip_tm.provisional_retr > 0 && resp.GetSCodeNum() > 100 {
+        if sip_tm.provisional_retr > 0 && resp.GetSCodeNum() > 100 && ! self.userv.IsReliable() {
             self.startTeF(sip_tm.provisional_retr)
         }
     } else {
@@ -398,8 +399,10 @@ func (self *serverTransaction) SendResponseWithLossEmul(resp sippy_types.SipResp
                 }
             }
             // Install retransmit timer if necessary
-            self.tout = time.Duration(0.5 * float64(time.Second))
-            self.startTeA()
+            if ! self.userv.IsReliable() {
+                self.tout = time.Duration(0.5 * float64(time.Second))
+                self.startTeA()
+            }
         } else {
             // We have done with the transaction
             sip_tm.tserver_del(self.tid)
@@ -0,0 +1,370 @@
+//
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2018 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "sippy/headers"
+    "sippy/types"
+)
+
+// The smallest session interval permitted by the RFC 4028.
+const SESSION_TIMER_MIN_SE = 90 * time.Second
+
+// Implements the RFC 4028 session timer of a single dialog. The
+// negotiated interval is kept between the UA states, the refreshes are
+// sent from the Connected state only.
+type sessionTimer struct {
+    ua              *Ua
+    interval        time.Duration
+    min_se          time.Duration
+    expires         time.Duration
+    local_refresh   bool
+    peer_update     bool
+    uas_pending     bool
+    pending_expires time.Duration
+    pending_refresher string
+    refresh_timer   *Timeout
+    expire_timer    *Timeout
+    triedauth       bool
+}
+
+func newSessionTimer(ua *Ua, interval, min_se time.Duration) *sessionTimer {
+    if min_se < SESSION_TIMER_MIN_SE {
+        min_se = SESSION_TIMER_MIN_SE
+    }
+    if interval < min_se {
+        interval = min_se
+    }
+    return &sessionTimer{
+        ua          : ua,
+        interval    : interval,
+        min_se      : min_se,
+    }
+}
+
+func (self *sessionTimer) isActive() bool {
+    return self.expires > 0
+}
+
+func seconds(d time.Duration) int {
+    return int(d / time.Second)
+}
+
+func hasOptionTag(msg sippy_types.SipMsg, tag string) bool {
+    for _, hf := range msg.GetSipSupported() {
+        if hf.HasTag(tag) {
+            return true
+        }
+    }
+    for _, hf := range msg.GetSipRequire() {
+        if hf.HasTag(tag) {
+            return true
+        }
+    }
+    return false
+}
+
+func allowsMethod(msg sippy_types.SipMsg, method string) bool {
+    for _, hf := range msg.GetHFs("Allow") {
+        for _, m := range strings.Split(hf.StringBody(), ",") {
+            if strings.ToUpper(strings.TrimSpace(m)) == method {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// Adds the session timer headers to an outgoing INVITE or UPDATE.
+func (self *sessionTimer) prepRequest(req sippy_types.SipRequest) {
+    req.AppendHeader(sippy_header.CreateSipSupported("timer")[0])
+    if self.isActive() {
+        refresher := "uas"
+        if self.local_refresh {
+            refresher = "uac"
+        }
+        req.AppendHeader(sippy_header.NewSipSessionExpires(seconds(self.expires), refresher))
+    } else {
+        req.AppendHeader(sippy_header.NewSipSessionExpires(seconds(self.interval), ""))
+    }
+    req.AppendHeader(sippy_header.NewSipMinSE(seconds(self.min_se)))
+}
+
+// Negotiates the session interval requested by the incoming INVITE or
+// UPDATE. Returns our Min-SE in seconds when the requested interval is
+// too small and the request has to be rejected with 422.
+func (self *sessionTimer) recvRequest(req sippy_types.SipRequest) int {
+    self.uas_pending = true
+    self.pending_expires = 0
+    self.peer_update = allowsMethod(req, "UPDATE")
+    supported := hasOptionTag(req, "timer")
+    req_min_se := time.Duration(0)
+    if hf := req.GetMinSE(); hf != nil {
+        if body, err := hf.GetBody(); err == nil {
+            req_min_se = time.Duration(body.Number) * time.Second
+        }
+    }
+    if hf := req.GetSessionExpires(); hf != nil {
+        body, err := hf.GetBody()
+        if err != nil {
+            self.ua.logError("sessionTimer::recvRequest: cannot parse Session-Expires: " + err.Error())
+            return 0
+        }
+        expires := time.Duration(body.Delta) * time.Second
+        if expires < self.min_se {
+            self.uas_pending = false
+            return seconds(self.min_se)
+        }
+        if expires > self.interval {
+            expires = self.interval
+            if expires < req_min_se {
+                expires = req_min_se
+            }
+        }
+        self.pending_expires = expires
+        switch {
+        case body.Refresher == "uac" || body.Refresher == "uas":
+            self.pending_refresher = body.Refresher
+        case supported:
+            self.pending_refresher = "uac"
+        default:
+            self.pending_refresher = "uas"
+        }
+    } else if supported {
+        self.pending_expires = self.interval
+        if self.pending_expires < req_min_se {
+            self.pending_expires = req_min_se
+        }
+        self.pending_refresher = "uac"
+    }
+    return 0
+}
+
+// Puts the negotiated interval into our final response to the INVITE or
+// UPDATE and starts the timers if it is a 2xx one.
+func (self *sessionTimer) prepResponse(resp sippy_types.SipResponse) {
+    if ! self.uas_pending {
+        return
+    }
+    code := resp.GetSCodeNum()
+    if code < 200 {
+        return
+    }
+    self.uas_pending = false
+    if code >= 300 {
+        return
+    }
+    if self.pending_expires == 0 {
+        self.stop()
+        return
+    }
+    resp.AppendHeader(sippy_header.NewSipSessionExpires(seconds(self.pending_expires), self.pending_refresher))
+    if self.pending_refresher == "uac" {
+        resp.AppendHeader(sippy_header.CreateSipRequire("timer")[0])
+    }
+    self.start(self.pending_expires, self.pending_refresher == "uas")
+}
+
+// Applies the session interval confirmed by the 2xx response to our
+// INVITE or UPDATE.
+func (self *sessionTimer) recvResponse(resp sippy_types.SipResponse) {
+    if allowsMethod(resp, "UPDATE") {
+        self.peer_update = true
+    }
+    hf := resp.GetSessionExpires()
+    if hf == nil {
+        self.stop()
+        return
+    }
+    body, err := hf.GetBody()
+    if err != nil {
+        self.ua.logError("sessionTimer::recvResponse: cannot parse Session-Expires: " + err.Error())
+        return
+    }
+    self.start(time.Duration(body.Delta) * time.Second, body.Refresher != "uas")
+}
+
+// Raises the session interval to the Min-SE of the 422 response. Returns
+// false if that would not change anything so that the request should not
+// be retried.
+func (self *sessionTimer) recv422(resp sippy_types.SipResponse) bool {
+    hf := resp.GetMinSE()
+    if hf == nil {
+        return false
+    }
+    body, err := hf.GetBody()
+    if err != nil {
+        return false
+    }
+    min_se := time.Duration(body.Number) * time.Second
+    if min_se <= self.interval && (! self.isActive() || min_se <= self.expires) {
+        return false
+    }
+    self.interval = min_se
+    if self.min_se < min_se {
+        self.min_se = min_se
+    }
+    if self.isActive() {
+        self.expires = min_se
+    }
+    return true
+}
+
+func (self *sessionTimer) start(expires time.Duration, local_refresh bool) {
+    self.cancelTimers()
+    self.expires = expires
+    self.local_refresh = local_refresh
+    logger := self.ua.config.ErrorLogger()
+    if local_refresh {
+        self.refresh_timer = StartTimeout(self.refresh, self.ua.session_lock, expires / 2, 1, logger)
+    }
+    // RFC 4028, section 10: BYE a bit before the session expires
+    // unless a refresh has been seen.
+    guard := expires / 3
+    if guard > 32 * time.Second {
+        guard = 32 * time.Second
+    }
+    self.expire_timer = StartTimeout(self.expired, self.ua.session_lock, expires - guard, 1, logger)
+}
+
+func (self *sessionTimer) cancelTimers() {
+    if self.refresh_timer != nil {
+        self.refresh_timer.Cancel()
+        self.refresh_timer = nil
+    }
+    if self.expire_timer != nil {
+        self.expire_timer.Cancel()
+        self.expire_timer = nil
+    }
+}
+
+func (self *sessionTimer) stop() {
+    self.cancelTimers()
+    self.expires = 0
+    self.uas_pending = false
+}
+
+func (self *sessionTimer) expired() {
+    self.expire_timer = nil
+    if self.refresh_timer != nil {
+        self.refresh_timer.Cancel()
+        self.refresh_timer = nil
+    }
+    self.expires = 0
+    self.ua.me().Disconnect(nil, "")
+}
+
+func (self *sessionTimer) refresh() {
+    self.refresh_timer = nil
+    if self.ua.GetState() != sippy_types.UA_STATE_CONNECTED {
+        // Some other transaction is in progress, its 2xx will do.
+        return
+    }
+    self.triedauth = false
+    self.sendRefresh("", "", nil)
+}
+
+func (self *sessionTimer) sendRefresh(nonce, realm string, new_auth_fn sippy_header.NewSipXXXAuthorizationFunc) {
+    var body sippy_types.MsgBody
+    method := "UPDATE"
+    if ! self.peer_update {
+        method = "INVITE"
+        body = self.ua.GetLSDP()
+    }
+    req, err := self.ua.me().GenRequest(method, body, nonce, realm, new_auth_fn)
+    if err != nil {
+        self.ua.logError("sessionTimer::sendRefresh: cannot create " + method + ": " + err.Error())
+        return
+    }
+    tr, err := self.ua.PrepTrWithReceiver(req, self)
+    if err != nil {
+        self.ua.logError("sessionTimer::sendRefresh: cannot create client transaction: " + err.Error())
+        return
+    }
+    self.ua.sip_tm.BeginClientTransaction(req, tr)
+}
+
+func (self *sessionTimer) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+    code := resp.GetSCodeNum()
+    if code < 200 {
+        return
+    }
+    cseq_body, err := resp.GetCSeq().GetBody()
+    if err != nil {
+        self.ua.logError("sessionTimer::RecvResponse: cannot parse CSeq: " + err.Error())
+        return
+    }
+    if self.ua.GetState() != sippy_types.UA_STATE_CONNECTED {
+        return
+    }
+    switch {
+    case code < 300:
+        self.recvResponse(resp)
+        return
+    case code == 401 || code == 407:
+        if self.triedauth || self.ua.username == "" || self.ua.password == "" {
+            break
+        }
+        var body sippy_types.MsgBody
+        if cseq_body.Method == "INVITE" {
+            body = self.ua.GetLSDP()
+        }
+        challenge, new_auth_fn, err := challengeAuthFn(resp, code, body)
+        if err != nil {
+            self.ua.logError(fmt.Sprintf("sessionTimer::RecvResponse: error parsing %d auth: %s", code, err.Error()))
+            break
+        }
+        if challenge != nil {
+            self.triedauth = true
+            self.sendRefresh(challenge.GetNonce(), challenge.GetRealm(), new_auth_fn)
+            return
+        }
+    case code == 422:
+        if self.recv422(resp) {
+            self.sendRefresh("", "", nil)
+            return
+        }
+    case code == 408 || code == 481:
+        self.stop()
+        self.ua.me().Disconnect(nil, "")
+        return
+    case code == 491:
+        self.refresh_timer = StartTimeout(self.refresh, self.ua.session_lock, 2 * time.Second, 1, self.ua.config.ErrorLogger())
+        return
+    case cseq_body.Method == "UPDATE":
+        // Fall back to re-INVITE if the peer does not really do UPDATE.
+        self.peer_update = false
+        self.sendRefresh("", "", nil)
+        return
+    }
+    // The session is not refreshed, let the expire timer do its job.
+}
@@ -0,0 +1,104 @@
+package sippy
+
+import (
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "sippy/conf"
+    "sippy/log"
+    "sippy/types"
+)
+
+func testStRequest(t *testing.T, config sippy_conf.Config, method string, hfs ...string) sippy_types.SipRequest {
+    buf := method + " sip:bob@192.168.0.2 SIP/2.0\r\n" +
+        "Via: SIP/2.0/UDP 192.168.0.1:5060;branch=z9hG4bK776asdhds\r\n" +
+        "From: <sip:alice@192.168.0.1>;tag=1928301774\r\n" +
+        "To: <sip:bob@192.168.0.2>\r\n" +
+        "Call-ID: a84b4c76e66710@192.168.0.1\r\n" +
+        "CSeq: 1 " + method + "\r\n" +
+        "Contact: <sip:alice@192.168.0.1>\r\n"
+    buf += strings.Join(append(hfs, "Content-Length: 0"), "\r\n") + "\r\n\r\n"
+    req, err := ParseSipRequest([]byte(buf), nil, config)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    return req
+}
+
+func Test_SessionTimerUas(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    ua := NewUA(nil, config, nil, nil, new(sync.Mutex), nil)
+    ua.SetSessionTimer(1800 * time.Second, 120 * time.Second)
+    defer ua.SetSessionTimer(0, 0)
+
+    req := testStRequest(t, config, "INVITE", "Supported: timer", "Session-Expires: 90")
+    if min_se := ua.SessionTimerRequest(req); min_se != 120 {
+        t.Fatalf("Too small interval accepted, Min-SE %d", min_se)
+    }
+    req = testStRequest(t, config, "INVITE", "Supported: timer", "x: 3600", "Min-SE: 1900")
+    if ua.SessionTimerRequest(req) != 0 {
+        t.Fatal("Acceptable interval rejected")
+    }
+    resp := req.GenResponse(200, "OK", nil, nil)
+    ua.SessionTimerResponse(resp)
+    se := resp.GetSessionExpires()
+    if se == nil || se.StringBody() != "1900;refresher=uac" || len(resp.GetSipRequire()) != 1 {
+        t.Fatal("Bad session timer headers in 2xx")
+    }
+    if ! ua.SessionTimerActive() || ua.session_timer.local_refresh {
+        t.Fatal("The peer should be refreshing")
+    }
+    // The peer that knows nothing about the timers is refreshed by us
+    req = testStRequest(t, config, "UPDATE", "Session-Expires: 600")
+    ua.SessionTimerRequest(req)
+    resp = req.GenResponse(200, "OK", nil, nil)
+    ua.SessionTimerResponse(resp)
+    if resp.GetSessionExpires().StringBody() != "600;refresher=uas" || len(resp.GetSipRequire()) != 0 || ! ua.session_timer.local_refresh {
+        t.Fatal("We should be refreshing")
+    }
+    // No timer at all turns the session timer off
+    req = testStRequest(t, config, "INVITE")
+    ua.SessionTimerRequest(req)
+    resp = req.GenResponse(200, "OK", nil, nil)
+    ua.SessionTimerResponse(resp)
+    if resp.GetSessionExpires() != nil || ua.SessionTimerActive() {
+        t.Fatal("The session timer should be off")
+    }
+}
+
+func Test_SessionTimerUac(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    ua := NewUA(nil, config, nil, nil, new(sync.Mutex), nil)
+    ua.SetSessionTimer(600 * time.Second, 0)
+    defer ua.SetSessionTimer(0, 0)
+    st := ua.session_timer
+
+    req := testStRequest(t, config, "INVITE")
+    st.prepRequest(req)
+    if req.GetSessionExpires().StringBody() != "600" || req.GetMinSE().StringBody() != "90" {
+        t.Fatal("Bad session timer headers in INVITE")
+    }
+    resp := req.GenResponse(422, "Session Interval Too Small", nil, nil)
+    resp.AppendHeader(testStRequest(t, config, "INVITE", "Min-SE: 1800").GetMinSE())
+    if ! st.recv422(resp) || st.interval != 1800 * time.Second || st.recv422(resp) {
+        t.Fatal("Min-SE has not been taken")
+    }
+    resp = req.GenResponse(200, "OK", nil, nil)
+    resp.AppendHeader(testStRequest(t, config, "INVITE", "Session-Expires: 1800;refresher=uac").GetSessionExpires())
+    resp.AppendHeader(testStRequest(t, config, "INVITE", "Allow: INVITE, ACK, BYE, UPDATE").GetHFs("Allow")[0])
+    st.recvResponse(resp)
+    if ! ua.SessionTimerActive() || ! st.local_refresh || ! st.peer_update || st.expires != 1800 * time.Second {
+        t.Fatal("The session timer has not been negotiated")
+    }
+    req = testStRequest(t, config, "UPDATE")
+    st.prepRequest(req)
+    if req.GetSessionExpires().StringBody() != "1800;refresher=uac" {
+        t.Fatal("Bad session timer headers in refresh")
+    }
+    st.recvResponse(req.GenResponse(200, "OK", nil, nil))
+    if ua.SessionTimerActive() {
+        t.Fatal("The session timer should be off")
+    }
+}
@@ -74,6 +74,9 @@ var sip_header_name_map = map[string]func(body string) ([]sippy_header.SipHeader
     "diversion"         : sippy_header.CreateSipDiversion,
     "require"           : sippy_header.CreateSipRequire,
     "supported"         : sippy_header.CreateSipSupported,
+    "session-expires"   : sippy_header.CreateSipSessionExpires,
+    "x"                 : sippy_header.CreateSipSessionExpires,
+    "min-se"            : sippy_header.CreateSipMinSE,
 }
 
 func ParseSipHeader(s string) ([]sippy_header.SipHeader, error) {
@@ -46,6 +46,7 @@ type sipMsg struct {
     startline           string
     vias                []*sippy_header.SipVia
     contacts            []*sippy_header.SipContact
+    contact_asterisk    bool
     to                  *sippy_header.SipTo
     from                *sippy_header.SipFrom
     cseq                *sippy_header.SipCSeq
@@ -75,6 +76,8 @@ type sipMsg struct {
     sip_h323_conf_id    *sippy_header.SipH323ConfId
     sip_require         []*sippy_header.SipRequire
     sip_supported       []*sippy_header.SipSupported
+    sip_session_expires *sippy_header.SipSessionExpires
+    sip_min_se          *sippy_header.SipMinSE
     config              sippy_conf.Config
 }
 
@@ -139,6 +142,8 @@ func ParseSipMsg(_buf []byte, rtime *sippy_time.MonoTime, config sippy_conf.Conf
         for _, header := range headers {
             if contact, ok := header.(*sippy_header.SipContact); ok {
                 if contact.Asterisk {
+                    // Only meaningful in REGISTER, keep it off the contact list
+                    self.contact_asterisk = true
                     continue
                 }
             }
@@ -235,6 +240,10 @@ func (self *sipMsg) AppendHeader(hdr sippy_header.SipHeader) {
         self.sip_require = append(self.sip_require, t)
     case *sippy_header.SipSupported:
         self.sip_supported = append(self.sip_supported, t)
+    case *sippy_header.SipSessionExpires:
+        self.sip_session_expires = t
+    case *sippy_header.SipMinSE:
+        self.sip_min_se = t
     case nil:
         return
     }
@@ -511,6 +520,28 @@ func (self *sipMsg) GetSipWWWAuthenticate() *sippy_header.SipWWWAuthenticate {
     return self.sip_www_authenticate
 }
 
+// The server may offer several challenges, e.g. one per digest
+// algorithm.
+func (self *sipMsg) GetSipWWWAuthenticates() []*sippy_header.SipWWWAuthenticate {
+    ret := []*sippy_header.SipWWWAuthenticate{}
+    for _, hf := range self.headers {
+        if t, ok := hf.(*sippy_header.SipWWWAuthenticate); ok {
+            ret = append(ret, t)
+        }
+    }
+    return ret
+}
+
+func (self *sipMsg) GetSipProxyAuthenticates() []*sippy_header.SipProxyAuthenticate {
+    ret := []*sippy_header.SipProxyAuthenticate{}
+    for _, hf := range self.headers {
+        if t, ok := hf.(*sippy_header.SipProxyAuthenticate); ok {
+            ret = append(ret, t)
+        }
+    }
+    return ret
+}
+
 func (self *sipMsg) GetTo() *sippy_header.SipTo {
     return self.to
 }
@@ -563,6 +594,11 @@ func (self *sipMsg) GetContacts() []*sippy_header.SipContact {
     return self.contacts
 }
 
+// Tells whether the message had the "Contact: *" header.
+func (self *sipMsg) HasContactAsterisk() bool {
+    return self.contact_asterisk
+}
+
 func (self *sipMsg) GetRecordRoutes() []*sippy_header.SipRecordRoute {
     return self.record_routes
 }
@@ -646,3 +682,11 @@ func (self *sipMsg) GetSipRequire() []*sippy_header.SipRequire {
 func (self *sipMsg) GetSipSupported() []*sippy_header.SipSupported {
     return self.sip_supported
 }
+
+func (self *sipMsg) GetSessionExpires() *sippy_header.SipSessionExpires {
+    return self.sip_session_expires
+}
+
+func (self *sipMsg) GetMinSE() *sippy_header.SipMinSE {
+    return self.sip_min_se
+}
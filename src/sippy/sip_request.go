@@ -237,6 +237,27 @@ func (self *sipRequest) GetExpires() *sippy_header.SipExpires {
     return self.expires
 }
 
+// GetTargetURL returns the URL the request is to be delivered to, that
+// is the topmost Route if any or the Request-URI otherwise.
+func (self *sipRequest) GetTargetURL() *sippy_header.SipURL {
+    if len(self.routes) > 0 {
+        if r0, err := self.routes[0].GetBody(self.config); err == nil {
+            return r0.GetUrl()
+        }
+    }
+    return self.ruri
+}
+
+// GetTargetProto returns the transport protocol to be used to deliver
+// the request.
+func (self *sipRequest) GetTargetProto() string {
+    url := self.GetTargetURL()
+    if url == nil {
+        return "udp"
+    }
+    return url.GetProto()
+}
+
 func (self *sipRequest) GetNated() bool {
     return self.nated
 }
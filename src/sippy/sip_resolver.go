@@ -0,0 +1,250 @@
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2015 Sippy Software, Inc. All rights reserved.
+// Copyright (c) 2015 Andrii Pylypenko. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package sippy
+
+import (
+    "errors"
+    "math/rand"
+    "sort"
+    "strconv"
+    "strings"
+
+    "sippy/headers"
+    "sippy/net"
+)
+
+// SipTarget is the transport and the address to send the request to.
+type SipTarget struct {
+    Proto       string
+    Address     *sippy_net.HostPort
+}
+
+var naptr_services = map[string]string{
+    "SIP+D2U"   : "udp",
+    "SIP+D2T"   : "tcp",
+    "SIPS+D2T"  : "tls",
+    "SIP+D2W"   : "ws",
+    "SIPS+D2W"  : "wss",
+}
+
+var srv_prefixes = map[string]string{
+    "udp"       : "_sip._udp.",
+    "tcp"       : "_sip._tcp.",
+    "tls"       : "_sips._tcp.",
+}
+
+func defaultPortFor(proto string) string {
+    if proto == "tls" || proto == "wss" {
+        return "5061"
+    }
+    return "5060"
+}
+
+// ResolveSipURL locates the servers for the URL as RFC 3263 section 4
+// describes. Only the transports listed in the protos are considered.
+// The targets are returned in the order they should be tried in.
+func ResolveSipURL(resolver sippy_net.DnsResolver, url *sippy_header.SipURL, protos []string) ([]*SipTarget, error) {
+    port := ""
+    if url.Port != nil {
+        port = url.Port.String()
+    }
+    proto := strings.ToLower(url.GetTransport())
+    secure := url.GetScheme() == "sips"
+    if proto != "" || secure {
+        proto = url.GetProto()
+    }
+    return ResolveSipHost(resolver, url.Host.String(), port, proto, secure, protos)
+}
+
+// ResolveSipHost does the RFC 3263 lookups for the host. The port and
+// the proto are empty when not known.
+func ResolveSipHost(resolver sippy_net.DnsResolver, host, port, proto string, secure bool, protos []string) ([]*SipTarget, error) {
+    if proto != "" && ! hasProto(protos, proto) {
+        return nil, errors.New("SIP transport is not enabled: " + proto)
+    }
+    if ip := sippy_net.NewMyAddress(host).ParseIP(); ip != nil || port != "" {
+        // Numeric IP or explicit port: no NAPTR/SRV lookups
+        if proto == "" {
+            proto = "udp"
+            if secure {
+                proto = "tls"
+            }
+        }
+        if port == "" {
+            port = defaultPortFor(proto)
+        }
+        if ip != nil {
+            return []*SipTarget{ &SipTarget{ Proto : proto, Address : sippy_net.NewHostPort(host, port) } }, nil
+        }
+        return resolveAddresses(resolver, host, port, proto)
+    }
+    if proto == "" {
+        ret := resolveNAPTR(resolver, host, secure, protos)
+        if len(ret) > 0 {
+            return ret, nil
+        }
+        // No usable NAPTR records, try SRV for every transport we have
+        for _, proto := range protos {
+            if secure && proto != "tls" {
+                continue
+            }
+            ret = append(ret, resolveSRV(resolver, host, proto)...)
+        }
+        if len(ret) > 0 {
+            return ret, nil
+        }
+        proto = "udp"
+        if secure {
+            proto = "tls"
+        }
+    } else if ret := resolveSRV(resolver, host, proto); len(ret) > 0 {
+        return ret, nil
+    }
+    return resolveAddresses(resolver, host, defaultPortFor(proto), proto)
+}
+
+func hasProto(protos []string, proto string) bool {
+    for _, it := range protos {
+        if it == proto {
+            return true
+        }
+    }
+    return false
+}
+
+func resolveNAPTR(resolver sippy_net.DnsResolver, host string, secure bool, protos []string) []*SipTarget {
+    naptrs, err := resolver.LookupNAPTR(host)
+    if err != nil {
+        return nil
+    }
+    usable := []*sippy_net.DnsNAPTR{}
+    for _, naptr := range naptrs {
+        if ! strings.EqualFold(naptr.Flags, "s") {
+            continue
+        }
+        service := strings.ToUpper(naptr.Service)
+        proto, ok := naptr_services[service]
+        if ! ok || ! hasProto(protos, proto) || (secure && ! strings.HasPrefix(service, "SIPS+")) {
+            continue
+        }
+        usable = append(usable, naptr)
+    }
+    sort.SliceStable(usable, func(i, j int) bool {
+        if usable[i].Order != usable[j].Order {
+            return usable[i].Order < usable[j].Order
+        }
+        return usable[i].Preference < usable[j].Preference
+    })
+    ret := []*SipTarget{}
+    for _, naptr := range usable {
+        proto := naptr_services[strings.ToUpper(naptr.Service)]
+        ret = append(ret, resolveSRVName(resolver, naptr.Replacement, proto)...)
+    }
+    return ret
+}
+
+func resolveSRV(resolver sippy_net.DnsResolver, host, proto string) []*SipTarget {
+    prefix, ok := srv_prefixes[proto]
+    if ! ok {
+        return nil
+    }
+    return resolveSRVName(resolver, prefix + host, proto)
+}
+
+func resolveSRVName(resolver sippy_net.DnsResolver, name, proto string) []*SipTarget {
+    srvs, err := resolver.LookupSRV(name)
+    if err != nil {
+        return nil
+    }
+    ret := []*SipTarget{}
+    for _, srv := range orderSRV(srvs) {
+        if srv.Target == "." || srv.Target == "" {
+            // the service is decidedly not available
+            continue
+        }
+        targets, err := resolveAddresses(resolver, srv.Target, strconv.Itoa(int(srv.Port)), proto)
+        if err == nil {
+            ret = append(ret, targets...)
+        }
+    }
+    return ret
+}
+
+// Order the SRV records by priority and by the weighted random
+// selection within the same priority as RFC 2782 describes.
+func orderSRV(srvs []*sippy_net.DnsSRV) []*sippy_net.DnsSRV {
+    tmp := make([]*sippy_net.DnsSRV, len(srvs))
+    copy(tmp, srvs)
+    sort.SliceStable(tmp, func(i, j int) bool { return tmp[i].Priority < tmp[j].Priority })
+    ret := make([]*sippy_net.DnsSRV, 0, len(srvs))
+    for len(tmp) > 0 {
+        n := 1
+        for n < len(tmp) && tmp[n].Priority == tmp[0].Priority {
+            n++
+        }
+        group := tmp[:n]
+        tmp = tmp[n:]
+        sort.SliceStable(group, func(i, j int) bool { return group[i].Weight == 0 && group[j].Weight != 0 })
+        for len(group) > 0 {
+            total := 0
+            for _, srv := range group {
+                total += int(srv.Weight)
+            }
+            idx := 0
+            if total > 0 {
+                r := rand.Intn(total + 1)
+                for sum := 0; idx < len(group); idx++ {
+                    sum += int(group[idx].Weight)
+                    if sum >= r {
+                        break
+                    }
+                }
+                if idx == len(group) {
+                    idx = len(group) - 1
+                }
+            }
+            ret = append(ret, group[idx])
+            group = append(group[:idx:idx], group[idx + 1:]...)
+        }
+    }
+    return ret
+}
+
+func resolveAddresses(resolver sippy_net.DnsResolver, host, port, proto string) ([]*SipTarget, error) {
+    ips, err := resolver.LookupIP(host)
+    if err != nil {
+        return nil, err
+    }
+    if len(ips) == 0 {
+        return nil, errors.New("Cannot resolve " + host)
+    }
+    ret := make([]*SipTarget, len(ips))
+    for i, ip := range ips {
+        ret[i] = &SipTarget{ Proto : proto, Address : sippy_net.NewHostPort(ip.IP.String(), port) }
+    }
+    return ret, nil
+}
@@ -0,0 +1,238 @@
+package sippy
+
+import (
+    "net"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "sippy/conf"
+    "sippy/headers"
+    "sippy/log"
+    "sippy/net"
+    "sippy/types"
+)
+
+type test_dns_zone struct {
+    naptr   map[string][]*sippy_net.DnsNAPTR
+    srv     map[string][]*sippy_net.DnsSRV
+    ip      map[string][]*sippy_net.DnsIP
+}
+
+func newTestDnsZone() *test_dns_zone {
+    return &test_dns_zone{
+        naptr   : make(map[string][]*sippy_net.DnsNAPTR),
+        srv     : make(map[string][]*sippy_net.DnsSRV),
+        ip      : make(map[string][]*sippy_net.DnsIP),
+    }
+}
+
+func (self *test_dns_zone) addNAPTR(name string, order uint16, service, replacement string) {
+    self.naptr[name] = append(self.naptr[name], &sippy_net.DnsNAPTR{ Order : order, Flags : "s", Service : service, Replacement : replacement })
+}
+
+func (self *test_dns_zone) addSRV(name string, prio uint16, port uint16, target string) {
+    self.srv[name] = append(self.srv[name], &sippy_net.DnsSRV{ Priority : prio, Port : port, Target : target })
+}
+
+func (self *test_dns_zone) addIP(name string, ip string) {
+    self.ip[name] = append(self.ip[name], &sippy_net.DnsIP{ IP : net.ParseIP(ip) })
+}
+
+func (self *test_dns_zone) LookupNAPTR(name string) ([]*sippy_net.DnsNAPTR, error) {
+    return self.naptr[name], nil
+}
+
+func (self *test_dns_zone) LookupSRV(name string) ([]*sippy_net.DnsSRV, error) {
+    return self.srv[name], nil
+}
+
+func (self *test_dns_zone) LookupIP(name string) ([]*sippy_net.DnsIP, error) {
+    return self.ip[name], nil
+}
+
+func newTestZone() *test_dns_zone {
+    zone := newTestDnsZone()
+    zone.addNAPTR("example.com", 20, "SIP+D2U", "_sip._udp.example.com")
+    zone.addNAPTR("example.com", 10, "SIPS+D2T", "_sips._tcp.example.com")
+    zone.addSRV("_sip._udp.example.com", 20, 5080, "b.example.com")
+    zone.addSRV("_sip._udp.example.com", 10, 5070, "a.example.com")
+    zone.addSRV("_sips._tcp.example.com", 10, 5061, "a.example.com")
+    zone.addIP("a.example.com", "10.0.0.1")
+    zone.addIP("b.example.com", "10.0.0.2")
+    zone.addSRV("_sip._tcp.example.net", 10, 5090, "c.example.net")
+    zone.addIP("c.example.net", "10.0.0.3")
+    zone.addIP("example.org", "10.0.0.4")
+    return zone
+}
+
+func targetsString(targets []*SipTarget) string {
+    s := []string{}
+    for _, t := range targets {
+        s = append(s, t.Proto + ":" + t.Address.String())
+    }
+    return strings.Join(s, ",")
+}
+
+func Test_Rfc3263Resolve(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    zone := newTestZone()
+    for _, tc := range []struct {
+        url         string
+        protos      []string
+        expected    string
+    } {
+        { "sip:bob@example.com", []string{ "udp" }, "udp:10.0.0.1:5070,udp:10.0.0.2:5080" },
+        { "sip:bob@example.com", []string{ "udp", "tls" }, "tls:10.0.0.1:5061,udp:10.0.0.1:5070,udp:10.0.0.2:5080" },
+        { "sips:bob@example.com", []string{ "udp", "tls" }, "tls:10.0.0.1:5061" },
+        { "sip:bob@example.com;transport=udp", []string{ "udp", "tls" }, "udp:10.0.0.1:5070,udp:10.0.0.2:5080" },
+        { "sip:bob@a.example.com:5090", []string{ "udp" }, "udp:10.0.0.1:5090" },
+        { "sip:bob@example.net", []string{ "udp", "tcp" }, "tcp:10.0.0.3:5090" },
+        { "sip:bob@example.org", []string{ "udp", "tcp" }, "udp:10.0.0.4:5060" },
+        { "sip:bob@192.168.0.1", []string{ "udp" }, "udp:192.168.0.1:5060" },
+    } {
+        url, err := sippy_header.ParseSipURL(tc.url, false, config)
+        if err != nil {
+            t.Fatal(err)
+        }
+        targets, err := ResolveSipURL(zone, url, tc.protos)
+        if err != nil {
+            t.Fatalf("%s: %s", tc.url, err.Error())
+        }
+        if res := targetsString(targets); res != tc.expected {
+            t.Fatalf("%s: expected %s, got %s", tc.url, tc.expected, res)
+        }
+    }
+    url, _ := sippy_header.ParseSipURL("sip:bob@example.net;transport=tcp", false, config)
+    if _, err := ResolveSipURL(zone, url, []string{ "udp" }); err == nil {
+        t.Fatal("disabled transport has been selected")
+    }
+}
+
+func Test_Rfc3263Failover(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetSipAddress(sippy_net.NewMyAddress("127.0.0.1"))
+    config.SetSipPort(config.GetMyPort())
+    config.SetDnsResolver(newTestZone())
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    cmap := NewTestCallMap(config)
+    sip_tm, err := NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go sip_tm.Run()
+    defer sip_tm.Shutdown()
+
+    ruri, _ := sippy_header.ParseSipURL("sip:bob@example.com", false, config)
+    req, err := NewSipRequest("OPTIONS", ruri, "", nil, nil, nil, 1, nil, nil, nil, nil, nil, nil, nil, nil, nil, config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    lock := new(sync.Mutex)
+    lock.Lock()
+    tr, err := sip_tm.CreateClientTransaction(req, nil, lock, nil, nil, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sip_tm.BeginClientTransaction(req, tr)
+    lock.Unlock()
+    tfactory.get()
+    ct := tr.(*clientTransaction)
+
+    lock.Lock()
+    assertStringEqual(ct.address.String(), "10.0.0.1:5070", t)
+    branch1 := ct.tid.Branch
+    ct.timerB()
+    lock.Unlock()
+    assertStringEqual(ct.address.String(), "10.0.0.2:5080", t)
+    if ct.tid.Branch == branch1 {
+        t.Fatal("the branch has not been changed on failover")
+    }
+    if ! strings.Contains(string(tfactory.get()), ct.tid.Branch) {
+        t.Fatal("the request has not been resent with the new branch")
+    }
+    lock.Lock()
+    ct.timerB()
+    lock.Unlock()
+    if ct.state != TERMINATED {
+        t.Fatal("the transaction has not been terminated after the last target failed")
+    }
+}
+
+// The zone that holds the lookups until released
+type test_slow_zone struct {
+    *test_dns_zone
+    gate    chan struct{}
+}
+
+func (self *test_slow_zone) LookupNAPTR(name string) ([]*sippy_net.DnsNAPTR, error) {
+    <-self.gate
+    return self.test_dns_zone.LookupNAPTR(name)
+}
+
+type test_resp_receiver struct {
+    resps   chan sippy_types.SipResponse
+}
+
+func (self *test_resp_receiver) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+    self.resps <- resp
+}
+
+func Test_Rfc3263Async(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetSipAddress(sippy_net.NewMyAddress("127.0.0.1"))
+    config.SetSipPort(config.GetMyPort())
+    zone := &test_slow_zone{ test_dns_zone : newTestZone(), gate : make(chan struct{}) }
+    config.SetDnsResolver(zone)
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    cmap := NewTestCallMap(config)
+    sip_tm, err := NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go sip_tm.Run()
+    defer sip_tm.Shutdown()
+
+    receiver := &test_resp_receiver{ resps : make(chan sippy_types.SipResponse, 1) }
+    send := func(ruri_str string) {
+        ruri, _ := sippy_header.ParseSipURL(ruri_str, false, config)
+        req, err := NewSipRequest("OPTIONS", ruri, "", nil, nil, nil, 1, nil, nil, nil, nil, nil, nil, nil, nil, nil, config)
+        if err != nil {
+            t.Fatal(err)
+        }
+        lock := new(sync.Mutex)
+        lock.Lock()
+        defer lock.Unlock()
+        tr, err := sip_tm.CreateClientTransaction(req, receiver, lock, nil, nil, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        sip_tm.BeginClientTransaction(req, tr)
+    }
+    // The lookup must not block the caller
+    send("sip:bob@example.com")
+    select {
+    case <-tfactory.data_ch:
+        t.Fatal("the request has been sent before the target is located")
+    case <-time.After(100 * time.Millisecond):
+    }
+    close(zone.gate)
+    data := string(tfactory.get())
+    if ! strings.HasPrefix(data, "OPTIONS sip:bob@example.com SIP/2.0") {
+        t.Fatalf("unexpected request: %q", data)
+    }
+
+    // The target that can not be located
+    send("sip:bob@nowhere.example")
+    select {
+    case resp := <-receiver.resps:
+        if resp.GetSCodeNum() != 503 {
+            t.Fatalf("503 expected, got %d", resp.GetSCodeNum())
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("no response for the target that can not be located")
+    }
+}
@@ -340,6 +340,23 @@ func (self *sipTransactionManager) process_request(rtime *sippy_time.MonoTime, d
             req.nated = true
         }
     }
+    if len(req.contacts) > 0 && !req.contacts[0].Asterisk && isWebSocket(server) {
+        // The WebSocket clients cannot be reached at the .invalid address
+        // they put into the Contact (RFC 7118 section 5.2), point it to the
+        // connection the request has arrived over.
+        var contact *sippy_header.SipAddress
+
+        contact, err = req.contacts[0].GetBody(self.config)
+        if err == nil && strings.HasSuffix(strings.ToLower(contact.GetUrl().Host.String()), ".invalid") {
+            curl := contact.GetUrl()
+            curl.Host = sippy_net.NewMyAddress(address.Host.String())
+            curl.Port = sippy_net.NewMyPort(address.Port.String())
+            if server.GetProto() == "wss" {
+                curl.SetScheme("sips")
+            }
+            curl.SetTransport("ws")
+        }
+    }
     host, port := address.Host.String(), address.Port.String()
     req.source = sippy_net.NewHostPort(host, port)
     self.incomingRequest(req, checksum, tids, server, data)
@@ -355,20 +372,16 @@ func (self *sipTransactionManager) CreateClientTransaction(req sippy_types.SipRe
         return nil, errors.New("BUG: Attempt to initiate transaction from terminated dialog!!!")
     }
     target := req.GetTarget()
+    if userv == nil && target.ParseIP() == nil {
+        return self.createResolvingTransaction(req, resp_receiver, session_lock, laddress, req_out_cb)
+    }
     if userv == nil {
-        var uv sippy_net.Transport
-        if laddress != nil {
-            uv = self.l4r.getServer(laddress, /*is_local =*/ true)
-        }
-        if uv == nil {
-            uv = self.l4r.getServer(target, /*is_local =*/ false)
-        }
-        if uv != nil {
-            userv = uv
+        if userv, err = self.getTransport(req.GetTargetProto(), target, laddress); err != nil {
+            return nil, err
         }
     }
-    if userv == nil {
-        return nil, errors.New("BUG: cannot get userv from local4remote!!!")
+    if err = self.setLocalTransport(req, userv); err != nil {
+        return nil, err
     }
     tid, err = req.GetTId(true /*wCSM*/, true/*wBRN*/, false /*wTTG*/)
     if err != nil {
@@ -382,6 +395,7 @@ func (self *sipTransactionManager) CreateClientTransaction(req sippy_types.SipRe
     data := []byte(req.LocalStr(userv.GetLAddress(), false /* compact */))
     t, err = NewClientTransactionObj(req, tid, userv, data, self, resp_receiver, session_lock, target, req_out_cb)
     if err != nil {
+        self.tclient_lock.Unlock()
         return nil, err
     }
     self.tclient[*tid] = t
@@ -389,6 +403,138 @@ func (self *sipTransactionManager) CreateClientTransaction(req sippy_types.SipRe
     return t, nil
 }
 
+// Create the transaction towards the target that has to be located in
+// the DNS first. The lookups are done in the background so that the
+// caller holding the session lock is not blocked. The request goes out
+// once the target has been located.
+func (self *sipTransactionManager) createResolvingTransaction(req sippy_types.SipRequest, resp_receiver sippy_types.ResponseReceiver, session_lock sync.Locker, laddress *sippy_net.HostPort, req_out_cb func(sippy_types.SipRequest)) (sippy_types.ClientTransaction, error) {
+    locate := self.targetLocator(req, req.GetTarget())
+    tid, err := req.GetTId(true /*wCSM*/, true/*wBRN*/, false /*wTTG*/)
+    if err != nil {
+        return nil, err
+    }
+    self.tclient_lock.Lock()
+    if _, ok := self.tclient[*tid]; ok {
+        self.tclient_lock.Unlock()
+        return nil, errors.New("BUG: Attempt to initiate transaction with the same TID as existing one!!!")
+    }
+    t, err := NewClientTransactionObj(req, tid, nil, nil, self, resp_receiver, session_lock, req.GetTarget(), req_out_cb)
+    if err != nil {
+        self.tclient_lock.Unlock()
+        return nil, err
+    }
+    t.resolving = true
+    t.setFailover(req, nil)
+    self.tclient[*tid] = t
+    self.tclient_lock.Unlock()
+    go func() {
+        targets, err := locate()
+        t.lock.Lock()
+        defer t.lock.Unlock()
+        t.located(targets, laddress, err)
+    }()
+    return t, nil
+}
+
+// Returns the function that finds the next hops for the request the way
+// RFC 3263 describes. Everything it needs is taken out of the request
+// beforehand as the lookups run without the session lock held.
+func (self *sipTransactionManager) targetLocator(req sippy_types.SipRequest, target *sippy_net.HostPort) func() ([]*SipTarget, error) {
+    resolver := self.config.GetDnsResolver()
+    protos := self.l4r.getOutboundProtos()
+    host := target.Host.String()
+    var resolve func() ([]*SipTarget, error)
+    if url := req.GetTargetURL(); url != nil && strings.EqualFold(url.Host.String(), host) {
+        url = url.GetCopy()
+        resolve = func() ([]*SipTarget, error) { return ResolveSipURL(resolver, url, protos) }
+    } else {
+        // The target is not taken from the URL (i.e. outbound proxy),
+        // so its port is known.
+        port, proto := target.Port.String(), req.GetTargetProto()
+        resolve = func() ([]*SipTarget, error) { return ResolveSipHost(resolver, host, port, proto, false, protos) }
+    }
+    return func() ([]*SipTarget, error) {
+        targets, err := resolve()
+        if err != nil {
+            return nil, err
+        }
+        if len(targets) == 0 {
+            return nil, errors.New("Cannot locate SIP server for " + host)
+        }
+        return targets, nil
+    }
+}
+
+// Get the transport to send the message to the target over.
+func (self *sipTransactionManager) getTransport(proto string, target, laddress *sippy_net.HostPort) (sippy_net.Transport, error) {
+    var userv sippy_net.Transport
+
+    if proto != "udp" {
+        return self.l4r.getStreamConnection(proto, target)
+    }
+    if laddress != nil {
+        userv = self.l4r.getServer(laddress, /*is_local =*/ true)
+    }
+    if userv == nil {
+        userv = self.l4r.getServer(target, /*is_local =*/ false)
+    }
+    if userv == nil {
+        return nil, errors.New("BUG: cannot get userv from local4remote!!!")
+    }
+    return userv, nil
+}
+
+// Make the topmost Via and our own Contacts to match the transport
+// the request is going to be sent over.
+func (self *sipTransactionManager) setLocalTransport(req sippy_types.SipRequest, userv sippy_net.Transport) error {
+    if len(req.GetVias()) > 0 {
+        via0, err := req.GetVias()[0].GetBody()
+        if err != nil {
+            return err
+        }
+        via0.SetTransport(userv.GetProto())
+        if userv.IsReliable() {
+            via0.SetPort(userv.GetLAddress().Port)
+        }
+    }
+    self.setLocalProto(req, userv)
+    return nil
+}
+
+// Restart the client transaction towards the next target after the
+// current one has failed to respond (RFC 3263 section 4.3).
+func (self *sipTransactionManager) failover(t *clientTransaction, req sippy_types.SipRequest, target *SipTarget) error {
+    via0, err := req.GetVias()[0].GetBody()
+    if err != nil {
+        return err
+    }
+    via0.GenBranch()
+    return self.retarget(t, req, target, nil)
+}
+
+// Point the client transaction and its request to the target.
+func (self *sipTransactionManager) retarget(t *clientTransaction, req sippy_types.SipRequest, target *SipTarget, laddress *sippy_net.HostPort) error {
+    userv, err := self.getTransport(target.Proto, target.Address, laddress)
+    if err != nil {
+        return err
+    }
+    if err = self.setLocalTransport(req, userv); err != nil {
+        return err
+    }
+    req.SetTarget(target.Address)
+    tid, err := req.GetTId(true /*wCSM*/, true/*wBRN*/, false /*wTTG*/)
+    if err != nil {
+        return err
+    }
+    if err = t.retarget(req, tid, userv, target.Address, []byte(req.LocalStr(userv.GetLAddress(), false /* compact */))); err != nil {
+        return err
+    }
+    self.tclient_lock.Lock()
+    self.tclient[*tid] = t
+    self.tclient_lock.Unlock()
+    return nil
+}
+
 func (self *sipTransactionManager) BeginClientTransaction(req sippy_types.SipRequest, tr sippy_types.ClientTransaction) {
     tr.StartTimers()
     tr.BeforeRequestSent(req)
@@ -668,6 +814,50 @@ func (self *sipTransactionManager) transmitDataWithCb(userv sippy_net.Transport,
     }
 }
 
+func isWebSocket(userv sippy_net.Transport) bool {
+    return userv.GetProto() == "ws" || userv.GetProto() == "wss"
+}
+
+// Make our own Contacts and Record-Routes advertise the reliable
+// transport and port the message is sent over, so that the remote side
+// reuses them for the dialog.
+func (self *sipTransactionManager) setLocalProto(msg sippy_types.SipMsg, userv sippy_net.Transport) {
+    if ! userv.IsReliable() {
+        return
+    }
+    addrs := []*sippy_header.SipAddress{}
+    for _, contact := range msg.GetContacts() {
+        if contact.Asterisk {
+            continue
+        }
+        if addr, err := contact.GetBody(self.config); err == nil {
+            addrs = append(addrs, addr)
+        }
+    }
+    for _, rr := range msg.GetRecordRoutes() {
+        if addr, err := rr.GetBody(self.config); err == nil {
+            addrs = append(addrs, addr)
+        }
+    }
+    for _, addr := range addrs {
+        url := addr.GetUrl()
+        if ! url.Host.IsSystemDefault() {
+            continue
+        }
+        switch userv.GetProto() {
+        case "tls":
+            url.SetScheme("sips")
+            url.SetTransport("")
+        case "wss":
+            url.SetScheme("sips")
+            url.SetTransport("ws")
+        default:
+            url.SetTransport(userv.GetProto())
+        }
+        url.Port = userv.GetLAddress().Port
+    }
+}
+
 func (self *sipTransactionManager) logError(msg string) {
     self.config.ErrorLogger().Error(msg)
 }
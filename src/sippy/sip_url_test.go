@@ -0,0 +1,33 @@
+package sippy
+
+import (
+    "testing"
+
+    "sippy/conf"
+    "sippy/headers"
+    "sippy/log"
+)
+
+func Test_SipURLGetAddr(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    for _, tc := range []struct {
+        url         string
+        addr        string
+    }{
+        { "sip:bob@192.0.2.1", "192.0.2.1:5060" },
+        { "sip:bob@192.0.2.1;transport=tcp", "192.0.2.1:5060" },
+        { "sips:bob@192.0.2.1", "192.0.2.1:5061" },
+        { "sip:bob@192.0.2.1;transport=tls", "192.0.2.1:5061" },
+        { "sip:bob@192.0.2.1;transport=TLS", "192.0.2.1:5061" },
+        { "sip:bob@192.0.2.1:5070;transport=tls", "192.0.2.1:5070" },
+        { "sips:bob@192.0.2.1:5080", "192.0.2.1:5080" },
+    } {
+        url, err := sippy_header.ParseSipURL(tc.url, false, config)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if addr := url.GetAddr(config).String(); addr != tc.addr {
+            t.Errorf("%s: expected %s, got %s", tc.url, tc.addr, addr)
+        }
+    }
+}
@@ -0,0 +1,529 @@
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2015 Sippy Software, Inc. All rights reserved.
+// Copyright (c) 2015 Andrii Pylypenko. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package sippy
+
+import (
+    "bufio"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "sippy/conf"
+    "sippy/log"
+    "sippy/net"
+    "sippy/time"
+    "sippy/utils"
+)
+
+const (
+    TCP_MAX_MSG_SIZE = 65536
+    TCP_CONNECT_TIMEOUT = 5 * time.Second
+)
+
+type tcpServerOpts struct {
+    laddress        *sippy_net.HostPort
+    data_callback   sippy_net.DataPacketReceiver
+    idle_timeout    time.Duration
+    tls_config      *tls.Config
+}
+
+func NewTcpServerOpts(laddress *sippy_net.HostPort, data_callback sippy_net.DataPacketReceiver) *tcpServerOpts {
+    return &tcpServerOpts{
+        laddress        : laddress,
+        data_callback   : data_callback,
+        idle_timeout    : 300 * time.Second,
+    }
+}
+
+type TcpServer struct {
+    topts           tcpServerOpts
+    proto           string
+    network         string
+    listener        net.Listener
+    logger          sippy_log.ErrorLogger
+    conns           map[string]*tcpConnection
+    conns_lock      sync.Mutex
+    shutdown_chan   chan struct{}
+    wg              sync.WaitGroup
+}
+
+func NewTcpServer(config sippy_conf.Config, topts *tcpServerOpts) (*TcpServer, error) {
+    network := "tcp4"
+    if ip := topts.laddress.ParseIP(); ip != nil && ! sippy_net.IsIP4(ip) {
+        network = "tcp6"
+    }
+    listener, err := net.Listen(network, topts.laddress.String())
+    if err != nil {
+        return nil, err
+    }
+    proto := "tcp"
+    if topts.tls_config != nil {
+        listener = tls.NewListener(listener, topts.tls_config)
+        proto = "tls"
+    }
+    self := &TcpServer{
+        topts           : *topts,
+        proto           : proto,
+        network         : network,
+        listener        : listener,
+        logger          : config.ErrorLogger(),
+        conns           : make(map[string]*tcpConnection),
+        shutdown_chan   : make(chan struct{}),
+    }
+    self.wg.Add(2)
+    go self.runAccept()
+    go self.runReaper()
+    return self, nil
+}
+
+func (self *TcpServer) runAccept() {
+    defer self.wg.Done()
+    for {
+        conn, err := self.listener.Accept()
+        if err != nil {
+            select {
+            case <-self.shutdown_chan:
+                return
+            default:
+            }
+            self.logger.Error("TcpServer: accept failed: " + err.Error())
+            time.Sleep(10 * time.Millisecond)
+            continue
+        }
+        raddress, err := sippy_net.NewHostPortFromAddr(conn.RemoteAddr())
+        if err != nil {
+            conn.Close()
+            continue
+        }
+        lhost, _, err := net.SplitHostPort(conn.LocalAddr().String())
+        if err != nil {
+            conn.Close()
+            continue
+        }
+        laddress := sippy_net.NewHostPort(lhost, self.topts.laddress.Port.String())
+        tconn := newTcpConnection(self, conn, laddress, raddress, "")
+        if ! self.putConnection(tconn) {
+            conn.Close()
+            continue
+        }
+        go tconn.runReader()
+        go tconn.runWriter()
+    }
+}
+
+// Close connections that carried no traffic for longer than the idle timeout.
+func (self *TcpServer) runReaper() {
+    defer self.wg.Done()
+    ival := self.topts.idle_timeout / 4
+    if ival < time.Second {
+        ival = time.Second
+    }
+    ticker := time.NewTicker(ival)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-self.shutdown_chan:
+            return
+        case <-ticker.C:
+        }
+        if self.topts.idle_timeout <= 0 {
+            continue
+        }
+        idle := []*tcpConnection{}
+        self.conns_lock.Lock()
+        for _, conn := range self.conns {
+            if conn.idleTime() > self.topts.idle_timeout {
+                idle = append(idle, conn)
+            }
+        }
+        self.conns_lock.Unlock()
+        for _, conn := range idle {
+            conn.close()
+        }
+    }
+}
+
+func (self *TcpServer) putConnection(conn *tcpConnection) bool {
+    self.conns_lock.Lock()
+    defer self.conns_lock.Unlock()
+    select {
+    case <-self.shutdown_chan:
+        return false
+    default:
+    }
+    if old, ok := self.conns[conn.raddress.String()]; ok {
+        go old.close()
+    }
+    self.conns[conn.raddress.String()] = conn
+    return true
+}
+
+func (self *TcpServer) delConnection(conn *tcpConnection) {
+    self.conns_lock.Lock()
+    defer self.conns_lock.Unlock()
+    if it, ok := self.conns[conn.raddress.String()]; ok && it == conn {
+        delete(self.conns, conn.raddress.String())
+    }
+}
+
+func (self *TcpServer) GetConnection(address *sippy_net.HostPort) (sippy_net.Transport, error) {
+    return self.getConnection(address)
+}
+
+func (self *TcpServer) getConnection(address *sippy_net.HostPort) (*tcpConnection, error) {
+    raddr, err := net.ResolveTCPAddr(self.network, address.String())
+    if err != nil {
+        return nil, err
+    }
+    raddress, err := sippy_net.NewHostPortFromAddr(raddr)
+    if err != nil {
+        return nil, err
+    }
+    self.conns_lock.Lock()
+    conn, ok := self.conns[raddress.String()]
+    self.conns_lock.Unlock()
+    if ok {
+        return conn, nil
+    }
+    laddress := sippy_net.NewHostPort(self.localAddressFor(raddr), self.topts.laddress.Port.String())
+    conn = newTcpConnection(self, nil, laddress, raddress, address.Host.String())
+    self.conns_lock.Lock()
+    defer self.conns_lock.Unlock()
+    if it, ok := self.conns[raddress.String()]; ok {
+        // somebody has been faster than us
+        return it, nil
+    }
+    select {
+    case <-self.shutdown_chan:
+        return nil, errors.New("TcpServer: the transport is shut down")
+    default:
+    }
+    self.conns[raddress.String()] = conn
+    go conn.runWriter()
+    return conn, nil
+}
+
+// Find out the local address the connection to the raddr will originate from.
+func (self *TcpServer) localAddressFor(raddr *net.TCPAddr) string {
+    if ip := self.topts.laddress.ParseIP(); ip != nil && ! ip.IsUnspecified() {
+        return self.topts.laddress.Host.String()
+    }
+    c, err := net.DialUDP("udp", nil, &net.UDPAddr{ IP : raddr.IP, Port : raddr.Port, Zone : raddr.Zone })
+    if err != nil {
+        return self.topts.laddress.Host.String()
+    }
+    defer c.Close()
+    host, _, err := net.SplitHostPort(c.LocalAddr().String())
+    if err != nil {
+        return self.topts.laddress.Host.String() // should not happen
+    }
+    return host
+}
+
+func (self *TcpServer) SendTo(data []byte, hostport *sippy_net.HostPort) {
+    self.SendToWithCb(data, hostport, nil)
+}
+
+func (self *TcpServer) SendToWithCb(data []byte, hostport *sippy_net.HostPort, on_complete func()) {
+    conn, err := self.getConnection(hostport)
+    if err != nil {
+        self.logger.Errorf("TcpServer: Cannot connect to '%s', dropping outgoing SIP message: %s", hostport, err.Error())
+        return
+    }
+    conn.SendToWithCb(data, hostport, on_complete)
+}
+
+func (self *TcpServer) Shutdown() {
+    self.conns_lock.Lock()
+    close(self.shutdown_chan)
+    conns := self.conns
+    self.conns = make(map[string]*tcpConnection)
+    self.conns_lock.Unlock()
+    self.listener.Close()
+    for _, conn := range conns {
+        conn.close()
+    }
+    self.wg.Wait()
+}
+
+func (self *TcpServer) GetLAddress() *sippy_net.HostPort {
+    return self.topts.laddress
+}
+
+func (self *TcpServer) GetProto() string {
+    return self.proto
+}
+
+func (self *TcpServer) IsReliable() bool {
+    return true
+}
+
+type tcpConnection struct {
+    server      *TcpServer
+    conn        net.Conn
+    laddress    *sippy_net.HostPort
+    raddress    *sippy_net.HostPort
+    server_name string
+    wi          chan *write_req
+    lock        sync.Mutex
+    closed      bool
+    closed_chan chan struct{}
+    last_used   time.Time
+}
+
+func newTcpConnection(server *TcpServer, conn net.Conn, laddress, raddress *sippy_net.HostPort, server_name string) *tcpConnection {
+    return &tcpConnection{
+        server      : server,
+        conn        : conn,
+        laddress    : laddress,
+        raddress    : raddress,
+        server_name : strings.Trim(server_name, "[]"),
+        wi          : make(chan *write_req, 1000),
+        closed      : false,
+        closed_chan : make(chan struct{}),
+        last_used   : time.Now(),
+    }
+}
+
+func (self *tcpConnection) touch() {
+    self.lock.Lock()
+    self.last_used = time.Now()
+    self.lock.Unlock()
+}
+
+func (self *tcpConnection) idleTime() time.Duration {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    return time.Since(self.last_used)
+}
+
+func (self *tcpConnection) close() {
+    // Unlink the connection first so that nobody picks it up once it is
+    // marked closed.
+    self.server.delConnection(self)
+    self.lock.Lock()
+    if self.closed {
+        self.lock.Unlock()
+        return
+    }
+    self.closed = true
+    close(self.closed_chan)
+    conn := self.conn
+    self.lock.Unlock()
+    if conn != nil {
+        conn.Close()
+    }
+}
+
+func (self *tcpConnection) connect() bool {
+    var conn net.Conn
+    var err error
+
+    if tls_config := self.server.topts.tls_config; tls_config != nil {
+        // Verify the peer against the host name the connection was
+        // requested for, not against the resolved address.
+        tls_config = tls_config.Clone()
+        tls_config.ServerName = self.server_name
+        dialer := &net.Dialer{ Timeout : TCP_CONNECT_TIMEOUT }
+        conn, err = tls.DialWithDialer(dialer, self.server.network, self.raddress.String(), tls_config)
+    } else {
+        conn, err = net.DialTimeout(self.server.network, self.raddress.String(), TCP_CONNECT_TIMEOUT)
+    }
+    if err != nil {
+        self.server.logger.Errorf("TcpServer: Cannot connect to '%s': %s", self.raddress, err.Error())
+        self.close()
+        return false
+    }
+    self.lock.Lock()
+    if self.closed {
+        self.lock.Unlock()
+        conn.Close()
+        return false
+    }
+    self.conn = conn
+    self.lock.Unlock()
+    go self.runReader()
+    return true
+}
+
+func (self *tcpConnection) runWriter() {
+    if self.conn == nil && ! self.connect() {
+        return
+    }
+    for {
+        var wi *write_req
+        select {
+        case <-self.closed_chan:
+            return
+        case wi = <-self.wi:
+        }
+        if _, err := self.conn.Write(wi.data); err != nil {
+            self.server.logger.Errorf("TcpServer: Cannot send to '%s': %s", self.raddress, err.Error())
+            self.close()
+            return
+        }
+        self.touch()
+        if wi.on_complete != nil {
+            wi.on_complete()
+        }
+    }
+}
+
+func (self *tcpConnection) runReader() {
+    defer self.close()
+    reader := bufio.NewReader(self.conn)
+    for {
+        data, err := readSipStreamMessage(reader, self.pong)
+        if err != nil {
+            if err != io.EOF {
+                self.server.logger.Debugf("TcpServer: closing connection to '%s': %s", self.raddress, err.Error())
+            }
+            return
+        }
+        self.touch()
+        rtime, err := sippy_time.NewMonoTime()
+        if err != nil {
+            self.server.logger.Error("Cannot create MonoTime object")
+            continue
+        }
+        sippy_utils.SafeCall(func() { self.server.topts.data_callback(data, self.raddress, self, rtime) }, nil, self.server.logger)
+    }
+}
+
+// Reply to the RFC 5626 double CRLF keep-alive ping.
+func (self *tcpConnection) pong() {
+    self.SendTo([]byte("\r\n"), self.raddress)
+}
+
+func (self *tcpConnection) SendTo(data []byte, hostport *sippy_net.HostPort) {
+    self.SendToWithCb(data, hostport, nil)
+}
+
+func (self *tcpConnection) SendToWithCb(data []byte, hostport *sippy_net.HostPort, on_complete func()) {
+    self.lock.Lock()
+    if self.closed {
+        self.lock.Unlock()
+        // The connection has gone. Try to open a new one as
+        // RFC 3261 section 18.2.2 suggests.
+        self.server.SendToWithCb(data, hostport, on_complete)
+        return
+    }
+    self.last_used = time.Now()
+    self.lock.Unlock()
+    select {
+    case self.wi <- &write_req{ data : data, on_complete : on_complete }:
+    case <-self.closed_chan:
+    }
+}
+
+func (self *tcpConnection) Shutdown() {
+    self.close()
+}
+
+func (self *tcpConnection) GetLAddress() *sippy_net.HostPort {
+    return self.laddress
+}
+
+func (self *tcpConnection) GetProto() string {
+    return self.server.GetProto()
+}
+
+func (self *tcpConnection) IsReliable() bool {
+    return true
+}
+
+// Read a single SIP message from the stream using the Content-Length
+// header to find the message boundary.
+func readSipStreamMessage(reader *bufio.Reader, pong func()) ([]byte, error) {
+    buf := []byte{}
+    clen := -1
+    nempty := 0
+    for {
+        line, err := readStreamLine(reader, TCP_MAX_MSG_SIZE - len(buf))
+        if err != nil {
+            return nil, err
+        }
+        tline := strings.TrimRight(line, "\r\n")
+        if len(buf) == 0 && tline == "" {
+            // CRLF keep-alives between the messages
+            nempty++
+            if nempty == 2 && pong != nil {
+                pong()
+                nempty = 0
+            }
+            continue
+        }
+        buf = append(buf, line...)
+        if tline == "" {
+            break
+        }
+        if idx := strings.IndexByte(tline, ':'); idx > 0 {
+            name := strings.ToLower(strings.TrimSpace(tline[:idx]))
+            if name == "content-length" || name == "l" {
+                clen, err = strconv.Atoi(strings.TrimSpace(tline[idx + 1:]))
+                if err != nil || clen < 0 {
+                    return nil, errors.New("bad Content-Length: " + tline)
+                }
+            }
+        }
+    }
+    if clen < 0 {
+        return nil, errors.New("Content-Length is missing in the SIP message")
+    }
+    if len(buf) + clen > TCP_MAX_MSG_SIZE {
+        return nil, fmt.Errorf("SIP message body is too big: %d bytes", clen)
+    }
+    if clen > 0 {
+        body := make([]byte, clen)
+        if _, err := io.ReadFull(reader, body); err != nil {
+            return nil, err
+        }
+        buf = append(buf, body...)
+    }
+    return buf, nil
+}
+
+// Read a single line not longer than limit bytes.
+func readStreamLine(reader *bufio.Reader, limit int) (string, error) {
+    line := []byte{}
+    for {
+        chunk, err := reader.ReadSlice('\n')
+        if len(line) + len(chunk) > limit {
+            return "", errors.New("SIP message is too big")
+        }
+        line = append(line, chunk...)
+        if err != bufio.ErrBufferFull {
+            return string(line), err
+        }
+    }
+}
@@ -0,0 +1,205 @@
+package sippy
+
+import (
+    "bufio"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io"
+    "io/ioutil"
+    "math/big"
+    "net"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "sippy/conf"
+    "sippy/log"
+    "sippy/net"
+    "sippy/time"
+)
+
+func Test_TcpStreamFraming(t *testing.T) {
+    msg1 := "OPTIONS sip:foo@1.1.1.1 SIP/2.0\r\nContent-Length: 4\r\n\r\nabcd"
+    msg2 := "OPTIONS sip:bar@1.1.1.1 SIP/2.0\r\nl: 0\r\n\r\n"
+    npongs := 0
+    reader := bufio.NewReader(strings.NewReader("\r\n\r\n" + msg1 + "\r\n" + msg2))
+    for _, expected := range []string{ msg1, msg2 } {
+        buf, err := readSipStreamMessage(reader, func() { npongs++ })
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(buf) != expected {
+            t.Fatalf("unexpected message: %q", string(buf))
+        }
+    }
+    if npongs != 1 {
+        t.Fatalf("expected 1 pong, got %d", npongs)
+    }
+    reader = bufio.NewReader(strings.NewReader("OPTIONS sip:foo@1.1.1.1 SIP/2.0\r\n\r\n"))
+    if _, err := readSipStreamMessage(reader, nil); err == nil {
+        t.Fatal("message without Content-Length has been accepted")
+    }
+    // A line that never ends
+    reader = bufio.NewReader(io.MultiReader(strings.NewReader("OPTIONS "), endlessReader{}))
+    if _, err := readSipStreamMessage(reader, nil); err == nil || err == io.EOF {
+        t.Fatalf("endless line has been accepted: %v", err)
+    }
+}
+
+type endlessReader struct {}
+
+func (endlessReader) Read(buf []byte) (int, error) {
+    for i := range buf {
+        buf[i] = 'a'
+    }
+    return len(buf), nil
+}
+
+func Test_TcpConnections(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    newServer := func(received chan []byte, idle_timeout time.Duration) *TcpServer {
+        topts := NewTcpServerOpts(sippy_net.NewHostPort("127.0.0.1", "0"), func(data []byte, _ *sippy_net.HostPort, _ sippy_net.Transport, _ *sippy_time.MonoTime) {
+            received <- data
+        })
+        topts.idle_timeout = idle_timeout
+        server, err := NewTcpServer(config, topts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return server
+    }
+    nconns := func(server *TcpServer) int {
+        server.conns_lock.Lock()
+        defer server.conns_lock.Unlock()
+        return len(server.conns)
+    }
+    expect := func(received chan []byte) {
+        select {
+        case <-received:
+        case <-time.After(5 * time.Second):
+            t.Fatal("the message has not been received")
+        }
+    }
+    s_received := make(chan []byte, 10)
+    c_received := make(chan []byte, 10)
+    server := newServer(s_received, 100 * time.Millisecond)
+    defer server.Shutdown()
+    client := newServer(c_received, 300 * time.Second)
+    defer client.Shutdown()
+    saddress, _ := sippy_net.NewHostPortFromAddr(server.listener.Addr())
+    msg := []byte("OPTIONS sip:foo@127.0.0.1 SIP/2.0\r\nContent-Length: 0\r\n\r\n")
+
+    client.SendTo(msg, saddress)
+    expect(s_received)
+    client.SendTo(msg, saddress)
+    expect(s_received)
+    if nconns(client) != 1 || nconns(server) != 1 {
+        t.Fatalf("the connection has not been reused: %d/%d", nconns(client), nconns(server))
+    }
+    // The reply goes back over the accepted connection
+    var caddress *sippy_net.HostPort
+    server.conns_lock.Lock()
+    for _, conn := range server.conns {
+        caddress = conn.raddress
+    }
+    server.conns_lock.Unlock()
+    server.SendTo(msg, caddress)
+    expect(c_received)
+    if nconns(client) != 1 || nconns(server) != 1 {
+        t.Fatalf("the connection has not been reused: %d/%d", nconns(client), nconns(server))
+    }
+
+    // The idle connection is reaped on the server side
+    deadline := time.Now().Add(5 * time.Second)
+    for nconns(server) > 0 {
+        if time.Now().After(deadline) {
+            t.Fatal("the idle connection has not been closed")
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+}
+
+func Test_TlsMutualAuth(t *testing.T) {
+    dir := t.TempDir()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tmpl := &x509.Certificate{
+        SerialNumber            : big.NewInt(1),
+        Subject                 : pkix.Name{ CommonName : "sippy test" },
+        NotBefore               : time.Now().Add(-time.Hour),
+        NotAfter                : time.Now().Add(time.Hour),
+        IsCA                    : true,
+        BasicConstraintsValid   : true,
+        KeyUsage                : x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage             : []x509.ExtKeyUsage{ x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth },
+        IPAddresses             : []net.IP{ net.ParseIP("127.0.0.1") },
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    kder, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert_file := filepath.Join(dir, "cert.pem")
+    key_file := filepath.Join(dir, "key.pem")
+    ioutil.WriteFile(cert_file, pem.EncodeToMemory(&pem.Block{ Type : "CERTIFICATE", Bytes : der }), 0600)
+    ioutil.WriteFile(key_file, pem.EncodeToMemory(&pem.Block{ Type : "EC PRIVATE KEY", Bytes : kder }), 0600)
+
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetTlsCertFile(cert_file)
+    config.SetTlsKeyFile(key_file)
+    config.SetTlsCAFile(cert_file)
+    config.SetTlsVerifyClient(true)
+    tls_config, err := NewSipTlsConfig(config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    received := make(chan []byte, 10)
+    newServer := func(tls_config *tls.Config) *TcpServer {
+        topts := NewTcpServerOpts(sippy_net.NewHostPort("127.0.0.1", "0"), func(data []byte, _ *sippy_net.HostPort, _ sippy_net.Transport, _ *sippy_time.MonoTime) {
+            received <- data
+        })
+        topts.tls_config = tls_config
+        server, err := NewTcpServer(config, topts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return server
+    }
+    server := newServer(tls_config)
+    defer server.Shutdown()
+    saddress, _ := sippy_net.NewHostPortFromAddr(server.listener.Addr())
+    msg := "OPTIONS sip:foo@127.0.0.1 SIP/2.0\r\nContent-Length: 0\r\n\r\n"
+
+    client := newServer(tls_config)
+    defer client.Shutdown()
+    client.SendTo([]byte(msg), saddress)
+    select {
+    case data := <-received:
+        if string(data) != msg {
+            t.Fatalf("unexpected message: %q", string(data))
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("the message has not been received over TLS")
+    }
+
+    // No client certificate, the server must refuse it
+    anon := newServer(&tls.Config{ RootCAs : tls_config.RootCAs })
+    defer anon.Shutdown()
+    anon.SendTo([]byte(msg), saddress)
+    select {
+    case <-received:
+        t.Fatal("the message without the client certificate has been accepted")
+    case <-time.After(500 * time.Millisecond):
+    }
+}
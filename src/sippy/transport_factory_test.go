@@ -43,6 +43,14 @@ func (self *test_sip_transport_factory) SendToWithCb(data []byte, dest *sippy_ne
 func (self *test_sip_transport_factory) Shutdown() {
 }
 
+func (self *test_sip_transport_factory) GetProto() string {
+    return "udp"
+}
+
+func (self *test_sip_transport_factory) IsReliable() bool {
+    return false
+}
+
 func (self *test_sip_transport_factory) feed(inp []string) {
     s := strings.Join(inp, "\r\n")
     rtime, _ := sippy_time.NewMonoTime()
@@ -75,6 +75,7 @@ type SipMsg interface {
     GetBody() MsgBody
     SetBody(MsgBody)
     GetContacts() []*sippy_header.SipContact
+    HasContactAsterisk() bool
     GetRecordRoutes() []*sippy_header.SipRecordRoute
     GetCGUID() *sippy_header.SipCiscoGUID
     GetH323ConfId() *sippy_header.SipH323ConfId
@@ -88,6 +89,8 @@ type SipMsg interface {
     GetRTId() (*sippy_header.RTID, error)
     GetSipRequire() []*sippy_header.SipRequire
     GetSipSupported() []*sippy_header.SipSupported
+    GetSessionExpires() *sippy_header.SipSessionExpires
+    GetMinSE() *sippy_header.SipMinSE
 }
 
 type SipRequest interface {
@@ -102,6 +105,8 @@ type SipRequest interface {
     SetRURI(ruri *sippy_header.SipURL)
     GetReferTo() *sippy_header.SipReferTo
     GetNated() bool
+    GetTargetProto() string
+    GetTargetURL() *sippy_header.SipURL
 }
 
 type SipResponse interface {
@@ -112,6 +117,8 @@ type SipResponse interface {
     GetSCodeReason() string
     GetSipWWWAuthenticate() *sippy_header.SipWWWAuthenticate
     GetSipProxyAuthenticate() *sippy_header.SipProxyAuthenticate
+    GetSipWWWAuthenticates() []*sippy_header.SipWWWAuthenticate
+    GetSipProxyAuthenticates() []*sippy_header.SipProxyAuthenticate
     SetSCodeReason(string)
     GetCopy() SipResponse
 }
@@ -236,6 +243,14 @@ type UA interface {
     Disconnect(*sippy_time.MonoTime, string)
     SetKaInterval(time.Duration)
     GetKaInterval() time.Duration
+    SetKaMethod(string)
+    GetKaMethod() string
+    SetKaMaxFailures(int)
+    GetKaMaxFailures() int
+    SetSessionTimer(interval, min_se time.Duration)
+    SessionTimerActive() bool
+    SessionTimerRequest(SipRequest) int
+    SessionTimerResponse(SipResponse)
     OnDead()
     OnUacSetupComplete()
     GetGoDeadTimeout() time.Duration
@@ -284,6 +299,7 @@ type UA interface {
     BeforeRequestSent(SipRequest)
     BeforeResponseSent(SipResponse)
     PrepTr(SipRequest) (ClientTransaction, error)
+    PrepTrWithReceiver(SipRequest, ResponseReceiver) (ClientTransaction, error)
     Cleanup()
     OnEarlyUasDisconnect(CCEvent) (int, string)
     SetExpireStartsOnSetup(bool)
@@ -395,6 +411,7 @@ type RtpProxyClient interface {
     GoOffline()
     GetOpts() RtpProxyClientOpts
     Start() error
+    Shutdown()
     UpdateActive(active_sessions, sessions_created, active_streams, preceived, ptransmitted int64)
 }
 
@@ -93,6 +93,9 @@ type Ua struct {
     uasResp         sippy_types.SipResponse
     useRefer        bool
     kaInterval      time.Duration
+    kaMethod        string
+    kaMaxFailures   int
+    session_timer   *sessionTimer
     godead_timeout  time.Duration
     last_scode      int
     _np_mtime       *sippy_time.MonoTime
@@ -138,6 +141,8 @@ func NewUA(sip_tm sippy_types.SipTransactionManager, config sippy_conf.Config, n
         rCSeq           : -1,
         useRefer        : true,
         kaInterval      : 0,
+        kaMethod        : "INVITE",
+        kaMaxFailures   : 1,
         godead_timeout  : time.Duration(32 * time.Second),
         last_scode      : 100,
         p100_ts         : nil,
@@ -225,32 +230,35 @@ func (self *Ua) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.Client
     code, _ := resp.GetSCode()
     orig_req, cseq_found := self.reqs[cseq_body.CSeq]
     if cseq_body.Method == "INVITE" && !self.pass_auth && cseq_found && self.username != "" && self.password != "" {
-        if code == 401 && resp.GetSipWWWAuthenticate() != nil && orig_req.sip_authorization == nil {
-            challenge, err = resp.GetSipWWWAuthenticate().GetBody()
+        if (code == 401 && orig_req.sip_authorization == nil) || (code == 407 && orig_req.sip_proxy_authorization == nil) {
+            challenge, new_auth_fn, err = challengeAuthFn(resp, code, self.lSDP)
             if err != nil {
-                self.logError("UA::RecvResponse: cannot parse WWW-Authenticate: " + err.Error())
+                self.logError("UA::RecvResponse: cannot parse the challenge: " + err.Error())
                 return
             }
-            new_auth_fn = func(realm, nonce, method, uri, username, password string) sippy_header.SipHeader {
-                return sippy_header.NewSipAuthorization(realm, nonce, method, uri, username, password)
-            }
-        } else if code == 407 && resp.GetSipProxyAuthenticate() != nil && orig_req.sip_proxy_authorization == nil {
-            challenge, err = resp.GetSipProxyAuthenticate().GetBody()
+        }
+        if challenge != nil {
+            req, err = self.GenRequest("INVITE", self.lSDP, challenge.GetNonce(), challenge.GetRealm(), new_auth_fn)
             if err != nil {
-                self.logError("UA::RecvResponse: cannot parse Proxy-Authenticate: " + err.Error())
+                self.logError("UA::RecvResponse: cannot create INVITE: " + err.Error())
                 return
             }
-            new_auth_fn = func(realm, nonce, method, uri, username, password string) sippy_header.SipHeader {
-                return sippy_header.NewSipProxyAuthorization(realm, nonce, method, uri, username, password)
+            self.lCSeq += 1
+            self.tr, err = self.me().PrepTr(req)
+            if err == nil {
+                self.sip_tm.BeginClientTransaction(req, self.tr)
+                delete(self.reqs, cseq_body.CSeq)
             }
+            return
         }
-        if challenge != nil {
-            req, err = self.GenRequest("INVITE", self.lSDP, challenge.GetNonce(), challenge.GetRealm(), new_auth_fn)
+    }
+    if self.session_timer != nil && cseq_found && (cseq_body.Method == "INVITE" || cseq_body.Method == "UPDATE") {
+        if code == 422 && cseq_body.Method == "INVITE" && self.session_timer.recv422(resp) {
+            req, err = self.GenRequest("INVITE", self.lSDP, "", "", nil)
             if err != nil {
                 self.logError("UA::RecvResponse: cannot create INVITE: " + err.Error())
                 return
             }
-            self.lCSeq += 1
             self.tr, err = self.me().PrepTr(req)
             if err == nil {
                 self.sip_tm.BeginClientTransaction(req, self.tr)
@@ -258,6 +266,9 @@ func (self *Ua) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.Client
             }
             return
         }
+        if code >= 200 && code < 300 {
+            self.session_timer.recvResponse(resp)
+        }
     }
     if code >= 200 && cseq_found {
         delete(self.reqs, cseq_body.CSeq)
@@ -269,8 +280,58 @@ func (self *Ua) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.Client
     self.emitPendingEvents()
 }
 
+// Picks the strongest of the challenges offered by the response and
+// returns the function that builds the credentials answering it. The
+// challenge is nil if there is nothing we can answer.
+func challengeAuthFn(resp sippy_types.SipResponse, code int, body sippy_types.MsgBody) (*sippy_header.SipWWWAuthenticateBody, sippy_header.NewSipXXXAuthorizationFunc, error) {
+    challenges := []*sippy_header.SipWWWAuthenticateBody{}
+    switch code {
+    case 401:
+        for _, hf := range resp.GetSipWWWAuthenticates() {
+            challenge, err := hf.GetBody()
+            if err != nil {
+                return nil, nil, err
+            }
+            challenges = append(challenges, challenge)
+        }
+    case 407:
+        for _, hf := range resp.GetSipProxyAuthenticates() {
+            challenge, err := hf.GetBody()
+            if err != nil {
+                return nil, nil, err
+            }
+            challenges = append(challenges, challenge)
+        }
+    }
+    challenge := sippy_header.SelectChallenge(challenges)
+    if challenge == nil {
+        return nil, nil, nil
+    }
+    entity_body := ""
+    if body != nil {
+        entity_body = body.String()
+    }
+    new_auth_fn := func(realm, nonce, method, uri, username, password string) sippy_header.SipHeader {
+        if code == 407 {
+            return sippy_header.NewSipProxyAuthorizationWithChallenge(challenge, method, uri, username, password, entity_body)
+        }
+        return sippy_header.NewSipAuthorizationWithChallenge(challenge, method, uri, username, password, entity_body)
+    }
+    return challenge, new_auth_fn, nil
+}
+
 func (self *Ua) PrepTr(req sippy_types.SipRequest) (sippy_types.ClientTransaction, error) {
-    tr, err := self.SipTM().CreateClientTransaction(req, self.me(), self.session_lock, /*laddress*/ self.source_address, /*udp_server*/ nil, self.me().BeforeRequestSent)
+    return self.PrepTrWithReceiver(req, self.me())
+}
+
+func (self *Ua) PrepTrWithReceiver(req sippy_types.SipRequest, resp_receiver sippy_types.ResponseReceiver) (sippy_types.ClientTransaction, error) {
+    if resp_receiver != self.me() {
+        // The responses bypass the UA, so the request is of no use to it
+        if cseq, err := req.GetCSeq().GetBody(); err == nil {
+            delete(self.reqs, cseq.CSeq)
+        }
+    }
+    tr, err := self.SipTM().CreateClientTransaction(req, resp_receiver, self.session_lock, /*laddress*/ self.source_address, /*udp_server*/ nil, self.me().BeforeRequestSent)
     if err != nil {
         return nil, err
     }
@@ -346,6 +407,12 @@ func (self *Ua) ChangeState(newstate sippy_types.UaState, cb func()) {
         self.state.OnDeactivate()
     }
     self.state = newstate //.Newstate(self, self.config)
+    if self.session_timer != nil && newstate != nil {
+        switch newstate.ID() {
+        case sippy_types.UA_STATE_DISCONNECTED, sippy_types.UA_STATE_FAILED, sippy_types.UA_STATE_DEAD:
+            self.session_timer.stop()
+        }
+    }
     if newstate != nil {
         newstate.OnActivation()
         if cb != nil {
@@ -402,6 +469,9 @@ func (self *Ua) GenRequest(method string, body sippy_types.MsgBody, nonce string
     if extra_headers != nil {
         req.appendHeaders(extra_headers)
     }
+    if self.session_timer != nil && (method == "INVITE" || method == "UPDATE") {
+        self.session_timer.prepRequest(req)
+    }
     self.reqs[self.lCSeq] = req
     self.lCSeq++
     return req, nil
@@ -427,6 +497,11 @@ func (self *Ua) SendUasResponse(t sippy_types.ServerTransaction, scode int, reas
     for _, eh := range extra_headers {
         uasResp.AppendHeader(eh)
     }
+    if self.session_timer != nil {
+        if cseq_body, err := uasResp.GetCSeq().GetBody(); err == nil && cseq_body.Method == "INVITE" {
+            self.session_timer.prepResponse(uasResp)
+        }
+    }
     var ack_cb func(sippy_types.SipRequest)
     if ack_wait {
         ack_cb = self.me().RecvACK
@@ -628,6 +703,8 @@ func (self *Ua) GetCallId() *sippy_header.SipCallId {
     return self.cId
 }
 
+// The Request-URI target set before the outgoing call is placed is used
+// instead of the one made out of the next hop address.
 func (self *Ua) SetRTarget(url *sippy_header.SipURL) {
     self.rTarget = url
 }
@@ -927,6 +1004,60 @@ func (self *Ua) SetKaInterval(ka time.Duration) {
     self.kaInterval = ka
 }
 
+func (self *Ua) GetKaMethod() string {
+    return self.kaMethod
+}
+
+// Selects the request used for the keep-alives: a re-INVITE with the
+// local SDP, OPTIONS or UPDATE without SDP.
+func (self *Ua) SetKaMethod(method string) {
+    self.kaMethod = method
+}
+
+func (self *Ua) GetKaMaxFailures() int {
+    return self.kaMaxFailures
+}
+
+// Sets the number of consecutive failed keep-alives that disconnect the
+// call.
+func (self *Ua) SetKaMaxFailures(n int) {
+    if n < 1 {
+        n = 1
+    }
+    self.kaMaxFailures = n
+}
+
+// Enables the RFC 4028 session timer with the given preferred session
+// interval, zero interval disables it.
+func (self *Ua) SetSessionTimer(interval, min_se time.Duration) {
+    if self.session_timer != nil {
+        self.session_timer.stop()
+        self.session_timer = nil
+    }
+    if interval > 0 {
+        self.session_timer = newSessionTimer(self, interval, min_se)
+    }
+}
+
+func (self *Ua) SessionTimerActive() bool {
+    return self.session_timer != nil && self.session_timer.isActive()
+}
+
+// Negotiates the session interval of the incoming INVITE or UPDATE.
+// Returns non-zero Min-SE if the request has to be rejected with 422.
+func (self *Ua) SessionTimerRequest(req sippy_types.SipRequest) int {
+    if self.session_timer == nil {
+        return 0
+    }
+    return self.session_timer.recvRequest(req)
+}
+
+func (self *Ua) SessionTimerResponse(resp sippy_types.SipResponse) {
+    if self.session_timer != nil {
+        self.session_timer.prepResponse(resp)
+    }
+}
+
 func (self *Ua) ResetOnLocalSdpChange() {
     self.on_local_sdp_change = nil
 }
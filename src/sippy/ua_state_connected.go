@@ -69,11 +69,19 @@ func (self *UaStateConnected) RecvRequest(req sippy_types.SipRequest, t sippy_ty
             self.config.ErrorLogger().Error("UaStateConnected::RecvRequest: #1: " + err.Error())
             return nil, nil
         }
-        self.ua.Enqueue(NewCCEventDisconnect(refer_to.GetCopy(), req.GetRtime(), self.ua.GetOrigin()))
+        event := NewCCEventDisconnect(refer_to.GetCopy(), req.GetRtime(), self.ua.GetOrigin())
+        event.SetMaxForwards(req.GetMaxForwards())
+        self.ua.Enqueue(event)
         self.ua.RecvEvent(NewCCEventDisconnect(nil, req.GetRtime(), self.ua.GetOrigin()))
         return nil, nil
     }
     if req.GetMethod() == "INVITE" {
+        if min_se := self.ua.SessionTimerRequest(req); min_se > 0 {
+            resp := req.GenResponse(422, "Session Interval Too Small", nil, self.ua.GetLocalUA().AsSipServer())
+            resp.AppendHeader(sippy_header.NewSipMinSE(min_se))
+            t.SendResponse(resp, false, nil)
+            return nil, nil
+        }
         self.ua.SetUasResp(req.GenResponse(100, "Trying", nil, self.ua.GetLocalUA().AsSipServer()))
         t.SendResponse(self.ua.GetUasResp(), false, nil)
         body := req.GetBody()
@@ -109,6 +117,7 @@ func (self *UaStateConnected) RecvRequest(req sippy_types.SipRequest, t sippy_ty
         }
         event := NewCCEventDisconnect(also, req.GetRtime(), self.ua.GetOrigin())
         event.SetReason(req.GetReason())
+        event.SetMaxForwards(req.GetMaxForwards())
         self.ua.Enqueue(event)
         self.ua.CancelCreditTimer()
         self.ua.SetDisconnectTs(req.GetRtime())
@@ -118,10 +127,23 @@ func (self *UaStateConnected) RecvRequest(req sippy_types.SipRequest, t sippy_ty
         t.SendResponse(req.GenResponse(200, "OK", nil, self.ua.GetLocalUA().AsSipServer()), false, nil)
         event := NewCCEventInfo(req.GetRtime(), self.ua.GetOrigin(), req.GetBody())
         event.SetReason(req.GetReason())
+        event.SetMaxForwards(req.GetMaxForwards())
         self.ua.Enqueue(event)
         return nil, nil
     }
-    if req.GetMethod() == "OPTIONS" || req.GetMethod() == "UPDATE" {
+    if req.GetMethod() == "UPDATE" {
+        if min_se := self.ua.SessionTimerRequest(req); min_se > 0 {
+            resp := req.GenResponse(422, "Session Interval Too Small", nil, self.ua.GetLocalUA().AsSipServer())
+            resp.AppendHeader(sippy_header.NewSipMinSE(min_se))
+            t.SendResponse(resp, false, nil)
+            return nil, nil
+        }
+        resp := req.GenResponse(200, "OK", nil, self.ua.GetLocalUA().AsSipServer())
+        self.ua.SessionTimerResponse(resp)
+        t.SendResponse(resp, false, nil)
+        return nil, nil
+    }
+    if req.GetMethod() == "OPTIONS" {
         t.SendResponse(req.GenResponse(200, "OK", nil, self.ua.GetLocalUA().AsSipServer()), false, nil)
         return nil, nil
     }
@@ -149,6 +171,17 @@ func (self *UaStateConnected) RecvEvent(event sippy_types.CCEvent) (sippy_types.
     }
     if ok {
         //println("event", event.String(), "received in the Connected state sending BYE")
+        // The hop count is not enforced here since the session is going
+        // down anyway.
+        var max_forwards *sippy_header.SipMaxForwards
+
+        max_forwards, _, err = nextHopMaxForwards(event)
+        if err != nil {
+            return nil, nil, err
+        }
+        if max_forwards != nil {
+            eh = append(eh, max_forwards)
+        }
         if redirect != nil && self.ua.ShouldUseRefer() {
             var lUri *sippy_header.SipAddress
 
@@ -204,19 +237,20 @@ func (self *UaStateConnected) RecvEvent(event sippy_types.CCEvent) (sippy_types.
         if body == nil {
             self.ua.SetLateMedia(true)
         }
-        eh2 := eh
-        if _event.GetMaxForwards() != nil {
-            var max_forwards *sippy_header.SipNumericHF
+        var max_forwards *sippy_header.SipMaxForwards
+        var hops_left bool
 
-            max_forwards, err = _event.GetMaxForwards().GetBody()
-            if err != nil {
-                return nil, nil, err
-            }
-            if max_forwards.Number <= 0 {
-                self.ua.Enqueue(NewCCEventFail(483, "Too Many Hops", event.GetRtime(), ""))
-                return nil, nil, nil
-            }
-            eh2 = append(eh2, sippy_header.NewSipMaxForwards(max_forwards.Number - 1))
+        eh2 := eh
+        max_forwards, hops_left, err = nextHopMaxForwards(event)
+        if err != nil {
+            return nil, nil, err
+        }
+        if ! hops_left {
+            self.ua.Enqueue(NewCCEventFail(483, "Too Many Hops", event.GetRtime(), ""))
+            return nil, nil, nil
+        }
+        if max_forwards != nil {
+            eh2 = append(eh2, max_forwards)
         }
         req, err = self.ua.GenRequest("INVITE", body, "", "", nil, eh2...)
         if err != nil {
@@ -232,6 +266,17 @@ func (self *UaStateConnected) RecvEvent(event sippy_types.CCEvent) (sippy_types.
         return NewUacStateUpdating(self.ua, self.config), nil, nil
     }
     if _event, ok := event.(*CCEventInfo); ok {
+        max_forwards, hops_left, err := nextHopMaxForwards(event)
+        if err != nil {
+            return nil, nil, err
+        }
+        if ! hops_left {
+            // Nowhere to report to, the INFO has been already accepted
+            return nil, nil, nil
+        }
+        if max_forwards != nil {
+            eh = append(eh, max_forwards)
+        }
         body := _event.GetBody()
         req, err = self.ua.GenRequest("INFO", nil, "", "", nil, eh...)
         if err != nil {
@@ -79,7 +79,12 @@ func (self *UacStateIdle) RecvEvent(_event sippy_types.CCEvent) (sippy_types.UaS
         } else {
             self.ua.SetCallId(event.GetSipCallId().GetCopy())
         }
-        self.ua.SetRTarget(sippy_header.NewSipURL(event.GetCLD(), self.ua.GetRAddr0().Host, self.ua.GetRAddr0().Port, false))
+        rTarget := self.ua.GetRTarget()
+        if rTarget == nil {
+            rTarget = sippy_header.NewSipURL("", self.ua.GetRAddr0().Host, self.ua.GetRAddr0().Port, false)
+        }
+        rTarget.Username = event.GetCLD()
+        self.ua.SetRTarget(rTarget)
         self.ua.SetRUri(sippy_header.NewSipTo(sippy_header.NewSipAddress("", self.ua.GetRTarget().GetCopy()), self.config))
         rUri, err = self.ua.GetRUri().GetBody(self.config)
         if err != nil {
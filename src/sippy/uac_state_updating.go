@@ -62,6 +62,7 @@ func (self *UacStateUpdating) RecvRequest(req sippy_types.SipRequest, t sippy_ty
         //print "BYE received in the Updating state, going to the Disconnected state"
         event := NewCCEventDisconnect(nil, req.GetRtime(), self.ua.GetOrigin())
         event.SetReason(req.GetReason())
+        event.SetMaxForwards(req.GetMaxForwards())
         self.ua.Enqueue(event)
         self.ua.CancelCreditTimer()
         self.ua.SetDisconnectTs(req.GetRtime())
@@ -175,7 +176,15 @@ func (self *UacStateUpdating) RecvEvent(event sippy_types.CCEvent) (sippy_types.
     }
     if send_bye {
         self.ua.GetClientTransaction().Cancel()
-        req, err := self.ua.GenRequest("BYE", nil, "", "", nil, event.GetExtraHeaders()...)
+        eh := event.GetExtraHeaders()
+        max_forwards, _, err := nextHopMaxForwards(event)
+        if err != nil {
+            return nil, nil, err
+        }
+        if max_forwards != nil {
+            eh = append(eh, max_forwards)
+        }
+        req, err := self.ua.GenRequest("BYE", nil, "", "", nil, eh...)
         if err != nil {
             return nil, nil, err
         }
@@ -98,6 +98,10 @@ func (self *UasStateIdle) RecvRequest(req sippy_types.SipRequest, t sippy_types.
     self.ua.SetRUri(sippy_header.NewSipTo(from_body, self.config))
     self.ua.SetCallId(self.ua.GetUasResp().GetCallId())
     self.ua.SipTM().RegConsumer(self.ua, self.ua.GetCallId().CallId)
+    if min_se := self.ua.SessionTimerRequest(req); min_se > 0 {
+        self.ua.SendUasResponse(t, 422, "Session Interval Too Small", nil, nil, false, sippy_header.NewSipMinSE(min_se))
+        return NewUaStateFailed(self.ua, self.config), func() { self.ua.FailCb(req.GetRtime(), self.ua.GetOrigin(), 422) }
+    }
     if auth_hf := req.GetSipAuthorization(); auth_hf != nil {
         auth, err = auth_hf.GetBody()
         if err != nil {
@@ -133,6 +133,7 @@ func (self *UasStateRinging) RecvRequest(req sippy_types.SipRequest, t sippy_typ
         }
         event := NewCCEventDisconnect(also, req.GetRtime(), self.ua.GetOrigin())
         event.SetReason(req.GetReason())
+        event.SetMaxForwards(req.GetMaxForwards())
         self.ua.Enqueue(event)
         self.ua.CancelExpireTimer()
         self.ua.SetDisconnectTs(req.GetRtime())
@@ -58,6 +58,7 @@ func (self *UasStateUpdating) RecvRequest(req sippy_types.SipRequest, t sippy_ty
         //print "BYE received in the Updating state, going to the Disconnected state"
         event := NewCCEventDisconnect(nil, req.GetRtime(), self.ua.GetOrigin())
         event.SetReason(req.GetReason())
+        event.SetMaxForwards(req.GetMaxForwards())
         self.ua.Enqueue(event)
         self.ua.CancelCreditTimer()
         self.ua.SetDisconnectTs(req.GetRtime())
@@ -74,7 +75,9 @@ func (self *UasStateUpdating) RecvRequest(req sippy_types.SipRequest, t sippy_ty
             self.config.ErrorLogger().Error("UasStateUpdating::RecvRequest: #1: " + err.Error())
             return nil, nil
         }
-        self.ua.Enqueue(NewCCEventDisconnect(refer_to.GetCopy(), req.GetRtime(), self.ua.GetOrigin()))
+        event := NewCCEventDisconnect(refer_to.GetCopy(), req.GetRtime(), self.ua.GetOrigin())
+        event.SetMaxForwards(req.GetMaxForwards())
+        self.ua.Enqueue(event)
         self.ua.CancelCreditTimer()
         self.ua.SetDisconnectTs(req.GetRtime())
         return NewUaStateDisconnected(self.ua, self.config), func() { self.ua.DiscCb(req.GetRtime(), self.ua.GetOrigin(), 0, req) }
@@ -131,6 +134,13 @@ func (self *UasStateUpdating) RecvEvent(_event sippy_types.CCEvent) (sippy_types
         return NewUaStateConnected(self.ua, self.config), nil, nil
     case *CCEventDisconnect:
         self.ua.SendUasResponse(nil, 487, "Request Terminated", nil, nil, false, eh...)
+        max_forwards, _, err := nextHopMaxForwards(event)
+        if err != nil {
+            return nil, nil, err
+        }
+        if max_forwards != nil {
+            eh = append(eh, max_forwards)
+        }
         req, err := self.ua.GenRequest("BYE", nil, "", "", nil, eh...)
         if err != nil {
             return nil, nil, err
@@ -333,3 +333,11 @@ func (self *UdpServer) Shutdown() {
 func (self *UdpServer) GetLAddress() *sippy_net.HostPort {
     return self.uopts.laddress
 }
+
+func (self *UdpServer) GetProto() string {
+    return "udp"
+}
+
+func (self *UdpServer) IsReliable() bool {
+    return false
+}
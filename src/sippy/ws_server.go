@@ -0,0 +1,518 @@
+// Copyright (c) 2003-2005 Maxim Sobolev. All rights reserved.
+// Copyright (c) 2006-2015 Sippy Software, Inc. All rights reserved.
+// Copyright (c) 2015 Andrii Pylypenko. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package sippy
+
+import (
+    "bufio"
+    "crypto/sha1"
+    "crypto/tls"
+    "encoding/base64"
+    "encoding/binary"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "sippy/conf"
+    "sippy/log"
+    "sippy/net"
+    "sippy/time"
+    "sippy/utils"
+)
+
+const (
+    WS_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+    WS_HANDSHAKE_TIMEOUT = 10 * time.Second
+    WS_CLOSE_TIMEOUT = 1 * time.Second
+
+    ws_op_continuation = 0x0
+    ws_op_text = 0x1
+    ws_op_binary = 0x2
+    ws_op_close = 0x8
+    ws_op_ping = 0x9
+    ws_op_pong = 0xa
+)
+
+// Returned by readWsMessage when the client has sent the close frame.
+var errWsClosed = errors.New("WebSocket closed by the client")
+
+type wsServerOpts struct {
+    laddress        *sippy_net.HostPort
+    data_callback   sippy_net.DataPacketReceiver
+    tls_config      *tls.Config
+}
+
+func NewWsServerOpts(laddress *sippy_net.HostPort, data_callback sippy_net.DataPacketReceiver) *wsServerOpts {
+    return &wsServerOpts{
+        laddress        : laddress,
+        data_callback   : data_callback,
+    }
+}
+
+// WsServer is the SIP over WebSocket (RFC 7118) transport. The browser
+// clients cannot accept connections, so the server is only able to send
+// messages over the connections opened by the remote side.
+type WsServer struct {
+    wopts           wsServerOpts
+    proto           string
+    listener        net.Listener
+    logger          sippy_log.ErrorLogger
+    conns           map[string]*wsConnection
+    conns_lock      sync.Mutex
+    shutdown_chan   chan struct{}
+    wg              sync.WaitGroup
+}
+
+func NewWsServer(config sippy_conf.Config, wopts *wsServerOpts) (*WsServer, error) {
+    network := "tcp4"
+    if ip := wopts.laddress.ParseIP(); ip != nil && ! sippy_net.IsIP4(ip) {
+        network = "tcp6"
+    }
+    listener, err := net.Listen(network, wopts.laddress.String())
+    if err != nil {
+        return nil, err
+    }
+    proto := "ws"
+    if wopts.tls_config != nil {
+        listener = tls.NewListener(listener, wopts.tls_config)
+        proto = "wss"
+    }
+    self := &WsServer{
+        wopts           : *wopts,
+        proto           : proto,
+        listener        : listener,
+        logger          : config.ErrorLogger(),
+        conns           : make(map[string]*wsConnection),
+        shutdown_chan   : make(chan struct{}),
+    }
+    self.wg.Add(1)
+    go self.runAccept()
+    return self, nil
+}
+
+func (self *WsServer) runAccept() {
+    defer self.wg.Done()
+    for {
+        conn, err := self.listener.Accept()
+        if err != nil {
+            select {
+            case <-self.shutdown_chan:
+                return
+            default:
+            }
+            self.logger.Error("WsServer: accept failed: " + err.Error())
+            time.Sleep(10 * time.Millisecond)
+            continue
+        }
+        go self.handshake(conn)
+    }
+}
+
+// Perform the server side of the RFC 6455 opening handshake and
+// start serving the connection if it succeeds.
+func (self *WsServer) handshake(conn net.Conn) {
+    raddress, err := sippy_net.NewHostPortFromAddr(conn.RemoteAddr())
+    if err != nil {
+        conn.Close()
+        return
+    }
+    lhost, _, err := net.SplitHostPort(conn.LocalAddr().String())
+    if err != nil {
+        conn.Close()
+        return
+    }
+    conn.SetDeadline(time.Now().Add(WS_HANDSHAKE_TIMEOUT))
+    reader := bufio.NewReader(conn)
+    req, err := http.ReadRequest(reader)
+    if err != nil {
+        self.logger.Debugf("WsServer: bad handshake from '%s': %s", raddress, err.Error())
+        conn.Close()
+        return
+    }
+    key, err := checkWsUpgrade(req)
+    if err != nil {
+        self.logger.Debugf("WsServer: bad handshake from '%s': %s", raddress, err.Error())
+        conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+        conn.Close()
+        return
+    }
+    _, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+                               "Upgrade: websocket\r\n" +
+                               "Connection: Upgrade\r\n" +
+                               "Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n" +
+                               "Sec-WebSocket-Protocol: sip\r\n\r\n"))
+    if err != nil {
+        conn.Close()
+        return
+    }
+    conn.SetDeadline(time.Time{})
+    laddress := sippy_net.NewHostPort(lhost, self.wopts.laddress.Port.String())
+    wconn := newWsConnection(self, conn, reader, laddress, raddress)
+    if ! self.putConnection(wconn) {
+        conn.Close()
+        return
+    }
+    go wconn.runReader()
+    go wconn.runWriter()
+}
+
+func checkWsUpgrade(req *http.Request) (string, error) {
+    if req.Method != "GET" {
+        return "", errors.New("unexpected method " + req.Method)
+    }
+    if ! headerHasToken(req.Header, "Upgrade", "websocket") || ! headerHasToken(req.Header, "Connection", "upgrade") {
+        return "", errors.New("not a WebSocket upgrade request")
+    }
+    if req.Header.Get("Sec-WebSocket-Version") != "13" {
+        return "", errors.New("unsupported WebSocket version")
+    }
+    key := req.Header.Get("Sec-WebSocket-Key")
+    if key == "" {
+        return "", errors.New("Sec-WebSocket-Key is missing")
+    }
+    if ! headerHasToken(req.Header, "Sec-WebSocket-Protocol", "sip") {
+        return "", errors.New("the client does not support the \"sip\" subprotocol")
+    }
+    return key, nil
+}
+
+func headerHasToken(header http.Header, name, token string) bool {
+    for _, value := range header[http.CanonicalHeaderKey(name)] {
+        for _, it := range strings.Split(value, ",") {
+            if strings.EqualFold(strings.TrimSpace(it), token) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func wsAcceptKey(key string) string {
+    sum := sha1.Sum([]byte(key + WS_GUID))
+    return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (self *WsServer) putConnection(conn *wsConnection) bool {
+    self.conns_lock.Lock()
+    defer self.conns_lock.Unlock()
+    select {
+    case <-self.shutdown_chan:
+        return false
+    default:
+    }
+    self.conns[conn.raddress.String()] = conn
+    return true
+}
+
+func (self *WsServer) delConnection(conn *wsConnection) {
+    self.conns_lock.Lock()
+    defer self.conns_lock.Unlock()
+    if it, ok := self.conns[conn.raddress.String()]; ok && it == conn {
+        delete(self.conns, conn.raddress.String())
+    }
+}
+
+func (self *WsServer) GetConnection(address *sippy_net.HostPort) (sippy_net.Transport, error) {
+    self.conns_lock.Lock()
+    defer self.conns_lock.Unlock()
+    if conn, ok := self.conns[address.String()]; ok {
+        return conn, nil
+    }
+    return nil, errors.New("WsServer: no WebSocket connection from " + address.String())
+}
+
+func (self *WsServer) SendTo(data []byte, hostport *sippy_net.HostPort) {
+    self.SendToWithCb(data, hostport, nil)
+}
+
+func (self *WsServer) SendToWithCb(data []byte, hostport *sippy_net.HostPort, on_complete func()) {
+    conn, err := self.GetConnection(hostport)
+    if err != nil {
+        self.logger.Error(err.Error() + ", dropping outgoing SIP message")
+        return
+    }
+    conn.SendToWithCb(data, hostport, on_complete)
+}
+
+func (self *WsServer) Shutdown() {
+    self.conns_lock.Lock()
+    close(self.shutdown_chan)
+    conns := self.conns
+    self.conns = make(map[string]*wsConnection)
+    self.conns_lock.Unlock()
+    self.listener.Close()
+    for _, conn := range conns {
+        conn.close()
+    }
+    self.wg.Wait()
+}
+
+func (self *WsServer) GetLAddress() *sippy_net.HostPort {
+    return self.wopts.laddress
+}
+
+func (self *WsServer) GetProto() string {
+    return self.proto
+}
+
+func (self *WsServer) IsReliable() bool {
+    return true
+}
+
+type wsConnection struct {
+    server      *WsServer
+    conn        net.Conn
+    reader      *bufio.Reader
+    laddress    *sippy_net.HostPort
+    raddress    *sippy_net.HostPort
+    wi          chan *write_req
+    lock        sync.Mutex
+    closed      bool
+    closed_chan chan struct{}
+    close_sent  chan struct{}
+}
+
+func newWsConnection(server *WsServer, conn net.Conn, reader *bufio.Reader, laddress, raddress *sippy_net.HostPort) *wsConnection {
+    return &wsConnection{
+        server      : server,
+        conn        : conn,
+        reader      : reader,
+        laddress    : laddress,
+        raddress    : raddress,
+        wi          : make(chan *write_req, 1000),
+        closed      : false,
+        closed_chan : make(chan struct{}),
+        close_sent  : make(chan struct{}),
+    }
+}
+
+func (self *wsConnection) close() {
+    self.server.delConnection(self)
+    self.lock.Lock()
+    if self.closed {
+        self.lock.Unlock()
+        return
+    }
+    self.closed = true
+    close(self.closed_chan)
+    self.lock.Unlock()
+    self.conn.Close()
+}
+
+func (self *wsConnection) runWriter() {
+    for {
+        var wi *write_req
+        select {
+        case <-self.closed_chan:
+            return
+        case wi = <-self.wi:
+        }
+        if _, err := self.conn.Write(wi.data); err != nil {
+            self.server.logger.Errorf("WsServer: Cannot send to '%s': %s", self.raddress, err.Error())
+            self.close()
+            return
+        }
+        if wi.on_complete != nil {
+            wi.on_complete()
+        }
+    }
+}
+
+func (self *wsConnection) runReader() {
+    defer self.close()
+    for {
+        data, err := readWsMessage(self.reader, self.control)
+        if err == errWsClosed {
+            // Let the writer deliver the close reply before closing the socket
+            select {
+            case <-self.close_sent:
+            case <-self.closed_chan:
+            case <-time.After(WS_CLOSE_TIMEOUT):
+            }
+            return
+        }
+        if err != nil {
+            if err != io.EOF {
+                self.server.logger.Debugf("WsServer: closing connection to '%s': %s", self.raddress, err.Error())
+            }
+            return
+        }
+        if len(strings.TrimSpace(string(data))) == 0 {
+            // keep-alive
+            continue
+        }
+        rtime, err := sippy_time.NewMonoTime()
+        if err != nil {
+            self.server.logger.Error("Cannot create MonoTime object")
+            continue
+        }
+        sippy_utils.SafeCall(func() { self.server.wopts.data_callback(data, self.raddress, self, rtime) }, nil, self.server.logger)
+    }
+}
+
+// Reply to the control frames received from the peer.
+func (self *wsConnection) control(opcode byte, payload []byte) {
+    switch opcode {
+    case ws_op_ping:
+        self.enqueue(wsFrame(ws_op_pong, payload), nil)
+    case ws_op_close:
+        self.enqueue(wsFrame(ws_op_close, payload), func() { close(self.close_sent) })
+    }
+}
+
+func (self *wsConnection) enqueue(data []byte, on_complete func()) {
+    select {
+    case self.wi <- &write_req{ data : data, on_complete : on_complete }:
+    case <-self.closed_chan:
+    }
+}
+
+func (self *wsConnection) SendTo(data []byte, hostport *sippy_net.HostPort) {
+    self.SendToWithCb(data, hostport, nil)
+}
+
+func (self *wsConnection) SendToWithCb(data []byte, hostport *sippy_net.HostPort, on_complete func()) {
+    self.lock.Lock()
+    closed := self.closed
+    self.lock.Unlock()
+    if closed {
+        self.server.logger.Errorf("WsServer: connection to '%s' is closed, dropping outgoing SIP message", self.raddress)
+        return
+    }
+    self.enqueue(wsFrame(ws_op_text, data), on_complete)
+}
+
+func (self *wsConnection) Shutdown() {
+    self.close()
+}
+
+func (self *wsConnection) GetLAddress() *sippy_net.HostPort {
+    return self.laddress
+}
+
+func (self *wsConnection) GetProto() string {
+    return self.server.GetProto()
+}
+
+func (self *wsConnection) IsReliable() bool {
+    return true
+}
+
+// Build a single unmasked frame as sent by the server.
+func wsFrame(opcode byte, payload []byte) []byte {
+    hdr := []byte{ 0x80 | opcode }
+    switch plen := len(payload); {
+    case plen < 126:
+        hdr = append(hdr, byte(plen))
+    case plen <= 0xffff:
+        hdr = append(hdr, 126, 0, 0)
+        binary.BigEndian.PutUint16(hdr[2:], uint16(plen))
+    default:
+        hdr = append(hdr, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+        binary.BigEndian.PutUint64(hdr[2:], uint64(plen))
+    }
+    return append(hdr, payload...)
+}
+
+// Read a complete (possibly fragmented) data message from the client.
+// The control frames found in between are passed to the control callback.
+// RFC 7118 requires each SIP message to be carried in a single message.
+func readWsMessage(reader *bufio.Reader, control func(byte, []byte)) ([]byte, error) {
+    buf := []byte{}
+    started := false
+    for {
+        var hdr [2]byte
+        if _, err := io.ReadFull(reader, hdr[:]); err != nil {
+            return nil, err
+        }
+        fin := hdr[0] & 0x80 != 0
+        opcode := hdr[0] & 0x0f
+        if hdr[1] & 0x80 == 0 {
+            return nil, errors.New("unmasked frame from the client")
+        }
+        plen := uint64(hdr[1] & 0x7f)
+        switch plen {
+        case 126:
+            var ext [2]byte
+            if _, err := io.ReadFull(reader, ext[:]); err != nil {
+                return nil, err
+            }
+            plen = uint64(binary.BigEndian.Uint16(ext[:]))
+        case 127:
+            var ext [8]byte
+            if _, err := io.ReadFull(reader, ext[:]); err != nil {
+                return nil, err
+            }
+            plen = binary.BigEndian.Uint64(ext[:])
+        }
+        if plen > TCP_MAX_MSG_SIZE - uint64(len(buf)) {
+            return nil, errors.New("WebSocket message is too big")
+        }
+        var mask [4]byte
+        if _, err := io.ReadFull(reader, mask[:]); err != nil {
+            return nil, err
+        }
+        payload := make([]byte, plen)
+        if _, err := io.ReadFull(reader, payload); err != nil {
+            return nil, err
+        }
+        for i := range payload {
+            payload[i] ^= mask[i % 4]
+        }
+        switch opcode {
+        case ws_op_close, ws_op_ping, ws_op_pong:
+            if ! fin || plen > 125 {
+                return nil, errors.New("bad control frame")
+            }
+            if control != nil {
+                control(opcode, payload)
+            }
+            if opcode == ws_op_close {
+                return nil, errWsClosed
+            }
+            continue
+        case ws_op_text, ws_op_binary:
+            if started {
+                return nil, errors.New("unexpected data frame in the middle of a fragmented message")
+            }
+            started = true
+        case ws_op_continuation:
+            if ! started {
+                return nil, errors.New("unexpected continuation frame")
+            }
+        default:
+            return nil, errors.New("unknown opcode")
+        }
+        buf = append(buf, payload...)
+        if fin {
+            return buf, nil
+        }
+    }
+}
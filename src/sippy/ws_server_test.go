@@ -0,0 +1,151 @@
+package sippy
+
+import (
+    "bufio"
+    "encoding/binary"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "sippy/conf"
+    "sippy/log"
+    "sippy/net"
+    "sippy/time"
+)
+
+func wsClientFrame(payload []byte) []byte {
+    mask := []byte{ 1, 2, 3, 4 }
+    hdr := []byte{ 0x80 | ws_op_text }
+    if len(payload) < 126 {
+        hdr = append(hdr, 0x80 | byte(len(payload)))
+    } else {
+        hdr = append(hdr, 0x80 | 126, 0, 0)
+        binary.BigEndian.PutUint16(hdr[2:], uint16(len(payload)))
+    }
+    hdr = append(hdr, mask...)
+    for i, b := range payload {
+        hdr = append(hdr, b ^ mask[i % 4])
+    }
+    return hdr
+}
+
+func wsReadServerFrame(t *testing.T, conn net.Conn, reader *bufio.Reader) string {
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    var hdr [2]byte
+    if _, err := io.ReadFull(reader, hdr[:]); err != nil {
+        t.Fatal(err)
+    }
+    plen := int(hdr[1] & 0x7f)
+    if plen == 126 {
+        var ext [2]byte
+        if _, err := io.ReadFull(reader, ext[:]); err != nil {
+            t.Fatal(err)
+        }
+        plen = int(binary.BigEndian.Uint16(ext[:]))
+    }
+    buf := make([]byte, plen)
+    if _, err := io.ReadFull(reader, buf); err != nil {
+        t.Fatal(err)
+    }
+    return string(buf)
+}
+
+func Test_WebSocket(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetSipAddress(sippy_net.NewMyAddress("127.0.0.1"))
+    config.SetSipPort(sippy_net.NewMyPort("0"))
+    config.SetWsEnabled(true)
+    config.SetWsPort(sippy_net.NewMyPort("0"))
+    cmap := NewTestCallMap(config)
+    sip_tm, err := NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    cmap.sip_tm = sip_tm
+    go sip_tm.Run()
+    defer sip_tm.Shutdown()
+
+    server := sip_tm.l4r.streams["ws"][0].(*WsServer)
+    conn, err := net.Dial("tcp", server.listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    conn.Write([]byte("GET / HTTP/1.1\r\n" +
+                      "Host: 127.0.0.1\r\n" +
+                      "Upgrade: websocket\r\n" +
+                      "Connection: Upgrade\r\n" +
+                      "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+                      "Sec-WebSocket-Version: 13\r\n" +
+                      "Sec-WebSocket-Protocol: sip\r\n\r\n"))
+    reader := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(reader, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if resp.StatusCode != 101 {
+        t.Fatalf("unexpected handshake response: %d", resp.StatusCode)
+    }
+    assertStringEqual(resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", t)
+    assertStringEqual(resp.Header.Get("Sec-WebSocket-Protocol"), "sip", t)
+
+    invite := strings.Join([]string{
+        "INVITE sip:bob@127.0.0.1 SIP/2.0",
+        "Via: SIP/2.0/WS df7jal23ls0d.invalid;branch=z9hG4bK56sdasks",
+        "Max-Forwards: 70",
+        "From: <sip:alice@example.com>;tag=asdyka899",
+        "To: <sip:bob@example.com>",
+        "Contact: <sip:alice@df7jal23ls0d.invalid;transport=ws>",
+        "Call-ID: asidkj3ss",
+        "CSeq: 1 INVITE",
+        "Content-Type: application/sdp",
+        "Content-Length: 126",
+        "",
+        "v=0",
+        "o=user1 53655765 2353687637 IN IP4 1.1.1.1",
+        "s=-",
+        "c=IN IP4 1.1.1.1",
+        "t=0 0",
+        "m=audio 11111 RTP/AVP 0",
+        "a=rtpmap:0 PCMU/8000",
+        "",
+    }, "\r\n")
+    conn.Write(wsClientFrame([]byte(invite)))
+    if msg := wsReadServerFrame(t, conn, reader); ! strings.HasPrefix(msg, "SIP/2.0 100 ") {
+        t.Fatalf("100 Trying expected, got %q", msg)
+    }
+    cmap.answer()
+    if msg := wsReadServerFrame(t, conn, reader); ! strings.HasPrefix(msg, "SIP/2.0 200 ") {
+        t.Fatalf("200 OK expected, got %q", msg)
+    }
+    cmap.disconnect()
+    rtime, _ := sippy_time.NewMonoTime()
+    bye, err := ParseSipRequest([]byte(wsReadServerFrame(t, conn, reader)), rtime, config)
+    if err != nil {
+        t.Fatal("Cannot parse BYE: " + err.Error())
+    }
+    assertStringEqual(bye.GetMethod(), "BYE", t)
+    assertStringEqual(bye.GetRURI().GetProto(), "ws", t)
+    assertStringEqual(bye.GetRURI().Host.String(), "127.0.0.1", t)
+    via0, _ := bye.GetVias()[0].GetBody()
+    assertStringEqual(via0.GetTransport(), "ws", t)
+
+    // The close frame is echoed back before the connection is closed
+    conn.Write([]byte{ 0x80 | ws_op_close, 0x80 | 2, 0, 0, 0, 0, 0x03, 0xe8 })
+    if msg := wsReadServerFrame(t, conn, reader); msg != "\x03\xe8" {
+        t.Fatalf("close reply expected, got %q", msg)
+    }
+}
+
+func Test_WebSocketTooBig(t *testing.T) {
+    // The first fragment followed by a continuation of 2^64 - 1 bytes
+    first := []byte{ ws_op_text, 0x80 | 1, 0, 0, 0, 0, 'x' }
+    huge := []byte{ 0x80 | ws_op_continuation, 0x80 | 127, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0 }
+    reader := bufio.NewReader(strings.NewReader(string(append(first, huge...))))
+    if _, err := readWsMessage(reader, nil); err == nil || err == io.ErrUnexpectedEOF {
+        t.Fatalf("oversized message accepted: %v", err)
+    }
+}